@@ -0,0 +1,155 @@
+// Copyright 2015 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"v.io/jiri"
+	"v.io/jiri/runutil"
+)
+
+// apiPrefix is the path prefix of the JSON API, which includes its
+// version.
+const apiPrefix = "/api/v1/"
+
+// apiError is an error of an API request, which is reported to the client
+// with the given HTTP status code.
+type apiError struct {
+	code int
+	msg  string
+}
+
+func (e apiError) Error() string {
+	return e.msg
+}
+
+func badRequest(format string, args ...interface{}) error {
+	return apiError{http.StatusBadRequest, fmt.Sprintf(format, args...)}
+}
+
+var errNotFound = apiError{http.StatusNotFound, "not found"}
+
+// apiHelper serves the JSON API, which returns the data of the dashboard
+// pages. Under apiPrefix, "status" returns the status of the services,
+// "presubmit/<n>" the summary of presubmit run <n>,
+// "presubmit/<n>/job/<job>" the details of a job of the run, and
+// "presubmit/<n>/job/<job>/test" the details of a test case of the job,
+// "presubmit/<n>/job/<job>/search" the lines of the console outputs of
+// the job that match a query, "test?name=<name>" the history of a test
+// case and "flaky" the top flaky tests. The job, test case and search
+// requests take the same query parameters as the corresponding HTML
+// pages.
+func apiHelper(jirix *jiri.X, w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	elems := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, apiPrefix), "/"), "/")
+	var data interface{}
+	var err error
+	immutable := false
+	switch {
+	case len(elems) == 1 && elems[0] == "status":
+		data, err = statusModel(jirix)
+	case len(elems) == 1 && elems[0] == "test":
+		data, err = testHistoryModel(jirix, r.Form.Get("name"))
+	case len(elems) == 1 && elems[0] == "flaky":
+		data, err = flakyModel(jirix)
+	case len(elems) > 1 && elems[0] == "presubmit":
+		data, err = presubmitAPIModel(jirix, elems[1:], r.Form)
+		immutable = true
+	default:
+		err = errNotFound
+	}
+	if err != nil {
+		respondWithJSONError(jirix, err, w)
+		return
+	}
+	bytes, err := json.Marshal(data)
+	if err != nil {
+		respondWithJSONError(jirix, fmt.Errorf("Marshal(%v) failed: %v", data, err), w)
+		return
+	}
+	if immutable {
+		// The presubmit test results data never changes, cache it in
+		// the clients for up to 30 days.
+		w.Header().Set("Cache-control", "public, max-age=2592000")
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(bytes)
+}
+
+// presubmitAPIModel returns the data of the presubmit results identified
+// by the given path elements, which follow "presubmit/" in the path of
+// the request, and the given query parameters.
+func presubmitAPIModel(jirix *jiri.X, elems []string, form url.Values) (interface{}, error) {
+	values := url.Values{}
+	values.Set("type", "presubmit")
+	values.Set("n", elems[0])
+	required := []string{}
+	switch {
+	case len(elems) == 1:
+	case len(elems) == 3 && elems[1] == "job":
+		required = []string{"os", "arch"}
+	case len(elems) == 4 && elems[1] == "job" && elems[3] == "test":
+		required = []string{"os", "arch", "part", "suite", "test"}
+		values.Set("class", form.Get("class"))
+	case len(elems) == 4 && elems[1] == "job" && elems[3] == "search":
+		required = []string{"os", "arch", "q"}
+	default:
+		return nil, errNotFound
+	}
+	if len(elems) > 1 {
+		values.Set("job", elems[2])
+	}
+	for _, param := range required {
+		value := form.Get(param)
+		if value == "" {
+			return nil, badRequest("required parameter %q not found", param)
+		}
+		values.Set(param, value)
+	}
+	if err := validateValues(values); err != nil {
+		return nil, badRequest("%v", err)
+	}
+	if len(elems) == 4 && elems[3] == "search" {
+		context := defaultSearchContext
+		if value := form.Get("context"); value != "" {
+			var err error
+			if context, err = strconv.Atoi(value); err != nil {
+				return nil, badRequest("invalid context %q", value)
+			}
+		}
+		return searchModel(jirix, values.Get("n"), values.Get("os"), values.Get("arch"), values.Get("job"), values.Get("q"), context)
+	}
+	data, _, err := presubmitModel(jirix, values.Get("n"), extractParams(values))
+	return data, err
+}
+
+// respondWithJSONError reports the given error of an API request to the
+// client as a JSON object with an "Error" field. Internal errors are
+// only logged.
+func respondWithJSONError(jirix *jiri.X, err error, w http.ResponseWriter) {
+	code, msg := http.StatusInternalServerError, "internal server error"
+	if e, ok := err.(apiError); ok {
+		code, msg = e.code, e.msg
+	} else if runutil.IsNotExist(err) {
+		code, msg = errNotFound.code, errNotFound.msg
+	}
+	if code == http.StatusInternalServerError {
+		fmt.Fprintf(jirix.Stderr(), "%v\n", err)
+	}
+	bytes, err := json.Marshal(struct{ Error string }{msg})
+	if err != nil {
+		http.Error(w, msg, code)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(bytes)
+}
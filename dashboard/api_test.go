@@ -0,0 +1,104 @@
+// Copyright 2015 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"v.io/jiri/jiritest"
+)
+
+func TestAPI(t *testing.T) {
+	jirix, cleanup := jiritest.NewX(t)
+	defer cleanup()
+	defer setupFixtures(t)()
+
+	get := func(url string, wantCode int, data interface{}) {
+		r, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		w := httptest.NewRecorder()
+		apiHelper(jirix, w, r)
+		if got := w.Code; got != wantCode {
+			t.Fatalf("%v: got status %v, want %v\n%v", url, got, wantCode, w.Body.String())
+		}
+		if got, want := w.Header().Get("Content-Type"), "application/json"; got != want {
+			t.Fatalf("%v: got content type %v, want %v", url, got, want)
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), data); err != nil {
+			t.Fatalf("%v: Unmarshal() failed: %v\n%v", url, err, w.Body.String())
+		}
+	}
+
+	// The status API returns the data of the status page.
+	var status statusPageData
+	get("/api/v1/status", http.StatusOK, &status)
+	wantStatus, err := statusModel(jirix)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if !reflect.DeepEqual(&status, wantStatus) {
+		t.Fatalf("got %#v, want %#v", status, wantStatus)
+	}
+	if got, want := len(status.Status), 2; got != want {
+		t.Fatalf("got %v services, want %v", got, want)
+	}
+
+	// The presubmit APIs return the data of the presubmit pages.
+	var summary summaryData
+	get("/api/v1/presubmit/1234", http.StatusOK, &summary)
+	if got, want := summary.Number, "1234"; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got, want := len(summary.OSJobs), 1; got != want {
+		t.Fatalf("got %v OSes, want %v", got, want)
+	}
+	jobs := summary.OSJobs[0].Jobs
+	if got, want := len(jobs), 2; got != want {
+		t.Fatalf("got %v jobs, want %v", got, want)
+	}
+	wantFailedTests := []failedTest{{Suite: "v.io/x/ref/services", ClassName: "v.io/x/ref/services", TestCase: "TestServer", PartIndex: 1}}
+	if got := jobs[1]; got.Name != "vanadium-go-test" || got.Result || !reflect.DeepEqual(got.FailedTests, wantFailedTests) {
+		t.Fatalf("got %#v", got)
+	}
+
+	var job jobData
+	get("/api/v1/presubmit/1234/job/vanadium-go-test?os=linux&arch=amd64", http.StatusOK, &job)
+	if job.Job != "vanadium-go-test" || job.Result || !reflect.DeepEqual(job.FailedTests, wantFailedTests) {
+		t.Fatalf("got %#v", job)
+	}
+
+	var testCase testData
+	get("/api/v1/presubmit/1234/job/vanadium-go-test/test?os=linux&arch=amd64&part=1&suite=v.io/x/ref/services&class=v.io/x/ref/services&test=TestServer", http.StatusOK, &testCase)
+	if got := testCase.TestCase; got.Name != "TestServer" || len(got.Failures) != 1 {
+		t.Fatalf("got %#v", got)
+	}
+
+	// Invalid requests are reported as JSON errors.
+	tests := []struct {
+		url  string
+		code int
+	}{
+		{"/api/v1/presubmit/4321", http.StatusNotFound},
+		{"/api/v1/presubmit/1234/job/vanadium-go-test", http.StatusBadRequest},
+		{"/api/v1/presubmit/1234/job/vanadium-go-test?os=linux&arch=../..", http.StatusBadRequest},
+		{"/api/v1/presubmit/1234/job/vanadium-go-test/test?os=linux&arch=amd64&part=1&suite=v.io/x/ref/services", http.StatusBadRequest},
+		{"/api/v1/presubmit/1234/build/vanadium-go-test", http.StatusNotFound},
+		{"/api/v1/postsubmit/1234", http.StatusNotFound},
+		{"/api/v1/", http.StatusNotFound},
+	}
+	for _, test := range tests {
+		var data struct{ Error string }
+		get(test.url, test.code, &data)
+		if data.Error == "" {
+			t.Fatalf("%v: no error reported", test.url)
+		}
+	}
+}
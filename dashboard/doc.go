@@ -8,6 +8,29 @@
 /*
 Command dashboard runs the Vanadium dashboard web server.
 
+Besides the HTML pages, the server provides a JSON API that returns the data of
+the pages: the status of the services (/api/v1/status), the summary of presubmit
+run <n> (/api/v1/presubmit/<n>), and the details of its jobs
+(/api/v1/presubmit/<n>/job/<job>) and test cases
+(/api/v1/presubmit/<n>/job/<job>/test), which take the same query parameters as
+the corresponding HTML pages.
+
+The /test?name=<name> page shows the history of a test case across presubmit and
+postsubmit builds, including its flake rate, and the /flaky page shows the tests
+that were flaky most often in the last week. These pages are served from an
+index of the stored xUnit reports, which the server updates in the background.
+The JSON API returns their data from /api/v1/test?name=<name> and /api/v1/flaky.
+
+The console output of each part of a job is shown a page at a time, with links
+to the previous and next pages, and can be searched across all parts of the job.
+Search results, which the JSON API returns from
+/api/v1/presubmit/<n>/job/<job>/search?q=<query>, link to the matching lines.
+
+If -probe-results is set, the status page and /api/v1/status are computed from
+the results of the production services test of the last 30 days: the incidents
+of each service, its uptime over 7 and 30 days, and the rates at which it burns
+the error budget of its availability target over 1 hour, 6 hours and 3 days.
+
 Usage:
    dashboard [flags]
 
@@ -16,14 +39,28 @@ The dashboard flags are:
    Directory to use for caching files.
  -color=true
    Use color to format output.
+ -index-dir=${HOME}/tmp/dashboard_index
+   Directory to use for the index of the test history. An empty value disables
+   the test history pages.
+ -index-interval=5m0s
+   The interval between updates of the index of the test history.
  -port=8000
    Port for the server.
+ -probe-results=
+   Google Storage bucket, or local directory, with the xUnit reports of the
+   production services test, named <unix time>.xml. If set, the service status
+   is computed from these reports instead of read from the status bucket.
+   Requires -cache, in which a summary of the reports is kept.
  -results-bucket=gs://vanadium-test-results
-   Google Storage bucket to use for fetching test results.
+   Google Storage bucket, or local directory with the same layout, to use for
+   fetching test results.
+ -slo-target=99.9
+   The availability target of the services over 30 days, in percent.
  -static=
    Directory to use for serving static files.
  -status-bucket=gs://vanadium-oncall/data
-   Google Storage bucket to use for fetching service status data.
+   Google Storage bucket, or local directory with the same layout, to use for
+   fetching service status data.
  -v=false
    Print verbose output.
 
@@ -0,0 +1,732 @@
+// Copyright 2015 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"v.io/jiri"
+	"v.io/jiri/collect"
+	"v.io/jiri/runutil"
+	"v.io/x/devtools/internal/blobstore"
+	"v.io/x/devtools/internal/xunit"
+)
+
+const (
+	defaultIndexDir = "${HOME}/tmp/dashboard_index"
+
+	// maxBuildLag is the number of builds by which a build without
+	// results must precede the newest indexed build of its kind to be
+	// considered to have none, as opposed to still running.
+	maxBuildLag = 50
+	// maxTestRuns is the number of the most recent runs of a test case
+	// that the index keeps.
+	maxTestRuns = 1000
+	// flakyWindow is the period before an update of the index whose runs
+	// the top flaky tests are computed from.
+	flakyWindow = 7 * 24 * time.Hour
+	// flakyUpdateInterval is the interval after which the top flaky tests
+	// are recomputed even if no builds have been indexed.
+	flakyUpdateInterval = time.Hour
+	// numFlakyTests is the number of the top flaky tests.
+	numFlakyTests = 20
+	// numTimelineRuns is the number of the most recent runs of a test
+	// case that its history page shows.
+	numTimelineRuns = 100
+)
+
+// The statuses of test case runs.
+const (
+	runPassed  = "pass"
+	runFailed  = "fail"
+	runSkipped = "skip"
+)
+
+// buildKinds lists the kinds of builds whose results are stored under
+// v0/<kind>/<n> in the results bucket and are indexed.
+var buildKinds = []string{"presubmit", "postsubmit"}
+
+var (
+	indexDirFlag      string
+	indexIntervalFlag time.Duration
+)
+
+func init() {
+	cmdDashboard.Flags.StringVar(&indexDirFlag, "index-dir", os.ExpandEnv(defaultIndexDir), "Directory to use for the index of the test history. An empty value disables the test history pages.")
+	cmdDashboard.Flags.Lookup("index-dir").DefValue = defaultIndexDir
+	cmdDashboard.Flags.DurationVar(&indexIntervalFlag, "index-interval", 5*time.Minute, "The interval between updates of the index of the test history.")
+}
+
+// testRun records the result of a test case in a part of a job of a
+// build.
+type testRun struct {
+	Kind   string
+	Build  int
+	Time   int64 // The time the results of the build were stored, in seconds since the epoch.
+	OSName string
+	Arch   string
+	Job    string
+	Part   int
+	Status string
+	// Duration is the duration of the test case in seconds.
+	Duration float64
+}
+
+func (r testRun) sameBuild(other testRun) bool {
+	return r.Kind == other.Kind && r.Build == other.Build && r.OSName == other.OSName && r.Arch == other.Arch && r.Job == other.Job && r.Part == other.Part
+}
+
+type testRunsByTime []testRun
+
+func (r testRunsByTime) Len() int { return len(r) }
+func (r testRunsByTime) Less(i, j int) bool {
+	switch {
+	case r[i].Time != r[j].Time:
+		return r[i].Time < r[j].Time
+	case r[i].Kind != r[j].Kind:
+		return r[i].Kind < r[j].Kind
+	case r[i].Build != r[j].Build:
+		return r[i].Build < r[j].Build
+	case r[i].OSName != r[j].OSName:
+		return r[i].OSName < r[j].OSName
+	case r[i].Arch != r[j].Arch:
+		return r[i].Arch < r[j].Arch
+	case r[i].Job != r[j].Job:
+		return r[i].Job < r[j].Job
+	}
+	return r[i].Part < r[j].Part
+}
+func (r testRunsByTime) Swap(i, j int) { r[i], r[j] = r[j], r[i] }
+
+// testHistory records the runs of a test case, identified by its name
+// "<class>/<case>", oldest first.
+type testHistory struct {
+	Name  string
+	Suite string
+	Class string
+	Case  string
+	Runs  []testRun
+}
+
+// add adds the given runs to the history, ignoring the runs it already
+// has and keeping only the most recent maxTestRuns runs.
+func (h *testHistory) add(runs []testRun) {
+outer:
+	for _, run := range runs {
+		for _, existing := range h.Runs {
+			if existing.sameBuild(run) {
+				continue outer
+			}
+		}
+		h.Runs = append(h.Runs, run)
+	}
+	sort.Sort(testRunsByTime(h.Runs))
+	if len(h.Runs) > maxTestRuns {
+		h.Runs = h.Runs[len(h.Runs)-maxTestRuns:]
+	}
+}
+
+// sequenced determines whether the given run is part of the sequence of
+// runs that flakiness and ongoing failures are computed from. Presubmit
+// runs test different changes, so their failures may be caused by the
+// changes: only the non-skipped postsubmit runs form a sequence.
+func sequenced(run testRun) bool {
+	return run.Kind == "postsubmit" && run.Status != runSkipped
+}
+
+// flakes determines which of the given runs, sorted by time, are
+// flaky: postsubmit failures whose previous and next postsubmit runs of
+// the test case in the same job and configuration passed.
+func flakes(runs []testRun) []bool {
+	configRuns := map[string][]int{}
+	for i, run := range runs {
+		if !sequenced(run) {
+			continue
+		}
+		config := run.Job + "/" + run.OSName + "/" + run.Arch
+		configRuns[config] = append(configRuns[config], i)
+	}
+	flaky := make([]bool, len(runs))
+	for _, indexes := range configRuns {
+		for i := 1; i+1 < len(indexes); i++ {
+			prev, cur, next := runs[indexes[i-1]], runs[indexes[i]], runs[indexes[i+1]]
+			if cur.Status == runFailed && prev.Status == runPassed && next.Status == runPassed {
+				flaky[indexes[i]] = true
+			}
+		}
+	}
+	return flaky
+}
+
+// indexState records the builds that have been indexed, as
+// "<kind>/<n>".
+type indexState struct {
+	Indexed map[string]bool
+}
+
+func buildKey(kind string, n int) string {
+	return fmt.Sprintf("%s/%d", kind, n)
+}
+
+// flakyTest records how often a test case was flaky.
+type flakyTest struct {
+	Name   string
+	Flakes int
+	Runs   int
+	// Rate is the percentage of the runs that were flaky.
+	Rate float64
+}
+
+type flakyTestsByRate []flakyTest
+
+func (f flakyTestsByRate) Len() int { return len(f) }
+func (f flakyTestsByRate) Less(i, j int) bool {
+	switch {
+	case f[i].Flakes != f[j].Flakes:
+		return f[i].Flakes > f[j].Flakes
+	case f[i].Rate != f[j].Rate:
+		return f[i].Rate > f[j].Rate
+	}
+	return f[i].Name < f[j].Name
+}
+func (f flakyTestsByRate) Swap(i, j int) { f[i], f[j] = f[j], f[i] }
+
+// flakyData records the top flaky tests of the runs since the given
+// time, computed at the given time. Times are in seconds since the
+// epoch.
+type flakyData struct {
+	Time  int64
+	Since int64
+	Tests []flakyTest
+}
+
+// testIndex is an index of the runs of test cases in the builds whose
+// results are stored in a blob store. The index is stored in a
+// directory, with the history of each test case in a file of the
+// "tests" subdirectory, and is updated incrementally.
+type testIndex struct {
+	dir   string
+	store blobstore.Store
+	// now returns the current time.
+	now func() time.Time
+}
+
+func newTestIndex(dir string, store blobstore.Store) *testIndex {
+	return &testIndex{dir: dir, store: store, now: time.Now}
+}
+
+func (x *testIndex) statePath() string {
+	return filepath.Join(x.dir, "state.json")
+}
+
+func (x *testIndex) flakyPath() string {
+	return filepath.Join(x.dir, "flaky.json")
+}
+
+func (x *testIndex) testsDir() string {
+	return filepath.Join(x.dir, "tests")
+}
+
+func (x *testIndex) historyPath(name string) string {
+	return filepath.Join(x.testsDir(), fmt.Sprintf("%x.json", sha1.Sum([]byte(name))))
+}
+
+// readJSON reads the given file into the given value.
+func readJSON(jirix *jiri.X, path string, value interface{}) error {
+	bytes, err := jirix.NewSeq().ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(bytes, value); err != nil {
+		return fmt.Errorf("Unmarshal(%v) failed: %v", path, err)
+	}
+	return nil
+}
+
+// writeJSON writes the given value to the given file, replacing the file
+// atomically so that concurrent readers never see a partial file.
+func writeJSON(jirix *jiri.X, path string, value interface{}) error {
+	bytes, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("Marshal(%v) failed: %v", path, err)
+	}
+	tmpPath := path + ".tmp"
+	return jirix.NewSeq().
+		MkdirAll(filepath.Dir(path), os.FileMode(0755)).
+		WriteFile(tmpPath, bytes, os.FileMode(0644)).
+		Rename(tmpPath, path).Done()
+}
+
+// run updates the index at the given interval until the process exits.
+func (x *testIndex) run(jirix *jiri.X, interval time.Duration) {
+	for {
+		if err := x.update(jirix); err != nil {
+			fmt.Fprintf(jirix.Stderr(), "failed to update the test history index: %v\n", err)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// update indexes the builds whose results have been stored since the
+// last update, and recomputes the top flaky tests if any builds were
+// indexed or they are out of date.
+func (x *testIndex) update(jirix *jiri.X) error {
+	state := indexState{}
+	if err := readJSON(jirix, x.statePath(), &state); err != nil && !runutil.IsNotExist(err) {
+		return err
+	}
+	if state.Indexed == nil {
+		state.Indexed = map[string]bool{}
+	}
+	numIndexed := 0
+	for _, kind := range buildKinds {
+		builds, err := x.listBuilds(jirix, kind)
+		if err != nil {
+			return err
+		}
+		newest := -1
+		for _, n := range builds {
+			if state.Indexed[buildKey(kind, n)] && n > newest {
+				newest = n
+			}
+		}
+		for _, n := range builds {
+			if state.Indexed[buildKey(kind, n)] {
+				continue
+			}
+			indexed, err := x.indexBuild(jirix, kind, n)
+			if err != nil {
+				return err
+			}
+			if indexed {
+				numIndexed++
+				if n > newest {
+					newest = n
+				}
+			} else if newest-n <= maxBuildLag {
+				// The build may still be running.
+				continue
+			}
+			state.Indexed[buildKey(kind, n)] = true
+			if err := writeJSON(jirix, x.statePath(), state); err != nil {
+				return err
+			}
+		}
+	}
+
+	var flaky flakyData
+	if err := readJSON(jirix, x.flakyPath(), &flaky); err != nil && !runutil.IsNotExist(err) {
+		return err
+	}
+	if numIndexed > 0 || x.now().Sub(time.Unix(flaky.Time, 0)) >= flakyUpdateInterval {
+		return x.updateFlaky(jirix)
+	}
+	return nil
+}
+
+// listBuilds returns the numbers of the builds of the given kind that
+// have stored results, in increasing order.
+func (x *testIndex) listBuilds(jirix *jiri.X, kind string) ([]int, error) {
+	names, err := x.store.List(jirix, path.Join("v0", kind))
+	if err != nil {
+		if runutil.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	builds := []int{}
+	for _, name := range names {
+		if n, err := strconv.Atoi(name); err == nil {
+			builds = append(builds, n)
+		}
+	}
+	sort.Ints(builds)
+	return builds, nil
+}
+
+// indexBuild adds the runs of test cases in the given build to the
+// index. It returns false if the build has not stored its results
+// archive, which builds do when they complete.
+func (x *testIndex) indexBuild(jirix *jiri.X, kind string, n int) (_ bool, e error) {
+	info, err := x.store.Stat(jirix, path.Join("v0", kind, strconv.Itoa(n), "results.tar.gz"))
+	if err != nil {
+		if runutil.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	root := cacheFlag
+	if root == "" {
+		tmpDir, err := jirix.NewSeq().TempDir("", "")
+		if err != nil {
+			return false, err
+		}
+		defer collect.Error(func() error { return jirix.NewSeq().RemoveAll(tmpDir).Done() }, &e)
+		root = tmpDir
+	}
+	resultsDir, err := fetchResults(jirix, x.store, root, kind, strconv.Itoa(n))
+	if err != nil {
+		return false, err
+	}
+	histories, err := readBuildRuns(jirix, resultsDir, testRun{Kind: kind, Build: n, Time: info.ModTime.Unix()})
+	if err != nil {
+		return false, err
+	}
+	for _, runs := range histories {
+		history := testHistory{}
+		if err := readJSON(jirix, x.historyPath(runs.Name), &history); err != nil {
+			if !runutil.IsNotExist(err) {
+				return false, err
+			}
+			history = testHistory{Name: runs.Name, Suite: runs.Suite, Class: runs.Class, Case: runs.Case}
+		}
+		history.add(runs.Runs)
+		if err := writeJSON(jirix, x.historyPath(runs.Name), history); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// readBuildRuns reads the runs of test cases from the xUnit reports in
+// the given results directory, whose structure is
+// <os>/<arch>/<job>/<part>/xunit.xml. The given run describes the build.
+// It returns the runs of each test case, keyed by its name.
+func readBuildRuns(jirix *jiri.X, resultsDir string, build testRun) (map[string]*testHistory, error) {
+	histories := map[string]*testHistory{}
+	s := jirix.NewSeq()
+	osFileInfos, err := s.ReadDir(resultsDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, osFileInfo := range osFileInfos {
+		osDir := filepath.Join(resultsDir, osFileInfo.Name())
+		archFileInfos, err := s.ReadDir(osDir)
+		if err != nil {
+			return nil, err
+		}
+		for _, archFileInfo := range archFileInfos {
+			archDir := filepath.Join(osDir, archFileInfo.Name())
+			jobFileInfos, err := s.ReadDir(archDir)
+			if err != nil {
+				return nil, err
+			}
+			for _, jobFileInfo := range jobFileInfos {
+				jobDir := filepath.Join(archDir, jobFileInfo.Name())
+				partFileInfos, err := s.ReadDir(jobDir)
+				if err != nil {
+					return nil, err
+				}
+				for _, partFileInfo := range partFileInfos {
+					part, err := strconv.Atoi(partFileInfo.Name())
+					if err != nil {
+						continue
+					}
+					reportPath := filepath.Join(jobDir, partFileInfo.Name(), "xunit.xml")
+					bytes, err := s.ReadFile(reportPath)
+					if err != nil {
+						if runutil.IsNotExist(err) {
+							continue
+						}
+						return nil, err
+					}
+					var suites xunit.TestSuites
+					if err := xml.Unmarshal(bytes, &suites); err != nil {
+						return nil, fmt.Errorf("Unmarshal(%v) failed: %v", reportPath, err)
+					}
+					run := build
+					run.OSName, run.Arch, run.Job, run.Part = osFileInfo.Name(), archFileInfo.Name(), jobFileInfo.Name(), part
+					for _, ts := range suites.Suites {
+						for _, tc := range ts.Cases {
+							class := tc.Classname
+							if class == "" {
+								class = ts.Name
+							}
+							name := class + "/" + tc.Name
+							history, ok := histories[name]
+							if !ok {
+								history = &testHistory{Name: name, Suite: ts.Name, Class: tc.Classname, Case: tc.Name}
+								histories[name] = history
+							}
+							run.Status = runPassed
+							switch {
+							case len(tc.Failures) > 0 || len(tc.Errors) > 0:
+								run.Status = runFailed
+							case len(tc.Skipped) > 0:
+								run.Status = runSkipped
+							}
+							run.Duration, _ = strconv.ParseFloat(tc.Time, 64)
+							history.Runs = append(history.Runs, run)
+						}
+					}
+				}
+			}
+		}
+	}
+	return histories, nil
+}
+
+// updateFlaky recomputes the top flaky tests of the runs in the last
+// flakyWindow.
+func (x *testIndex) updateFlaky(jirix *jiri.X) error {
+	now := x.now()
+	since := now.Add(-flakyWindow).Unix()
+	data := flakyData{Time: now.Unix(), Since: since}
+	fileInfos, err := jirix.NewSeq().ReadDir(x.testsDir())
+	if err != nil && !runutil.IsNotExist(err) {
+		return err
+	}
+	tests := flakyTestsByRate{}
+	for _, fileInfo := range fileInfos {
+		if !strings.HasSuffix(fileInfo.Name(), ".json") {
+			continue
+		}
+		var history testHistory
+		if err := readJSON(jirix, filepath.Join(x.testsDir(), fileInfo.Name()), &history); err != nil {
+			return err
+		}
+		test := flakyTest{Name: history.Name}
+		for i, flaky := range flakes(history.Runs) {
+			if run := history.Runs[i]; run.Time < since || !sequenced(run) {
+				continue
+			}
+			test.Runs++
+			if flaky {
+				test.Flakes++
+			}
+		}
+		if test.Flakes > 0 {
+			test.Rate = 100 * float64(test.Flakes) / float64(test.Runs)
+			tests = append(tests, test)
+		}
+	}
+	sort.Sort(tests)
+	if len(tests) > numFlakyTests {
+		tests = tests[:numFlakyTests]
+	}
+	data.Tests = tests
+	return writeJSON(jirix, x.flakyPath(), data)
+}
+
+// testRunData describes a run of a test case on its history page.
+type testRunData struct {
+	testRun
+	Flaky bool
+}
+
+// testHistoryData describes the history of a test case.
+type testHistoryData struct {
+	Name  string
+	Suite string
+	Class string
+	Case  string
+	// Runs lists the most recent runs, oldest first.
+	Runs []testRunData
+	// NumRuns, Flakes and FlakeRate describe all indexed postsubmit
+	// runs, except for skipped runs. FlakeRate is a percentage.
+	NumRuns   int
+	Flakes    int
+	FlakeRate float64
+	// FailingSince is the first run of the failures that the most
+	// recent postsubmit runs are, if any.
+	FailingSince *testRun
+	// DurationPoints lists the points of a plot of the durations of
+	// the most recent runs.
+	DurationPoints string
+}
+
+// The size of the plot of the durations of test case runs.
+const (
+	plotWidth  = 600
+	plotHeight = 60
+)
+
+// testHistoryModel returns the history of the test case with the given
+// name from the index.
+func testHistoryModel(jirix *jiri.X, name string) (*testHistoryData, error) {
+	if indexDirFlag == "" {
+		return nil, fmt.Errorf("the test history is disabled")
+	}
+	if name == "" {
+		return nil, badRequest("required parameter %q not found", "name")
+	}
+	index := newTestIndex(indexDirFlag, nil)
+	var history testHistory
+	if err := readJSON(jirix, index.historyPath(name), &history); err != nil {
+		return nil, err
+	}
+	data := testHistoryData{
+		Name:  history.Name,
+		Suite: history.Suite,
+		Class: history.Class,
+		Case:  history.Case,
+	}
+	flaky := flakes(history.Runs)
+	for i, run := range history.Runs {
+		if !sequenced(run) {
+			continue
+		}
+		data.NumRuns++
+		if flaky[i] {
+			data.Flakes++
+		}
+	}
+	if data.NumRuns > 0 {
+		data.FlakeRate = 100 * float64(data.Flakes) / float64(data.NumRuns)
+	}
+	for i := len(history.Runs) - 1; i >= 0; i-- {
+		run := history.Runs[i]
+		if !sequenced(run) {
+			continue
+		}
+		if run.Status != runFailed {
+			break
+		}
+		data.FailingSince = &history.Runs[i]
+	}
+	first := 0
+	if len(history.Runs) > numTimelineRuns {
+		first = len(history.Runs) - numTimelineRuns
+	}
+	maxDuration := 0.0
+	for i := first; i < len(history.Runs); i++ {
+		data.Runs = append(data.Runs, testRunData{history.Runs[i], flaky[i]})
+		if d := history.Runs[i].Duration; d > maxDuration {
+			maxDuration = d
+		}
+	}
+	points := []string{}
+	for i, run := range data.Runs {
+		x, y := 0.0, float64(plotHeight)
+		if len(data.Runs) > 1 {
+			x = float64(i*plotWidth) / float64(len(data.Runs)-1)
+		}
+		if maxDuration > 0 {
+			y -= run.Duration / maxDuration * plotHeight
+		}
+		points = append(points, fmt.Sprintf("%.1f,%.1f", x, y))
+	}
+	data.DurationPoints = strings.Join(points, " ")
+	return &data, nil
+}
+
+// flakyModel returns the top flaky tests from the index.
+func flakyModel(jirix *jiri.X) (*flakyData, error) {
+	if indexDirFlag == "" {
+		return nil, fmt.Errorf("the test history is disabled")
+	}
+	index := newTestIndex(indexDirFlag, nil)
+	var data flakyData
+	if err := readJSON(jirix, index.flakyPath(), &data); err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+var historyFuncMap = template.FuncMap{
+	"formatTime": func(t int64) string {
+		return time.Unix(t, 0).Format("2006-01-02 15:04")
+	},
+}
+
+var testHistoryTemplate = template.Must(template.New("history").Funcs(historyFuncMap).Parse(`
+<!DOCTYPE html>
+<html>
+<head>
+	<title>{{ .Name }} History</title>
+	<link rel="stylesheet" href="/static/dashboard.css">
+</head>
+<body>
+<h1>{{ .Name }} History</h1>
+<table class="param-table">
+	<tr><td>Suite</td><td>{{ .Suite }}</td></tr>
+	<tr><td>Test</td><td>{{ .Case }}</td></tr>
+	<tr><td>Runs</td><td>{{ .NumRuns }}</td></tr>
+	<tr><td>Flake rate</td><td>{{ printf "%.1f" .FlakeRate }}% ({{ .Flakes }} flaky failures)</td></tr>
+	{{ with .FailingSince }}
+	<tr><td>Failing since</td><td><span class="label-fail-large">{{ .Kind }} #{{ .Build }}</span> ({{ formatTime .Time }})</td></tr>
+	{{ end }}
+</table>
+<a href="/flaky">Top Flaky Tests</a>
+<h2>Timeline</h2>
+<div class="timeline">
+{{ $history := . }}
+{{ range $run := .Runs }}
+	{{ $title := printf "%s #%d, %s, %s/%s, part %d: %s, %.2fs" $run.Kind $run.Build $run.Job $run.OSName $run.Arch $run.Part $run.Status $run.Duration }}
+	{{ if eq $run.Kind "presubmit" }}
+	<a target="_blank" class="run run-{{ $run.Status }}{{ if $run.Flaky }} run-flaky{{ end }}" title="{{ $title }}" href="index.html?type=presubmit&n={{ $run.Build }}&arch={{ $run.Arch }}&os={{ $run.OSName }}&job={{ $run.Job }}&part={{ $run.Part }}&suite={{ $history.Suite }}&class={{ $history.Class }}&test={{ $history.Case }}"></a>
+	{{ else }}
+	<span class="run run-{{ $run.Status }}{{ if $run.Flaky }} run-flaky{{ end }}" title="{{ $title }}"></span>
+	{{ end }}
+{{ end }}
+</div>
+<h2>Duration</h2>
+<svg class="duration-plot" width="600" height="60">
+	<polyline points="{{ .DurationPoints }}"/>
+</svg>
+</body>
+</html>
+`))
+
+var flakyTemplate = template.Must(template.New("flaky").Funcs(historyFuncMap).Parse(`
+<!DOCTYPE html>
+<html>
+<head>
+	<title>Top Flaky Tests</title>
+	<link rel="stylesheet" href="/static/dashboard.css">
+</head>
+<body>
+<h1>Top Flaky Tests</h1>
+<div class="label-usage">Runs from {{ formatTime .Since }} to {{ formatTime .Time }}</div>
+<ol class="test-list2">
+{{ range $test := .Tests }}
+	<li>
+	<a href="/test?name={{ $test.Name | urlquery }}">{{ $test.Name }}</a>
+	<span class="label-usage">{{ $test.Flakes }} flaky failures in {{ $test.Runs }} runs ({{ printf "%.1f" $test.Rate }}%)</span>
+	</li>
+{{ end }}
+</ol>
+</body>
+</html>
+`))
+
+// historyHelper serves the test history pages: "/test?name=<name>" shows
+// the history of a test case and "/flaky" the top flaky tests.
+func historyHelper(jirix *jiri.X, w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	var data interface{}
+	var tmpl *template.Template
+	var err error
+	switch r.URL.Path {
+	case "/test":
+		data, err = testHistoryModel(jirix, r.Form.Get("name"))
+		tmpl = testHistoryTemplate
+	case "/flaky":
+		data, err = flakyModel(jirix)
+		tmpl = flakyTemplate
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		respondWithError(jirix, err, w)
+		return
+	}
+	if err := tmpl.Execute(w, data); err != nil {
+		respondWithError(jirix, fmt.Errorf("Execute() failed: %v", err), w)
+	}
+}
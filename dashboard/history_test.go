@@ -0,0 +1,204 @@
+// Copyright 2015 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"v.io/jiri/jiritest"
+	"v.io/x/devtools/internal/blobstore"
+)
+
+// writeBuild stores the results of the given build of the given kind, in which
+// the test cases with the given names have the given statuses, in the
+// given store directory. If complete is true, it also stores the results
+// archive, with the given modification time.
+func writeBuild(t *testing.T, storeDir, kind string, n int, statuses map[string]string, complete bool, modTime time.Time) {
+	buildDir := filepath.Join(storeDir, "v0", kind, fmt.Sprintf("%d", n))
+	partDir := filepath.Join(buildDir, "linux", "amd64", "vanadium-go-test", "0")
+	if err := os.MkdirAll(partDir, os.FileMode(0755)); err != nil {
+		t.Fatalf("%v", err)
+	}
+	report := `<?xml version="1.0" encoding="utf-8"?>
+<testsuites>
+  <testsuite name="v.io/x/ref">
+`
+	for _, name := range []string{"TestA", "TestB", "TestC"} {
+		switch statuses[name] {
+		case runFailed:
+			report += fmt.Sprintf(`    <testcase classname="v.io/x/ref" name="%s" time="2.00"><failure message="error">failed</failure></testcase>
+`, name)
+		case runSkipped:
+			report += fmt.Sprintf(`    <testcase classname="v.io/x/ref" name="%s" time="0"><skipped>skipped</skipped></testcase>
+`, name)
+		default:
+			report += fmt.Sprintf(`    <testcase classname="v.io/x/ref" name="%s" time="%d.00"></testcase>
+`, name, n)
+		}
+	}
+	report += `  </testsuite>
+</testsuites>
+`
+	if err := ioutil.WriteFile(filepath.Join(partDir, "xunit.xml"), []byte(report), os.FileMode(0644)); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if !complete {
+		return
+	}
+	tarDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(tarDir)
+	tarFile := filepath.Join(tarDir, "results.tar.gz")
+	cmd := exec.Command("tar", "-zcf", tarFile, "-C", filepath.Dir(buildDir), filepath.Base(buildDir))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+	if err := os.Rename(tarFile, filepath.Join(buildDir, "results.tar.gz")); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := os.Chtimes(filepath.Join(buildDir, "results.tar.gz"), modTime, modTime); err != nil {
+		t.Fatalf("%v", err)
+	}
+}
+
+func TestTestIndex(t *testing.T) {
+	jirix, cleanup := jiritest.NewX(t)
+	defer cleanup()
+
+	// Fetching results changes the working directory.
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.Chdir(cwd)
+
+	storeDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(storeDir)
+	oldIndexDir, oldCache := indexDirFlag, cacheFlag
+	indexDirFlag, cacheFlag = filepath.Join(jirix.Root, "index"), ""
+	defer func() { indexDirFlag, cacheFlag = oldIndexDir, oldCache }()
+
+	now := time.Date(2015, time.October, 16, 12, 0, 0, 0, time.UTC)
+	builds := []map[string]string{
+		{"TestA": runPassed, "TestB": runPassed, "TestC": runSkipped},
+		{"TestA": runFailed, "TestB": runPassed, "TestC": runSkipped},
+		{"TestA": runPassed, "TestB": runFailed, "TestC": runSkipped},
+		{"TestA": runPassed, "TestB": runFailed, "TestC": runSkipped},
+	}
+	for i, statuses := range builds {
+		writeBuild(t, storeDir, "postsubmit", i+1, statuses, true, now.Add(time.Duration(i-len(builds))*time.Hour))
+	}
+	// Presubmit runs test other changes: their failures are not flakes
+	// and do not interrupt ongoing failures.
+	writeBuild(t, storeDir, "presubmit", 7, map[string]string{"TestA": runFailed, "TestB": runPassed, "TestC": runSkipped}, true, now.Add(-90*time.Minute))
+	// Build 5 is still running.
+	writeBuild(t, storeDir, "postsubmit", 5, map[string]string{"TestA": runFailed}, false, time.Time{})
+
+	index := newTestIndex(indexDirFlag, blobstore.NewLocal(storeDir))
+	index.now = func() time.Time { return now }
+	// Updating the index again does not change it.
+	for i := 0; i < 2; i++ {
+		if err := index.update(jirix); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+
+	history, err := testHistoryModel(jirix, "v.io/x/ref/TestA")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if got, want := len(history.Runs), 5; got != want {
+		t.Fatalf("got %v runs, want %v", got, want)
+	}
+	for _, run := range history.Runs {
+		if got, want := run.Flaky, run.Kind == "postsubmit" && run.Build == 2; got != want {
+			t.Fatalf("%v build %v: got flaky %v, want %v", run.Kind, run.Build, got, want)
+		}
+	}
+	if history.NumRuns != 4 || history.Flakes != 1 || history.FlakeRate != 25 || history.FailingSince != nil {
+		t.Fatalf("got %#v", history)
+	}
+	if got, want := history.DurationPoints, "0.0,45.0 150.0,30.0 300.0,15.0 450.0,30.0 600.0,0.0"; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	history, err = testHistoryModel(jirix, "v.io/x/ref/TestB")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if history.FailingSince == nil || history.FailingSince.Kind != "postsubmit" || history.FailingSince.Build != 3 || history.Flakes != 0 {
+		t.Fatalf("got %#v", history)
+	}
+	history, err = testHistoryModel(jirix, "v.io/x/ref/TestC")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if history.NumRuns != 0 || len(history.Runs) != 5 || history.Runs[0].Status != runSkipped {
+		t.Fatalf("got %#v", history)
+	}
+
+	flaky, err := flakyModel(jirix)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	want := &flakyData{
+		Time:  now.Unix(),
+		Since: now.Add(-flakyWindow).Unix(),
+		Tests: []flakyTest{{Name: "v.io/x/ref/TestA", Flakes: 1, Runs: 4, Rate: 25}},
+	}
+	if !reflect.DeepEqual(flaky, want) {
+		t.Fatalf("got %#v, want %#v", flaky, want)
+	}
+
+	// Builds are indexed once they complete.
+	writeBuild(t, storeDir, "postsubmit", 5, map[string]string{"TestA": runFailed, "TestB": runPassed}, true, now)
+	if err := index.update(jirix); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if history, err = testHistoryModel(jirix, "v.io/x/ref/TestB"); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if got, want := len(history.Runs), 6; got != want || history.FailingSince != nil {
+		t.Fatalf("got %v runs, want %v: %#v", got, want, history)
+	}
+	if _, err := testHistoryModel(jirix, "v.io/x/ref/TestD"); err == nil {
+		t.Fatalf("got the history of an unknown test")
+	}
+
+	// Check the pages.
+	pages := map[string]string{
+		"/test?name=v.io/x/ref/TestB": `<tr><td>Runs</td><td>5</td></tr>`,
+		"/flaky":                      `<a href="/test?name=v.io%2Fx%2Fref%2FTestA">v.io/x/ref/TestA</a>`,
+	}
+	for url, want := range pages {
+		r, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		w := httptest.NewRecorder()
+		historyHelper(jirix, w, r)
+		if got := w.Code; got != http.StatusOK {
+			t.Fatalf("%v: got status %v, want %v", url, got, http.StatusOK)
+		}
+		if body := w.Body.String(); !strings.Contains(body, want) {
+			t.Fatalf("%v: %q not found in:\n%v", url, want, body)
+		}
+	}
+}
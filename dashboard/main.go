@@ -13,6 +13,7 @@ import (
 
 	"v.io/jiri"
 	"v.io/jiri/tool"
+	"v.io/x/devtools/internal/blobstore"
 	"v.io/x/lib/cmdline"
 )
 
@@ -25,8 +26,8 @@ var (
 )
 
 func init() {
-	cmdDashboard.Flags.StringVar(&resultsBucketFlag, "results-bucket", resultsBucket, "Google Storage bucket to use for fetching test results.")
-	cmdDashboard.Flags.StringVar(&statusBucketFlag, "status-bucket", statusBucket, "Google Storage bucket to use for fetching service status data.")
+	cmdDashboard.Flags.StringVar(&resultsBucketFlag, "results-bucket", resultsBucket, "Google Storage bucket, or local directory with the same layout, to use for fetching test results.")
+	cmdDashboard.Flags.StringVar(&statusBucketFlag, "status-bucket", statusBucket, "Google Storage bucket, or local directory with the same layout, to use for fetching service status data.")
 	cmdDashboard.Flags.StringVar(&cacheFlag, "cache", "", "Directory to use for caching files.")
 	cmdDashboard.Flags.StringVar(&staticDirFlag, "static", "", "Directory to use for serving static files.")
 	cmdDashboard.Flags.IntVar(&portFlag, "port", 8000, "Port for the server.")
@@ -50,6 +51,16 @@ func helper(jirix *jiri.X, w http.ResponseWriter, r *http.Request) {
 		// The presubmit test results data never changes, cache it in
 		// the clients for up to 30 days.
 		w.Header().Set("Cache-control", "public, max-age=2592000")
+	case "output":
+		if err := displayOutputPage(jirix, w, r); err != nil {
+			respondWithError(jirix, err, w)
+			return
+		}
+	case "search":
+		if err := displaySearchPage(jirix, w, r); err != nil {
+			respondWithError(jirix, err, w)
+			return
+		}
 	case "":
 		if err := displayServiceStatusPage(jirix, w, r); err != nil {
 			respondWithError(jirix, err, w)
@@ -81,7 +92,32 @@ var cmdDashboard = &cmdline.Command{
 	Runner: cmdline.RunnerFunc(runDashboard),
 	Name:   "dashboard",
 	Short:  "Runs the Vanadium dashboard web server",
-	Long:   "Command dashboard runs the Vanadium dashboard web server.",
+	Long: `
+Command dashboard runs the Vanadium dashboard web server.
+
+Besides the HTML pages, the server provides a JSON API that returns the data of
+the pages: the status of the services (/api/v1/status), the summary of presubmit
+run <n> (/api/v1/presubmit/<n>), and the details of its jobs
+(/api/v1/presubmit/<n>/job/<job>) and test cases
+(/api/v1/presubmit/<n>/job/<job>/test), which take the same query parameters as
+the corresponding HTML pages.
+
+The /test?name=<name> page shows the history of a test case across presubmit and
+postsubmit builds, including its flake rate, and the /flaky page shows the tests
+that were flaky most often in the last week. These pages are served from an
+index of the stored xUnit reports, which the server updates in the background.
+The JSON API returns their data from /api/v1/test?name=<name> and /api/v1/flaky.
+
+The console output of each part of a job is shown a page at a time, with links
+to the previous and next pages, and can be searched across all parts of the job.
+Search results, which the JSON API returns from
+/api/v1/presubmit/<n>/job/<job>/search?q=<query>, link to the matching lines.
+
+If -probe-results is set, the status page and /api/v1/status are computed from
+the results of the production services test of the last 30 days: the incidents
+of each service, its uptime over 7 and 30 days, and the rates at which it burns
+the error budget of its availability target over 1 hour, 6 hours and 3 days.
+`,
 }
 
 func runDashboard(env *cmdline.Env, args []string) error {
@@ -89,6 +125,9 @@ func runDashboard(env *cmdline.Env, args []string) error {
 	if err != nil {
 		return err
 	}
+	if probeResultsFlag != "" && cacheFlag == "" {
+		return jirix.UsageErrorf("-probe-results requires -cache")
+	}
 	handler := func(w http.ResponseWriter, r *http.Request) {
 		helper(jirix, w, r)
 	}
@@ -99,6 +138,17 @@ func runDashboard(env *cmdline.Env, args []string) error {
 	http.Handle("/static/", http.StripPrefix("/static/", staticHandler))
 	http.Handle("/favicon.ico", staticHandler)
 	http.HandleFunc("/health", health)
+	http.HandleFunc(apiPrefix, func(w http.ResponseWriter, r *http.Request) {
+		apiHelper(jirix, w, r)
+	})
+	history := func(w http.ResponseWriter, r *http.Request) {
+		historyHelper(jirix, w, r)
+	}
+	http.HandleFunc("/test", history)
+	http.HandleFunc("/flaky", history)
+	if indexDirFlag != "" {
+		go newTestIndex(indexDirFlag, blobstore.New(resultsBucketFlag)).run(jirix, indexIntervalFlag)
+	}
 	http.HandleFunc("/", handler)
 	if err := http.ListenAndServe(fmt.Sprintf(":%d", portFlag), loggingHandler(jirix, http.DefaultServeMux)); err != nil {
 		return fmt.Errorf("ListenAndServer() failed: %v", err)
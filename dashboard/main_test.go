@@ -0,0 +1,159 @@
+// Copyright 2015 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"v.io/jiri/jiritest"
+)
+
+// setupFixtures points the dashboard at the results and status data in
+// the testdata directory and at a new cache directory, returning a
+// function that restores the original flags.
+func setupFixtures(t *testing.T) func() {
+	testdata, err := filepath.Abs("testdata")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	cacheDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	oldResultsBucket, oldStatusBucket, oldCache := resultsBucketFlag, statusBucketFlag, cacheFlag
+	resultsBucketFlag = filepath.Join(testdata, "results")
+	statusBucketFlag = filepath.Join(testdata, "status")
+	cacheFlag = cacheDir
+	return func() {
+		resultsBucketFlag, statusBucketFlag, cacheFlag = oldResultsBucket, oldStatusBucket, oldCache
+		os.RemoveAll(cacheDir)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	jirix, cleanup := jiritest.NewX(t)
+	defer cleanup()
+	defer setupFixtures(t)()
+
+	tests := []struct {
+		url     string
+		code    int
+		want    []string
+		notWant []string
+	}{
+		// Service status page.
+		{
+			url:     "/",
+			code:    http.StatusOK,
+			want:    []string{"MOUNTTABLE", "Snapshot: snapshot-1", "PROXY", "Built at: N/A", "service-history-item serviceStatusDown"},
+			notWant: []string{"BINARY REPOSITORY"},
+		},
+		// Presubmit summary page.
+		{
+			url:  "/?type=presubmit&n=1234",
+			code: http.StatusOK,
+			want: []string{
+				"Presubmit #1234 Summary",
+				">vanadium-go-build</a>",
+				">vanadium-go-test</a>",
+				">v.io/x/ref/services/TestServer</a>",
+				"wall time 2m0s, CPU time 5m0s, peak RSS 100.0 MB",
+			},
+			notWant: []string{"TestLib", "TestClient"},
+		},
+		// Job detail page, with links to the output of all parts.
+		{
+			url:  "/?type=presubmit&n=1234&os=linux&arch=amd64&job=vanadium-go-test",
+			code: http.StatusOK,
+			want: []string{
+				"Presubmit #1234 Job Details",
+				`<a target="_blank" href="index.html?type=output&n=1234&arch=amd64&os=linux&job=vanadium-go-test&part=0">Part 0</a>`,
+				`<a target="_blank" href="index.html?type=output&n=1234&arch=amd64&os=linux&job=vanadium-go-test&part=1">Part 1</a>`,
+				">v.io/x/ref/services/TestServer</a>",
+			},
+			notWant: []string{"v.io/x/ref/lib 1.0s"},
+		},
+		// Output page.
+		{
+			url:     "/?type=output&n=1234&os=linux&arch=amd64&job=vanadium-go-test&part=1",
+			code:    http.StatusOK,
+			want:    []string{"Presubmit #1234 Console Output", `<span id="L0">FAIL v.io/x/ref/services 2.0s` + "\n</span>"},
+			notWant: []string{"Previous Page", "Next Page"},
+		},
+		{
+			url:     "/?type=output&n=1234&os=linux&arch=amd64&job=vanadium-go-test&part=0&offset=3",
+			code:    http.StatusOK,
+			want:    []string{"Previous Page"},
+			notWant: []string{"<span id="},
+		},
+		{
+			url:  "/?type=output&n=1234&os=linux&arch=amd64&job=vanadium-go-test",
+			code: http.StatusInternalServerError,
+		},
+		// Search page.
+		{
+			url:     "/?type=search&n=1234&os=linux&arch=amd64&job=vanadium-go-test&q=services",
+			code:    http.StatusOK,
+			want:    []string{"1 matches", "Part 1, line 1", "#L0"},
+			notWant: []string{"v.io/x/ref/lib"},
+		},
+		// Test detail page.
+		{
+			url:  "/?type=presubmit&n=1234&os=linux&arch=amd64&job=vanadium-go-test&part=1&suite=v.io/x/ref/services&class=v.io/x/ref/services&test=TestServer",
+			code: http.StatusOK,
+			want: []string{"Presubmit #1234 Test Details", "server_test.go:42: unexpected error: connection refused"},
+		},
+		// Unknown presubmit run.
+		{
+			url:  "/?type=presubmit&n=4321",
+			code: http.StatusInternalServerError,
+		},
+		// Missing and unsafe parameters.
+		{
+			url:  "/?type=presubmit",
+			code: http.StatusInternalServerError,
+		},
+		{
+			url:  "/?type=presubmit&n=1234&os=linux&arch=amd64&job=../../1234",
+			code: http.StatusInternalServerError,
+		},
+		// Unknown page type.
+		{
+			url:  "/?type=postsubmit",
+			code: http.StatusNotFound,
+		},
+	}
+	// Serve each page twice, the second time from the cache.
+	for i := 0; i < 2; i++ {
+		for _, test := range tests {
+			r, err := http.NewRequest("GET", test.url, nil)
+			if err != nil {
+				t.Fatalf("%v", err)
+			}
+			w := httptest.NewRecorder()
+			helper(jirix, w, r)
+			if got, want := w.Code, test.code; got != want {
+				t.Fatalf("%v: got status %v, want %v\n%v", test.url, got, want, w.Body.String())
+			}
+			body := w.Body.String()
+			for _, want := range test.want {
+				if !strings.Contains(body, want) {
+					t.Fatalf("%v: %q not found in:\n%v", test.url, want, body)
+				}
+			}
+			for _, notWant := range test.notWant {
+				if strings.Contains(body, notWant) {
+					t.Fatalf("%v: unexpected %q in:\n%v", test.url, notWant, body)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,412 @@
+// Copyright 2015 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"v.io/jiri"
+	"v.io/jiri/runutil"
+)
+
+const (
+	// outputPageSize is the number of bytes of console output that a
+	// page shows, rounded up to whole lines.
+	outputPageSize = 1 << 20
+	// outputChunkSize is the number of bytes of converted console output
+	// after which it is flushed to the client.
+	outputChunkSize = 64 << 10
+	// maxSearchMatches is the maximum number of matches that a search of
+	// console outputs returns.
+	maxSearchMatches = 1000
+	// maxSearchContext is the maximum number of lines of context that a
+	// search of console outputs returns around each match.
+	maxSearchContext = 10
+	// defaultSearchContext is the number of lines of context that a
+	// search of console outputs returns by default.
+	defaultSearchContext = 2
+)
+
+// outputURL returns the URL of the page of the console output of the
+// given part of a job that starts at the given offset.
+func outputURL(n, osName, arch, job, part string, offset int64) string {
+	values := url.Values{}
+	values.Set("type", "output")
+	values.Set("n", n)
+	values.Set("os", osName)
+	values.Set("arch", arch)
+	values.Set("job", job)
+	values.Set("part", part)
+	values.Set("offset", fmt.Sprintf("%d", offset))
+	return "/index.html?" + values.Encode()
+}
+
+// outputPageData describes a page of the console output of a part of a
+// job. Offsets are in bytes.
+type outputPageData struct {
+	Number string
+	OSName string
+	Arch   string
+	Job    string
+	Part   string
+	Size   int64
+	Offset int64
+	// PrevURL and NextURL are the URLs of the previous and next pages,
+	// if any.
+	PrevURL string
+	NextURL string
+}
+
+var outputHeaderTemplate = template.Must(template.New("outputHeader").Funcs(templateFuncMap).Parse(`
+<!DOCTYPE html>
+<html>
+<head>
+	<title>Presubmit #{{ .Number }} Console Output</title>
+	<link rel="stylesheet" href="/static/dashboard.css">
+</head>
+<body>
+<h1>Presubmit #{{ .Number }} Console Output</h1>
+<table class="param-table">
+	<tr><th class="param-table-name-col"></th><th></th></tr>
+	<tr><td>OS</td><td>{{ .OSName }}</td></tr>
+	<tr><td>Arch</td><td>{{ .Arch }}</td></tr>
+	<tr><td>Job</td><td>{{ .Job }}</td></tr>
+	<tr><td>Part</td><td>{{ .Part }}</td></tr>
+</table>
+<br>
+<a href="index.html?type=presubmit&n={{ .Number }}&arch={{ .Arch }}&os={{ .OSName }}&job={{ .Job }}">Back to Job</a>
+<div class="label-usage">Showing from byte {{ .Offset }} of {{ formatBytes .Size }}</div>
+{{ if .PrevURL }}<a href="{{ .PrevURL }}">Previous Page</a>{{ end }}
+<pre>`))
+
+var outputFooterTemplate = template.Must(template.New("outputFooter").Parse(`</pre>
+{{ if .NextURL }}<a href="{{ .NextURL }}">Next Page</a>{{ end }}
+</body>
+</html>
+`))
+
+// displayOutputPage streams a page of the console output of a part of a
+// job to the client.
+func displayOutputPage(jirix *jiri.X, w http.ResponseWriter, r *http.Request) error {
+	data := outputPageData{
+		Number: r.Form.Get("n"),
+		OSName: r.Form.Get("os"),
+		Arch:   r.Form.Get("arch"),
+		Job:    r.Form.Get("job"),
+		Part:   r.Form.Get("part"),
+	}
+	if data.OSName == "" || data.Arch == "" || data.Job == "" || data.Part == "" {
+		return fmt.Errorf("invalid combination of parameters")
+	}
+	if offset := r.Form.Get("offset"); offset != "" {
+		var err error
+		if data.Offset, err = strconv.ParseInt(offset, 10, 64); err != nil || data.Offset < 0 {
+			return fmt.Errorf("invalid offset %q", offset)
+		}
+	}
+	return withResults(jirix, data.Number, func(resultsDir string) error {
+		file, err := jirix.NewSeq().Open(filepath.Join(resultsDir, data.OSName, data.Arch, data.Job, data.Part, "output"))
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		fileInfo, err := file.Stat()
+		if err != nil {
+			return fmt.Errorf("Stat(%v) failed: %v", file.Name(), err)
+		}
+		data.Size = fileInfo.Size()
+		if data.Offset > data.Size {
+			data.Offset = data.Size
+		}
+		if data.Offset > 0 {
+			prev := data.Offset - outputPageSize
+			if prev < 0 {
+				prev = 0
+			}
+			data.PrevURL = outputURL(data.Number, data.OSName, data.Arch, data.Job, data.Part, prev)
+		}
+		if err := outputHeaderTemplate.Execute(w, data); err != nil {
+			return fmt.Errorf("Execute() failed: %v", err)
+		}
+		next, err := streamOutput(w, file, data.Offset, outputPageSize)
+		if err != nil {
+			return err
+		}
+		if next < data.Size {
+			data.NextURL = outputURL(data.Number, data.OSName, data.Arch, data.Job, data.Part, next)
+		}
+		if err := outputFooterTemplate.Execute(w, data); err != nil {
+			return fmt.Errorf("Execute() failed: %v", err)
+		}
+		return nil
+	})
+}
+
+// streamOutput writes the lines of the given console output that start
+// in the given number of bytes from the given offset to the given
+// writer, converting them to HTML and flushing them to the client in
+// chunks. Each line is anchored by its offset ("L<offset>"), and a
+// partial line at the offset is skipped. It returns the offset of the
+// line after the last line written.
+func streamOutput(w io.Writer, file *os.File, offset, size int64) (int64, error) {
+	if offset > 0 {
+		// Start at the beginning of the next line, unless the offset is
+		// at the beginning of a line.
+		offset--
+	}
+	if _, err := file.Seek(offset, 0); err != nil {
+		return 0, fmt.Errorf("Seek(%v) failed: %v", offset, err)
+	}
+	reader := bufio.NewReader(file)
+	if offset > 0 {
+		line, err := reader.ReadString('\n')
+		offset += int64(len(line))
+		if err != nil {
+			if err == io.EOF {
+				return offset, nil
+			}
+			return 0, fmt.Errorf("ReadString() failed: %v", err)
+		}
+	}
+	end, chunk := offset+size, 0
+	for offset < end {
+		line, err := reader.ReadString('\n')
+		if len(line) > 0 {
+			html, _ := ansiColorsToHTML(line)
+			n, werr := fmt.Fprintf(w, `<span id="L%d">%s</span>`, offset, html)
+			if werr != nil {
+				return 0, werr
+			}
+			offset += int64(len(line))
+			if chunk += n; chunk >= outputChunkSize {
+				if flusher, ok := w.(http.Flusher); ok {
+					flusher.Flush()
+				}
+				chunk = 0
+			}
+		}
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return 0, fmt.Errorf("ReadString() failed: %v", err)
+		}
+	}
+	return offset, nil
+}
+
+// searchMatch describes a line of the console output of a part of a job
+// that matches a search.
+type searchMatch struct {
+	Part string
+	// Line is the number of the line, starting at 1.
+	Line int
+	// Offset is the offset of the line in bytes.
+	Offset int64
+	Before []string
+	Text   string
+	After  []string
+	// URL is the URL of the output page that shows the line with its
+	// context.
+	URL string
+}
+
+// searchData describes the matches of a search of the console outputs of
+// all parts of a job.
+type searchData struct {
+	Number  string
+	OSName  string
+	Arch    string
+	Job     string
+	Query   string
+	Matches []searchMatch
+	// Truncated indicates that only the first maxSearchMatches
+	// matches are returned.
+	Truncated bool
+}
+
+var searchTemplate = template.Must(template.New("search").Funcs(templateFuncMap).Parse(`
+<!DOCTYPE html>
+<html>
+<head>
+	<title>Presubmit #{{ .Number }} Console Output Search</title>
+	<link rel="stylesheet" href="/static/dashboard.css">
+</head>
+<body>
+<h1>Presubmit #{{ .Number }} Console Output Search</h1>
+<table class="param-table">
+	<tr><th class="param-table-name-col"></th><th></th></tr>
+	<tr><td>OS</td><td>{{ .OSName }}</td></tr>
+	<tr><td>Arch</td><td>{{ .Arch }}</td></tr>
+	<tr><td>Job</td><td>{{ .Job }}</td></tr>
+</table>
+<br>
+<a href="index.html?type=presubmit&n={{ .Number }}&arch={{ .Arch }}&os={{ .OSName }}&job={{ .Job }}">Back to Job</a>
+<h2>{{ len .Matches }}{{ if .Truncated }}+{{ end }} matches</h2>
+{{ range $match := .Matches }}
+<div class="search-match">
+	<a target="_blank" href="{{ $match.URL }}">Part {{ $match.Part }}, line {{ $match.Line }}</a>
+	<pre>{{ range $line := $match.Before }}{{ colors $line }}
+{{ end }}<span class="search-match-line">{{ colors $match.Text }}</span>
+{{ range $line := $match.After }}{{ colors $line }}
+{{ end }}</pre>
+</div>
+{{ end }}
+</body>
+</html>
+`))
+
+// searchModel searches the console outputs of all parts of the given job
+// for lines that contain the given query, returning the given number of
+// lines of context around each match.
+func searchModel(jirix *jiri.X, n, osName, arch, job, query string, context int) (*searchData, error) {
+	if query == "" {
+		return nil, badRequest("required parameter %q not found", "q")
+	}
+	if context < 0 || context > maxSearchContext {
+		return nil, badRequest("the context must be between 0 and %d lines", maxSearchContext)
+	}
+	data := searchData{
+		Number:  n,
+		OSName:  osName,
+		Arch:    arch,
+		Job:     job,
+		Query:   query,
+		Matches: []searchMatch{},
+	}
+	if err := withResults(jirix, n, func(resultsDir string) error {
+		jobDir := filepath.Join(resultsDir, osName, arch, job)
+		partFileInfos, err := jirix.NewSeq().ReadDir(jobDir)
+		if err != nil {
+			return err
+		}
+		parts := []string{}
+		for _, partFileInfo := range partFileInfos {
+			parts = append(parts, partFileInfo.Name())
+		}
+		sort.Strings(parts)
+		for _, part := range parts {
+			matches, truncated, err := searchOutput(jirix, filepath.Join(jobDir, part, "output"), query, context, maxSearchMatches-len(data.Matches))
+			if err != nil {
+				return err
+			}
+			for _, match := range matches {
+				match.Part = part
+				pageOffset := match.Offset
+				for _, line := range match.Before {
+					pageOffset -= int64(len(line)) + 1
+				}
+				match.URL = outputURL(n, osName, arch, job, part, pageOffset) + fmt.Sprintf("#L%d", match.Offset)
+				data.Matches = append(data.Matches, match)
+			}
+			if truncated {
+				data.Truncated = true
+				break
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+// searchOutput returns the lines of the given console output that
+// contain the given query, up to the given number of matches, with the
+// given number of lines of context. It also returns whether there were
+// more matches. A missing output has no matches.
+func searchOutput(jirix *jiri.X, path, query string, context, maxMatches int) ([]searchMatch, bool, error) {
+	file, err := jirix.NewSeq().Open(path)
+	if err != nil {
+		if runutil.IsNotExist(err) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	defer file.Close()
+	reader := bufio.NewReader(file)
+	matches, before := []searchMatch{}, []string{}
+	// pending holds the indexes of the matches that still need lines
+	// of context after them.
+	pending := []int{}
+	var offset int64
+	for lineNumber := 1; ; lineNumber++ {
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, false, fmt.Errorf("ReadString() failed: %v", err)
+		}
+		if len(line) == 0 {
+			break
+		}
+		text := strings.TrimSuffix(line, "\n")
+		remaining := []int{}
+		for _, i := range pending {
+			matches[i].After = append(matches[i].After, text)
+			if len(matches[i].After) < context {
+				remaining = append(remaining, i)
+			}
+		}
+		pending = remaining
+		if strings.Contains(text, query) {
+			if len(matches) == maxMatches {
+				return matches, true, nil
+			}
+			matches = append(matches, searchMatch{
+				Line:   lineNumber,
+				Offset: offset,
+				Before: append([]string{}, before...),
+				Text:   text,
+				After:  []string{},
+			})
+			if context > 0 {
+				pending = append(pending, len(matches)-1)
+			}
+		}
+		if context > 0 {
+			if before = append(before, text); len(before) > context {
+				before = before[1:]
+			}
+		}
+		offset += int64(len(line))
+		if err == io.EOF {
+			break
+		}
+	}
+	return matches, false, nil
+}
+
+// displaySearchPage displays the matches of a search of the console
+// outputs of all parts of a job.
+func displaySearchPage(jirix *jiri.X, w http.ResponseWriter, r *http.Request) error {
+	context := defaultSearchContext
+	if value := r.Form.Get("context"); value != "" {
+		var err error
+		if context, err = strconv.Atoi(value); err != nil {
+			return fmt.Errorf("invalid context %q", value)
+		}
+	}
+	osName, arch, job := r.Form.Get("os"), r.Form.Get("arch"), r.Form.Get("job")
+	if osName == "" || arch == "" || job == "" {
+		return fmt.Errorf("invalid combination of parameters")
+	}
+	data, err := searchModel(jirix, r.Form.Get("n"), osName, arch, job, r.Form.Get("q"), context)
+	if err != nil {
+		return err
+	}
+	if err := searchTemplate.Execute(w, data); err != nil {
+		return fmt.Errorf("Execute() failed: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+// Copyright 2015 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"v.io/jiri/jiritest"
+)
+
+func TestStreamOutput(t *testing.T) {
+	jirix, cleanup := jiritest.NewX(t)
+	defer cleanup()
+
+	path := filepath.Join(jirix.Root, "output")
+	output := "line 0\n\x1b[0;31mline 1\x1b[0m\nline <2>\nline 3"
+	if err := ioutil.WriteFile(path, []byte(output), os.FileMode(0644)); err != nil {
+		t.Fatalf("%v", err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer file.Close()
+
+	tests := []struct {
+		offset, size int64
+		want         string
+		next         int64
+	}{
+		// Pages are rounded up to whole lines.
+		{0, 1, "<span id=\"L0\">line 0\n</span>", 7},
+		{7, 19, "<span id=\"L7\">\x1b<font style=\"color:red\">line 1\x1b</font>\n</span><span id=\"L25\">line &lt;2&gt;\n</span>", 34},
+		// Partial lines at the offset are skipped.
+		{3, 5, "<span id=\"L7\">\x1b<font style=\"color:red\">line 1\x1b</font>\n</span>", 25},
+		{30, 100, "<span id=\"L34\">line 3</span>", 40},
+		{40, 100, "", 40},
+	}
+	for _, test := range tests {
+		var out bytes.Buffer
+		next, err := streamOutput(&out, file, test.offset, test.size)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		if got := out.String(); got != test.want || next != test.next {
+			t.Fatalf("offset %v: got %q, %v, want %q, %v", test.offset, got, next, test.want, test.next)
+		}
+	}
+}
+
+func TestSearchOutput(t *testing.T) {
+	jirix, cleanup := jiritest.NewX(t)
+	defer cleanup()
+
+	path := filepath.Join(jirix.Root, "output")
+	lines := []string{}
+	for i := 0; i < 10; i++ {
+		lines = append(lines, fmt.Sprintf("line %d", i))
+	}
+	lines[2] += " FAIL"
+	lines[3] += " FAIL"
+	lines[9] += " FAIL"
+	if err := ioutil.WriteFile(path, []byte(strings.Join(lines, "\n")), os.FileMode(0644)); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	matches, truncated, err := searchOutput(jirix, path, "FAIL", 2, maxSearchMatches)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	want := []searchMatch{
+		{Line: 3, Offset: 14, Before: []string{"line 0", "line 1"}, Text: "line 2 FAIL", After: []string{"line 3 FAIL", "line 4"}},
+		{Line: 4, Offset: 26, Before: []string{"line 1", "line 2 FAIL"}, Text: "line 3 FAIL", After: []string{"line 4", "line 5"}},
+		{Line: 10, Offset: 73, Before: []string{"line 7", "line 8"}, Text: "line 9 FAIL", After: []string{}},
+	}
+	if truncated || !reflect.DeepEqual(matches, want) {
+		t.Fatalf("got %#v, %v, want %#v", matches, truncated, want)
+	}
+
+	matches, truncated, err = searchOutput(jirix, path, "FAIL", 0, 1)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	want = []searchMatch{{Line: 3, Offset: 14, Before: []string{}, Text: "line 2 FAIL", After: []string{}}}
+	if !truncated || !reflect.DeepEqual(matches, want) {
+		t.Fatalf("got %#v, %v, want %#v", matches, truncated, want)
+	}
+
+	if matches, _, err := searchOutput(jirix, filepath.Join(jirix.Root, "missing"), "FAIL", 2, maxSearchMatches); err != nil || len(matches) != 0 {
+		t.Fatalf("got %v, %v, want no matches", matches, err)
+	}
+}
@@ -13,6 +13,7 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"regexp"
 	"sort"
@@ -22,24 +23,28 @@ import (
 	"v.io/jiri"
 	"v.io/jiri/collect"
 	"v.io/jiri/runutil"
+	"v.io/x/devtools/internal/blobstore"
 	"v.io/x/devtools/internal/cache"
 	"v.io/x/devtools/internal/test"
 	"v.io/x/devtools/internal/xunit"
 )
 
 const (
-	resultsBucket       = "gs://vanadium-test-results"
-	multiPartOutputNote = `
-###################################################################################
-THIS TEST HAS BEEN DIVIDED INTO MULTIPLE PARTS THAT HAVE BEEN EXECUTED IN PARALLEL.
-THE OUTPUT BELOW IS THE CONCATENATION OF THOSE OUTPUTS.
-###################################################################################
-`
+	resultsBucket = "gs://vanadium-test-results"
 )
 
 type summaryData struct {
 	Number string
 	OSJobs []osJobs
+	// TopJobs lists the jobs with the highest resource usage.
+	TopJobs []topJob
+}
+
+type topJob struct {
+	Name   string
+	OSName string
+	Arch   string
+	Usage  string
 }
 
 type osJobs struct {
@@ -54,6 +59,9 @@ type job struct {
 	HasGo32BitTest bool
 	Result         bool
 	FailedTests    []failedTest
+	// Usage describes the resources used by all parts of the job, if
+	// they were measured.
+	Usage string
 }
 
 type failedTest struct {
@@ -66,9 +74,31 @@ type failedTest struct {
 type aggregatedPartsData struct {
 	result      bool
 	failedTests []failedTest
-	output      string
+	outputs     []partOutput
+	usage       *test.Usage
 }
 
+// partOutput describes the console output of a part of a job.
+type partOutput struct {
+	Part string
+	Size int64
+}
+
+// numTopJobs is the number of jobs with the highest resource usage that
+// summaries list.
+const numTopJobs = 5
+
+type jobUsage struct {
+	job   topJob
+	usage test.Usage
+}
+
+type jobUsagesByCost []jobUsage
+
+func (u jobUsagesByCost) Len() int           { return len(u) }
+func (u jobUsagesByCost) Less(i, j int) bool { return u[i].usage.CPUTime > u[j].usage.CPUTime }
+func (u jobUsagesByCost) Swap(i, j int)      { u[i], u[j] = u[j], u[i] }
+
 var go32BitTests = map[string]struct{}{
 	"vanadium-go-build": struct{}{},
 	"vanadium-go-test":  struct{}{},
@@ -84,6 +114,18 @@ var summaryTemplate = template.Must(template.New("summary").Parse(`
 </head>
 <body>
 <h1>Presubmit #{{ $n }} Summary</h1>
+{{ if gt (len .TopJobs) 0 }}
+<h2>Most Expensive Jobs</h2>
+<ol class="test-list">
+{{ range $topJob := .TopJobs }}
+	<li>
+	<a target="_blank" href="index.html?type=presubmit&n={{ $n }}&arch={{ $topJob.Arch }}&os={{ $topJob.OSName }}&job={{ $topJob.Name }}">{{ $topJob.Name }}</a>
+	<span class="label-arch">{{ $topJob.OSName }}, {{ $topJob.Arch }}</span>
+	<span class="label-usage">{{ $topJob.Usage }}</span>
+	</li>
+{{ end }}
+</ol>
+{{ end }}
 <ul>
 {{ range $osJobs := .OSJobs }}
 	<h2 class="os-label">{{ $osJobs.OSName }}</h2>
@@ -99,6 +141,9 @@ var summaryTemplate = template.Must(template.New("summary").Parse(`
 		{{ if $job.HasGo32BitTest }}
 		<span class="label-arch">{{ $job.Arch }}</span>
 		{{ end }}
+		{{ if $job.Usage }}
+		<span class="label-usage">{{ $job.Usage }}</span>
+		{{ end }}
 		{{ if gt (len $job.FailedTests) 0 }}
 			<ol class="test-list">
 			{{ range $failedTest := $job.FailedTests }}
@@ -123,7 +168,7 @@ type jobData struct {
 	Arch        string
 	PartIndex   string
 	Number      string
-	Output      string
+	Outputs     []partOutput
 	Result      bool
 	FailedTests []failedTest
 }
@@ -162,7 +207,23 @@ var jobTemplate = template.Must(template.New("job").Funcs(templateFuncMap).Parse
 </ol>
 {{ end }}
 <h2>Console Output:</h2>
-<pre>{{ colors .Output }}</pre>
+<form action="index.html">
+	<input type="hidden" name="type" value="search">
+	<input type="hidden" name="n" value="{{ $n }}">
+	<input type="hidden" name="os" value="{{ $osName }}">
+	<input type="hidden" name="arch" value="{{ $arch }}">
+	<input type="hidden" name="job" value="{{ $jobName }}">
+	<input type="text" name="q" placeholder="Search the output of all parts">
+	<input type="submit" value="Search">
+</form>
+<ul class="test-list2">
+{{ range $output := .Outputs }}
+	<li>
+		<a target="_blank" href="index.html?type=output&n={{ $n }}&arch={{ $arch }}&os={{ $osName }}&job={{ $jobName }}&part={{ $output.Part }}">Part {{ $output.Part }}</a>
+		<span class="label-usage">{{ formatBytes $output.Size }}</span>
+	</li>
+{{ end }}
+</ul>
 </body>
 </html>
 `))
@@ -219,7 +280,8 @@ var testTemplate = template.Must(template.New("test").Funcs(templateFuncMap).Par
 `))
 
 var templateFuncMap = template.FuncMap{
-	"colors": ansiColorsToHTML,
+	"colors":      ansiColorsToHTML,
+	"formatBytes": test.FormatBytes,
 }
 
 type ansiColor struct {
@@ -258,24 +320,86 @@ var (
 	}
 )
 
+// ansiColorREs holds the regular expressions that match the text colored
+// by ansiColors. Colored text does not span lines, so that outputs can be
+// converted line by line.
+var ansiColorREs = func() []*regexp.Regexp {
+	res := []*regexp.Regexp{}
+	for _, ansi := range ansiColors {
+		res = append(res, regexp.MustCompile(fmt.Sprintf(`\[0;%sm(.*)\[0m`, ansi.code)))
+	}
+	return res
+}()
+
 func ansiColorsToHTML(text string) (string, error) {
 	escapedText := html.EscapeString(text)
-	for _, ansi := range ansiColors {
-		re, err := regexp.Compile(fmt.Sprintf(`\[0;%sm(.*)\[0m`, ansi.code))
-		if err != nil {
-			return "", err
-		}
-		escapedText = re.ReplaceAllString(escapedText, fmt.Sprintf(`<font style="%s">$1</font>`, ansi.style))
+	for i, ansi := range ansiColors {
+		escapedText = ansiColorREs[i].ReplaceAllString(escapedText, fmt.Sprintf(`<font style="%s">$1</font>`, ansi.style))
 	}
 	return escapedText, nil
 }
 
-func displayPresubmitPage(jirix *jiri.X, w http.ResponseWriter, r *http.Request) (e error) {
+func displayPresubmitPage(jirix *jiri.X, w http.ResponseWriter, r *http.Request) error {
+	data, tmpl, err := presubmitModel(jirix, r.Form.Get("n"), extractParams(r.Form))
+	if err != nil {
+		return err
+	}
+	if err := tmpl.Execute(w, data); err != nil {
+		return fmt.Errorf("Execute() failed: %v", err)
+	}
+	return nil
+}
+
+// presubmitModel returns the data of the page of the results of the given
+// presubmit run that the given parameters identify, along with the
+// template that renders the page.
+func presubmitModel(jirix *jiri.X, n string, params params) (interface{}, *template.Template, error) {
+	var data interface{}
+	var tmpl *template.Template
+	if err := withResults(jirix, n, func(presubmitResultsDir string) error {
+		switch {
+		case params.arch == "" || params.osName == "" || params.job == "":
+			// Generate the summary page.
+			summary, err := params.generateSummaryData(jirix, n, presubmitResultsDir)
+			if err != nil {
+				return err
+			}
+			data, tmpl = summary, summaryTemplate
+		case params.testSuite == "":
+			// Generate the job detail page.
+			path := filepath.Join(presubmitResultsDir, params.osName, params.arch, params.job)
+			job, err := params.generateJobData(jirix, n, path)
+			if err != nil {
+				return err
+			}
+			data, tmpl = job, jobTemplate
+		case (params.testClass != "" || params.testSuite != "") && params.testCase != "":
+			// Generate the test detail page.
+			path := filepath.Join(presubmitResultsDir, params.osName, params.arch, params.job, params.partIndex)
+			test, err := params.generateTestData(jirix, n, path)
+			if err != nil {
+				return err
+			}
+			data, tmpl = test, testTemplate
+		default:
+			return fmt.Errorf("invalid combination of parameters")
+		}
+		return nil
+	}); err != nil {
+		return nil, nil, err
+	}
+	return data, tmpl, nil
+}
+
+// withResults fetches the test results of the given presubmit run to the
+// cache directory, or to a temporary directory that is removed
+// afterwards, and calls the given function with the directory of the
+// results.
+func withResults(jirix *jiri.X, n string, fn func(resultsDir string) error) (e error) {
 	// Set up the root directory.
 	root := cacheFlag
-	s := jirix.NewSeq()
 	if root == "" {
-		tmpDir, err := s.TempDir("", "")
+		tmpDir, err := jirix.NewSeq().TempDir("", "")
 		if err != nil {
 			return err
 		}
@@ -283,88 +407,69 @@ func displayPresubmitPage(jirix *jiri.X, w http.ResponseWriter, r *http.Request)
 		root = tmpDir
 	}
 
-	// Fetch the presubmit test results.
-	// The dir structure is:
-	// <root>/presubmit/<n>/<os>/<arch>/<job>/<part>/...
-	n := r.Form.Get("n")
-	presubmitCacheDir := filepath.Join(root, "presubmit")
-	presubmitTars := filepath.Join(root, "presubmitTarFiles", n)
-	if err := s.MkdirAll(presubmitCacheDir, os.FileMode(0700)).
-		MkdirAll(presubmitTars, os.FileMode(0700)).Done(); err != nil {
+	resultsDir, err := fetchResults(jirix, blobstore.New(resultsBucketFlag), root, "presubmit", n)
+	if err != nil {
 		return err
 	}
-	presubmitResultsDir := filepath.Join(presubmitCacheDir, n)
-
-	if _, err := s.Stat(presubmitResultsDir); err != nil {
-		// Try downloading the tar file first.
-		tarFile := "results.tar.gz"
-		if _, err := cache.StoreGoogleStorageFile(jirix, presubmitTars, resultsBucketFlag+"/v0/presubmit/"+n, tarFile); err == nil {
-			if err := s.
-				Chdir(presubmitTars).
-				Run("tar", "-zxf", tarFile, "-C", presubmitCacheDir).Done(); err != nil {
-				return err
-			}
-		} else {
-			_, err := cache.StoreGoogleStorageFile(jirix, presubmitCacheDir, resultsBucketFlag+"/v0/presubmit", n)
-			if err != nil {
-				return err
-			}
-		}
+	return fn(resultsDir)
+}
 
+// fetchResults fetches the test results of the given build of the given
+// kind ("presubmit" or "postsubmit") from the given store to the given
+// cache directory, unless they are already cached, and returns the
+// directory of the results.
+func fetchResults(jirix *jiri.X, store blobstore.Store, root, kind, n string) (string, error) {
+	// The dir structure is:
+	// <root>/<kind>/<n>/<os>/<arch>/<job>/<part>/...
+	s := jirix.NewSeq()
+	cacheDir := filepath.Join(root, kind)
+	tarsDir := filepath.Join(root, kind+"TarFiles", n)
+	if err := s.MkdirAll(cacheDir, os.FileMode(0700)).
+		MkdirAll(tarsDir, os.FileMode(0700)).Done(); err != nil {
+		return "", err
+	}
+	resultsDir := filepath.Join(cacheDir, n)
+	if _, err := s.Stat(resultsDir); err == nil {
+		return resultsDir, nil
 	}
 
-	params := extractParams(r)
-	switch {
-	case params.arch == "" || params.osName == "" || params.job == "":
-		// Generate the summary page.
-		data, err := params.generateSummaryData(jirix, n, presubmitResultsDir)
-		if err != nil {
-			return err
-		}
-		if err := summaryTemplate.Execute(w, data); err != nil {
-			return fmt.Errorf("Execute() failed: %v", err)
-		}
-		return nil
-	case params.testSuite == "":
-		// Generate the job detail page.
-		path := filepath.Join(presubmitResultsDir, params.osName, params.arch, params.job)
-		data, err := params.generateJobData(jirix, n, path)
-		if err != nil {
-			return err
-		}
-		if err := jobTemplate.Execute(w, data); err != nil {
-			return fmt.Errorf("Execute() failed: %v", err)
+	// Try downloading the tar file first.
+	tarFile := "results.tar.gz"
+	tarName := path.Join("v0", kind, n, tarFile)
+	if _, err := store.Stat(jirix, tarName); err == nil {
+		if _, err := cache.StoreFile(jirix, store, tarsDir, tarName); err != nil {
+			return "", err
 		}
-	case (params.testClass != "" || params.testSuite != "") && params.testCase != "":
-		// Generate the test detail page.
-		path := filepath.Join(presubmitResultsDir, params.osName, params.arch, params.job, params.partIndex)
-		data, err := params.generateTestData(jirix, n, path)
-		if err != nil {
-			return err
+		if err := s.
+			Chdir(tarsDir).
+			Run("tar", "-zxf", tarFile, "-C", cacheDir).Done(); err != nil {
+			return "", err
 		}
-		if err := testTemplate.Execute(w, data); err != nil {
-			return fmt.Errorf("Execute() failed: %v", err)
+	} else if runutil.IsNotExist(err) {
+		if _, err := cache.StoreFile(jirix, store, cacheDir, path.Join("v0", kind, n)); err != nil {
+			return "", err
 		}
-	default:
-		return fmt.Errorf("invalid combination of parameters")
+	} else {
+		return "", err
 	}
-	return nil
+	return resultsDir, nil
 }
 
-func extractParams(r *http.Request) params {
+func extractParams(values url.Values) params {
 	return params{
-		arch:      r.Form.Get("arch"),
-		job:       r.Form.Get("job"),
-		osName:    r.Form.Get("os"),
-		partIndex: r.Form.Get("part"),
-		testCase:  r.Form.Get("test"),
-		testClass: r.Form.Get("class"),
-		testSuite: r.Form.Get("suite"),
+		arch:      values.Get("arch"),
+		job:       values.Get("job"),
+		osName:    values.Get("os"),
+		partIndex: values.Get("part"),
+		testCase:  values.Get("test"),
+		testClass: values.Get("class"),
+		testSuite: values.Get("suite"),
 	}
 }
 
 func (p params) generateSummaryData(jirix *jiri.X, n, path string) (*summaryData, error) {
-	data := summaryData{n, []osJobs{}}
+	data := summaryData{n, []osJobs{}, []topJob{}}
+	usages := jobUsagesByCost{}
 	osFileInfos, err := ioutil.ReadDir(path)
 	if err != nil {
 		return nil, fmt.Errorf("ReadDir(%v) failed: %v", path, err)
@@ -394,7 +499,7 @@ func (p params) generateSummaryData(jirix *jiri.X, n, path string) (*summaryData
 				jobDir := filepath.Join(archDir, jobName)
 
 				// Aggregate job data for all its parts.
-				data, err := aggregateTestParts(jirix, jobDir, false)
+				data, err := aggregateTestParts(jirix, jobDir)
 				if err != nil {
 					return nil, err
 				}
@@ -408,6 +513,10 @@ func (p params) generateSummaryData(jirix *jiri.X, n, path string) (*summaryData
 				if _, ok := go32BitTests[jobName]; ok {
 					j.HasGo32BitTest = true
 				}
+				if data.usage != nil {
+					j.Usage = data.usage.String()
+					usages = append(usages, jobUsage{topJob{jobName, osName, arch, j.Usage}, *data.usage})
+				}
 				jobKey := fmt.Sprintf("%s-%s", jobName, arch)
 				jobKeys = append(jobKeys, jobKey)
 				jobsMap[jobKey] = j
@@ -419,11 +528,15 @@ func (p params) generateSummaryData(jirix *jiri.X, n, path string) (*summaryData
 		}
 		data.OSJobs = append(data.OSJobs, o)
 	}
+	sort.Stable(usages)
+	for i := 0; i < len(usages) && i < numTopJobs; i++ {
+		data.TopJobs = append(data.TopJobs, usages[i].job)
+	}
 	return &data, nil
 }
 
 func (p params) generateJobData(jirix *jiri.X, n, path string) (*jobData, error) {
-	data, err := aggregateTestParts(jirix, path, true)
+	data, err := aggregateTestParts(jirix, path)
 	if err != nil {
 		return nil, err
 	}
@@ -434,7 +547,7 @@ func (p params) generateJobData(jirix *jiri.X, n, path string) (*jobData, error)
 		Number:      n,
 		Result:      data.result,
 		FailedTests: data.failedTests,
-		Output:      data.output,
+		Outputs:     data.outputs,
 	}, nil
 }
 
@@ -477,7 +590,7 @@ outer:
 	return &data, nil
 }
 
-func aggregateTestParts(jirix *jiri.X, jobDir string, aggregateOutput bool) (*aggregatedPartsData, error) {
+func aggregateTestParts(jirix *jiri.X, jobDir string) (*aggregatedPartsData, error) {
 	// Read dirs for parts under the given job dir.
 	partFileInfos, err := ioutil.ReadDir(jobDir)
 	if err != nil {
@@ -488,7 +601,6 @@ func aggregateTestParts(jirix *jiri.X, jobDir string, aggregateOutput bool) (*ag
 	data := &aggregatedPartsData{
 		result: true,
 	}
-	outputs := []string{}
 	s := jirix.NewSeq()
 	for index, partFileInfo := range partFileInfos {
 		part := partFileInfo.Name()
@@ -512,6 +624,13 @@ func aggregateTestParts(jirix *jiri.X, jobDir string, aggregateOutput bool) (*ag
 		if r.Status != test.Passed {
 			data.result = false
 		}
+		if r.Usage != nil {
+			usage := *r.Usage
+			if data.usage != nil {
+				usage = data.usage.Add(usage)
+			}
+			data.usage = &usage
+		}
 
 		// Failed tests.
 		failedTests, err := parseFailedTests(jirix, partDir, index)
@@ -520,23 +639,16 @@ func aggregateTestParts(jirix *jiri.X, jobDir string, aggregateOutput bool) (*ag
 		}
 		data.failedTests = append(data.failedTests, failedTests...)
 
-		// Console output.
-		if aggregateOutput {
-			outputBytes, err := s.ReadFile(filepath.Join(partDir, "output"))
-			if err != nil {
+		// Console output, which is not read as it can be large.
+		outputInfo, err := s.Stat(filepath.Join(partDir, "output"))
+		if err != nil {
+			if !runutil.IsNotExist(err) {
 				return nil, err
 			}
-			if len(partFileInfos) > 1 {
-				outputs = append(outputs, fmt.Sprintf("#### Part %d ####\n%s", index, string(outputBytes)))
-			} else {
-				outputs = append(outputs, string(outputBytes))
-			}
+		} else {
+			data.outputs = append(data.outputs, partOutput{part, outputInfo.Size()})
 		}
 	}
-	data.output = strings.Join(outputs, "\n")
-	if len(partFileInfos) > 1 {
-		data.output = multiPartOutputNote + data.output
-	}
 	return data, nil
 }
 
@@ -571,14 +683,14 @@ func parseFailedTests(jirix *jiri.X, jobDir string, partIndex int) ([]failedTest
 
 func validateValues(values url.Values) error {
 	ty := values.Get("type")
-	if ty == "presubmit" {
+	if ty == "presubmit" || ty == "output" || ty == "search" {
 		paramsToCheck := []string{}
 		if n := values.Get("n"); n == "" {
 			return fmt.Errorf("required parameter 'n' not found")
 		} else {
 			paramsToCheck = append(paramsToCheck, "n")
 		}
-		paramsToCheck = append(paramsToCheck, "job", "os", "arch", "suite", "test")
+		paramsToCheck = append(paramsToCheck, "job", "os", "arch", "part", "suite", "test")
 		if err := checkPathTraversal(values, paramsToCheck); err != nil {
 			return err
 		}
@@ -0,0 +1,338 @@
+// Copyright 2015 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"v.io/jiri"
+	"v.io/jiri/runutil"
+	"v.io/x/devtools/internal/blobstore"
+	"v.io/x/devtools/internal/xunit"
+)
+
+const (
+	// probeWindow is the period before the time the service status is
+	// computed whose probe results it is computed from.
+	probeWindow = 30 * 24 * time.Hour
+	// incidentWindow is the period before the time the service status
+	// is computed whose incidents the status page shows.
+	incidentWindow = 7 * 24 * time.Hour
+	// probeSuffix is the suffix of the names of probe results, which
+	// are named <unix time>.xml.
+	probeSuffix = ".xml"
+)
+
+// The statuses of services and their incidents, which match the classes
+// of the status page stylesheet.
+const (
+	serviceStatusOK      = "serviceStatusOK"
+	serviceStatusWarning = "serviceStatusWarning"
+	serviceStatusDown    = "serviceStatusDown"
+)
+
+// sloWindow is a period before the time the service status is computed
+// over which uptimes and burn rates are computed.
+type sloWindow struct {
+	name     string
+	duration time.Duration
+}
+
+var (
+	uptimeWindows = []sloWindow{
+		{"7d", 7 * 24 * time.Hour},
+		{"30d", probeWindow},
+	}
+	// burnRateWindows is ordered from the shortest window to the
+	// longest.
+	burnRateWindows = []sloWindow{
+		{"1h", time.Hour},
+		{"6h", 6 * time.Hour},
+		{"3d", 3 * 24 * time.Hour},
+	}
+)
+
+var (
+	probeResultsFlag string
+	sloTargetFlag    float64
+)
+
+func init() {
+	cmdDashboard.Flags.StringVar(&probeResultsFlag, "probe-results", "", "Google Storage bucket, or local directory, with the xUnit reports of the production services test, named <unix time>.xml. If set, the service status is computed from these reports instead of read from the status bucket. Requires -cache, in which a summary of the reports is kept.")
+	cmdDashboard.Flags.Float64Var(&sloTargetFlag, "slo-target", 99.9, "The availability target of the services over 30 days, in percent.")
+}
+
+// probeResult records whether a service was up when it was probed.
+type probeResult struct {
+	Time    int64 // The time of the probe, in seconds since the epoch.
+	Service string
+	Up      bool
+}
+
+// sloValue is the value of an uptime or a burn rate over a window.
+type sloValue struct {
+	Window string
+	Value  float64
+}
+
+// sloData describes how a service meets its service level objective.
+type sloData struct {
+	// Target is the availability target, in percent.
+	Target float64
+	// Uptime holds the percentages of time the service was up.
+	Uptime []sloValue
+	// BurnRates holds the rates at which the service consumed its error
+	// budget, relative to the rate that consumes exactly the budget of
+	// the 30 days of the objective.
+	BurnRates []sloValue
+	// BudgetRemaining is the percentage of the error budget of the last
+	// 30 days that the service has not consumed, which is negative if it
+	// consumed more than its budget.
+	BudgetRemaining float64
+}
+
+// probeStatusModel returns the data of the service status page, which
+// is computed from the probe results of the last 30 days in the given
+// store.
+func probeStatusModel(jirix *jiri.X, store blobstore.Store, now time.Time) (*statusPageData, error) {
+	if sloTargetFlag <= 0 || sloTargetFlag >= 100 {
+		return nil, fmt.Errorf("invalid SLO target %v, must be between 0 and 100", sloTargetFlag)
+	}
+	results, err := readProbeResults(jirix, store, now)
+	if err != nil {
+		return nil, err
+	}
+	return computeProbeStatus(results, sloTargetFlag, now), nil
+}
+
+// probeSummary records the results of the probes in the probe window,
+// which are read from the store once and kept in the cache directory so
+// that page loads only read the probes stored since the last load.
+type probeSummary struct {
+	// Probes maps the names of the probes to their results.
+	Probes map[string][]probeResult
+}
+
+// probeSummaryMu serializes the updates of the probe summary by
+// concurrent page loads.
+var probeSummaryMu sync.Mutex
+
+// readProbeResults reads the probe results of the last 30 days from the
+// given store, updating the summary of the results kept in the cache
+// directory with the probes stored since it was last updated.
+func readProbeResults(jirix *jiri.X, store blobstore.Store, now time.Time) ([]probeResult, error) {
+	if cacheFlag == "" {
+		return nil, fmt.Errorf("-probe-results requires -cache")
+	}
+	probeSummaryMu.Lock()
+	defer probeSummaryMu.Unlock()
+	path := filepath.Join(cacheFlag, "probes", "summary.json")
+	summary := probeSummary{}
+	if err := readJSON(jirix, path, &summary); err != nil && !runutil.IsNotExist(err) {
+		return nil, err
+	}
+	if summary.Probes == nil {
+		summary.Probes = map[string][]probeResult{}
+	}
+
+	names, err := store.List(jirix, "")
+	if err != nil {
+		return nil, err
+	}
+	since := now.Add(-probeWindow).Unix()
+	probes, inWindow := map[string][]probeResult{}, []string{}
+	updated := false
+	for _, name := range names {
+		if !strings.HasSuffix(name, probeSuffix) {
+			continue
+		}
+		t, err := strconv.ParseInt(strings.TrimSuffix(name, probeSuffix), 10, 64)
+		if err != nil || t < since || t > now.Unix() {
+			continue
+		}
+		inWindow = append(inWindow, name)
+		// Probe results never change once stored, so they are read
+		// only once.
+		if results, ok := summary.Probes[name]; ok {
+			probes[name] = results
+			continue
+		}
+		bytes, err := store.Read(jirix, name)
+		if err != nil {
+			return nil, err
+		}
+		var suites xunit.TestSuites
+		if err := xml.Unmarshal(bytes, &suites); err != nil {
+			return nil, fmt.Errorf("Unmarshal(%v) failed: %v", name, err)
+		}
+		results := []probeResult{}
+		for _, suite := range suites.Suites {
+			results = append(results, probeResult{
+				Time:    t,
+				Service: suite.Name,
+				Up:      suitePassed(suite),
+			})
+		}
+		probes[name] = results
+		updated = true
+	}
+	// Probes that left the window are dropped from the summary.
+	if updated || len(probes) != len(summary.Probes) {
+		if err := writeJSON(jirix, path, probeSummary{Probes: probes}); err != nil {
+			return nil, err
+		}
+	}
+
+	allResults := []probeResult{}
+	for _, name := range inWindow {
+		allResults = append(allResults, probes[name]...)
+	}
+	return allResults, nil
+}
+
+// suitePassed checks whether all test cases of the given suite passed.
+func suitePassed(suite xunit.TestSuite) bool {
+	if suite.Failures > 0 || suite.Errors > 0 {
+		return false
+	}
+	for _, c := range suite.Cases {
+		if len(c.Failures) > 0 || len(c.Errors) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+type probeResultsByTime []probeResult
+
+func (r probeResultsByTime) Len() int           { return len(r) }
+func (r probeResultsByTime) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+func (r probeResultsByTime) Less(i, j int) bool { return r[i].Time < r[j].Time }
+
+// computeProbeStatus computes the status of the services probed in the
+// given results, at the given time, for the given availability target.
+//
+// The result of a probe is taken to hold until the next probe of the
+// same service, or until the given time for the last probe. Consecutive
+// failed probes make up an incident.
+func computeProbeStatus(results []probeResult, target float64, now time.Time) *statusPageData {
+	byService := map[string][]probeResult{}
+	for _, result := range results {
+		byService[result.Service] = append(byService[result.Service], result)
+	}
+	services := []string{}
+	for service := range byService {
+		services = append(services, service)
+	}
+	sort.Strings(services)
+
+	data := &statusPageData{CollectionTimestamp: now.Unix()}
+	for _, service := range services {
+		serviceResults := byService[service]
+		sort.Stable(probeResultsByTime(serviceResults))
+		status := statusData{
+			Name:      service,
+			Incidents: incidents(serviceResults, now),
+			SLO:       computeSLO(serviceResults, target, now),
+		}
+		switch {
+		case !serviceResults[len(serviceResults)-1].Up:
+			status.CurrentStatus = serviceStatusDown
+		case status.SLO.BurnRates[0].Value > 1:
+			// The service is up, but recently failed often enough to
+			// exhaust its error budget early.
+			status.CurrentStatus = serviceStatusWarning
+		default:
+			status.CurrentStatus = serviceStatusOK
+		}
+		data.Status = append(data.Status, status)
+	}
+	return data
+}
+
+// incidents returns the incidents of the last 7 days in the given
+// results of a service, which are sorted by time.
+func incidents(results []probeResult, now time.Time) []incidentData {
+	incidents := []incidentData{}
+	since := now.Add(-incidentWindow).Unix()
+	for i := 0; i < len(results); i++ {
+		if results[i].Up {
+			continue
+		}
+		start, end := results[i].Time, now.Unix()
+		for ; i < len(results); i++ {
+			if results[i].Up {
+				end = results[i].Time
+				break
+			}
+		}
+		if end < since {
+			continue
+		}
+		incidents = append(incidents, incidentData{
+			Start:    start,
+			Duration: end - start,
+			Status:   serviceStatusDown,
+		})
+	}
+	return incidents
+}
+
+// downtime returns the time in seconds during the given window that the
+// service whose results, sorted by time, are given was down, and the
+// time during the window that it was probed for.
+func downtime(results []probeResult, window time.Duration, now time.Time) (int64, int64) {
+	since := now.Add(-window).Unix()
+	down, covered := int64(0), int64(0)
+	for i, result := range results {
+		start, end := result.Time, now.Unix()
+		if i+1 < len(results) {
+			end = results[i+1].Time
+		}
+		if start < since {
+			start = since
+		}
+		if end <= start {
+			continue
+		}
+		covered += end - start
+		if !result.Up {
+			down += end - start
+		}
+	}
+	return down, covered
+}
+
+// computeSLO computes the uptimes and burn rates of the service whose
+// results, sorted by time, are given.
+func computeSLO(results []probeResult, target float64, now time.Time) *sloData {
+	errorRate := func(window time.Duration) float64 {
+		down, covered := downtime(results, window, now)
+		if covered == 0 {
+			return 0
+		}
+		return float64(down) / float64(covered)
+	}
+	slo := &sloData{Target: target}
+	for _, window := range uptimeWindows {
+		slo.Uptime = append(slo.Uptime, sloValue{window.name, 100 * (1 - errorRate(window.duration))})
+	}
+	budget := 1 - target/100
+	burnRate := func(window time.Duration) float64 {
+		return errorRate(window) / budget
+	}
+	for _, window := range burnRateWindows {
+		slo.BurnRates = append(slo.BurnRates, sloValue{window.name, burnRate(window.duration)})
+	}
+	slo.BudgetRemaining = 100 * (1 - burnRate(probeWindow))
+	return slo
+}
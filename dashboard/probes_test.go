@@ -0,0 +1,174 @@
+// Copyright 2015 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"v.io/jiri/jiritest"
+	"v.io/x/devtools/internal/blobstore"
+	"v.io/x/devtools/internal/xunit"
+)
+
+// writeProbe stores the results of a run of the production services
+// test at the given time, in which the services with the given names
+// were up or down, in the given store directory.
+func writeProbe(t *testing.T, storeDir string, probeTime time.Time, services map[string]bool) {
+	suites := xunit.TestSuites{}
+	for name, up := range services {
+		suite := xunit.TestSuite{Name: name, Tests: 1}
+		c := xunit.TestCase{Classname: name, Name: "Test", Time: "1.00"}
+		if !up {
+			c.Failures = append(c.Failures, xunit.Failure{Message: "vrpc", Data: "mismatching signature"})
+			suite.Failures++
+		}
+		suite.Cases = append(suite.Cases, c)
+		suites.Suites = append(suites.Suites, suite)
+	}
+	bytes, err := xml.MarshalIndent(suites, "", "  ")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	path := filepath.Join(storeDir, fmt.Sprintf("%d.xml", probeTime.Unix()))
+	if err := ioutil.WriteFile(path, bytes, os.FileMode(0644)); err != nil {
+		t.Fatalf("%v", err)
+	}
+}
+
+func TestProbeStatus(t *testing.T) {
+	jirix, cleanup := jiritest.NewX(t)
+	defer cleanup()
+
+	storeDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(storeDir)
+	oldCache := cacheFlag
+	cacheFlag = filepath.Join(jirix.Root, "cache")
+	defer func() { cacheFlag = oldCache }()
+
+	now := time.Date(2015, time.October, 16, 12, 0, 0, 0, time.UTC)
+	// The services are probed every hour in the last 10 hours. The
+	// mounttable is down for 2 hours, the proxy has just gone down and
+	// the discharger was down for half an hour.
+	for i := 10; i > 0; i-- {
+		probeTime := now.Add(-time.Duration(i) * time.Hour)
+		writeProbe(t, storeDir, probeTime, map[string]bool{
+			"mounttable":        i != 5 && i != 4,
+			"proxy service":     i != 1,
+			"binary discharger": i != 1,
+		})
+	}
+	writeProbe(t, storeDir, now.Add(-30*time.Minute), map[string]bool{"binary discharger": true})
+	// Results older than 30 days and other files are ignored.
+	writeProbe(t, storeDir, now.Add(-40*24*time.Hour), map[string]bool{"mounttable": false})
+	if err := ioutil.WriteFile(filepath.Join(storeDir, "latest"), []byte("1"), os.FileMode(0644)); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	data, err := probeStatusModel(jirix, blobstore.NewLocal(storeDir), now)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if got, want := data.CollectionTimestamp, now.Unix(); got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	hour := int64(3600)
+	want := []struct {
+		name      string
+		status    string
+		incidents []incidentData
+		uptime    []float64
+		burnRates []float64
+		budget    float64
+	}{
+		{
+			name:      "binary discharger",
+			status:    serviceStatusWarning,
+			incidents: []incidentData{{now.Unix() - hour, hour / 2, serviceStatusDown}},
+			uptime:    []float64{95, 95},
+			burnRates: []float64{500, 1000.0 / 12, 50},
+			budget:    -4900,
+		},
+		{
+			name:      "mounttable",
+			status:    serviceStatusOK,
+			incidents: []incidentData{{now.Unix() - 5*hour, 2 * hour, serviceStatusDown}},
+			uptime:    []float64{80, 80},
+			burnRates: []float64{0, 1000.0 / 3, 200},
+			budget:    -19900,
+		},
+		{
+			name:      "proxy service",
+			status:    serviceStatusDown,
+			incidents: []incidentData{{now.Unix() - hour, hour, serviceStatusDown}},
+			uptime:    []float64{90, 90},
+			burnRates: []float64{1000, 1000.0 / 6, 100},
+			budget:    -9900,
+		},
+	}
+	if got, want := len(data.Status), len(want); got != want {
+		t.Fatalf("got %v services, want %v: %#v", got, want, data.Status)
+	}
+	equal := func(a, b float64) bool { return math.Abs(a-b) < 1e-6 }
+	for i, w := range want {
+		got := data.Status[i]
+		if got.Name != w.name || got.CurrentStatus != w.status || !reflect.DeepEqual(got.Incidents, w.incidents) {
+			t.Fatalf("got %#v, want %#v", got, w)
+		}
+		if got.SLO == nil || got.SLO.Target != 99.9 || !equal(got.SLO.BudgetRemaining, w.budget) {
+			t.Fatalf("%v: got %#v, want budget %v", w.name, got.SLO, w.budget)
+		}
+		for j, uptime := range got.SLO.Uptime {
+			if uptime.Window != uptimeWindows[j].name || !equal(uptime.Value, w.uptime[j]) {
+				t.Fatalf("%v: got uptime %v, want %v", w.name, uptime, w.uptime[j])
+			}
+		}
+		for j, rate := range got.SLO.BurnRates {
+			if rate.Window != burnRateWindows[j].name || !equal(rate.Value, w.burnRates[j]) {
+				t.Fatalf("%v: got burn rate %v, want %v", w.name, rate, w.burnRates[j])
+			}
+		}
+	}
+
+	// Probes already in the summary of the cache are not read again.
+	probePath := filepath.Join(storeDir, fmt.Sprintf("%d.xml", now.Add(-time.Hour).Unix()))
+	if err := ioutil.WriteFile(probePath, []byte("not xml"), os.FileMode(0644)); err != nil {
+		t.Fatalf("%v", err)
+	}
+	again, err := probeStatusModel(jirix, blobstore.NewLocal(storeDir), now)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if !reflect.DeepEqual(again, data) {
+		t.Fatalf("got %#v, want %#v", again, data)
+	}
+	// The probe results are not read without a cache directory.
+	cacheFlag = ""
+	if _, err := probeStatusModel(jirix, blobstore.NewLocal(storeDir), now); err == nil {
+		t.Fatalf("computing the status without a cache directory did not fail")
+	}
+
+	// Incidents that ended more than 7 days ago are not shown, but count
+	// against the objective.
+	results := []probeResult{
+		{now.Add(-10 * 24 * time.Hour).Unix(), "proxy service", false},
+		{now.Add(-9 * 24 * time.Hour).Unix(), "proxy service", true},
+	}
+	data = computeProbeStatus(results, 99, now)
+	status := data.Status[0]
+	if len(status.Incidents) != 0 || status.CurrentStatus != serviceStatusOK || !equal(status.SLO.Uptime[1].Value, 90) {
+		t.Fatalf("got %#v", status)
+	}
+}
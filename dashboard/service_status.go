@@ -5,7 +5,6 @@
 package main
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
 	"math"
@@ -16,6 +15,7 @@ import (
 	"time"
 
 	"v.io/jiri"
+	"v.io/x/devtools/internal/blobstore"
 	"v.io/x/devtools/internal/cache"
 )
 
@@ -62,6 +62,11 @@ var statusPageTemplate = template.Must(template.New("status").Funcs(statusFuncMa
 		  			<div class="service-name">{{ $serviceData.Name }}</div>
 						<div class="service-buildts">Built at: {{ $serviceData.BuildTimestamp }}</div>
 						<div class="service-snapshot">Snapshot: {{ $serviceData.SnapshotLabel }}</div>
+						{{ with $serviceData.SLO }}
+						<div class="service-slo">Uptime: {{ range .Uptime }}{{ .Window }} {{ printf "%.2f" .Value }}% {{ end }}</div>
+						<div class="service-slo">Burn rate: {{ range .BurnRates }}{{ .Window }} {{ printf "%.1f" .Value }}x {{ end }}</div>
+						<div class="service-slo">Budget left: {{ printf "%.1f" .BudgetRemaining }}% of {{ printf "%g" .Target }}% SLO</div>
+						{{ end }}
 					</div>
 					<div class="service-cur-status {{ $serviceData.CurrentStatus }}">
 					</div>
@@ -127,55 +132,56 @@ func widthPxForDuration(duration int64) string {
 	return fmt.Sprintf("%fpx", width)
 }
 
-func displayServiceStatusPage(jirix *jiri.X, w http.ResponseWriter, r *http.Request) (e error) {
-	s := jirix.NewSeq()
-	// Set up the root directory.
-	root := cacheFlag
-	if root == "" {
-		tmpDir, err := s.TempDir("", "")
-		if err != nil {
-			return err
-		}
-		defer jirix.NewSeq().RemoveAll(tmpDir)
-		root = tmpDir
-	}
-	root = filepath.Join(root, "status")
-	var out bytes.Buffer
-	// Read timestamp from the "latest" file.
-	if err := s.MkdirAll(root, 0700).
-		Capture(&out, &out).
-		Last("gsutil", "-q", "cat", statusBucketFlag+"/latest"); err != nil {
-		return err
-	}
+// statusData describes the status of a service.
+type statusData struct {
+	Name           string
+	BuildTimestamp string
+	SnapshotLabel  string
+	CurrentStatus  string
+	Incidents      []incidentData
+	// SLO is only set for statuses computed from probe results.
+	SLO *sloData `json:",omitempty"`
+}
 
-	// Read status file.
-	cachedFile, err := cache.StoreGoogleStorageFile(jirix, root, statusBucketFlag, out.String()+".status")
+// incidentData describes a period during which a service was not OK.
+type incidentData struct {
+	Start    int64
+	Duration int64
+	Status   string
+}
+
+// statusPageData describes the status of all services at the time the
+// data was collected.
+type statusPageData struct {
+	CollectionTimestamp int64
+	Status              []statusData
+}
+
+func displayServiceStatusPage(jirix *jiri.X, w http.ResponseWriter, r *http.Request) error {
+	data, err := statusModel(jirix)
 	if err != nil {
 		return err
 	}
-	fileBytes, err := s.ReadFile(cachedFile)
-	if err != nil {
-		return err
+	if err := statusPageTemplate.Execute(w, data); err != nil {
+		return fmt.Errorf("Execute() failed: %v!!", err)
 	}
 
-	// Parse status file and render.
-	type statusData struct {
-		Name           string
-		BuildTimestamp string
-		SnapshotLabel  string
-		CurrentStatus  string
-		Incidents      []struct {
-			Start    int64
-			Duration int64
-			Status   string
-		}
-	}
-	var data struct {
-		CollectionTimestamp int64
-		Status              []statusData
+	return nil
+}
+
+// statusModel returns the data of the service status page, which is
+// computed from the probe results if they are configured, and read from
+// the latest status file otherwise.
+func statusModel(jirix *jiri.X) (*statusPageData, error) {
+	var data *statusPageData
+	var err error
+	if probeResultsFlag != "" {
+		data, err = probeStatusModel(jirix, blobstore.New(probeResultsFlag), time.Now())
+	} else {
+		data, err = storedStatusModel(jirix)
 	}
-	if err := json.Unmarshal(fileBytes, &data); err != nil {
-		return fmt.Errorf("Unmarshal(%v) failed: %v", string(fileBytes), err)
+	if err != nil {
+		return nil, err
 	}
 	filteredStatus := []statusData{}
 	for _, s := range data.Status {
@@ -193,10 +199,48 @@ func displayServiceStatusPage(jirix *jiri.X, w http.ResponseWriter, r *http.Requ
 		filteredStatus = append(filteredStatus, s)
 	}
 	data.Status = filteredStatus
+	return data, nil
+}
 
-	if err := statusPageTemplate.Execute(w, data); err != nil {
-		return fmt.Errorf("Execute() failed: %v!!", err)
+// storedStatusModel reads the data of the service status page from the
+// latest status file.
+func storedStatusModel(jirix *jiri.X) (*statusPageData, error) {
+	s := jirix.NewSeq()
+	// Set up the root directory.
+	root := cacheFlag
+	if root == "" {
+		tmpDir, err := s.TempDir("", "")
+		if err != nil {
+			return nil, err
+		}
+		defer jirix.NewSeq().RemoveAll(tmpDir)
+		root = tmpDir
+	}
+	root = filepath.Join(root, "status")
+	if err := s.MkdirAll(root, 0700).Done(); err != nil {
+		return nil, err
+	}
+	// Read timestamp from the "latest" file.
+	store := blobstore.New(statusBucketFlag)
+	latest, err := blobstore.ReadLatest(jirix, store, "")
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	// Read status file.
+	cachedFile, err := cache.StoreFile(jirix, store, root, latest+".status")
+	if err != nil {
+		return nil, err
+	}
+	fileBytes, err := s.ReadFile(cachedFile)
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse status file.
+	var data statusPageData
+	if err := json.Unmarshal(fileBytes, &data); err != nil {
+		return nil, fmt.Errorf("Unmarshal(%v) failed: %v", string(fileBytes), err)
+	}
+	return &data, nil
 }
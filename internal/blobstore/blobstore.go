@@ -0,0 +1,202 @@
+// Copyright 2015 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package blobstore provides access to stores of blobs, such as the
+// Google Storage buckets that hold test results and service status
+// data, or local directories with the same layout.
+package blobstore
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"v.io/jiri"
+)
+
+const gcsPrefix = "gs://"
+
+// Store is a store of blobs, identified by slash-separated names
+// relative to the root of the store. The "directories" of a store are
+// the name prefixes that end with a slash.
+type Store interface {
+	// Get copies the blob with the given name, or all blobs in the
+	// directory with the given name, to the given local directory,
+	// naming the copy after the last element of the given name.
+	Get(jirix *jiri.X, name, dir string) error
+	// List returns the sorted names of the blobs and directories in
+	// the directory with the given name, relative to that directory.
+	List(jirix *jiri.X, dir string) ([]string, error)
+	// Read returns the contents of the blob with the given name.
+	Read(jirix *jiri.X, name string) ([]byte, error)
+	// Stat returns information about the blob with the given name. The
+	// returned error satisfies runutil.IsNotExist if there is no such
+	// blob.
+	Stat(jirix *jiri.X, name string) (Info, error)
+}
+
+// WritableStore is a Store that blobs can be written to and removed
+// from.
+type WritableStore interface {
+	Store
+	// Write stores the given data as the blob with the given name,
+	// replacing any existing blob. Readers of the store never see a
+	// partially written blob.
+	Write(jirix *jiri.X, name string, data []byte) error
+	// Remove removes the blobs with the given names.
+	Remove(jirix *jiri.X, names ...string) error
+}
+
+// Info describes a blob.
+type Info struct {
+	Name string
+	Size int64
+	// ModTime is the time the blob was last modified.
+	ModTime time.Time
+}
+
+// New returns the store at the given location, which is either a Google
+// Storage location (gs://<bucket>/<path>) or a local directory.
+func New(location string) Store {
+	return NewWritable(location)
+}
+
+// NewWritable is like New, but returns a store that can be written to.
+func NewWritable(location string) WritableStore {
+	if strings.HasPrefix(location, gcsPrefix) {
+		return gcsStore{strings.TrimSuffix(location, "/")}
+	}
+	return localStore{location}
+}
+
+// ReadLatest returns the contents of the "latest" blob in the given
+// directory of the given store, which by convention names the most
+// recent blob in that directory.
+func ReadLatest(jirix *jiri.X, store Store, dir string) (string, error) {
+	bytes, err := store.Read(jirix, path.Join(dir, "latest"))
+	if err != nil {
+		return "", err
+	}
+	latest := strings.TrimSpace(string(bytes))
+	if latest == "" {
+		return "", fmt.Errorf("no latest blob in %q", dir)
+	}
+	return latest, nil
+}
+
+// notExist returns an error indicating that the given blob does not
+// exist.
+func notExist(op, name string) error {
+	return &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+}
+
+// localStore is a store of the files in a local directory.
+type localStore struct {
+	root string
+}
+
+// NewLocal returns the store of the files in the given local directory.
+func NewLocal(root string) Store {
+	return localStore{root}
+}
+
+func (l localStore) path(name string) string {
+	return filepath.Join(l.root, filepath.FromSlash(name))
+}
+
+func (l localStore) Get(jirix *jiri.X, name, dir string) error {
+	src := l.path(name)
+	dst := filepath.Join(dir, path.Base(name))
+	s := jirix.NewSeq()
+	return filepath.Walk(src, func(srcPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, srcPath)
+		if err != nil {
+			return fmt.Errorf("Rel(%v, %v) failed: %v", src, srcPath, err)
+		}
+		dstPath := filepath.Join(dst, rel)
+		if info.IsDir() {
+			return s.MkdirAll(dstPath, os.FileMode(0700)).Done()
+		}
+		return copyFile(jirix, srcPath, dstPath)
+	})
+}
+
+// copyFile copies the given local file.
+func copyFile(jirix *jiri.X, src, dst string) error {
+	s := jirix.NewSeq()
+	in, err := s.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := s.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return fmt.Errorf("Copy(%v, %v) failed: %v", dst, src, err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("Close(%v) failed: %v", dst, err)
+	}
+	return nil
+}
+
+func (l localStore) List(jirix *jiri.X, dir string) ([]string, error) {
+	fileInfos, err := jirix.NewSeq().ReadDir(l.path(dir))
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, fileInfo := range fileInfos {
+		names = append(names, fileInfo.Name())
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (l localStore) Read(jirix *jiri.X, name string) ([]byte, error) {
+	return jirix.NewSeq().ReadFile(l.path(name))
+}
+
+func (l localStore) Stat(jirix *jiri.X, name string) (Info, error) {
+	fileInfo, err := jirix.NewSeq().Stat(l.path(name))
+	if err != nil {
+		return Info{}, err
+	}
+	// Directories are not blobs.
+	if fileInfo.IsDir() {
+		return Info{}, notExist("stat", l.path(name))
+	}
+	return Info{Name: name, Size: fileInfo.Size(), ModTime: fileInfo.ModTime()}, nil
+}
+
+func (l localStore) Write(jirix *jiri.X, name string, data []byte) error {
+	file := l.path(name)
+	dir := filepath.Dir(file)
+	// Write to a tmp file first so that readers never see a partial
+	// blob.
+	tmpFile := filepath.Join(dir, "."+filepath.Base(file)+".tmp")
+	return jirix.NewSeq().
+		MkdirAll(dir, os.FileMode(0755)).
+		WriteFile(tmpFile, data, os.FileMode(0644)).
+		Rename(tmpFile, file).Done()
+}
+
+func (l localStore) Remove(jirix *jiri.X, names ...string) error {
+	s := jirix.NewSeq()
+	for _, name := range names {
+		s.RemoveAll(l.path(name))
+	}
+	return s.Done()
+}
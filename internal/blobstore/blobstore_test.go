@@ -0,0 +1,144 @@
+// Copyright 2015 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package blobstore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"v.io/jiri/jiritest"
+	"v.io/jiri/runutil"
+)
+
+func TestLocalStore(t *testing.T) {
+	jirix, cleanup := jiritest.NewX(t)
+	defer cleanup()
+
+	root, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(root)
+	files := map[string]string{
+		"latest":          "200\n",
+		"100.status":      "old",
+		"200.status":      "new",
+		"runs/1/results":  "passed",
+		"runs/1/a/output": "ok",
+	}
+	for name, contents := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), os.FileMode(0700)); err != nil {
+			t.Fatalf("%v", err)
+		}
+		if err := ioutil.WriteFile(path, []byte(contents), os.FileMode(0600)); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+	store := New(root)
+
+	latest, err := ReadLatest(jirix, store, "")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if got, want := latest, "200"; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got, err := store.Read(jirix, latest+".status"); err != nil || string(got) != "new" {
+		t.Fatalf("got %q, %v, want %q", got, err, "new")
+	}
+
+	names, err := store.List(jirix, "")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if want := []string{"100.status", "200.status", "latest", "runs"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("got %v, want %v", names, want)
+	}
+	if _, err := store.List(jirix, "missing"); !runutil.IsNotExist(err) {
+		t.Fatalf("got %v, want a not-exist error", err)
+	}
+
+	modTime := time.Date(2015, time.October, 16, 11, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(filepath.Join(root, "runs", "1", "results"), modTime, modTime); err != nil {
+		t.Fatalf("%v", err)
+	}
+	info, err := store.Stat(jirix, "runs/1/results")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if info.Name != "runs/1/results" || info.Size != 6 || !info.ModTime.Equal(modTime) {
+		t.Fatalf("got %v", info)
+	}
+	for _, name := range []string{"runs", "runs/2/results"} {
+		if _, err := store.Stat(jirix, name); !runutil.IsNotExist(err) {
+			t.Fatalf("%v: got %v, want a not-exist error", name, err)
+		}
+	}
+
+	// Get copies blobs and directories of blobs.
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+	if err := store.Get(jirix, "runs/1", dir); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := store.Get(jirix, "latest", dir); err != nil {
+		t.Fatalf("%v", err)
+	}
+	for name, want := range map[string]string{"1/results": "passed", "1/a/output": "ok", "latest": "200\n"} {
+		got, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		if string(got) != want {
+			t.Fatalf("%v: got %q, want %q", name, got, want)
+		}
+	}
+	if err := store.Get(jirix, "runs/2", dir); !runutil.IsNotExist(err) {
+		t.Fatalf("got %v, want a not-exist error", err)
+	}
+
+	// Write replaces blobs and creates their directories, and Remove
+	// removes blobs and directories.
+	writable := NewWritable(root)
+	if err := writable.Write(jirix, "latest", []byte("300\n")); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := writable.Write(jirix, "runs/3/results", []byte("failed")); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if latest, err := ReadLatest(jirix, writable, ""); err != nil || latest != "300" {
+		t.Fatalf("got %q, %v, want %q", latest, err, "300")
+	}
+	if got, err := writable.Read(jirix, "runs/3/results"); err != nil || string(got) != "failed" {
+		t.Fatalf("got %q, %v, want %q", got, err, "failed")
+	}
+	if err := writable.Remove(jirix, "100.status", "runs/1"); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if names, err = writable.List(jirix, ""); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if want := []string{"200.status", "latest", "runs"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("got %v, want %v", names, want)
+	}
+	if names, err = writable.List(jirix, "runs"); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if want := []string{"3"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("got %v, want %v", names, want)
+	}
+
+	if got, want := New("gs://vanadium-test-results/"), NewGCS("gs://vanadium-test-results"); got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
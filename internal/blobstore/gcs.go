@@ -0,0 +1,154 @@
+// Copyright 2015 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package blobstore
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"v.io/jiri"
+)
+
+var (
+	contentLengthRE = regexp.MustCompile(`Content-Length:\s*(\d+)`)
+	creationTimeRE  = regexp.MustCompile(`Creation time:\s*(.+)`)
+)
+
+// gcsStore is a store of the objects in a Google Storage location, which
+// it accesses using gsutil.
+type gcsStore struct {
+	root string
+}
+
+// NewGCS returns the store of the objects in the given Google Storage
+// location (gs://<bucket>/<path>).
+func NewGCS(root string) Store {
+	return gcsStore{strings.TrimSuffix(root, "/")}
+}
+
+func (g gcsStore) url(name string) string {
+	if name == "" {
+		return g.root
+	}
+	return g.root + "/" + name
+}
+
+// noMatch determines whether the given gsutil output reports that there
+// are no objects with the requested name.
+func noMatch(output string) bool {
+	return strings.Contains(output, "No URLs matched") || strings.Contains(output, "matched no objects")
+}
+
+func (g gcsStore) Get(jirix *jiri.X, name, dir string) error {
+	var errOut bytes.Buffer
+	if err := jirix.NewSeq().Capture(nil, &errOut).Last("gsutil", "-m", "-q", "cp", "-r", g.url(name), dir); err != nil {
+		if noMatch(errOut.String()) {
+			return notExist("get", g.url(name))
+		}
+		return fmt.Errorf("%v\n%v", err, errOut.String())
+	}
+	return nil
+}
+
+func (g gcsStore) List(jirix *jiri.X, dir string) ([]string, error) {
+	var out bytes.Buffer
+	prefix := g.url(dir) + "/"
+	if err := jirix.NewSeq().Capture(&out, &out).Last("gsutil", "ls", prefix); err != nil {
+		if noMatch(out.String()) {
+			return nil, notExist("list", prefix)
+		}
+		return nil, fmt.Errorf("%v\n%v", err, out.String())
+	}
+	names := []string{}
+	for _, line := range strings.Split(out.String(), "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, prefix) {
+			continue
+		}
+		if name := strings.TrimSuffix(strings.TrimPrefix(line, prefix), "/"); name != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (g gcsStore) Read(jirix *jiri.X, name string) ([]byte, error) {
+	var out, errOut bytes.Buffer
+	if err := jirix.NewSeq().Capture(&out, &errOut).Last("gsutil", "-q", "cat", g.url(name)); err != nil {
+		if noMatch(errOut.String()) {
+			return nil, notExist("read", g.url(name))
+		}
+		return nil, fmt.Errorf("%v\n%v", err, errOut.String())
+	}
+	return out.Bytes(), nil
+}
+
+func (g gcsStore) Stat(jirix *jiri.X, name string) (Info, error) {
+	var out bytes.Buffer
+	if err := jirix.NewSeq().Capture(&out, &out).Last("gsutil", "stat", g.url(name)); err != nil {
+		if noMatch(out.String()) {
+			return Info{}, notExist("stat", g.url(name))
+		}
+		return Info{}, fmt.Errorf("%v\n%v", err, out.String())
+	}
+	info := Info{Name: name}
+	if matches := contentLengthRE.FindStringSubmatch(out.String()); matches != nil {
+		size, err := strconv.ParseInt(matches[1], 10, 64)
+		if err != nil {
+			return Info{}, fmt.Errorf("ParseInt(%v) failed: %v", matches[1], err)
+		}
+		info.Size = size
+	}
+	if matches := creationTimeRE.FindStringSubmatch(out.String()); matches != nil {
+		modTime, err := time.Parse(time.RFC1123, strings.TrimSpace(matches[1]))
+		if err != nil {
+			return Info{}, fmt.Errorf("Parse(%v) failed: %v", matches[1], err)
+		}
+		info.ModTime = modTime
+	}
+	return info, nil
+}
+
+func (g gcsStore) Write(jirix *jiri.X, name string, data []byte) error {
+	s := jirix.NewSeq()
+	tmpDir, err := s.TempDir("", "blobstore")
+	if err != nil {
+		return err
+	}
+	defer jirix.NewSeq().RemoveAll(tmpDir)
+	// gsutil uploads the file as a whole, so readers never see a
+	// partial object.
+	tmpFile := filepath.Join(tmpDir, path.Base(name))
+	var errOut bytes.Buffer
+	if err := s.WriteFile(tmpFile, data, os.FileMode(0644)).
+		Capture(nil, &errOut).Last("gsutil", "-q", "cp", tmpFile, g.url(name)); err != nil {
+		return fmt.Errorf("%v\n%v", err, errOut.String())
+	}
+	return nil
+}
+
+func (g gcsStore) Remove(jirix *jiri.X, names ...string) error {
+	if len(names) == 0 {
+		return nil
+	}
+	args := []string{"-m", "-q", "rm"}
+	for _, name := range names {
+		args = append(args, g.url(name))
+	}
+	var errOut bytes.Buffer
+	if err := jirix.NewSeq().Capture(nil, &errOut).Last("gsutil", args...); err != nil {
+		return fmt.Errorf("%v\n%v", err, errOut.String())
+	}
+	return nil
+}
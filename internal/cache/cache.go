@@ -5,19 +5,30 @@
 package cache
 
 import (
+	"path"
 	"path/filepath"
 	"strings"
 
 	"v.io/jiri"
 	"v.io/jiri/collect"
 	"v.io/jiri/runutil"
+	"v.io/x/devtools/internal/blobstore"
 )
 
 // StoreGoogleStorageFile reads the given file from the given Google Storage
 // location and stores it in the given cache location. It returns the cached
 // file path.
-func StoreGoogleStorageFile(jirix *jiri.X, cacheRoot, bucketRoot, filename string) (_ string, e error) {
+func StoreGoogleStorageFile(jirix *jiri.X, cacheRoot, bucketRoot, filename string) (string, error) {
+	return StoreFile(jirix, blobstore.NewGCS(bucketRoot), cacheRoot, filename)
+}
+
+// StoreFile reads the blob, or the directory of blobs, with the given name
+// from the given store and stores it in the given cache location, named
+// after the last element of the given name. It returns the cached file
+// path.
+func StoreFile(jirix *jiri.X, store blobstore.Store, cacheRoot, name string) (_ string, e error) {
 	s := jirix.NewSeq()
+	filename := path.Base(name)
 	cachedFile := filepath.Join(cacheRoot, filename)
 	if _, err := s.Stat(cachedFile); err != nil {
 		if !runutil.IsNotExist(err) {
@@ -30,7 +41,7 @@ func StoreGoogleStorageFile(jirix *jiri.X, cacheRoot, bucketRoot, filename strin
 			return "", err
 		}
 		defer collect.Error(func() error { return jirix.NewSeq().RemoveAll(tmpDir).Done() }, &e)
-		if err := s.Last("gsutil", "-m", "-q", "cp", "-r", bucketRoot+"/"+filename, tmpDir); err != nil {
+		if err := store.Get(jirix, name, tmpDir); err != nil {
 			return "", err
 		}
 		if err := s.Rename(filepath.Join(tmpDir, filename), cachedFile).Done(); err != nil {
@@ -0,0 +1,79 @@
+// Copyright 2015 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package ci provides an interface to the continuous integration
+// systems that run the builds of presubmit and postsubmit jobs, along
+// with implementations backed by Jenkins and by a local queue.
+package ci
+
+import (
+	"net/url"
+)
+
+// Build results.
+const (
+	ResultAborted = "ABORTED"
+	ResultFailure = "FAILURE"
+	ResultSuccess = "SUCCESS"
+)
+
+// Build represents a build of a job.
+type Build struct {
+	// Job is the name of the job.
+	Job string
+	// ID identifies the build. For builds that are still queued, it
+	// identifies the queue item; otherwise it holds the build number.
+	ID string
+	// Number is the build number, which is only set for builds that
+	// have started.
+	Number int
+	// Params holds the parameters of the build.
+	Params url.Values
+	// Queued determines whether the build is waiting to run.
+	Queued bool
+	// Building determines whether the build is running.
+	Building bool
+	// Result is the result of a completed build.
+	Result string
+	// Timestamp is the start time of the build in milliseconds since
+	// the epoch.
+	Timestamp int64
+}
+
+// Refs returns the review references the build tests, separated by ':'.
+func (b Build) Refs() string {
+	return b.Params.Get("REFS")
+}
+
+// TestCase identifies a test case of a build.
+type TestCase struct {
+	ClassName string
+	Name      string
+}
+
+// Equal determines whether the given test cases are the same.
+func (t TestCase) Equal(other TestCase) bool {
+	return t.ClassName == other.ClassName && t.Name == other.Name
+}
+
+// Executor is the interface of continuous integration systems. Builds
+// of matrix (multi-configuration) jobs are identified by the values of
+// their axes, which may be nil for other jobs.
+type Executor interface {
+	// Enqueue adds a build of the given job with the given parameters.
+	Enqueue(job string, params url.Values) error
+	// Queued returns the builds of the given job that wait to run.
+	Queued(job string) ([]Build, error)
+	// Ongoing returns the builds of the given job that are running.
+	Ongoing(job string) ([]Build, error)
+	// Cancel cancels the given queued or running build.
+	Cancel(build Build) error
+	// LastCompleted returns the last completed build of the given job.
+	LastCompleted(job string, axisValues map[string]string) (*Build, error)
+	// Build returns the given build of the given job.
+	Build(job string, axisValues map[string]string, number int) (*Build, error)
+	// FailedTestCases returns the test cases that failed in the given
+	// build of the given job.
+	FailedTestCases(job string, axisValues map[string]string, number int) ([]TestCase, error)
+}
@@ -0,0 +1,131 @@
+// Copyright 2015 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ci
+
+import (
+	"fmt"
+	"net/url"
+
+	"v.io/jiri"
+	"v.io/jiri/jenkins"
+)
+
+// jenkinsExecutor implements the Executor interface using the remote
+// access API of Jenkins.
+type jenkinsExecutor struct {
+	jenkins *jenkins.Jenkins
+}
+
+// NewJenkins returns an executor for the given Jenkins host.
+func NewJenkins(jirix *jiri.X, host string) (Executor, error) {
+	j, err := jirix.Jenkins(host)
+	if err != nil {
+		return nil, err
+	}
+	return &jenkinsExecutor{jenkins: j}, nil
+}
+
+func (e *jenkinsExecutor) Enqueue(job string, params url.Values) error {
+	if len(params) == 0 {
+		return e.jenkins.AddBuild(job)
+	}
+	return e.jenkins.AddBuildWithParameter(job, params)
+}
+
+func (e *jenkinsExecutor) Queued(job string) ([]Build, error) {
+	queuedBuilds, err := e.jenkins.QueuedBuilds(job)
+	if err != nil {
+		return nil, err
+	}
+	builds := []Build{}
+	for _, b := range queuedBuilds {
+		builds = append(builds, Build{
+			Job:    job,
+			ID:     fmt.Sprintf("%d", b.Id),
+			Params: refsParams(b.ParseRefs()),
+			Queued: true,
+		})
+	}
+	return builds, nil
+}
+
+func (e *jenkinsExecutor) Ongoing(job string) ([]Build, error) {
+	buildInfos, err := e.jenkins.OngoingBuilds(job)
+	if err != nil {
+		return nil, err
+	}
+	builds := []Build{}
+	for _, info := range buildInfos {
+		if !info.Building {
+			continue
+		}
+		build := buildFromInfo(job, info)
+		builds = append(builds, *build)
+	}
+	return builds, nil
+}
+
+func (e *jenkinsExecutor) Cancel(build Build) error {
+	if build.Queued {
+		return e.jenkins.CancelQueuedBuild(build.ID)
+	}
+	return e.jenkins.CancelOngoingBuild(build.Job, build.Number)
+}
+
+func (e *jenkinsExecutor) LastCompleted(job string, axisValues map[string]string) (*Build, error) {
+	info, err := e.jenkins.LastCompletedBuildStatus(job, axisValues)
+	if err != nil {
+		return nil, err
+	}
+	return buildFromInfo(job, *info), nil
+}
+
+func (e *jenkinsExecutor) Build(job string, axisValues map[string]string, number int) (*Build, error) {
+	var info *jenkins.BuildInfo
+	var err error
+	if axisValues == nil {
+		info, err = e.jenkins.BuildInfo(job, number)
+	} else {
+		info, err = e.jenkins.BuildInfoForSpec(jenkins.GenBuildSpec(job, axisValues, fmt.Sprintf("%d", number)))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buildFromInfo(job, *info), nil
+}
+
+func (e *jenkinsExecutor) FailedTestCases(job string, axisValues map[string]string, number int) ([]TestCase, error) {
+	cases, err := e.jenkins.FailedTestCasesForBuildSpec(jenkins.GenBuildSpec(job, axisValues, fmt.Sprintf("%d", number)))
+	if err != nil {
+		return nil, err
+	}
+	result := []TestCase{}
+	for _, c := range cases {
+		result = append(result, TestCase{ClassName: c.ClassName, Name: c.Name})
+	}
+	return result, nil
+}
+
+// buildFromInfo converts the given Jenkins build information to a
+// Build.
+func buildFromInfo(job string, info jenkins.BuildInfo) *Build {
+	return &Build{
+		Job:       job,
+		ID:        info.Id,
+		Number:    info.Number,
+		Params:    refsParams(info.ParseRefs()),
+		Building:  info.Building,
+		Result:    info.Result,
+		Timestamp: info.Timestamp,
+	}
+}
+
+// refsParams returns the build parameters that hold the given refs.
+func refsParams(refs string) url.Values {
+	if refs == "" {
+		return url.Values{}
+	}
+	return url.Values{"REFS": {refs}}
+}
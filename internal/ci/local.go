@@ -0,0 +1,526 @@
+// Copyright 2015 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ci
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"v.io/x/devtools/internal/xunit"
+)
+
+// Local implements the Executor interface using a queue that is
+// persisted in a local directory. The builds are run as subprocesses by
+// RunNext, which makes it possible to run the presubmit pipeline on a
+// single machine.
+//
+// The directory contains a "jobs.json" file that maps job names to the
+// shell commands that implement the jobs. Build parameters are passed
+// to the commands as environment variables, along with the JOB_NAME,
+// BUILD_NUMBER and WORKSPACE variables Jenkins sets. The record of
+// build N of job J is stored in J/N.json, its workspace is J/N, and its
+// output is stored in J/N.log. The record is updated while holding a
+// lock on J/N.lock, so that a build is run at most once and cancelling
+// it does not race with running it.
+//
+// Local jobs have no axes, so axis values passed to the methods of the
+// Executor interface are ignored.
+type Local struct {
+	root string
+}
+
+// localBuild is the on-disk record of a build of a local job.
+type localBuild struct {
+	Build
+	// Enqueued is the time the build was enqueued in milliseconds
+	// since the epoch.
+	Enqueued int64
+	// Pid is the ID of the process that runs the build.
+	Pid int
+}
+
+// NewLocal returns an executor that stores its queue in the given
+// directory.
+func NewLocal(root string) (*Local, error) {
+	if err := os.MkdirAll(root, os.FileMode(0755)); err != nil {
+		return nil, fmt.Errorf("MkdirAll(%v) failed: %v", root, err)
+	}
+	return &Local{root: root}, nil
+}
+
+// jobs returns the commands of the configured jobs.
+func (l *Local) jobs() (map[string]string, error) {
+	path := filepath.Join(l.root, "jobs.json")
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return map[string]string{}, nil
+		}
+		return nil, fmt.Errorf("ReadFile(%v) failed: %v", path, err)
+	}
+	jobs := map[string]string{}
+	if err := json.Unmarshal(bytes, &jobs); err != nil {
+		return nil, fmt.Errorf("Unmarshal(%v) failed: %v", path, err)
+	}
+	return jobs, nil
+}
+
+func (l *Local) recordPath(job string, number int) string {
+	return filepath.Join(l.root, job, fmt.Sprintf("%d.json", number))
+}
+
+// LogPath returns the path to the output of the given build.
+func (l *Local) LogPath(job string, number int) string {
+	return filepath.Join(l.root, job, fmt.Sprintf("%d.log", number))
+}
+
+// WorkspacePath returns the path to the workspace of the given build.
+func (l *Local) WorkspacePath(job string, number int) string {
+	return filepath.Join(l.root, job, fmt.Sprintf("%d", number))
+}
+
+func (l *Local) readBuild(job string, number int) (*localBuild, error) {
+	path := l.recordPath(job, number)
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("ReadFile(%v) failed: %v", path, err)
+	}
+	var build localBuild
+	if err := json.Unmarshal(bytes, &build); err != nil {
+		return nil, fmt.Errorf("Unmarshal(%v) failed: %v", path, err)
+	}
+	return &build, nil
+}
+
+// writeTmpBuild writes the record of the given build to a new
+// temporary file in the directory of the job and returns its path.
+func (l *Local) writeTmpBuild(build *localBuild) (string, error) {
+	bytes, err := json.MarshalIndent(build, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("MarshalIndent(%v) failed: %v", build, err)
+	}
+	dir := filepath.Join(l.root, build.Job)
+	file, err := ioutil.TempFile(dir, "tmp")
+	if err != nil {
+		return "", fmt.Errorf("TempFile(%v) failed: %v", dir, err)
+	}
+	if _, err := file.Write(bytes); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return "", fmt.Errorf("Write(%v) failed: %v", file.Name(), err)
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(file.Name())
+		return "", fmt.Errorf("Close(%v) failed: %v", file.Name(), err)
+	}
+	return file.Name(), nil
+}
+
+// writeBuild atomically replaces the record of the given build.
+func (l *Local) writeBuild(build *localBuild) error {
+	tmpPath, err := l.writeTmpBuild(build)
+	if err != nil {
+		return err
+	}
+	path := l.recordPath(build.Job, build.Number)
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("Rename(%v, %v) failed: %v", tmpPath, path, err)
+	}
+	return nil
+}
+
+// lockBuild acquires an exclusive lock on the record of the given build
+// and returns a function that releases it. The lock is released when the
+// process that holds it exits, so a crashed runner does not leave the
+// build locked.
+func (l *Local) lockBuild(job string, number int) (func(), error) {
+	path := filepath.Join(l.root, job, fmt.Sprintf("%d.lock", number))
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, os.FileMode(0644))
+	if err != nil {
+		return nil, fmt.Errorf("OpenFile(%v) failed: %v", path, err)
+	}
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("Flock(%v) failed: %v", path, err)
+	}
+	// Closing the file releases the lock.
+	return func() { file.Close() }, nil
+}
+
+// updateBuild applies the given function to the current record of the
+// given build while holding its lock, and writes the record back if the
+// function reports that it modified it.
+func (l *Local) updateBuild(job string, number int, update func(*localBuild) (bool, error)) (*localBuild, error) {
+	unlock, err := l.lockBuild(job, number)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	build, err := l.readBuild(job, number)
+	if err != nil {
+		return nil, err
+	}
+	modified, err := update(build)
+	if err != nil {
+		return nil, err
+	}
+	if modified {
+		if err := l.writeBuild(build); err != nil {
+			return nil, err
+		}
+	}
+	return build, nil
+}
+
+// processExists reports whether the process with the given ID exists.
+func processExists(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
+
+// builds returns the records of all builds of the given job, ordered
+// by build number. Builds whose process no longer exists, for example
+// because the runner crashed, are recorded as failed.
+func (l *Local) builds(job string) ([]*localBuild, error) {
+	numbers, err := l.buildNumbers(job)
+	if err != nil {
+		return nil, err
+	}
+	builds := []*localBuild{}
+	for _, number := range numbers {
+		build, err := l.readBuild(job, number)
+		if err != nil {
+			return nil, err
+		}
+		if build.Building && !processExists(build.Pid) {
+			// The runner records the result of the builds it waited
+			// for, but may not have done so yet, so re-read the record.
+			build, err = l.updateBuild(job, number, func(build *localBuild) (bool, error) {
+				if !build.Building {
+					return false, nil
+				}
+				build.Building = false
+				build.Result = ResultFailure
+				return true, nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+		builds = append(builds, build)
+	}
+	return builds, nil
+}
+
+func (l *Local) buildNumbers(job string) ([]int, error) {
+	dir := filepath.Join(l.root, job)
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("ReadDir(%v) failed: %v", dir, err)
+	}
+	numbers := []int{}
+	for _, info := range infos {
+		name := info.Name()
+		if !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		if number, err := strconv.Atoi(strings.TrimSuffix(name, ".json")); err == nil {
+			numbers = append(numbers, number)
+		}
+	}
+	sort.Ints(numbers)
+	return numbers, nil
+}
+
+func (l *Local) Enqueue(job string, params url.Values) error {
+	jobs, err := l.jobs()
+	if err != nil {
+		return err
+	}
+	if _, ok := jobs[job]; !ok {
+		return fmt.Errorf("job %q is not configured in %v", job, filepath.Join(l.root, "jobs.json"))
+	}
+	dir := filepath.Join(l.root, job)
+	if err := os.MkdirAll(dir, os.FileMode(0755)); err != nil {
+		return fmt.Errorf("MkdirAll(%v) failed: %v", dir, err)
+	}
+	numbers, err := l.buildNumbers(job)
+	if err != nil {
+		return err
+	}
+	number := 1
+	if len(numbers) > 0 {
+		number = numbers[len(numbers)-1] + 1
+	}
+	if params == nil {
+		params = url.Values{}
+	}
+	// Claim a build number by linking the complete record of the build
+	// to its path, which fails if another invocation of Enqueue claimed
+	// the number. Readers thus never see a partially written record.
+	for {
+		build := &localBuild{
+			Build: Build{
+				Job:    job,
+				ID:     fmt.Sprintf("%d", number),
+				Number: number,
+				Params: params,
+				Queued: true,
+			},
+			Enqueued: time.Now().UnixNano() / int64(time.Millisecond),
+		}
+		tmpPath, err := l.writeTmpBuild(build)
+		if err != nil {
+			return err
+		}
+		path := l.recordPath(job, number)
+		err = os.Link(tmpPath, path)
+		os.Remove(tmpPath)
+		if err == nil {
+			return nil
+		}
+		if !os.IsExist(err) {
+			return fmt.Errorf("Link(%v, %v) failed: %v", tmpPath, path, err)
+		}
+		number++
+	}
+}
+
+func (l *Local) Queued(job string) ([]Build, error) {
+	return l.filter(job, func(b *localBuild) bool { return b.Queued })
+}
+
+func (l *Local) Ongoing(job string) ([]Build, error) {
+	return l.filter(job, func(b *localBuild) bool { return b.Building })
+}
+
+func (l *Local) filter(job string, pred func(*localBuild) bool) ([]Build, error) {
+	builds, err := l.builds(job)
+	if err != nil {
+		return nil, err
+	}
+	result := []Build{}
+	for _, b := range builds {
+		if pred(b) {
+			result = append(result, b.Build)
+		}
+	}
+	return result, nil
+}
+
+func (l *Local) Cancel(build Build) error {
+	_, err := l.updateBuild(build.Job, build.Number, func(record *localBuild) (bool, error) {
+		switch {
+		case record.Queued:
+			record.Queued = false
+		case record.Building:
+			// Builds run in their own process group.
+			if err := syscall.Kill(-record.Pid, syscall.SIGTERM); err != nil && err != syscall.ESRCH {
+				return false, fmt.Errorf("Kill(%v) failed: %v", -record.Pid, err)
+			}
+			record.Building = false
+		default:
+			return false, nil
+		}
+		record.Result = ResultAborted
+		return true, nil
+	})
+	return err
+}
+
+func (l *Local) LastCompleted(job string, _ map[string]string) (*Build, error) {
+	builds, err := l.builds(job)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(builds) - 1; i >= 0; i-- {
+		if builds[i].Result != "" {
+			return &builds[i].Build, nil
+		}
+	}
+	return nil, fmt.Errorf("job %q has no completed builds", job)
+}
+
+func (l *Local) Build(job string, _ map[string]string, number int) (*Build, error) {
+	record, err := l.readBuild(job, number)
+	if err != nil {
+		return nil, err
+	}
+	return &record.Build, nil
+}
+
+// FailedTestCases returns the failed test cases found in the xUnit
+// reports (files named tests_*.xml) in the workspace of the given build.
+func (l *Local) FailedTestCases(job string, _ map[string]string, number int) ([]TestCase, error) {
+	cases := []TestCase{}
+	err := filepath.Walk(l.WorkspacePath(job, number), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		name := info.Name()
+		if info.IsDir() || !strings.HasPrefix(name, "tests_") || !strings.HasSuffix(name, ".xml") {
+			return nil
+		}
+		bytes, err := ioutil.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("ReadFile(%v) failed: %v", path, err)
+		}
+		var suites xunit.TestSuites
+		if err := xml.Unmarshal(bytes, &suites); err != nil {
+			return fmt.Errorf("Unmarshal(%v) failed: %v", path, err)
+		}
+		for _, suite := range suites.Suites {
+			for _, c := range suite.Cases {
+				if len(c.Failures) > 0 || len(c.Errors) > 0 {
+					cases = append(cases, TestCase{ClassName: c.Classname, Name: c.Name})
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cases, nil
+}
+
+type localBuildsByEnqueued []*localBuild
+
+func (b localBuildsByEnqueued) Len() int           { return len(b) }
+func (b localBuildsByEnqueued) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b localBuildsByEnqueued) Less(i, j int) bool { return b[i].Enqueued < b[j].Enqueued }
+
+// RunNext runs the build that has been queued for the longest time and
+// reports whether there was such a build.
+func (l *Local) RunNext() (bool, error) {
+	jobs, err := l.jobs()
+	if err != nil {
+		return false, err
+	}
+	queued := []*localBuild{}
+	for job := range jobs {
+		builds, err := l.builds(job)
+		if err != nil {
+			return false, err
+		}
+		for _, b := range builds {
+			if b.Queued {
+				queued = append(queued, b)
+			}
+		}
+	}
+	sort.Stable(localBuildsByEnqueued(queued))
+	// The builds may have been cancelled, or run by another runner,
+	// since they were listed.
+	for _, b := range queued {
+		ran, err := l.run(b, jobs[b.Job])
+		if err != nil || ran {
+			return ran, err
+		}
+	}
+	return false, nil
+}
+
+// run runs the given build using the given command, unless it is no
+// longer queued, and reports whether it ran the build.
+func (l *Local) run(build *localBuild, command string) (bool, error) {
+	cmd, log, err := l.start(build, command)
+	if err != nil || cmd == nil {
+		return false, err
+	}
+	defer log.Close()
+	runErr := cmd.Wait()
+
+	// The build may have been cancelled while running.
+	_, err = l.updateBuild(build.Job, build.Number, func(record *localBuild) (bool, error) {
+		if record.Result == ResultAborted {
+			return false, nil
+		}
+		record.Building = false
+		record.Result = ResultSuccess
+		if runErr != nil {
+			fmt.Fprintf(log, "%v\n", runErr)
+			record.Result = ResultFailure
+		}
+		return true, nil
+	})
+	return true, err
+}
+
+// start claims the given build and starts its command, returning the
+// started command and the file its output is written to, or a nil
+// command if the build is no longer queued. The build is claimed while
+// holding the lock on its record, so that cancelling it either dequeues
+// it or kills the started command.
+func (l *Local) start(build *localBuild, command string) (*exec.Cmd, *os.File, error) {
+	unlock, err := l.lockBuild(build.Job, build.Number)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer unlock()
+	build, err = l.readBuild(build.Job, build.Number)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !build.Queued {
+		return nil, nil, nil
+	}
+	workspace := l.WorkspacePath(build.Job, build.Number)
+	if err := os.MkdirAll(workspace, os.FileMode(0755)); err != nil {
+		return nil, nil, fmt.Errorf("MkdirAll(%v) failed: %v", workspace, err)
+	}
+	logPath := l.LogPath(build.Job, build.Number)
+	log, err := os.Create(logPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Create(%v) failed: %v", logPath, err)
+	}
+
+	env := os.Environ()
+	for key := range build.Params {
+		env = append(env, key+"="+build.Params.Get(key))
+	}
+	env = append(env,
+		"JOB_NAME="+build.Job,
+		fmt.Sprintf("BUILD_NUMBER=%d", build.Number),
+		"WORKSPACE="+workspace,
+	)
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Dir = workspace
+	cmd.Env = env
+	cmd.Stdout, cmd.Stderr = log, log
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		log.Close()
+		return nil, nil, fmt.Errorf("Start(%v) failed: %v", command, err)
+	}
+	build.Queued, build.Building = false, true
+	build.Timestamp = time.Now().UnixNano() / int64(time.Millisecond)
+	build.Pid = cmd.Process.Pid
+	if err := l.writeBuild(build); err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		log.Close()
+		return nil, nil, err
+	}
+	return cmd, log, nil
+}
@@ -0,0 +1,64 @@
+// Copyright 2015 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ci
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLocalExecutorCancelBeforeRun(t *testing.T) {
+	root, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(root)
+	jobs := `{"pass": "touch ran"}`
+	if err := ioutil.WriteFile(filepath.Join(root, "jobs.json"), []byte(jobs), os.FileMode(0644)); err != nil {
+		t.Fatalf("%v", err)
+	}
+	local, err := NewLocal(root)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := local.Enqueue("pass", nil); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// Cancel the build after RunNext would have listed it as queued,
+	// but before it runs it.
+	builds, err := local.builds("pass")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if got, want := len(builds), 1; got != want {
+		t.Fatalf("got %v builds, want %v", got, want)
+	}
+	if err := local.Cancel(builds[0].Build); err != nil {
+		t.Fatalf("%v", err)
+	}
+	ran, err := local.run(builds[0], "touch ran")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if ran {
+		t.Fatalf("a cancelled build was run")
+	}
+	if _, err := os.Stat(filepath.Join(local.WorkspacePath("pass", 1), "ran")); !os.IsNotExist(err) {
+		t.Fatalf("the command of a cancelled build was run: %v", err)
+	}
+	build, err := local.Build("pass", nil, 1)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if build.Queued || build.Building {
+		t.Fatalf("got queued %v, building %v, want neither", build.Queued, build.Building)
+	}
+	if got, want := build.Result, ResultAborted; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,244 @@
+// Copyright 2015 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ci_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"v.io/x/devtools/internal/ci"
+)
+
+const failingReport = `<?xml version="1.0" encoding="utf-8"?>
+<testsuites>
+  <testsuite name="v.io/x/foo" tests="2" errors="0" failures="1" skip="0">
+    <testcase classname="v.io/x/foo" name="TestPass" time="0"></testcase>
+    <testcase classname="v.io/x/foo" name="TestFail" time="0">
+      <failure message="failed">oops</failure>
+    </testcase>
+  </testsuite>
+</testsuites>
+`
+
+func newLocal(t *testing.T) (*ci.Local, string, func()) {
+	root, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	jobs, err := json.Marshal(map[string]string{
+		"pass": "echo $REFS > refs",
+		"fail": "cat > tests_fail.xml <<'EOF'\n" + failingReport + "EOF\nexit 1",
+	})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "jobs.json"), jobs, os.FileMode(0644)); err != nil {
+		t.Fatalf("%v", err)
+	}
+	local, err := ci.NewLocal(root)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	return local, root, func() { os.RemoveAll(root) }
+}
+
+func TestLocalExecutor(t *testing.T) {
+	local, _, cleanup := newLocal(t)
+	defer cleanup()
+
+	if err := local.Enqueue("unknown", nil); err == nil {
+		t.Fatalf("enqueueing a build of an unknown job did not fail")
+	}
+
+	// Enqueue and run a passing build.
+	if err := local.Enqueue("pass", url.Values{"REFS": {"refs/changes/12/1012/1"}}); err != nil {
+		t.Fatalf("%v", err)
+	}
+	queued, err := local.Queued("pass")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if got, want := len(queued), 1; got != want {
+		t.Fatalf("got %v queued builds, want %v", got, want)
+	}
+	if got, want := queued[0].Refs(), "refs/changes/12/1012/1"; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if ran, err := local.RunNext(); err != nil || !ran {
+		t.Fatalf("RunNext() = %v, %v, want true, nil", ran, err)
+	}
+	build, err := local.LastCompleted("pass", nil)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if got, want := build.Result, ci.ResultSuccess; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	bytes, err := ioutil.ReadFile(filepath.Join(local.WorkspacePath("pass", build.Number), "refs"))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if got, want := strings.TrimSpace(string(bytes)), "refs/changes/12/1012/1"; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	// Run a failing build and check its failed test cases.
+	if err := local.Enqueue("fail", nil); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if ran, err := local.RunNext(); err != nil || !ran {
+		t.Fatalf("RunNext() = %v, %v, want true, nil", ran, err)
+	}
+	build, err = local.Build("fail", nil, 1)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if got, want := build.Result, ci.ResultFailure; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	cases, err := local.FailedTestCases("fail", nil, 1)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if got, want := cases, []ci.TestCase{{ClassName: "v.io/x/foo", Name: "TestFail"}}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	// Cancel a queued build.
+	if err := local.Enqueue("pass", nil); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if queued, err = local.Queued("pass"); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if got, want := len(queued), 1; got != want {
+		t.Fatalf("got %v queued builds, want %v", got, want)
+	}
+	if err := local.Cancel(queued[0]); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if ran, err := local.RunNext(); err != nil || ran {
+		t.Fatalf("RunNext() = %v, %v, want false, nil", ran, err)
+	}
+	if build, err = local.LastCompleted("pass", nil); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if got, want := build.Result, ci.ResultAborted; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestLocalExecutorPersistence(t *testing.T) {
+	local, root, cleanup := newLocal(t)
+	defer cleanup()
+
+	for i := 0; i < 2; i++ {
+		if err := local.Enqueue("pass", nil); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+	// A new executor for the same directory sees the queued builds.
+	other, err := ci.NewLocal(root)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	queued, err := other.Queued("pass")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	numbers := []int{}
+	for _, b := range queued {
+		numbers = append(numbers, b.Number)
+	}
+	if got, want := numbers, []int{1, 2}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestLocalExecutorConcurrentEnqueue(t *testing.T) {
+	local, _, cleanup := newLocal(t)
+	defer cleanup()
+
+	// Builds being enqueued are never seen partially written.
+	const n = 10
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*n)
+	for i := 0; i < n; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			errs <- local.Enqueue("pass", nil)
+		}()
+		go func() {
+			defer wg.Done()
+			_, err := local.Queued("pass")
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+	queued, err := local.Queued("pass")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	numbers := []int{}
+	for _, b := range queued {
+		numbers = append(numbers, b.Number)
+	}
+	want := []int{}
+	for i := 1; i <= n; i++ {
+		want = append(want, i)
+	}
+	if !reflect.DeepEqual(numbers, want) {
+		t.Fatalf("got %v, want %v", numbers, want)
+	}
+}
+
+func TestLocalExecutorStaleBuild(t *testing.T) {
+	local, root, cleanup := newLocal(t)
+	defer cleanup()
+
+	// Record a build whose runner crashed, using the ID of a process
+	// that no longer exists.
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("%v", err)
+	}
+	record := fmt.Sprintf(`{"Job": "pass", "ID": "1", "Number": 1, "Building": true, "Pid": %d}`, cmd.Process.Pid)
+	if err := os.MkdirAll(filepath.Join(root, "pass"), os.FileMode(0755)); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "pass", "1.json"), []byte(record), os.FileMode(0644)); err != nil {
+		t.Fatalf("%v", err)
+	}
+	ongoing, err := local.Ongoing("pass")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if got, want := len(ongoing), 0; got != want {
+		t.Fatalf("got %v ongoing builds, want %v", got, want)
+	}
+	build, err := local.LastCompleted("pass", nil)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if got, want := build.Result, ci.ResultFailure; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
@@ -9,6 +9,7 @@ package goutil
 import (
 	"bytes"
 	"fmt"
+	"sort"
 	"strings"
 
 	"v.io/jiri"
@@ -29,6 +30,78 @@ func ListDirs(jirix *jiri.X, jiriArgs []string, pkgs ...string) ([]string, error
 	return list(jirix, jiriArgs, "{{.Dir}}", pkgs...)
 }
 
+// importersFormat is the 'go list' format used by Importers. It lists
+// the transitive dependencies of a package, followed by the direct
+// dependencies of its tests.
+const importersFormat = `{{.ImportPath}}:{{join .Deps " "}}:{{join .TestImports " "}} {{join .XTestImports " "}}`
+
+// Importers inputs a list of Go packages (targets) and a list of Go
+// package expressions and returns the packages matching the expressions
+// that are affected by changes to the targets: the targets themselves
+// and the packages that depend on them, either directly, transitively,
+// or through their tests. The implementation invokes 'go list'
+// internally with jiriArgs as arguments to the jiri-go subcommand.
+func Importers(jirix *jiri.X, jiriArgs, targets []string, pkgs ...string) ([]string, error) {
+	lines, err := list(jirix, jiriArgs, importersFormat, pkgs...)
+	if err != nil {
+		return nil, err
+	}
+	return importers(lines, targets), nil
+}
+
+// importers implements Importers given the output of 'go list' using
+// importersFormat.
+func importers(lines, targets []string) []string {
+	affected := map[string]bool{}
+	for _, target := range targets {
+		affected[target] = true
+	}
+	type pkgDeps struct {
+		path     string
+		deps     []string
+		testDeps []string
+	}
+	pkgs := []pkgDeps{}
+	for _, line := range lines {
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		pkgs = append(pkgs, pkgDeps{
+			path:     parts[0],
+			deps:     strings.Fields(parts[1]),
+			testDeps: strings.Fields(parts[2]),
+		})
+	}
+	// The dependencies reported by 'go list' are transitive, so a single
+	// pass identifies the packages whose code is affected.
+	codeAffected := map[string]bool{}
+	for _, pkg := range pkgs {
+		if affected[pkg.path] || containsAny(pkg.deps, affected) {
+			codeAffected[pkg.path] = true
+		}
+	}
+	// The dependencies of tests are direct, so the tests of a package
+	// are affected if they import a package whose code is affected.
+	paths := []string{}
+	for _, pkg := range pkgs {
+		if codeAffected[pkg.path] || containsAny(pkg.testDeps, codeAffected) || containsAny(pkg.testDeps, affected) {
+			paths = append(paths, pkg.path)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func containsAny(pkgs []string, set map[string]bool) bool {
+	for _, pkg := range pkgs {
+		if set[pkg] {
+			return true
+		}
+	}
+	return false
+}
+
 func list(jirix *jiri.X, jiriArgs []string, format string, pkgs ...string) ([]string, error) {
 	s := jirix.NewSeq()
 	args := append([]string{"go"}, jiriArgs...)
@@ -0,0 +1,38 @@
+// Copyright 2015 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package goutil
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestImporters(t *testing.T) {
+	// Package "c" depends on "b", which depends on "a". The tests of
+	// "d" import "c", and the tests of "e" import "d".
+	lines := []string{
+		"a:fmt: a",
+		"b:a fmt: b",
+		"c:a b fmt: c",
+		"d:fmt:c d",
+		"e:fmt:d e",
+		"f:fmt:fmt f",
+	}
+	testCases := []struct {
+		targets []string
+		want    []string
+	}{
+		{[]string{"a"}, []string{"a", "b", "c", "d"}},
+		{[]string{"c"}, []string{"c", "d"}},
+		{[]string{"d"}, []string{"d", "e"}},
+		{[]string{"f"}, []string{"f"}},
+		{[]string{"unknown"}, []string{}},
+	}
+	for _, test := range testCases {
+		if got := importers(lines, test.targets); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("importers(%v): got %v, want %v", test.targets, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright 2015 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package review
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+
+	"v.io/jiri"
+	"v.io/jiri/gerrit"
+)
+
+// verifiedLabel is the Gerrit label that records whether a change
+// passed presubmit tests.
+const verifiedLabel = "Verified"
+
+// commitMessageFile is the file Gerrit lists among the files of every
+// patchset for its commit message.
+const commitMessageFile = "/COMMIT_MSG"
+
+// gerritSystem implements the System interface using the Gerrit REST
+// API.
+type gerritSystem struct {
+	gerrit *gerrit.Gerrit
+}
+
+// NewGerrit returns a code review system for the given Gerrit host.
+func NewGerrit(jirix *jiri.X, host *url.URL) System {
+	return &gerritSystem{gerrit: jirix.Gerrit(host)}
+}
+
+func (s *gerritSystem) Query(query string) ([]Change, error) {
+	cls, err := s.gerrit.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	changes := []Change{}
+	for _, cl := range cls {
+		change, err := changeFromGerrit(cl)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, change)
+	}
+	return changes, nil
+}
+
+// PostReview posts a review to the given change. The "Verified" label
+// is only set for changes whose project uses it, as Gerrit rejects
+// reviews that set unknown labels.
+func (s *gerritSystem) PostReview(change Change, message string, verified bool) error {
+	cls, err := s.gerrit.Query(fmt.Sprintf("change:%d", change.Number))
+	if err != nil {
+		return err
+	}
+	labels := map[string]string{}
+	for _, cl := range cls {
+		if _, ok := cl.Labels[verifiedLabel]; ok {
+			labels[verifiedLabel] = "1"
+			if !verified {
+				labels[verifiedLabel] = "-1"
+			}
+		}
+	}
+	return s.gerrit.PostReview(gerritRef(change), message, labels)
+}
+
+func (s *gerritSystem) PostMessage(change Change, message string) error {
+	return s.gerrit.PostReview(gerritRef(change), message, nil)
+}
+
+func (s *gerritSystem) Submit(change Change) error {
+	return s.gerrit.Submit(change.ID)
+}
+
+// gerritRef returns the Gerrit reference of the current patchset of the
+// given change.
+func gerritRef(change Change) string {
+	return fmt.Sprintf("refs/changes/%02d/%d/%d", change.Number%100, change.Number, change.Patchset)
+}
+
+// changeFromGerrit converts the given Gerrit change to a Change.
+func changeFromGerrit(cl gerrit.Change) (Change, error) {
+	ref := cl.Reference()
+	number, patchset, err := gerrit.ParseRefString(ref)
+	if err != nil {
+		return Change{}, err
+	}
+	change := Change{
+		ID:            cl.Change_id,
+		Number:        number,
+		Patchset:      patchset,
+		Revision:      cl.Current_revision,
+		Ref:           ref,
+		Project:       cl.Project,
+		Topic:         cl.Topic,
+		Owner:         cl.OwnerEmail(),
+		Files:         []string{},
+		Labels:        map[string][]string{},
+		AutoSubmit:    cl.AutoSubmit,
+		PresubmitTest: string(cl.PresubmitTest),
+	}
+	for file := range cl.Revisions[cl.Current_revision].Files {
+		if file != commitMessageFile {
+			change.Files = append(change.Files, file)
+		}
+	}
+	sort.Strings(change.Files)
+	for label, data := range cl.Labels {
+		states := []string{}
+		for state := range data {
+			states = append(states, state)
+		}
+		sort.Strings(states)
+		change.Labels[label] = states
+	}
+	if cl.MultiPart != nil {
+		change.MultiPart = &MultiPart{
+			Topic: cl.MultiPart.Topic,
+			Index: cl.MultiPart.Index,
+			Total: cl.MultiPart.Total,
+		}
+	}
+	return change, nil
+}
@@ -0,0 +1,152 @@
+// Copyright 2015 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package review_test
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"v.io/jiri/gerrit"
+	"v.io/jiri/jiritest"
+	"v.io/x/devtools/internal/review"
+	"v.io/x/devtools/internal/review/reviewtest"
+)
+
+func TestGerrit(t *testing.T) {
+	jirix, cleanup := jiritest.NewX(t)
+	defer cleanup()
+
+	fake := reviewtest.NewFakeGerrit()
+	defer fake.Close()
+	home, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(home)
+	if err := fake.WriteNetrc(home); err != nil {
+		t.Fatalf("%v", err)
+	}
+	oldHome := os.Getenv("HOME")
+	defer os.Setenv("HOME", oldHome)
+	os.Setenv("HOME", home)
+
+	// CL 1000 uses the "Verified" label, CL 2000 does not.
+	verified := gerrit.GenCL(1000, 1, "release.go.core")
+	ref := verified.Reference()
+	verified.Current_revision = "0123456789abcdef"
+	verified.Revisions = gerrit.Revisions{
+		verified.Current_revision: gerrit.Revision{
+			Fetch: gerrit.Fetch{Http: gerrit.Http{Ref: ref}},
+			Files: gerrit.Files{"/COMMIT_MSG": {}, "b.go": {}, "a.go": {}},
+		},
+	}
+	verified.Labels = map[string]map[string]interface{}{"Verified": {"rejected": struct{}{}}}
+	unverified := gerrit.GenCL(2000, 1, "release.js.core")
+	for _, change := range []gerrit.Change{verified, unverified} {
+		if err := fake.AddChange(change); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+	system := review.NewGerrit(jirix, fake.URL)
+
+	// Query the changes.
+	changes, err := system.Query("(status:open -project:experimental)")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if got, want := len(changes), 2; got != want {
+		t.Fatalf("got %v changes, want %v", got, want)
+	}
+	changes, err = system.Query("status:open commit:0123456")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if got, want := len(changes), 1; got != want {
+		t.Fatalf("got %v changes, want %v", got, want)
+	}
+	change := changes[0]
+	if got, want := change, (review.Change{
+		ID:            "I0000000000000000000000000000000000001000",
+		Number:        1000,
+		Patchset:      1,
+		Revision:      "0123456789abcdef",
+		Ref:           ref,
+		Project:       "release.go.core",
+		Owner:         verified.OwnerEmail(),
+		Files:         []string{"a.go", "b.go"},
+		Labels:        map[string][]string{"Verified": {"rejected"}},
+		PresubmitTest: string(verified.PresubmitTest),
+	}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %#v, want %#v", got, want)
+	}
+	if changes, err = system.Query("project:release.js.core"); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if got, want := len(changes), 1; got != want {
+		t.Fatalf("got %v changes, want %v", got, want)
+	}
+	other := changes[0]
+
+	// Post reviews; only CL 1000 gets a vote.
+	if err := system.PostReview(change, "failed", false); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := system.PostReview(other, "passed", true); err != nil {
+		t.Fatalf("%v", err)
+	}
+	reviews := fake.Reviews()
+	if got, want := len(reviews), 2; got != want {
+		t.Fatalf("got %v reviews, want %v", got, want)
+	}
+	if got, want := reviews[0], (reviewtest.Review{CL: 1000, Patchset: 1, Message: "failed", Labels: map[string]string{"Verified": "-1"}}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %#v, want %#v", got, want)
+	}
+	if got, want := len(reviews[1].Labels), 0; got != want {
+		t.Fatalf("got %v labels, want %v", got, want)
+	}
+
+	// Post a message, which does not vote even on CL 1000.
+	if err := system.PostMessage(change, "culprit"); err != nil {
+		t.Fatalf("%v", err)
+	}
+	reviews = fake.Reviews()
+	if got, want := len(reviews), 3; got != want {
+		t.Fatalf("got %v reviews, want %v", got, want)
+	}
+	if got, want := reviews[2].Message, "culprit"; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got, want := len(reviews[2].Labels), 0; got != want {
+		t.Fatalf("got %v labels, want %v", got, want)
+	}
+
+	// Submit CL 1000, which merges it.
+	if err := system.Submit(change); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if got, want := fake.Submitted(), []string{change.ID}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if changes, err = system.Query("status:open"); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if got, want := len(changes), 1; got != want {
+		t.Fatalf("got %v changes, want %v", got, want)
+	}
+	if changes, err = system.Query("change:1000 status:merged"); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if got, want := len(changes), 1; got != want {
+		t.Fatalf("got %v changes, want %v", got, want)
+	}
+	if err := system.Submit(other); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := system.Submit(other); err == nil {
+		t.Fatalf("submitting a merged change did not fail")
+	}
+}
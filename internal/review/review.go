@@ -0,0 +1,67 @@
+// Copyright 2015 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package review provides an interface to the code review systems that
+// hold the changes presubmit tests, along with an implementation backed
+// by Gerrit.
+//
+// Changes are represented using the Change type of this package, which
+// implementations (e.g. one for GitHub pull requests) convert the
+// changes of their code review system to.
+package review
+
+// Change represents a change in a code review system.
+type Change struct {
+	// ID identifies the change in the code review system.
+	ID string
+	// Number identifies the change to users, and Patchset identifies
+	// the current version of the change.
+	Number   int
+	Patchset int
+	// Revision is the commit of the current version of the change,
+	// which can be fetched from the git reference Ref.
+	Revision string
+	Ref      string
+	// Project is the project the change belongs to.
+	Project string
+	// Topic is the topic of the change, if any.
+	Topic string
+	// Owner is the email address of the owner of the change.
+	Owner string
+	// Files lists the files modified by the current version of the
+	// change, relative to the root of its project.
+	Files []string
+	// Labels maps the labels of the change to their states, such as
+	// "approved" or "rejected".
+	Labels map[string][]string
+	// AutoSubmit, MultiPart and PresubmitTest hold the presubmit
+	// settings of the change, which are read from its description.
+	AutoSubmit    bool
+	MultiPart     *MultiPart
+	PresubmitTest string
+}
+
+// MultiPart identifies a change as a part of a set of changes that
+// must be tested and submitted together.
+type MultiPart struct {
+	Topic string
+	Index int
+	Total int
+}
+
+// System is the interface of code review systems.
+type System interface {
+	// Query returns the changes that match the given query, which uses
+	// the query language of the code review system.
+	Query(query string) ([]Change, error)
+	// PostReview posts the given message to the current version of the
+	// given change. If the change requires a verification vote, the
+	// vote is set according to the given outcome of its tests.
+	PostReview(change Change, message string, verified bool) error
+	// PostMessage posts the given message to the current version of
+	// the given change, without voting on the change.
+	PostMessage(change Change, message string) error
+	// Submit submits the given change.
+	Submit(change Change) error
+}
@@ -0,0 +1,275 @@
+// Copyright 2015 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package reviewtest provides fake code review systems for tests.
+package reviewtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"v.io/jiri/gerrit"
+)
+
+// xssiPrefix is the prefix Gerrit adds to its JSON responses to
+// prevent cross-site script inclusion.
+const xssiPrefix = ")]}'\n"
+
+// Review records a review posted to a FakeGerrit.
+type Review struct {
+	// CL and Patchset identify the reviewed patchset.
+	CL       int
+	Patchset int
+	// Message is the message of the review.
+	Message string
+	// Labels holds the label votes of the review.
+	Labels map[string]string
+}
+
+// FakeGerrit is an in-memory HTTP server implementing the subset of the
+// Gerrit REST API used by the devtools: querying changes, posting
+// reviews and submitting changes.
+//
+// Queries support the "change:", "commit:", "project:", "topic:",
+// "status:open" and "status:merged" operators, which can be negated with
+// "-"; parentheses are ignored.
+type FakeGerrit struct {
+	// URL is the base URL of the server.
+	URL *url.URL
+
+	server    *httptest.Server
+	mu        sync.Mutex
+	changes   []gerrit.Change
+	reviews   []Review
+	submitted map[string]bool
+}
+
+// NewFakeGerrit starts a new fake Gerrit server. Clients need to be
+// able to find credentials for the server, which can be arranged using
+// WriteNetrc.
+func NewFakeGerrit() *FakeGerrit {
+	g := &FakeGerrit{submitted: map[string]bool{}}
+	g.server = httptest.NewServer(http.HandlerFunc(g.serveHTTP))
+	u, err := url.Parse(g.server.URL)
+	if err != nil {
+		panic(fmt.Sprintf("Parse(%v) failed: %v", g.server.URL, err))
+	}
+	g.URL = u
+	return g
+}
+
+// Close shuts down the server.
+func (g *FakeGerrit) Close() {
+	g.server.Close()
+}
+
+// WriteNetrc writes a .netrc file with credentials for the server to
+// the given directory, which tests should use as $HOME.
+func (g *FakeGerrit) WriteNetrc(dir string) error {
+	path := filepath.Join(dir, ".netrc")
+	content := fmt.Sprintf("machine %s login fake password fake\n", g.URL.Host)
+	if err := ioutil.WriteFile(path, []byte(content), os.FileMode(0600)); err != nil {
+		return fmt.Errorf("WriteFile(%v) failed: %v", path, err)
+	}
+	return nil
+}
+
+// AddChange adds the given open change to the server. Changes without
+// a change ID are assigned one derived from their CL number.
+func (g *FakeGerrit) AddChange(change gerrit.Change) error {
+	cl, _, err := gerrit.ParseRefString(change.Reference())
+	if err != nil {
+		return err
+	}
+	if change.Change_id == "" {
+		change.Change_id = fmt.Sprintf("I%040d", cl)
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.changes = append(g.changes, change)
+	return nil
+}
+
+// Reviews returns the reviews posted to the server.
+func (g *FakeGerrit) Reviews() []Review {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([]Review(nil), g.reviews...)
+}
+
+// Submitted returns the IDs of the changes submitted to the server, in
+// the order they were added.
+func (g *FakeGerrit) Submitted() []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	ids := []string{}
+	for _, change := range g.changes {
+		if g.submitted[change.Change_id] {
+			ids = append(ids, change.Change_id)
+		}
+	}
+	return ids
+}
+
+func (g *FakeGerrit) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	// Authenticated requests use the "/a" prefix.
+	path := strings.TrimPrefix(r.URL.Path, "/a")
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	switch {
+	case r.Method == "GET" && len(parts) == 1 && parts[0] == "changes":
+		g.query(w, r.URL.Query().Get("q"))
+	case r.Method == "POST" && len(parts) == 5 && parts[0] == "changes" && parts[2] == "revisions" && parts[4] == "review":
+		g.review(w, r, parts[1], parts[3])
+	case r.Method == "POST" && len(parts) == 3 && parts[0] == "changes" && parts[2] == "submit":
+		g.submit(w, parts[1])
+	default:
+		http.Error(w, "not found", http.StatusNotFound)
+	}
+}
+
+func (g *FakeGerrit) query(w http.ResponseWriter, query string) {
+	terms := strings.Fields(strings.NewReplacer("(", " ", ")", " ").Replace(query))
+	result := []gerrit.Change{}
+	for _, change := range g.changes {
+		match, err := g.matches(change, terms)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if match {
+			result = append(result, change)
+		}
+	}
+	writeJSON(w, result)
+}
+
+// matches determines whether the given change matches all of the given
+// query terms.
+func (g *FakeGerrit) matches(change gerrit.Change, terms []string) (bool, error) {
+	for _, term := range terms {
+		negate := strings.HasPrefix(term, "-")
+		term = strings.TrimPrefix(term, "-")
+		var match bool
+		switch {
+		case term == "status:open":
+			match = !g.submitted[change.Change_id]
+		case term == "status:merged":
+			match = g.submitted[change.Change_id]
+		case strings.HasPrefix(term, "commit:"):
+			// Commits can be abbreviated, and match any patchset of
+			// the change.
+			commit := strings.TrimPrefix(term, "commit:")
+			for revision := range change.Revisions {
+				if commit != "" && strings.HasPrefix(revision, commit) {
+					match = true
+				}
+			}
+		case strings.HasPrefix(term, "change:"):
+			cl, _, err := gerrit.ParseRefString(change.Reference())
+			if err != nil {
+				return false, err
+			}
+			match = strings.TrimPrefix(term, "change:") == strconv.Itoa(cl)
+		case strings.HasPrefix(term, "project:"):
+			match = strings.TrimPrefix(term, "project:") == change.Project
+		case strings.HasPrefix(term, "topic:"):
+			match = strings.TrimPrefix(term, "topic:") == change.Topic
+		default:
+			return false, fmt.Errorf("unsupported query term %q", term)
+		}
+		if match == negate {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func (g *FakeGerrit) review(w http.ResponseWriter, r *http.Request, clStr, patchsetStr string) {
+	cl, err := strconv.Atoi(clStr)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	patchset, err := strconv.Atoi(patchsetStr)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var input struct {
+		Message string            `json:"message"`
+		Labels  map[string]string `json:"labels"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	change := g.find(func(c gerrit.Change) bool {
+		n, _, err := gerrit.ParseRefString(c.Reference())
+		return err == nil && n == cl
+	})
+	if change == nil {
+		http.Error(w, fmt.Sprintf("change %d not found", cl), http.StatusNotFound)
+		return
+	}
+	for label := range input.Labels {
+		if _, ok := change.Labels[label]; !ok {
+			http.Error(w, fmt.Sprintf("label %q not found", label), http.StatusBadRequest)
+			return
+		}
+	}
+	g.reviews = append(g.reviews, Review{
+		CL:       cl,
+		Patchset: patchset,
+		Message:  input.Message,
+		Labels:   input.Labels,
+	})
+	writeJSON(w, map[string]interface{}{"labels": input.Labels})
+}
+
+func (g *FakeGerrit) submit(w http.ResponseWriter, id string) {
+	change := g.find(func(c gerrit.Change) bool { return c.Change_id == id })
+	if change == nil {
+		http.Error(w, fmt.Sprintf("change %q not found", id), http.StatusNotFound)
+		return
+	}
+	if g.submitted[id] {
+		http.Error(w, "change is merged", http.StatusConflict)
+		return
+	}
+	g.submitted[id] = true
+	writeJSON(w, map[string]string{"change_id": id, "status": "MERGED"})
+}
+
+func (g *FakeGerrit) find(pred func(gerrit.Change) bool) *gerrit.Change {
+	for i := range g.changes {
+		if pred(g.changes[i]) {
+			return &g.changes[i]
+		}
+	}
+	return nil
+}
+
+// writeJSON writes the given value as a Gerrit JSON response.
+func writeJSON(w http.ResponseWriter, value interface{}) {
+	bytes, err := json.Marshal(value)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, xssiPrefix)
+	w.Write(bytes)
+}
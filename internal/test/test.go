@@ -28,6 +28,56 @@ type Result struct {
 	ToolsBuildFailureMsg string              // Used when Status == ToolsBuildFailure
 	ExcludedTests        map[string][]string // Tests that are excluded within packages keyed by package name
 	SkippedTests         map[string][]string // Tests that are skipped within packages keyed by package name
+	Usage                *Usage              `json:",omitempty"` // Resources used by the test, if measured
+}
+
+// Usage records the resources used by a test.
+type Usage struct {
+	// WallTime is the time the test took.
+	WallTime time.Duration
+	// CPUTime is the user and system CPU time of the processes the
+	// test ran.
+	CPUTime time.Duration
+	// PeakRSS is the largest resident set size of the processes the
+	// test ran, in bytes, or zero if it is unknown.
+	PeakRSS int64
+}
+
+// Add returns the usage of running two tests, such as two parts of a
+// test, whose usages are u and v: times add up, and the peak RSS is the
+// larger of the two.
+func (u Usage) Add(v Usage) Usage {
+	peakRSS := u.PeakRSS
+	if v.PeakRSS > peakRSS {
+		peakRSS = v.PeakRSS
+	}
+	return Usage{
+		WallTime: u.WallTime + v.WallTime,
+		CPUTime:  u.CPUTime + v.CPUTime,
+		PeakRSS:  peakRSS,
+	}
+}
+
+func (u Usage) String() string {
+	s := fmt.Sprintf("wall time %v, CPU time %v", u.WallTime-u.WallTime%time.Second, u.CPUTime-u.CPUTime%time.Second)
+	if u.PeakRSS > 0 {
+		s += fmt.Sprintf(", peak RSS %v", FormatBytes(u.PeakRSS))
+	}
+	return s
+}
+
+// FormatBytes formats the given number of bytes using the largest
+// unit that keeps the number above 1, such as "1.5 GB".
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	value, units := float64(n)/unit, "KMGTPE"
+	for value >= unit && len(units) > 1 {
+		value, units = value/unit, units[1:]
+	}
+	return fmt.Sprintf("%.1f %cB", value, units[0])
 }
 
 const (
@@ -9,6 +9,8 @@ package main
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"v.io/jiri"
@@ -17,8 +19,41 @@ import (
 	"v.io/x/lib/cmdline"
 )
 
+// dateLayouts lists the layouts of the dates accepted on the command
+// line. Dates without a timezone are in the timezone given by -timezone.
+var dateLayouts = []string{
+	"2006-01-02",
+	"2006-01-02 15:04",
+	time.RFC3339,
+	tooldata.OncallTimeLayout,
+}
+
+var (
+	timezoneFlag    string
+	membersFlag     string
+	lengthFlag      string
+	fromFlag        string
+	shiftsFlag      int
+	unavailableFlag string
+	reasonFlag      string
+	primaryFlag     string
+	secondaryFlag   string
+	memberFlag      string
+)
+
 func init() {
 	tool.InitializeRunFlags(&cmdOncall.Flags)
+	cmdOncall.Flags.StringVar(&timezoneFlag, "timezone", "", "The IANA timezone of the dates on the command line, such as America/Los_Angeles. Defaults to the timezone of the rotation if it has one, and to the local timezone otherwise. Setting it for the generate command also converts the dates of the rotation to it and records it in the rotation.")
+	cmdOncallGenerate.Flags.StringVar(&membersFlag, "members", "", "Comma-separated list of the LDAPs of the members of the rotation.")
+	cmdOncallGenerate.Flags.StringVar(&lengthFlag, "length", "1w", "The length of a shift, as a number of weeks (1w), days (3d) or a duration (12h).")
+	cmdOncallGenerate.Flags.StringVar(&fromFlag, "from", "", "The start of the first generated shift. Defaults to the end of the current schedule.")
+	cmdOncallGenerate.Flags.IntVar(&shiftsFlag, "shifts", 0, "The number of shifts to generate. Defaults to the number of members.")
+	cmdOncallGenerate.Flags.StringVar(&unavailableFlag, "unavailable", "", "Comma-separated list of <ldap>@<start>..<end> periods during which members cannot be oncall.")
+	cmdOncallSwap.Flags.StringVar(&reasonFlag, "reason", "", "The reason for the swap.")
+	cmdOncallOverride.Flags.StringVar(&reasonFlag, "reason", "", "The reason for the override.")
+	cmdOncallOverride.Flags.StringVar(&primaryFlag, "primary", "", "The LDAP of the substitute primary oncall.")
+	cmdOncallOverride.Flags.StringVar(&secondaryFlag, "secondary", "", "The LDAP of the substitute secondary oncall.")
+	cmdOncallICS.Flags.StringVar(&memberFlag, "member", "", "If set, only the shifts of the member with the given LDAP are exported.")
 }
 
 // cmdOncall represents the "jiri oncall" command.
@@ -29,8 +64,16 @@ var cmdOncall = &cmdline.Command{
 	Long: `
 Manage vanadium oncall schedule. If no subcommand is given, it shows the LDAP
 of the current oncall.
+
+The schedule consists of shifts, which are generated for the members of the
+rotation, and of overrides, which record one-off substitutions without changing
+the shifts.
+
+Dates on the command line are formatted as 2006-01-02, 2006-01-02 15:04, or
+2006-01-02T15:04:05-07:00. Dates without a timezone are in the timezone given by
+-timezone, which defaults to the timezone of the rotation.
 `,
-	Children: []*cmdline.Command{cmdOncallList},
+	Children: []*cmdline.Command{cmdOncallList, cmdOncallGenerate, cmdOncallSwap, cmdOncallOverride, cmdOncallICS},
 }
 
 // cmdOncallList represents the "jiri oncall list" command.
@@ -41,6 +84,59 @@ var cmdOncallList = &cmdline.Command{
 	Long:   "List available oncall schedule.",
 }
 
+// cmdOncallGenerate represents the "jiri oncall generate" command.
+var cmdOncallGenerate = &cmdline.Command{
+	Runner: jiri.RunnerFunc(runOncallGenerate),
+	Name:   "generate",
+	Short:  "Generate oncall shifts",
+	Long: `
+Generate oncall shifts for the given members, replacing the shifts that start at
+or after the start of the first generated shift. The overrides of the replaced
+shifts are dropped.
+
+The rotation is fair: the primary of each shift is the available member who has
+been primary the least often, and the secondary is the other available member
+who has been secondary the least often, counting the shifts that are kept.
+`,
+}
+
+// cmdOncallSwap represents the "jiri oncall swap" command.
+var cmdOncallSwap = &cmdline.Command{
+	Runner: jiri.RunnerFunc(runOncallSwap),
+	Name:   "swap",
+	Short:  "Swap the oncalls of two shifts",
+	Long: `
+Swap the primary and secondary oncalls of the shifts at the given dates, by
+recording an override for each shift.
+`,
+	ArgsName: "<date> <date>",
+	ArgsLong: "<date> <date> are dates in the two shifts to swap.",
+}
+
+// cmdOncallOverride represents the "jiri oncall override" command.
+var cmdOncallOverride = &cmdline.Command{
+	Runner: jiri.RunnerFunc(runOncallOverride),
+	Name:   "override",
+	Short:  "Substitute the oncall for a period",
+	Long: `
+Record a substitution of the primary and/or secondary oncall for the given
+period.
+`,
+	ArgsName: "<start> [<end>]",
+	ArgsLong: "<start> [<end>] is the period of the substitution, which ends with the shift at <start> if <end> is not given.",
+}
+
+// cmdOncallICS represents the "jiri oncall ics" command.
+var cmdOncallICS = &cmdline.Command{
+	Runner: jiri.RunnerFunc(runOncallICS),
+	Name:   "ics",
+	Short:  "Export the oncall schedule as an iCalendar file",
+	Long: `
+Print the oncall schedule, with the overrides applied, as an iCalendar (.ics)
+file that calendar applications can import or subscribe to.
+`,
+}
+
 func runOncall(jirix *jiri.X, _ []string) error {
 	shift, err := tooldata.Oncall(jirix, time.Now())
 	if err != nil {
@@ -50,37 +146,265 @@ func runOncall(jirix *jiri.X, _ []string) error {
 	return nil
 }
 
-func runOncallList(jirix *jiri.X, _ []string) error {
+// loadRotation loads the oncall rotation and returns it with the
+// timezone of its dates and the timezone of the dates on the command
+// line.
+func loadRotation(jirix *jiri.X) (*tooldata.OncallRotation, *time.Location, *time.Location, error) {
 	rotation, err := tooldata.LoadOncallRotation(jirix)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	loc, err := rotation.Location(time.Local)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if timezoneFlag == "" {
+		return rotation, loc, loc, nil
+	}
+	argLoc, err := time.LoadLocation(timezoneFlag)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("LoadLocation(%v) failed: %v", timezoneFlag, err)
+	}
+	return rotation, loc, argLoc, nil
+}
+
+// parseDate parses the given command-line date in the given timezone.
+func parseDate(value string, loc *time.Location) (time.Time, error) {
+	for _, layout := range dateLayouts {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q", value)
+}
+
+// parseLength parses the given shift length. Weeks and days are
+// calendar units, so that shifts keep starting at the same time of day
+// across daylight saving time changes.
+func parseLength(value string) (tooldata.OncallShiftLength, error) {
+	for suffix, days := range map[string]int{"w": 7, "d": 1} {
+		if strings.HasSuffix(value, suffix) {
+			n, err := strconv.Atoi(strings.TrimSuffix(value, suffix))
+			if err != nil {
+				return tooldata.OncallShiftLength{}, fmt.Errorf("invalid length %q", value)
+			}
+			return tooldata.OncallShiftLength{Days: n * days}, nil
+		}
+	}
+	length, err := time.ParseDuration(value)
+	if err != nil {
+		return tooldata.OncallShiftLength{}, fmt.Errorf("invalid length %q", value)
+	}
+	return tooldata.OncallShiftLength{Duration: length}, nil
+}
+
+// parseUnavailable parses the given comma-separated list of
+// <ldap>@<start>..<end> periods.
+func parseUnavailable(value string, loc *time.Location) ([]tooldata.OncallUnavailability, error) {
+	result := []tooldata.OncallUnavailability{}
+	if value == "" {
+		return result, nil
+	}
+	for _, period := range strings.Split(value, ",") {
+		parts := strings.SplitN(period, "@", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid unavailability %q", period)
+		}
+		dates := strings.SplitN(parts[1], "..", 2)
+		if len(dates) != 2 {
+			return nil, fmt.Errorf("invalid unavailability %q", period)
+		}
+		start, err := parseDate(dates[0], loc)
+		if err != nil {
+			return nil, err
+		}
+		end, err := parseDate(dates[1], loc)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, tooldata.OncallUnavailability{Member: parts[0], Start: start, End: end})
+	}
+	return result, nil
+}
+
+func runOncallList(jirix *jiri.X, _ []string) error {
+	rotation, loc, _, err := loadRotation(jirix)
 	if err != nil {
 		return err
 	}
 	// Print the schedule with the current oncall marked.
-	layout := "Jan 2, 2006 3:04:05 PM"
-	now := time.Now().Unix()
-	foundOncall := false
+	current, err := rotation.ShiftIndex(time.Now(), loc)
+	if err != nil {
+		return err
+	}
 	for i, shift := range rotation.Shifts {
 		prefix := "   "
-		if !foundOncall && i < len(rotation.Shifts)-1 {
-			nextDate := rotation.Shifts[i+1].Date
-			nextTimestamp, err := time.Parse(layout, nextDate)
-			if err != nil {
-				fmt.Fprintf(jirix.Stderr(), "Parse(%q, %v) failed: %v", layout, nextDate, err)
-				continue
-			}
-			if now < nextTimestamp.Unix() {
-				prefix = "-> "
-				foundOncall = true
-			}
-		}
-		if i == len(rotation.Shifts)-1 && !foundOncall {
+		if i == current {
 			prefix = "-> "
 		}
 		fmt.Fprintf(jirix.Stdout(), "%s%25s: %s\n", prefix, shift.Date, shift.Primary)
 	}
+	for _, override := range rotation.Overrides {
+		fmt.Fprintf(jirix.Stdout(), "   %25s: %s,%s until %s", override.Start, override.Primary, override.Secondary, override.End)
+		if override.Reason != "" {
+			fmt.Fprintf(jirix.Stdout(), " (%s)", override.Reason)
+		}
+		fmt.Fprintln(jirix.Stdout())
+	}
 	return nil
 }
 
+func runOncallGenerate(jirix *jiri.X, _ []string) error {
+	rotation, loc, argLoc, err := loadRotation(jirix)
+	if err != nil {
+		return err
+	}
+	// The new shifts are generated in the given timezone, which all the
+	// dates of the rotation must then be in.
+	if timezoneFlag != "" && timezoneFlag != rotation.Timezone {
+		if err := rotation.SetTimezone(timezoneFlag, loc); err != nil {
+			return err
+		}
+		loc = argLoc
+	}
+	members := []string{}
+	for _, member := range strings.Split(membersFlag, ",") {
+		if member = strings.TrimSpace(member); member != "" {
+			members = append(members, member)
+		}
+	}
+	if len(members) < 2 {
+		return jirix.UsageErrorf("-members must list at least two members")
+	}
+	length, err := parseLength(lengthFlag)
+	if err != nil {
+		return jirix.UsageErrorf("%v", err)
+	}
+	var from time.Time
+	switch {
+	case fromFlag != "":
+		if from, err = parseDate(fromFlag, argLoc); err != nil {
+			return jirix.UsageErrorf("%v", err)
+		}
+	case len(rotation.Shifts) > 0:
+		if from, err = rotation.ShiftEnd(len(rotation.Shifts)-1, loc); err != nil {
+			return err
+		}
+	default:
+		return jirix.UsageErrorf("-from must be set for an empty schedule")
+	}
+	n := shiftsFlag
+	if n == 0 {
+		n = len(members)
+	}
+	unavailable, err := parseUnavailable(unavailableFlag, argLoc)
+	if err != nil {
+		return jirix.UsageErrorf("%v", err)
+	}
+	dropped, err := rotation.Generate(members, from, length, n, unavailable, loc)
+	if err != nil {
+		return err
+	}
+	if err := tooldata.SaveOncallRotation(jirix, rotation); err != nil {
+		return err
+	}
+	for _, override := range dropped {
+		fmt.Fprintf(jirix.Stdout(), "Dropped the override of the replaced shifts at %s: %s,%s until %s\n", override.Start, override.Primary, override.Secondary, override.End)
+	}
+	for _, shift := range rotation.Shifts[len(rotation.Shifts)-n:] {
+		fmt.Fprintf(jirix.Stdout(), "%25s: %s,%s\n", shift.Date, shift.Primary, shift.Secondary)
+	}
+	return nil
+}
+
+func runOncallSwap(jirix *jiri.X, args []string) error {
+	if len(args) != 2 {
+		return jirix.UsageErrorf("unexpected number of arguments")
+	}
+	rotation, loc, argLoc, err := loadRotation(jirix)
+	if err != nil {
+		return err
+	}
+	dates := []time.Time{}
+	for _, arg := range args {
+		date, err := parseDate(arg, argLoc)
+		if err != nil {
+			return jirix.UsageErrorf("%v", err)
+		}
+		dates = append(dates, date)
+	}
+	if err := rotation.Swap(dates[0], dates[1], reasonFlag, loc); err != nil {
+		return err
+	}
+	return tooldata.SaveOncallRotation(jirix, rotation)
+}
+
+func runOncallOverride(jirix *jiri.X, args []string) error {
+	if len(args) != 1 && len(args) != 2 {
+		return jirix.UsageErrorf("unexpected number of arguments")
+	}
+	if primaryFlag == "" && secondaryFlag == "" {
+		return jirix.UsageErrorf("-primary or -secondary must be set")
+	}
+	rotation, loc, argLoc, err := loadRotation(jirix)
+	if err != nil {
+		return err
+	}
+	start, err := parseDate(args[0], argLoc)
+	if err != nil {
+		return jirix.UsageErrorf("%v", err)
+	}
+	var end time.Time
+	if len(args) == 2 {
+		if end, err = parseDate(args[1], argLoc); err != nil {
+			return jirix.UsageErrorf("%v", err)
+		}
+	} else {
+		i, err := rotation.ShiftIndex(start, loc)
+		if err != nil {
+			return err
+		}
+		if i == -1 {
+			return fmt.Errorf("no shift at %v", start)
+		}
+		if end, err = rotation.ShiftEnd(i, loc); err != nil {
+			return err
+		}
+	}
+	if !start.Before(end) {
+		return jirix.UsageErrorf("the override must end after it starts")
+	}
+	rotation.Overrides = append(rotation.Overrides, tooldata.OncallOverride{
+		Primary:   primaryFlag,
+		Secondary: secondaryFlag,
+		Start:     tooldata.FormatOncallTime(start, loc),
+		End:       tooldata.FormatOncallTime(end, loc),
+		Reason:    reasonFlag,
+	})
+	return tooldata.SaveOncallRotation(jirix, rotation)
+}
+
+func runOncallICS(jirix *jiri.X, _ []string) error {
+	rotation, loc, _, err := loadRotation(jirix)
+	if err != nil {
+		return err
+	}
+	periods, err := rotation.Schedule(loc)
+	if err != nil {
+		return err
+	}
+	if memberFlag != "" {
+		filtered := []tooldata.OncallPeriod{}
+		for _, period := range periods {
+			if period.Primary == memberFlag || period.Secondary == memberFlag {
+				filtered = append(filtered, period)
+			}
+		}
+		periods = filtered
+	}
+	return tooldata.WriteOncallCalendar(jirix.Stdout(), periods, time.Now())
+}
+
 func main() {
 	cmdline.Main(cmdOncall)
 }
@@ -15,6 +15,7 @@ The jiri test commands are:
    poll        Poll existing jiri projects
    project     Run tests for a vanadium project
    run         Run vanadium tests
+   repro       Reproduce a vanadium test locally
    list        List vanadium tests
    help        Display help for commands or topics
 
@@ -121,6 +122,14 @@ The jiri test run flags are:
    tests. Setting this flag to 'false' may lead to faster Go builds, but it may
    also result in some source code changes not being reflected in the tests
    (e.g., if the change was made in a different Go workspace).
+ -coverage-base=
+   Git revision against which to compute the coverage of changed lines; only
+   relevant for Go coverage tests. If empty, the coverage of changed lines is
+   not computed.
+ -limit-pkgs=false
+   Whether -pkgs limits the default packages of each Go-based test instead of
+   replacing them: requested packages outside of the defaults of a test are
+   ignored, and a test none of whose defaults are requested tests all of them.
  -mock-file-contents=
    Colon-separated file contents to check when testing presubmit test. This flag
    is only used when running presubmit end-to-end test.
@@ -137,6 +146,9 @@ The jiri test run flags are:
    Comma-separated list of Go package expressions that identify a subset of
    tests to run; only relevant for Go-based tests. Example usage: jiri test run
    -pkgs v.io/x/ref vanadium-go-test
+ -sigquit-grace=30s
+   How long before killing a hung Go test binary to send it SIGQUIT, which makes
+   it dump the stacks of all goroutines; only relevant for Go-based tests.
  -v23.namespace.root=/ns.dev.v.io:8101
    The namespace root.
 
@@ -157,6 +169,54 @@ The jiri test run flags are:
  -v=false
    Print verbose output.
 
+Jiri test repro - Reproduce a vanadium test locally
+
+Sets up a vanadium test in the same way as the continuous integration system
+does and prints the commands the test would run, so that a test failure can be
+reproduced locally. Profiles are neither installed nor updated, so the command
+works offline against the local checkout.
+
+Only tests that record the commands they would run can be reproduced: the Go
+tests run by the Go test runner, such as vanadium-go-test, vanadium-go-race and
+vanadium-integration-test, and the script tests defined by the tools
+configuration. Other tests, such as builds, deployments and Makefile-based
+tests, run commands that cannot be recorded, and reproducing them fails before
+any of their commands runs.
+
+Usage:
+   jiri test repro [flags] <name>
+
+<name> identifies the test to reproduce.
+
+The jiri test repro flags are:
+ -part=-1
+   Specify which part of the test to reproduce.
+ -pkgs=
+   Comma-separated list of Go package expressions that identify a subset of
+   tests to reproduce; only relevant for Go-based tests.
+ -run=
+   Regular expression that identifies the Go tests to reproduce; only relevant
+   for Go-based tests.
+ -shell=false
+   Start a shell in the environment of the test after printing its commands.
+
+ -color=true
+   Use color to format output.
+ -env=
+   specify an environment variable in the form: <var>=[<val>],...
+ -merge-policies=+CCFLAGS,+CGO_CFLAGS,+CGO_CXXFLAGS,+CGO_LDFLAGS,+CXXFLAGS,GOARCH,GOOS,GOPATH:,^GOROOT*,+LDFLAGS,:PATH,VDLPATH:
+   specify policies for merging environment variables
+ -profiles=v23:base
+   a comma separated list of profiles to use
+ -profiles-db=$JIRI_ROOT/.jiri_root/profile_db
+   the path, relative to JIRI_ROOT, that contains the profiles database.
+ -skip-profiles=false
+   if set, no profiles will be used
+ -target=<runtime.GOARCH>-<runtime.GOOS>
+   specifies a profile target in the following form: <arch>-<os>[@<version>]
+ -v=false
+   Print verbose output.
+
 Jiri test list - List vanadium tests
 
 List vanadium tests.
@@ -0,0 +1,406 @@
+// Copyright 2015 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package test
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"v.io/jiri"
+	"v.io/jiri/runutil"
+	"v.io/x/devtools/internal/test"
+	"v.io/x/devtools/internal/xunit"
+)
+
+// benchConfig holds the configuration of the benchmark regression
+// detection of vanadium-go-bench. It can be overridden by setting the
+// V23_BENCH_CONFIG environment variable to its JSON encoding.
+type benchConfig struct {
+	// Alpha is the significance level; a difference is only reported
+	// when the p-value of the significance test is below it.
+	Alpha float64 `json:"alpha"`
+	// BaselineRuns is the number of most recent runs that form the
+	// baseline window.
+	BaselineRuns int `json:"baselineRuns"`
+	// Count is the number of times each benchmark is run (i.e. the
+	// value of the "go test -count" flag).
+	Count int `json:"count"`
+	// MinBaselineRuns is the minimum number of runs that must exist in
+	// the store before regressions are reported.
+	MinBaselineRuns int `json:"minBaselineRuns"`
+	// Store is the path to the file that holds the benchmark history.
+	Store string `json:"store"`
+	// Threshold is the relative change (in percent) of a metric beyond
+	// which a significant difference is considered a regression.
+	Threshold float64 `json:"threshold"`
+}
+
+func defaultBenchConfig(testName string) *benchConfig {
+	return &benchConfig{
+		Alpha:           0.05,
+		BaselineRuns:    10,
+		Count:           5,
+		MinBaselineRuns: 3,
+		Store:           filepath.Join(os.Getenv("HOME"), "tmp", "benchmarks", testName+".json"),
+		Threshold:       10,
+	}
+}
+
+// loadBenchConfig returns the benchmark configuration for the given
+// test, taking the V23_BENCH_CONFIG environment variable into account.
+func loadBenchConfig(testName string) (*benchConfig, error) {
+	config := defaultBenchConfig(testName)
+	if configStr := os.Getenv("V23_BENCH_CONFIG"); configStr != "" {
+		if err := json.Unmarshal([]byte(configStr), config); err != nil {
+			return nil, fmt.Errorf("Unmarshal(%q) failed: %v", configStr, err)
+		}
+	}
+	if config.Count < 1 {
+		config.Count = 1
+	}
+	return config, nil
+}
+
+// benchResult represents a single benchmark result line of "go test
+// -bench" output.
+type benchResult struct {
+	Pkg        string             `json:"pkg"`
+	Name       string             `json:"name"`
+	Iterations int64              `json:"iterations"`
+	Metrics    map[string]float64 `json:"metrics"`
+}
+
+// benchRun represents the results of a single run of a benchmark test.
+type benchRun struct {
+	Timestamp time.Time     `json:"timestamp"`
+	Results   []benchResult `json:"results"`
+}
+
+// benchLineRE matches benchmark result lines such as "BenchmarkFoo-8
+// 1000000 1234 ns/op 56 B/op 2 allocs/op".
+var benchLineRE = regexp.MustCompile(`^(Benchmark\S+?)(?:-\d+)?\s+(\d+)\s+(.*)$`)
+
+// parseBenchOutput extracts the benchmark results from the output of
+// "go test -bench" for the given package.
+func parseBenchOutput(pkg, output string) []benchResult {
+	results := []benchResult{}
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		matches := benchLineRE.FindStringSubmatch(strings.TrimSpace(scanner.Text()))
+		if matches == nil {
+			continue
+		}
+		iterations, err := strconv.ParseInt(matches[2], 10, 64)
+		if err != nil {
+			continue
+		}
+		// The remainder of the line is a sequence of <value> <unit> pairs.
+		fields := strings.Fields(matches[3])
+		if len(fields) < 2 || len(fields)%2 != 0 {
+			continue
+		}
+		metrics := map[string]float64{}
+		for i := 0; i < len(fields); i += 2 {
+			value, err := strconv.ParseFloat(fields[i], 64)
+			if err != nil {
+				metrics = nil
+				break
+			}
+			metrics[fields[i+1]] = value
+		}
+		if len(metrics) == 0 {
+			continue
+		}
+		results = append(results, benchResult{
+			Pkg:        pkg,
+			Name:       matches[1],
+			Iterations: iterations,
+			Metrics:    metrics,
+		})
+	}
+	return results
+}
+
+// readBenchHistory reads the benchmark runs recorded in the given
+// store. The store contains one JSON-encoded benchRun per line.
+func readBenchHistory(jirix *jiri.X, store string) ([]benchRun, error) {
+	data, err := jirix.NewSeq().ReadFile(store)
+	if err != nil {
+		if runutil.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	runs := []benchRun{}
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		var run benchRun
+		if err := json.Unmarshal([]byte(line), &run); err != nil {
+			return nil, fmt.Errorf("Unmarshal(%v) failed: %v", line, err)
+		}
+		runs = append(runs, run)
+	}
+	return runs, nil
+}
+
+// appendBenchRun appends the given benchmark run to the given store.
+func appendBenchRun(jirix *jiri.X, store string, run benchRun) (e error) {
+	bytes, err := json.Marshal(run)
+	if err != nil {
+		return fmt.Errorf("Marshal(%v) failed: %v", run, err)
+	}
+	if err := jirix.NewSeq().MkdirAll(filepath.Dir(store), os.FileMode(0755)).Done(); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(store, os.O_APPEND|os.O_CREATE|os.O_WRONLY, os.FileMode(0644))
+	if err != nil {
+		return fmt.Errorf("OpenFile(%v) failed: %v", store, err)
+	}
+	defer func() {
+		if err := file.Close(); err != nil && e == nil {
+			e = err
+		}
+	}()
+	if _, err := file.Write(append(bytes, '\n')); err != nil {
+		return fmt.Errorf("Write(%v) failed: %v", store, err)
+	}
+	return nil
+}
+
+// checkBenchRun compares the given run of the benchmarks against the
+// baseline window of the history in the configured store, and reports
+// the regressions it finds by failing the given result of the test that
+// ran the benchmarks. Only complete runs of all benchmarks that passed,
+// regressions aside, are recorded in the history, so that the baseline
+// is not skewed by runs that were cut short or limited to some packages.
+func checkBenchRun(jirix *jiri.X, config *benchConfig, run benchRun, result *test.Result, complete bool) ([]benchRegression, error) {
+	history, err := readBenchHistory(jirix, config.Store)
+	if err != nil {
+		return nil, err
+	}
+	regressions := findBenchRegressions(config, history, run)
+	if complete && result.Status == test.Passed {
+		if err := appendBenchRun(jirix, config.Store, run); err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(jirix.Stdout(), "recorded %d benchmark results in %q\n", len(run.Results), config.Store)
+	}
+	for _, r := range regressions {
+		test.Fail(jirix.Context, "%s %v\n", r.pkg, r)
+	}
+	// A test that failed or timed out keeps its status.
+	if len(regressions) > 0 && result.Status == test.Passed {
+		result.Status = test.Failed
+	}
+	return regressions, nil
+}
+
+// benchKey identifies a metric of a benchmark.
+type benchKey struct {
+	pkg, name, unit string
+}
+
+// benchSamples groups the metric values of the given results by
+// benchmark and unit.
+func benchSamples(results []benchResult) map[benchKey][]float64 {
+	samples := map[benchKey][]float64{}
+	for _, result := range results {
+		for unit, value := range result.Metrics {
+			key := benchKey{result.Pkg, result.Name, unit}
+			samples[key] = append(samples[key], value)
+		}
+	}
+	return samples
+}
+
+// benchRegression describes a statistically significant regression of
+// a benchmark metric.
+type benchRegression struct {
+	pkg, name, unit string
+	// baseline and current are the medians of the baseline and current
+	// samples respectively.
+	baseline, current float64
+	// delta is the relative change in percent.
+	delta float64
+	// pValue is the p-value of the significance test.
+	pValue float64
+}
+
+func (r benchRegression) String() string {
+	return fmt.Sprintf("%s %s: %+.2f%% (%v -> %v), confidence %.2f%%", r.name, r.unit, r.delta, r.baseline, r.current, 100*(1-r.pValue))
+}
+
+// higherIsBetter determines whether higher values of the given unit
+// denote better performance.
+func higherIsBetter(unit string) bool {
+	return strings.HasSuffix(unit, "/s")
+}
+
+// findBenchRegressions compares the given run against the baseline
+// window of the given history and returns the metrics that regressed
+// beyond the configured threshold with the configured significance.
+func findBenchRegressions(config *benchConfig, history []benchRun, run benchRun) []benchRegression {
+	if len(history) > config.BaselineRuns {
+		history = history[len(history)-config.BaselineRuns:]
+	}
+	if len(history) < config.MinBaselineRuns {
+		return nil
+	}
+	baselineResults := []benchResult{}
+	for _, r := range history {
+		baselineResults = append(baselineResults, r.Results...)
+	}
+	baselineSamples, currentSamples := benchSamples(baselineResults), benchSamples(run.Results)
+	regressions := []benchRegression{}
+	for key, current := range currentSamples {
+		baseline, ok := baselineSamples[key]
+		if !ok {
+			continue
+		}
+		baselineMedian, currentMedian := median(baseline), median(current)
+		if baselineMedian == 0 {
+			continue
+		}
+		delta := 100 * (currentMedian - baselineMedian) / baselineMedian
+		worse := delta > config.Threshold
+		if higherIsBetter(key.unit) {
+			worse = -delta > config.Threshold
+		}
+		if !worse {
+			continue
+		}
+		pValue := mannWhitneyUTest(baseline, current)
+		if pValue >= config.Alpha {
+			continue
+		}
+		regressions = append(regressions, benchRegression{
+			pkg:      key.pkg,
+			name:     key.name,
+			unit:     key.unit,
+			baseline: baselineMedian,
+			current:  currentMedian,
+			delta:    delta,
+			pValue:   pValue,
+		})
+	}
+	sort.Sort(benchRegressionsByName(regressions))
+	return regressions
+}
+
+type benchRegressionsByName []benchRegression
+
+func (r benchRegressionsByName) Len() int      { return len(r) }
+func (r benchRegressionsByName) Swap(i, j int) { r[i], r[j] = r[j], r[i] }
+func (r benchRegressionsByName) Less(i, j int) bool {
+	if r[i].pkg != r[j].pkg {
+		return r[i].pkg < r[j].pkg
+	}
+	if r[i].name != r[j].name {
+		return r[i].name < r[j].name
+	}
+	return r[i].unit < r[j].unit
+}
+
+// benchRegressionSuites encodes the given regressions as xUnit test
+// suites with one failure per regression.
+func benchRegressionSuites(regressions []benchRegression) []xunit.TestSuite {
+	suites := []xunit.TestSuite{}
+	for _, r := range regressions {
+		name := fmt.Sprintf("%s [%s]", r.name, r.unit)
+		s := xunit.CreateTestSuiteWithFailure(r.pkg, name, "benchmark regression", r.String(), 0)
+		suites = append(suites, *s)
+	}
+	return suites
+}
+
+// median returns the median of the given values.
+func median(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
+
+type rankedSample struct {
+	value float64
+	// first identifies whether the sample belongs to the first group.
+	first bool
+}
+
+type rankedSamples []rankedSample
+
+func (s rankedSamples) Len() int           { return len(s) }
+func (s rankedSamples) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s rankedSamples) Less(i, j int) bool { return s[i].value < s[j].value }
+
+// mannWhitneyUTest returns the two-sided p-value of the Mann-Whitney U
+// test for the given samples (the test used by benchstat), using the
+// normal approximation with tie correction.
+func mannWhitneyUTest(x, y []float64) float64 {
+	n1, n2 := len(x), len(y)
+	if n1 == 0 || n2 == 0 {
+		return 1
+	}
+	all := make(rankedSamples, 0, n1+n2)
+	for _, v := range x {
+		all = append(all, rankedSample{v, true})
+	}
+	for _, v := range y {
+		all = append(all, rankedSample{v, false})
+	}
+	sort.Sort(all)
+
+	// Assign ranks, averaging the ranks of tied values.
+	n := len(all)
+	rankSum, tieCorrection := 0.0, 0.0
+	for i := 0; i < n; {
+		j := i
+		for j < n && all[j].value == all[i].value {
+			j++
+		}
+		rank := float64(i+j+1) / 2
+		for k := i; k < j; k++ {
+			if all[k].first {
+				rankSum += rank
+			}
+		}
+		if t := float64(j - i); t > 1 {
+			tieCorrection += t*t*t - t
+		}
+		i = j
+	}
+
+	fn1, fn2, fn := float64(n1), float64(n2), float64(n)
+	u := rankSum - fn1*(fn1+1)/2
+	mean := fn1 * fn2 / 2
+	variance := fn1 * fn2 / 12 * ((fn + 1) - tieCorrection/(fn*(fn-1)))
+	if variance <= 0 {
+		return 1
+	}
+	// Apply continuity correction.
+	z := math.Abs(u-mean) - 0.5
+	if z < 0 {
+		z = 0
+	}
+	z /= math.Sqrt(variance)
+	return math.Erfc(z / math.Sqrt2)
+}
@@ -0,0 +1,191 @@
+// Copyright 2015 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package test
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"v.io/jiri/jiritest"
+	"v.io/x/devtools/internal/test"
+)
+
+func TestParseBenchOutput(t *testing.T) {
+	output := `=== RUN   TestFoo
+--- PASS: TestFoo (0.00s)
+PASS
+BenchmarkFoo-8   	 1000000	      1234 ns/op	      56 B/op	       2 allocs/op
+BenchmarkBar	     200	   5000000 ns/op	  12.50 MB/s
+BenchmarkBad-8   	 garbage
+ok  	v.io/x/foo	3.210s
+`
+	got := parseBenchOutput("v.io/x/foo", output)
+	want := []benchResult{
+		{
+			Pkg:        "v.io/x/foo",
+			Name:       "BenchmarkFoo",
+			Iterations: 1000000,
+			Metrics:    map[string]float64{"ns/op": 1234, "B/op": 56, "allocs/op": 2},
+		},
+		{
+			Pkg:        "v.io/x/foo",
+			Name:       "BenchmarkBar",
+			Iterations: 200,
+			Metrics:    map[string]float64{"ns/op": 5000000, "MB/s": 12.5},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %#v, want %#v", got, want)
+	}
+}
+
+func TestMannWhitneyUTest(t *testing.T) {
+	same := []float64{1, 2, 3, 4, 5}
+	if got := mannWhitneyUTest(same, same); got < 0.9 {
+		t.Fatalf("identical samples: got p-value %v, want >= 0.9", got)
+	}
+	x := []float64{10, 11, 10, 12, 11, 10, 11, 12, 10, 11}
+	y := []float64{20, 21, 22, 20, 21}
+	if got := mannWhitneyUTest(x, y); got >= 0.01 {
+		t.Fatalf("disjoint samples: got p-value %v, want < 0.01", got)
+	}
+}
+
+func TestFindBenchRegressions(t *testing.T) {
+	config := &benchConfig{
+		Alpha:           0.05,
+		BaselineRuns:    3,
+		MinBaselineRuns: 2,
+		Threshold:       10,
+	}
+	newRun := func(nsPerOp, mbPerSec []float64) benchRun {
+		run := benchRun{}
+		for i := range nsPerOp {
+			run.Results = append(run.Results, benchResult{
+				Pkg:     "v.io/x/foo",
+				Name:    "BenchmarkFoo",
+				Metrics: map[string]float64{"ns/op": nsPerOp[i], "MB/s": mbPerSec[i]},
+			})
+		}
+		return run
+	}
+	history := []benchRun{
+		// This run falls outside of the baseline window.
+		newRun([]float64{1, 1, 1, 1, 1}, []float64{1, 1, 1, 1, 1}),
+		newRun([]float64{100, 101, 99, 100, 102}, []float64{50, 51, 49, 50, 50}),
+		newRun([]float64{101, 100, 98, 99, 100}, []float64{50, 50, 51, 49, 50}),
+		newRun([]float64{100, 99, 101, 100, 100}, []float64{49, 50, 50, 51, 50}),
+	}
+
+	// Insufficient history.
+	if got := findBenchRegressions(config, history[:1], newRun([]float64{200}, []float64{50})); len(got) != 0 {
+		t.Fatalf("got %v, want no regressions", got)
+	}
+
+	// No regression.
+	if got := findBenchRegressions(config, history, newRun([]float64{100, 101, 99, 100, 100}, []float64{50, 50, 50, 51, 49})); len(got) != 0 {
+		t.Fatalf("got %v, want no regressions", got)
+	}
+
+	// Improvements are not regressions.
+	if got := findBenchRegressions(config, history, newRun([]float64{50, 51, 49, 50, 50}, []float64{100, 101, 99, 100, 100})); len(got) != 0 {
+		t.Fatalf("got %v, want no regressions", got)
+	}
+
+	// Regressions of both a lower-is-better and a higher-is-better metric.
+	got := findBenchRegressions(config, history, newRun([]float64{150, 151, 149, 150, 152}, []float64{25, 26, 24, 25, 25}))
+	if len(got) != 2 {
+		t.Fatalf("got %v, want 2 regressions", got)
+	}
+	if got[0].unit != "MB/s" || got[0].delta != -50 {
+		t.Fatalf("got %v, want MB/s regression of -50%%", got[0])
+	}
+	if got[1].unit != "ns/op" || got[1].delta != 50 {
+		t.Fatalf("got %v, want ns/op regression of +50%%", got[1])
+	}
+}
+
+func TestCheckBenchRun(t *testing.T) {
+	fake, cleanup := jiritest.NewFakeJiriRoot(t)
+	defer cleanup()
+
+	config := &benchConfig{
+		Alpha:           0.05,
+		BaselineRuns:    3,
+		MinBaselineRuns: 1,
+		Store:           filepath.Join(fake.X.Root, "bench.json"),
+		Threshold:       10,
+	}
+	newRun := func(nsPerOp ...float64) benchRun {
+		run := benchRun{}
+		for _, value := range nsPerOp {
+			run.Results = append(run.Results, benchResult{
+				Pkg:     "v.io/x/foo",
+				Name:    "BenchmarkFoo",
+				Metrics: map[string]float64{"ns/op": value},
+			})
+		}
+		return run
+	}
+	historyLen := func() int {
+		history, err := readBenchHistory(fake.X, config.Store)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		return len(history)
+	}
+	baseline := newRun(100, 101, 99, 100, 102)
+	slow := newRun(150, 151, 149, 150, 152)
+
+	// Runs that are incomplete or did not pass are not recorded.
+	for _, status := range []test.Status{test.Failed, test.TimedOut} {
+		result := &test.Result{Status: status}
+		if _, err := checkBenchRun(fake.X, config, baseline, result, true); err != nil {
+			t.Fatalf("%v", err)
+		}
+		if got, want := result.Status, status; got != want {
+			t.Fatalf("got status %v, want %v", got, want)
+		}
+	}
+	if _, err := checkBenchRun(fake.X, config, baseline, &test.Result{Status: test.Passed}, false); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if got, want := historyLen(), 0; got != want {
+		t.Fatalf("got %v recorded runs, want %v", got, want)
+	}
+
+	// A complete passing run is recorded.
+	if _, err := checkBenchRun(fake.X, config, baseline, &test.Result{Status: test.Passed}, true); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if got, want := historyLen(), 1; got != want {
+		t.Fatalf("got %v recorded runs, want %v", got, want)
+	}
+
+	// A regression fails a passing test, but a test that timed out
+	// keeps its status. The passing run is recorded, so that the
+	// baseline follows lasting changes of performance.
+	for _, status := range []test.Status{test.TimedOut, test.Passed} {
+		result := &test.Result{Status: status}
+		regressions, err := checkBenchRun(fake.X, config, slow, result, true)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		if got, want := len(regressions), 1; got != want {
+			t.Fatalf("got %v regressions, want %v", got, want)
+		}
+		want := status
+		if status == test.Passed {
+			want = test.Failed
+		}
+		if got := result.Status; got != want {
+			t.Fatalf("got status %v, want %v", got, want)
+		}
+	}
+	if got, want := historyLen(), 2; got != want {
+		t.Fatalf("got %v recorded runs, want %v", got, want)
+	}
+}
@@ -32,6 +32,9 @@ var (
 
 	// Regexp to match common test result files.
 	reTestResult = regexp.MustCompile(`^((tests_.*\.xml)|(status_.*\.json))$`)
+
+	// Regexp to match Go coverage profiles.
+	reCoverageProfile = regexp.MustCompile(`^coverage_.*\.out$`)
 )
 
 // internalTestError represents an internal test error.
@@ -101,12 +104,18 @@ func initTestImpl(jirix *jiri.X, needCleanup, updateProfiles, printProfiles bool
 	// Create a working test directory under $HOME/tmp and set the
 	// TMPDIR environment variable to it.
 	rootDir := filepath.Join(os.Getenv("HOME"), "tmp", testName)
+	var repro *reproduction
 	for _, opt := range opts {
 		switch typedOpt := opt.(type) {
 		case rootDirOpt:
 			rootDir = string(typedOpt)
+		case reproOpt:
+			repro = typedOpt.reproduction
 		}
 	}
+	if reproducing && repro == nil {
+		return nil, notReproducibleError(testName)
+	}
 	s := jirix.NewSeq()
 	workDir, err := s.MkdirAll(rootDir, os.FileMode(0755)).
 		TempDir(rootDir, "")
@@ -126,7 +135,9 @@ func initTestImpl(jirix *jiri.X, needCleanup, updateProfiles, printProfiles bool
 		return nil, err
 	}
 
-	if updateProfiles {
+	// Profiles are not updated when reproducing a test so that the
+	// reproduction works offline against the local checkout.
+	if updateProfiles && repro == nil {
 		insertTarget := func(profile string) []string {
 			if len(target) > 0 {
 				return []string{"--target=" + target, profile}
@@ -173,7 +184,7 @@ func initTestImpl(jirix *jiri.X, needCleanup, updateProfiles, printProfiles bool
 	}
 
 	// Remove all stale Go object files and binaries.
-	if cleanGo {
+	if cleanGo && repro == nil {
 		if err := s.Last("jiri", "goext", "distclean"); err != nil {
 			return nil, fmt.Errorf("jiri goext distclean: %v", err)
 		}
@@ -222,7 +233,8 @@ func findTestResultFiles(jirix *jiri.X, testName string) ([]string, error) {
 		}
 	}
 
-	// Collect xUnit xml files and test status json files.
+	// Collect xUnit xml files, test status json files and stale coverage
+	// profiles.
 	workspaceDir := os.Getenv("WORKSPACE")
 	if workspaceDir == "" {
 		workspaceDir = filepath.Join(os.Getenv("HOME"), "tmp", testName)
@@ -236,6 +248,11 @@ func findTestResultFiles(jirix *jiri.X, testName string) ([]string, error) {
 		if reTestResult.MatchString(fileName) {
 			result = append(result, filepath.Join(workspaceDir, fileName))
 		}
+		// Coverage profiles of earlier builds would be merged into the
+		// coverage reports of the current build.
+		if reCoverageProfile.MatchString(fileName) && !isCurrentCoverageProfile(fileName) {
+			result = append(result, filepath.Join(workspaceDir, fileName))
+		}
 	}
 	return result, nil
 }
@@ -0,0 +1,397 @@
+// Copyright 2015 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"v.io/jiri"
+	"v.io/jiri/project"
+)
+
+// coverBlock represents a single block of a Go coverage profile.
+type coverBlock struct {
+	file                string
+	startLine, startCol int
+	endLine, endCol     int
+	numStmt, count      int
+}
+
+// coverProfileLineRE matches the block lines of a Go coverage profile,
+// which have the form "<file>:<line>.<col>,<line>.<col> <stmts> <count>".
+var coverProfileLineRE = regexp.MustCompile(`^(.+):(\d+)\.(\d+),(\d+)\.(\d+) (\d+) (\d+)$`)
+
+// parseCoverProfile parses the given Go coverage profile (as produced
+// by "go test -coverprofile"), returning its mode and blocks.
+func parseCoverProfile(r io.Reader) (string, []coverBlock, error) {
+	mode, blocks := "", []coverBlock{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "mode: ") {
+			mode = strings.TrimPrefix(line, "mode: ")
+			continue
+		}
+		matches := coverProfileLineRE.FindStringSubmatch(line)
+		if matches == nil {
+			return "", nil, fmt.Errorf("invalid coverage profile line: %q", line)
+		}
+		values := make([]int, 6)
+		for i := range values {
+			value, err := strconv.Atoi(matches[i+2])
+			if err != nil {
+				return "", nil, fmt.Errorf("Atoi(%v) failed: %v", matches[i+2], err)
+			}
+			values[i] = value
+		}
+		blocks = append(blocks, coverBlock{
+			file:      matches[1],
+			startLine: values[0],
+			startCol:  values[1],
+			endLine:   values[2],
+			endCol:    values[3],
+			numStmt:   values[4],
+			count:     values[5],
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return "", nil, err
+	}
+	return mode, blocks, nil
+}
+
+// mergeCoverBlocks merges the given blocks, combining the counts of
+// blocks that describe the same source range. With "-coverpkg", every
+// test binary reports on all covered packages, so the same block is
+// typically reported once per tested package.
+func mergeCoverBlocks(mode string, blocks []coverBlock) []coverBlock {
+	type blockKey struct {
+		file                                 string
+		startLine, startCol, endLine, endCol int
+	}
+	merged := map[blockKey]*coverBlock{}
+	for _, b := range blocks {
+		key := blockKey{b.file, b.startLine, b.startCol, b.endLine, b.endCol}
+		existing, ok := merged[key]
+		if !ok {
+			block := b
+			merged[key] = &block
+			continue
+		}
+		if mode == "set" {
+			if b.count > existing.count {
+				existing.count = b.count
+			}
+		} else {
+			existing.count += b.count
+		}
+	}
+	result := make([]coverBlock, 0, len(merged))
+	for _, b := range merged {
+		result = append(result, *b)
+	}
+	sort.Sort(coverBlocks(result))
+	return result
+}
+
+type coverBlocks []coverBlock
+
+func (b coverBlocks) Len() int      { return len(b) }
+func (b coverBlocks) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b coverBlocks) Less(i, j int) bool {
+	if b[i].file != b[j].file {
+		return b[i].file < b[j].file
+	}
+	if b[i].startLine != b[j].startLine {
+		return b[i].startLine < b[j].startLine
+	}
+	return b[i].startCol < b[j].startCol
+}
+
+// writeCoverProfile writes the given blocks as a Go coverage profile.
+func writeCoverProfile(w io.Writer, mode string, blocks []coverBlock) error {
+	if _, err := fmt.Fprintf(w, "mode: %s\n", mode); err != nil {
+		return err
+	}
+	for _, b := range blocks {
+		if _, err := fmt.Fprintf(w, "%s:%d.%d,%d.%d %d %d\n", b.file, b.startLine, b.startCol, b.endLine, b.endCol, b.numStmt, b.count); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// coverageProfilePath returns the path to the coverage profile of the
+// given part of the given test. Profiles of all parts of a test that
+// are written to the same directory by the same build are merged into
+// the final reports.
+func coverageProfilePath(testName string, part int) string {
+	fileName := fmt.Sprintf("coverage_%s%s", strings.Replace(testName, "-", "_", -1), coverageBuildSuffix())
+	if part >= 0 {
+		fileName += fmt.Sprintf("_part%d", part)
+	}
+	return filepath.Join(filepath.Dir(coberturaReportPath(testName)), fileName+".out")
+}
+
+// coverageBuildSuffix returns the suffix of the names of the coverage
+// profiles written by the current CI build. It is empty outside of CI
+// builds.
+func coverageBuildSuffix() string {
+	if build := os.Getenv("BUILD_NUMBER"); build != "" {
+		return "_build" + build
+	}
+	return ""
+}
+
+// isCurrentCoverageProfile determines whether the coverage profile with
+// the given file name was written by the current CI build.
+func isCurrentCoverageProfile(fileName string) bool {
+	suffix := coverageBuildSuffix()
+	if suffix == "" {
+		return false
+	}
+	return strings.HasSuffix(fileName, suffix+".out") || strings.Contains(fileName, suffix+"_part")
+}
+
+// coverageSummaryPath returns the path to the JSON coverage summary.
+func coverageSummaryPath(testName string) string {
+	return filepath.Join(filepath.Dir(coberturaReportPath(testName)), "coverage_summary.json")
+}
+
+// readCoverageProfiles reads and merges the coverage profiles of the
+// given test written by the current build: the profiles of all parts of
+// the test in CI builds, and the profile of the given part otherwise.
+// Only the profiles of the parts that ran in the same $WORKSPACE are
+// found, so parts run on separate machines are not merged. Profiles of
+// earlier builds are removed by initTest.
+func readCoverageProfiles(jirix *jiri.X, testName string, part int) (string, []coverBlock, error) {
+	files := []string{coverageProfilePath(testName, part)}
+	if suffix := coverageBuildSuffix(); suffix != "" {
+		pattern := filepath.Join(filepath.Dir(coberturaReportPath(testName)), fmt.Sprintf("coverage_%s%s*.out", strings.Replace(testName, "-", "_", -1), suffix))
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return "", nil, fmt.Errorf("Glob(%v) failed: %v", pattern, err)
+		}
+		files = []string{}
+		for _, match := range matches {
+			if isCurrentCoverageProfile(filepath.Base(match)) {
+				files = append(files, match)
+			}
+		}
+	}
+	mode, blocks := "set", []coverBlock{}
+	for _, file := range files {
+		data, err := jirix.NewSeq().ReadFile(file)
+		if err != nil {
+			return "", nil, err
+		}
+		fileMode, fileBlocks, err := parseCoverProfile(bytes.NewReader(data))
+		if err != nil {
+			return "", nil, fmt.Errorf("%v: %v", file, err)
+		}
+		if fileMode != "" {
+			mode = fileMode
+		}
+		blocks = append(blocks, fileBlocks...)
+	}
+	return mode, mergeCoverBlocks(mode, blocks), nil
+}
+
+// coverageCounts records the number of covered and total statements
+// (or lines for diff coverage).
+type coverageCounts struct {
+	Covered int `json:"covered"`
+	Total   int `json:"total"`
+}
+
+func (c *coverageCounts) add(covered bool, n int) {
+	c.Total += n
+	if covered {
+		c.Covered += n
+	}
+}
+
+// coverageSummary is the plain JSON summary of a coverage run.
+type coverageSummary struct {
+	// Total holds the statement coverage across all packages.
+	Total coverageCounts `json:"total"`
+	// Packages holds the statement coverage per package.
+	Packages map[string]coverageCounts `json:"packages"`
+	// DiffBase is the git revision against which the diff coverage
+	// was computed.
+	DiffBase string `json:"diffBase,omitempty"`
+	// DiffTotal holds the coverage of the changed lines across all
+	// files.
+	DiffTotal *coverageCounts `json:"diffTotal,omitempty"`
+	// Diff holds the coverage of the changed lines per file.
+	Diff map[string]coverageCounts `json:"diff,omitempty"`
+}
+
+// summarizeCoverage computes the per-package statement coverage of the
+// given blocks.
+func summarizeCoverage(blocks []coverBlock) *coverageSummary {
+	summary := &coverageSummary{Packages: map[string]coverageCounts{}}
+	for _, b := range blocks {
+		pkg := path.Dir(b.file)
+		counts := summary.Packages[pkg]
+		counts.add(b.count > 0, b.numStmt)
+		summary.Packages[pkg] = counts
+		summary.Total.add(b.count > 0, b.numStmt)
+	}
+	return summary
+}
+
+// diffHunkRE matches the hunk headers of a unified diff, capturing the
+// start line and the (optional) line count of the new file.
+var diffHunkRE = regexp.MustCompile(`^@@ -\d+(?:,\d+)? \+(\d+)(?:,(\d+))? @@`)
+
+// parseUnifiedDiff returns the lines added or modified by the given
+// unified diff, keyed by the (relative) path of the changed file.
+func parseUnifiedDiff(diff string) (map[string][]int, error) {
+	changed := map[string][]int{}
+	file := ""
+	scanner := bufio.NewScanner(strings.NewReader(diff))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "+++ ") {
+			file = strings.TrimPrefix(line, "+++ ")
+			if file == "/dev/null" {
+				file = ""
+			}
+			file = strings.TrimPrefix(file, "b/")
+			continue
+		}
+		matches := diffHunkRE.FindStringSubmatch(line)
+		if matches == nil || file == "" {
+			continue
+		}
+		start, err := strconv.Atoi(matches[1])
+		if err != nil {
+			return nil, fmt.Errorf("Atoi(%v) failed: %v", matches[1], err)
+		}
+		count := 1
+		if matches[2] != "" {
+			if count, err = strconv.Atoi(matches[2]); err != nil {
+				return nil, fmt.Errorf("Atoi(%v) failed: %v", matches[2], err)
+			}
+		}
+		for i := start; i < start+count; i++ {
+			changed[file] = append(changed[file], i)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return changed, nil
+}
+
+// gitChangedLines returns the Go source lines that were changed in the
+// local projects relative to the given git revision, keyed by the
+// absolute path of the changed file.
+func gitChangedLines(jirix *jiri.X, base string) (map[string][]int, error) {
+	projects, err := project.LocalProjects(jirix, project.FastScan)
+	if err != nil {
+		return nil, err
+	}
+	changed := map[string][]int{}
+	s := jirix.NewSeq()
+	for _, p := range projects {
+		if p.Protocol != "git" {
+			continue
+		}
+		var out bytes.Buffer
+		if err := s.Pushd(p.Path).Capture(&out, nil).Last("git", "diff", "--unified=0", "--no-color", base, "--", "*.go"); err != nil {
+			// The base revision does not necessarily exist in all
+			// projects.
+			fmt.Fprintf(jirix.Stderr(), "skipping diff coverage for %v: %v\n", p.Path, err)
+			continue
+		}
+		files, err := parseUnifiedDiff(out.String())
+		if err != nil {
+			return nil, err
+		}
+		for file, lines := range files {
+			changed[filepath.Join(p.Path, file)] = lines
+		}
+	}
+	return changed, nil
+}
+
+// computeDiffCoverage computes the coverage of the given changed lines
+// using the given blocks. Changed lines that are not part of any block
+// (e.g. comments) are not counted. Files in the coverage profile are
+// identified by their import path, so a changed file is matched with
+// the profile file that forms a suffix of its path in a Go workspace.
+func computeDiffCoverage(blocks []coverBlock, changed map[string][]int) map[string]coverageCounts {
+	fileBlocks := map[string][]coverBlock{}
+	for _, b := range blocks {
+		fileBlocks[b.file] = append(fileBlocks[b.file], b)
+	}
+	result := map[string]coverageCounts{}
+	for absPath, lines := range changed {
+		file := ""
+		slashPath := filepath.ToSlash(absPath)
+		for i := strings.Index(slashPath, "/src/"); i != -1; {
+			candidate := slashPath[i+len("/src/"):]
+			if _, ok := fileBlocks[candidate]; ok {
+				file = candidate
+				break
+			}
+			next := strings.Index(candidate, "/src/")
+			if next == -1 {
+				break
+			}
+			i += len("/src/") + next
+		}
+		if file == "" {
+			continue
+		}
+		counts := coverageCounts{}
+		for _, line := range lines {
+			coverable, covered := false, false
+			for _, b := range fileBlocks[file] {
+				if b.numStmt > 0 && b.startLine <= line && line <= b.endLine {
+					coverable = true
+					if b.count > 0 {
+						covered = true
+					}
+				}
+			}
+			if coverable {
+				counts.add(covered, 1)
+			}
+		}
+		if counts.Total > 0 {
+			result[file] = counts
+		}
+	}
+	return result
+}
+
+// writeCoverageSummary writes the given summary as JSON.
+func writeCoverageSummary(jirix *jiri.X, testName string, summary *coverageSummary) error {
+	bytes, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return fmt.Errorf("MarshalIndent(%v) failed: %v", summary, err)
+	}
+	if err := jirix.NewSeq().WriteFile(coverageSummaryPath(testName), bytes, os.FileMode(0644)).Done(); err != nil {
+		return fmt.Errorf("WriteFile(%v) failed: %v", coverageSummaryPath(testName), err)
+	}
+	return nil
+}
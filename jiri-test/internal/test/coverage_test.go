@@ -0,0 +1,189 @@
+// Copyright 2015 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"v.io/jiri/jiritest"
+)
+
+func TestMergeCoverProfiles(t *testing.T) {
+	// The profiles of two test binaries instrumented for the same
+	// packages.
+	profile1 := `mode: set
+v.io/x/foo/foo.go:5.20,7.2 2 1
+v.io/x/foo/foo.go:9.20,11.2 1 0
+v.io/x/bar/bar.go:3.14,5.2 1 0
+`
+	profile2 := `mode: set
+v.io/x/foo/foo.go:5.20,7.2 2 0
+v.io/x/foo/foo.go:9.20,11.2 1 0
+v.io/x/bar/bar.go:3.14,5.2 1 1
+`
+	blocks := []coverBlock{}
+	for _, profile := range []string{profile1, profile2} {
+		mode, b, err := parseCoverProfile(strings.NewReader(profile))
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		if got, want := mode, "set"; got != want {
+			t.Fatalf("got mode %v, want %v", got, want)
+		}
+		blocks = append(blocks, b...)
+	}
+	var out bytes.Buffer
+	if err := writeCoverProfile(&out, "set", mergeCoverBlocks("set", blocks)); err != nil {
+		t.Fatalf("%v", err)
+	}
+	want := `mode: set
+v.io/x/bar/bar.go:3.14,5.2 1 1
+v.io/x/foo/foo.go:5.20,7.2 2 1
+v.io/x/foo/foo.go:9.20,11.2 1 0
+`
+	if got := out.String(); got != want {
+		t.Fatalf("unexpected merged profile:\ngot\n%v\nwant\n%v", got, want)
+	}
+
+	summary := summarizeCoverage(mergeCoverBlocks("set", blocks))
+	if got, want := summary.Total, (coverageCounts{Covered: 3, Total: 4}); got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got, want := summary.Packages["v.io/x/foo"], (coverageCounts{Covered: 2, Total: 3}); got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	if _, _, err := parseCoverProfile(strings.NewReader("mode: set\ngarbage\n")); err == nil {
+		t.Fatalf("parsing an invalid profile did not fail")
+	}
+}
+
+func TestCoverageProfilesOfBuild(t *testing.T) {
+	jirix, cleanup := jiritest.NewX(t)
+	defer cleanup()
+	for key, value := range map[string]string{"WORKSPACE": jirix.Root, "BUILD_NUMBER": "12"} {
+		defer os.Setenv(key, os.Getenv(key))
+		if err := os.Setenv(key, value); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+
+	// Profiles of earlier builds and of other tests are not merged,
+	// and the stale ones are cleaned up.
+	profiles := map[string]string{
+		"coverage_vanadium_go_cover_build12_part0.out": "mode: set\nv.io/x/foo/foo.go:5.20,7.2 2 1\nv.io/x/foo/foo.go:9.20,11.2 1 0\n",
+		"coverage_vanadium_go_cover_build12_part1.out": "mode: set\nv.io/x/foo/foo.go:5.20,7.2 2 0\nv.io/x/foo/foo.go:9.20,11.2 1 1\n",
+		"coverage_vanadium_go_cover_build1_part0.out":  "mode: set\nv.io/x/bar/bar.go:3.14,5.2 1 1\n",
+		"coverage_vanadium_go_cover_part2.out":         "mode: set\nv.io/x/bar/bar.go:3.14,5.2 1 1\n",
+	}
+	for name, profile := range profiles {
+		if err := ioutil.WriteFile(filepath.Join(jirix.Root, name), []byte(profile), os.FileMode(0644)); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+	if got, want := coverageProfilePath("vanadium-go-cover", 1), filepath.Join(jirix.Root, "coverage_vanadium_go_cover_build12_part1.out"); got != want {
+		t.Fatalf("got profile path %v, want %v", got, want)
+	}
+	_, blocks, err := readCoverageProfiles(jirix, "vanadium-go-cover", 1)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if got, want := summarizeCoverage(blocks).Total, (coverageCounts{Covered: 3, Total: 3}); got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	files, err := findTestResultFiles(jirix, "vanadium-go-cover")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	sort.Strings(files)
+	want := []string{
+		filepath.Join(jirix.Root, "coverage_vanadium_go_cover_build1_part0.out"),
+		filepath.Join(jirix.Root, "coverage_vanadium_go_cover_part2.out"),
+	}
+	if !reflect.DeepEqual(files, want) {
+		t.Fatalf("got stale files %v, want %v", files, want)
+	}
+}
+
+func TestDiffCoverage(t *testing.T) {
+	diff := `diff --git a/go/src/v.io/x/foo/foo.go b/go/src/v.io/x/foo/foo.go
+index 1234567..89abcde 100644
+--- a/go/src/v.io/x/foo/foo.go
++++ b/go/src/v.io/x/foo/foo.go
+@@ -4,0 +5,2 @@ package foo
++func Foo() {
++	foo()
+@@ -20 +10 @@ func Bar() {
+-	bar()
++	baz()
+@@ -30,2 +20,0 @@ func Baz() {
+-	a()
+-	b()
+diff --git a/go/src/v.io/x/foo/gone.go b/go/src/v.io/x/foo/gone.go
+deleted file mode 100644
+--- a/go/src/v.io/x/foo/gone.go
++++ /dev/null
+@@ -1,3 +0,0 @@
+-package foo
+`
+	changed, err := parseUnifiedDiff(diff)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if got, want := changed, map[string][]int{"go/src/v.io/x/foo/foo.go": []int{5, 6, 10}}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	blocks := []coverBlock{
+		{file: "v.io/x/foo/foo.go", startLine: 5, startCol: 13, endLine: 7, endCol: 2, numStmt: 1, count: 1},
+		{file: "v.io/x/foo/foo.go", startLine: 9, startCol: 13, endLine: 11, endCol: 2, numStmt: 1, count: 0},
+	}
+	abs := map[string][]int{
+		"/jiri/release/go/src/v.io/x/foo/foo.go": []int{1, 5, 6, 10},
+		"/jiri/release/go/src/v.io/x/bar/bar.go": []int{1},
+	}
+	want := map[string]coverageCounts{
+		"v.io/x/foo/foo.go": coverageCounts{Covered: 2, Total: 3},
+	}
+	if got := computeDiffCoverage(blocks, abs); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestCoverageTasks(t *testing.T) {
+	pkgs := []string{"v.io/x/a", "v.io/x/b", "v.io/x/c", "v.io/x/d", "v.io/x/e"}
+	tests := map[string][]string{
+		"v.io/x/a": {"TestA", "TestV23A", "TestV23B"},
+		"v.io/x/b": {"TestB"},
+		"v.io/x/c": {"TestC"},
+		"v.io/x/e": {"TestV23E"},
+	}
+	integrationTests := map[string][]string{
+		"v.io/x/a": {"TestV23A", "TestV23B"},
+		"v.io/x/e": {"TestV23E"},
+	}
+	exclusions := []exclusion{newExclusion("v.io/x/c", ".*", true)}
+	integrationExclusions := []exclusion{
+		newExclusion("v.io/x/a", "TestV23B", true),
+		// Integration exclusions do not apply to other tests.
+		newExclusion("v.io/x/b", ".*", true),
+	}
+	want := []coverageTask{
+		{pkg: "v.io/x/a", specificTests: []string{"TestA", "TestV23A"}, integration: true},
+		{pkg: "v.io/x/b"},
+		{pkg: "v.io/x/d"},
+		{pkg: "v.io/x/e", integration: true},
+	}
+	if got := coverageTasks(pkgs, tests, integrationTests, exclusions, integrationExclusions); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
@@ -85,28 +85,38 @@ type funcMatcherOpt struct{ funcMatcher }
 
 type argsOpt []string
 type exclusionsOpt []exclusion
+type v23TestsOpt []exclusion
 type jiriGoOpt []string
 type nonTestArgsOpt []string
 type numWorkersOpt int
+type outputHandlerOpt func(pkg, output string)
 type suppressTestOutputOpt bool
 type pkgsOpt []string
+type sigquitGraceOpt time.Duration
 type suffixOpt string
 type timeoutOpt string
 
 func (argsOpt) goBuildOpt()              {}
 func (argsOpt) goCoverageOpt()           {}
 func (argsOpt) goTestOpt()               {}
+func (CoverageBaseOpt) goCoverageOpt()   {}
+func (exclusionsOpt) goCoverageOpt()     {}
 func (exclusionsOpt) goTestOpt()         {}
 func (funcMatcherOpt) goTestOpt()        {}
+func (v23TestsOpt) goCoverageOpt()       {}
 func (jiriGoOpt) Opt()                   {}
 func (jiriGoOpt) goBuildOpt()            {}
 func (jiriGoOpt) goCoverageOpt()         {}
 func (jiriGoOpt) goTestOpt()             {}
+func (nonTestArgsOpt) goCoverageOpt()    {}
 func (nonTestArgsOpt) goTestOpt()        {}
 func (numWorkersOpt) goTestOpt()         {}
+func (outputHandlerOpt) goTestOpt()      {}
 func (pkgsOpt) goBuildOpt()              {}
 func (pkgsOpt) goCoverageOpt()           {}
 func (pkgsOpt) goTestOpt()               {}
+func (PartOpt) goCoverageOpt()           {}
+func (sigquitGraceOpt) goTestOpt()       {}
 func (suffixOpt) goTestOpt()             {}
 func (timeoutOpt) goCoverageOpt()        {}
 func (timeoutOpt) goTestOpt()            {}
@@ -239,6 +249,65 @@ func processBuildOutput(pkg string, outputLines []string, suite *xunit.TestSuite
 	suite.Cases = append(suite.Cases, c)
 }
 
+// coverageTask identifies the tests of a package that goCoverage runs.
+type coverageTask struct {
+	pkg string
+	// specificTests enumerates the tests to run, or is nil if all the
+	// tests of the package are to be run.
+	specificTests []string
+	// integration means that integration tests of the package are to
+	// be run, which requires the -v23.tests flag.
+	integration bool
+}
+
+// coverageTasks returns the coverage tasks of the given packages, whose
+// tests and integration tests are given. The tests matched by the given
+// exclusions and the integration tests matched by the given integration
+// exclusions are not run, and packages whose tests are all excluded are
+// skipped.
+func coverageTasks(pkgs []string, tests, integrationTests map[string][]string, exclusions, integrationExclusions []exclusion) []coverageTask {
+	tasks := []coverageTask{}
+	for _, pkg := range pkgs {
+		names := tests[pkg]
+		if len(names) == 0 {
+			tasks = append(tasks, coverageTask{pkg: pkg})
+			continue
+		}
+		isIntegration := map[string]bool{}
+		for _, name := range integrationTests[pkg] {
+			isIntegration[name] = true
+		}
+		task, filtered := coverageTask{pkg: pkg}, false
+		for _, name := range names {
+			if isExcluded(pkg, name, exclusions) || (isIntegration[name] && isExcluded(pkg, name, integrationExclusions)) {
+				filtered = true
+				continue
+			}
+			task.specificTests = append(task.specificTests, name)
+			task.integration = task.integration || isIntegration[name]
+		}
+		if len(task.specificTests) == 0 {
+			continue
+		}
+		if !filtered {
+			task.specificTests = nil
+		}
+		tasks = append(tasks, task)
+	}
+	return tasks
+}
+
+// isExcluded checks whether the given test of the given package is
+// matched by one of the given exclusions.
+func isExcluded(pkg, name string, exclusions []exclusion) bool {
+	for _, e := range exclusions {
+		if e.exclude && e.pkgRE.MatchString(pkg) && e.nameRE.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
 type coverageResult struct {
 	pkg      string
 	coverage *os.File
@@ -250,19 +319,47 @@ type coverageResult struct {
 const defaultTestCoverageTimeout = "5m"
 
 // goCoverage is a helper function for running Go coverage tests.
+//
+// Each test binary is instrumented for all tested packages, so that a
+// package is credited with the coverage provided by the tests of the
+// other tested packages. The coverage profiles of all tested packages
+// are merged and turned into a cobertura report and a JSON summary.
+// The profiles of other parts of the test are merged as well, but only
+// if they were written to the same directory by the same build, that
+// is if the parts ran on the same machine with the same $WORKSPACE.
+// Parts run on separate machines produce separate reports. If a CoverageBaseOpt is
+// given, the summary also includes the coverage of the lines changed
+// since the given git revision.
+//
+// The tests matched by the exclusionsOpt option are not run. If a
+// v23TestsOpt is given, the integration tests of the packages, except
+// for those it matches, are run as well.
 func goCoverage(jirix *jiri.X, testName string, opts ...goCoverageOpt) (_ *test.Result, e error) {
 	timeout := defaultTestCoverageTimeout
-	var args, pkgs, goFlags []string
+	var args, pkgs, goFlags, nonTestArgs []string
+	var exclusions, integrationExclusions []exclusion
+	integration := false
+	part, base := -1, ""
 	for _, opt := range opts {
 		switch typedOpt := opt.(type) {
 		case timeoutOpt:
 			timeout = string(typedOpt)
 		case argsOpt:
 			args = []string(typedOpt)
+		case nonTestArgsOpt:
+			nonTestArgs = []string(typedOpt)
 		case pkgsOpt:
 			pkgs = []string(typedOpt)
 		case jiriGoOpt:
 			goFlags = []string(typedOpt)
+		case PartOpt:
+			part = int(typedOpt)
+		case CoverageBaseOpt:
+			base = string(typedOpt)
+		case exclusionsOpt:
+			exclusions = []exclusion(typedOpt)
+		case v23TestsOpt:
+			integration, integrationExclusions = true, []exclusion(typedOpt)
 		}
 	}
 
@@ -296,26 +393,39 @@ func goCoverage(jirix *jiri.X, testName string, opts ...goCoverageOpt) (_ *test.
 	}
 	fmt.Fprintf(jirix.Stdout(), "ok\n")
 
+	// Identify the tests to run, if some are excluded or integration
+	// tests are run.
+	var tests, integrationTests map[string][]string
+	if len(exclusions) > 0 || integration {
+		_, tests, err = goListPackagesAndFuncs(jirix, optsFromGoCoverage(opts), pkgs, &matchGoTestFunc{testNameRE: goTestNameRE})
+		if err == nil && integration {
+			_, integrationTests, err = goListPackagesAndFuncs(jirix, optsFromGoCoverage(opts), pkgs, &matchV23TestFunc{testNameRE: integrationTestNameRE})
+		}
+		if err != nil {
+			if err := xunit.CreateFailureReport(jirix, testName, "ListPackages", "TestCoverage", "listing package failure", err.Error()); err != nil {
+				return nil, err
+			}
+			return &test.Result{Status: test.Failed}, nil
+		}
+	}
+	coverageTaskList := coverageTasks(pkgList, tests, integrationTests, exclusions, integrationExclusions)
+
 	// Create a pool of workers.
-	numPkgs := len(pkgList)
-	tasks := make(chan string, numPkgs)
+	numPkgs := len(coverageTaskList)
+	tasks := make(chan coverageTask, numPkgs)
 	taskResults := make(chan coverageResult, numPkgs)
 	for i := 0; i < runtime.NumCPU(); i++ {
-		go coverageWorker(jirix, timeout, args, tasks, taskResults)
+		go coverageWorker(jirix, timeout, args, nonTestArgs, pkgList, tasks, taskResults)
 	}
 
 	// Distribute work to workers.
-	for _, pkg := range pkgList {
-		tasks <- pkg
+	for _, task := range coverageTaskList {
+		tasks <- task
 	}
 	close(tasks)
 
 	// Collect the results.
-	//
-	// TODO(jsimsa): Gather coverage data using the testCoverage
-	// data structure as opposed to a buffer.
-	var coverageData bytes.Buffer
-	fmt.Fprintf(&coverageData, "mode: set\n")
+	mode, blocks := "set", []coverBlock{}
 	allPassed, suites := true, []xunit.TestSuite{}
 	for i := 0; i < numPkgs; i++ {
 		result := <-taskResults
@@ -324,16 +434,15 @@ func goCoverage(jirix *jiri.X, testName string, opts ...goCoverageOpt) (_ *test.
 		case buildFailed:
 			s = xunit.CreateTestSuiteWithFailure(result.pkg, "TestCoverage", "build failure", result.output, result.time)
 		case testPassed:
-			data, err := ioutil.ReadAll(result.coverage)
+			// Only the coverage provided by passing tests is counted.
+			pkgMode, pkgBlocks, err := parseCoverProfile(result.coverage)
 			if err != nil {
 				return nil, err
 			}
-			lines := strings.Split(string(data), "\n")
-			for _, line := range lines {
-				if line != "" && strings.Index(line, "mode: set") == -1 {
-					fmt.Fprintf(&coverageData, "%s\n", line)
-				}
+			if pkgMode != "" {
+				mode = pkgMode
 			}
+			blocks = append(blocks, pkgBlocks...)
 			fallthrough
 		case testFailed:
 			if strings.Index(result.output, "no test files") == -1 {
@@ -370,17 +479,56 @@ func goCoverage(jirix *jiri.X, testName string, opts ...goCoverageOpt) (_ *test.
 	}
 	close(taskResults)
 
-	// Create the xUnit and cobertura reports.
+	// Create the xUnit report.
 	if err := xunit.CreateReport(jirix, testName, suites); err != nil {
 		return nil, err
 	}
-	coverage, err := coverageFromGoTestOutput(jirix, &coverageData)
+
+	// Write the merged coverage profile of this part of the test and
+	// merge it with the profiles of the other parts.
+	var profile bytes.Buffer
+	if err := writeCoverProfile(&profile, mode, mergeCoverBlocks(mode, blocks)); err != nil {
+		return nil, err
+	}
+	profilePath := coverageProfilePath(testName, part)
+	if err := s.WriteFile(profilePath, profile.Bytes(), os.FileMode(0644)).Done(); err != nil {
+		return nil, fmt.Errorf("WriteFile(%v) failed: %v", profilePath, err)
+	}
+	mode, blocks, err = readCoverageProfiles(jirix, testName, part)
+	if err != nil {
+		return nil, err
+	}
+	profile.Reset()
+	if err := writeCoverProfile(&profile, mode, blocks); err != nil {
+		return nil, err
+	}
+
+	// Create the cobertura report and the JSON summary.
+	coverage, err := coverageFromGoTestOutput(jirix, &profile)
 	if err != nil {
 		return nil, err
 	}
 	if err := createCoberturaReport(jirix, testName, coverage); err != nil {
 		return nil, err
 	}
+	summary := summarizeCoverage(blocks)
+	if base != "" {
+		changed, err := gitChangedLines(jirix, base)
+		if err != nil {
+			return nil, err
+		}
+		summary.DiffBase = base
+		summary.Diff = computeDiffCoverage(blocks, changed)
+		summary.DiffTotal = &coverageCounts{}
+		for _, counts := range summary.Diff {
+			summary.DiffTotal.Covered += counts.Covered
+			summary.DiffTotal.Total += counts.Total
+		}
+		fmt.Fprintf(jirix.Stdout(), "diff coverage against %v: %d/%d changed lines covered\n", base, summary.DiffTotal.Covered, summary.DiffTotal.Total)
+	}
+	if err := writeCoverageSummary(jirix, testName, summary); err != nil {
+		return nil, err
+	}
 	if !allPassed {
 		return &test.Result{Status: test.Failed}, nil
 	}
@@ -388,9 +536,9 @@ func goCoverage(jirix *jiri.X, testName string, opts ...goCoverageOpt) (_ *test.
 }
 
 // coverageWorker generates test coverage.
-func coverageWorker(jirix *jiri.X, timeout string, args []string, pkgs <-chan string, results chan<- coverageResult) {
+func coverageWorker(jirix *jiri.X, timeout string, args, nonTestArgs, coverPkgs []string, tasks <-chan coverageTask, results chan<- coverageResult) {
 	s := jirix.NewSeq()
-	for pkg := range pkgs {
+	for task := range tasks {
 		// Compute the test coverage.
 		var out bytes.Buffer
 		coverageFile, err := ioutil.TempFile("", "")
@@ -398,11 +546,19 @@ func coverageWorker(jirix *jiri.X, timeout string, args []string, pkgs <-chan st
 			panic(fmt.Sprintf("TempFile() failed: %v", err))
 		}
 		args := append([]string{"go", "test", "-tags=leveldb", "-cover", "-coverprofile",
-			coverageFile.Name(), "-timeout", timeout, "-v",
+			coverageFile.Name(), "-coverpkg", strings.Join(coverPkgs, ","), "-timeout", timeout, "-v",
 		}, args...)
-		args = append(args, pkg)
+		if task.specificTests != nil {
+			args = append(args, "-run", fmt.Sprintf("^(%s)$", strings.Join(task.specificTests, "|")))
+		}
+		args = append(args, task.pkg)
+		args = append(args, nonTestArgs...)
+		if task.integration {
+			args = append(args, "-v23.tests")
+		}
 		start := time.Now()
 		err = s.Capture(&out, &out).Verbose(false).Last("jiri", args...)
+		pkg := task.pkg
 		result := coverageResult{
 			pkg:      pkg,
 			coverage: coverageFile,
@@ -597,6 +753,11 @@ func goTestAndReport(jirix *jiri.X, testName string, opts ...goTestOpt) (_ *test
 	if err != nil {
 		return nil, err
 	}
+	for _, opt := range opts {
+		if r, ok := opt.(reproOpt); ok && r.reproduction != nil {
+			return res, nil
+		}
+	}
 	// Create the xUnit report.
 	return res, xunit.CreateReport(jirix, testName, suites)
 }
@@ -611,6 +772,9 @@ func goTest(jirix *jiri.X, testName string, opts ...goTestOpt) (_ *test.Result,
 	matcher = &matchGoTestFunc{testNameRE: goTestNameRE}
 	numWorkers := runtime.GOMAXPROCS(0)
 	var nonTestArgs nonTestArgsOpt
+	var outputHandler outputHandlerOpt
+	var repro *reproduction
+	grace := defaultSigquitGrace
 	suppressOutput := false
 	for _, opt := range opts {
 		switch typedOpt := opt.(type) {
@@ -630,6 +794,8 @@ func goTest(jirix *jiri.X, testName string, opts ...goTestOpt) (_ *test.Result,
 			pkgs = []string(typedOpt)
 		case suppressTestOutputOpt:
 			suppressOutput = bool(typedOpt)
+		case outputHandlerOpt:
+			outputHandler = typedOpt
 		case numWorkersOpt:
 			numWorkers = int(typedOpt)
 			if numWorkers < 1 {
@@ -637,9 +803,17 @@ func goTest(jirix *jiri.X, testName string, opts ...goTestOpt) (_ *test.Result,
 			}
 		case jiriGoOpt:
 			goFlags = []string(typedOpt)
+		case reproOpt:
+			repro = typedOpt.reproduction
+		case sigquitGraceOpt:
+			grace = time.Duration(typedOpt)
 		}
 	}
 
+	if repro != nil {
+		return reproGoTest(jirix, repro, timeout, args, nonTestArgs, pkgs, exclusions, matcher, optsFromGoTest(opts))
+	}
+
 	// TODO(cnicolaou): this gets run for every test case, which is going
 	// to be pretty slow. We should refactor so that it only gets run once.
 	// Install required tools.
@@ -684,13 +858,13 @@ func goTest(jirix *jiri.X, testName string, opts ...goTestOpt) (_ *test.Result,
 			fmt.Fprintf(jirix.Stdout(), "staggering start of test worker by %s\n", delay)
 		}
 		time.Sleep(delay)
-		testWorker(jirix, timeout, args, nonTestArgs, tasks, taskResults)
+		testWorker(jirix, timeout, grace, args, nonTestArgs, tasks, taskResults)
 	}
 	for i := 0; i < numWorkers; i++ {
 		if numWorkers > 1 {
 			go staggeredWorker()
 		} else {
-			go testWorker(jirix, timeout, args, nonTestArgs, tasks, taskResults)
+			go testWorker(jirix, timeout, grace, args, nonTestArgs, tasks, taskResults)
 		}
 	}
 
@@ -725,19 +899,21 @@ func goTest(jirix *jiri.X, testName string, opts ...goTestOpt) (_ *test.Result,
 		case buildFailed:
 			ss = append(ss, xunit.CreateTestSuiteWithFailure(result.pkg, "Test", "build failure", result.output, result.time))
 		case testTimedout:
-			ss = append(ss, xunit.CreateTestSuiteWithFailure(result.pkg, "Test", fmt.Sprintf("test timed out after %s", timeout), "", result.time))
+			// Attribute the timeout to the test that was running and
+			// include the goroutines dumped in response to SIGQUIT.
+			name, data := "Test", ""
+			if running := lastRunningTest(result.output); running != "" {
+				name = running
+			}
+			if groups := parseGoroutineDump(result.output); len(groups) > 0 {
+				data = formatGoroutineGroups(groups)
+			}
+			ss = append(ss, xunit.CreateTestSuiteWithFailure(result.pkg, name, fmt.Sprintf("test timed out after %s", timeout), data, result.time))
 		case testFailed, testPassed:
 			if strings.Index(result.output, "no test files") == -1 &&
 				strings.Index(result.output, "package excluded") == -1 {
-				if testName == "vanadium-go-bench" {
-					// TODO(jsimsa): The go2xunit tool used for parsing output
-					// of Go tests ignores output of Go benchmarks. We dump
-					// output of benchmarks to stdout to persist this
-					// information in the console logs of our CI. This is a
-					// temporary solution until someone finds the enthusiasm to
-					// implement benchmark output parsing, tracking and
-					// graphing.
-					fmt.Fprintf(jirix.Stdout(), result.output)
+				if outputHandler != nil {
+					outputHandler(result.pkg, result.output)
 				}
 				// Escape test output to make sure go2xunit can process it.
 				var escapedOutput bytes.Buffer
@@ -837,38 +1013,10 @@ func goTest(jirix *jiri.X, testName string, opts ...goTestOpt) (_ *test.Result,
 }
 
 // testWorker tests packages.
-func testWorker(jirix *jiri.X, timeout string, args, nonTestArgs []string, tasks <-chan goTestTask, results chan<- testResult) {
-	s := jirix.NewSeq()
+func testWorker(jirix *jiri.X, timeout string, grace time.Duration, args, nonTestArgs []string, tasks <-chan goTestTask, results chan<- testResult) {
 	for task := range tasks {
 		// Run the test.
-		//
-		// The "leveldb" tag is needed to compile the levelDB-based
-		// storage engine for the groups service. See v.io/i/632 for more
-		// details.
-		taskArgs := append([]string{"go", "test", "-tags=leveldb", "-timeout", timeout, "-v"}, args...)
-
-		// Use the -run command-line flag to identify the specific tests to run.
-		// If this flag is already set, make sure to override it.
-		testsExpr := fmt.Sprintf("^(%s)$", strings.Join(task.specificTests, "|"))
-		found := false
-		for i, arg := range taskArgs {
-			switch {
-			case arg == "-run" || arg == "--run":
-				taskArgs[i+1] = testsExpr
-				found = true
-				break
-			case strings.HasPrefix(arg, "-run=") || strings.HasPrefix(arg, "--run="):
-				taskArgs[i] = fmt.Sprintf("-run=%s", testsExpr)
-				found = true
-				break
-			}
-		}
-		if !found {
-			taskArgs = append(taskArgs, "-run", testsExpr)
-		}
-
-		taskArgs = append(taskArgs, task.pkg)
-		taskArgs = append(taskArgs, nonTestArgs...)
+		taskArgs := goTestTaskArgs(timeout, args, nonTestArgs, task)
 		var out bytes.Buffer
 		start := time.Now()
 		timeoutDuration, err := time.ParseDuration(timeout)
@@ -881,7 +1029,9 @@ func testWorker(jirix *jiri.X, timeout string, args, nonTestArgs []string, tasks
 			}
 			continue
 		}
-		err = s.Capture(&out, &out).Timeout(timeoutDuration+time.Minute).Verbose(false).Last("jiri", taskArgs...)
+		// If the test hangs, make the test binary dump its goroutines
+		// before it gets killed so that the hang can be diagnosed.
+		timedOut, err := runWithTimeoutDiagnostics(jirix, &out, timeoutDuration+time.Minute, grace, "jiri", taskArgs...)
 		result := testResult{
 			pkg:      task.pkg,
 			time:     time.Now().Sub(start),
@@ -890,10 +1040,10 @@ func testWorker(jirix *jiri.X, timeout string, args, nonTestArgs []string, tasks
 		}
 		if err != nil {
 			oe := runutil.GetOriginalError(err)
-			if isBuildFailure(oe, out.String(), task.pkg) {
-				result.status = buildFailed
-			} else if runutil.IsTimeout(err) {
+			if timedOut {
 				result.status = testTimedout
+			} else if isBuildFailure(oe, out.String(), task.pkg) {
+				result.status = buildFailed
 			} else {
 				result.status = testFailed
 			}
@@ -904,6 +1054,37 @@ func testWorker(jirix *jiri.X, timeout string, args, nonTestArgs []string, tasks
 	}
 }
 
+// goTestTaskArgs returns the arguments of the "jiri" command that runs
+// the tests of the given task.
+func goTestTaskArgs(timeout string, args, nonTestArgs []string, task goTestTask) []string {
+	// The "leveldb" tag is needed to compile the levelDB-based storage
+	// engine for the groups service. See v.io/i/632 for more details.
+	taskArgs := append([]string{"go", "test", "-tags=leveldb", "-timeout", timeout, "-v"}, args...)
+
+	// Use the -run command-line flag to identify the specific tests to run.
+	// If this flag is already set, make sure to override it.
+	testsExpr := fmt.Sprintf("^(%s)$", strings.Join(task.specificTests, "|"))
+	found := false
+	for i, arg := range taskArgs {
+		switch {
+		case arg == "-run" || arg == "--run":
+			taskArgs[i+1] = testsExpr
+			found = true
+			break
+		case strings.HasPrefix(arg, "-run=") || strings.HasPrefix(arg, "--run="):
+			taskArgs[i] = fmt.Sprintf("-run=%s", testsExpr)
+			found = true
+			break
+		}
+	}
+	if !found {
+		taskArgs = append(taskArgs, "-run", testsExpr)
+	}
+
+	taskArgs = append(taskArgs, task.pkg)
+	return append(taskArgs, nonTestArgs...)
+}
+
 // buildTestDeps builds dependencies for the given test packages
 func buildTestDeps(jirix *jiri.X, pkgs []string, jiriGoFlags []string) error {
 	fmt.Fprintf(jirix.Stdout(), "building test dependencies ... ")
@@ -1120,16 +1301,21 @@ func excludedTests(exclusions []exclusion) []string {
 // via opts are amongst the defaults assuming that all of the defaults are
 // specified in <pkg>/... form and returns one of each of the goBuildOpt,
 // goCoverageOpt and goTestOpt options.
-// If no packages are requested, the defaults are returned.
+// If no packages are requested, the defaults are returned. If the
+// requested packages limit the defaults (LimitPkgsOpt), the requested
+// packages that are not amongst the defaults are dropped instead, and
+// the defaults are returned if none of them are requested.
 // TODO(cnicolaou): ideally there'd be one piece of code that understands
 //   go package specifications that could be used here.
 func validateAgainstDefaultPackages(jirix *jiri.X, opts []Opt, defaults []string) (pkgsOpt, error) {
 
-	optPkgs := []string{}
+	optPkgs, limit := []string{}, false
 	for _, opt := range opts {
 		switch v := opt.(type) {
 		case PkgsOpt:
 			optPkgs = []string(v)
+		case LimitPkgsOpt:
+			limit = bool(v)
 		}
 	}
 
@@ -1148,6 +1334,7 @@ func validateAgainstDefaultPackages(jirix *jiri.X, opts []Opt, defaults []string
 		return nil, err
 	}
 
+	limited := []string{}
 	for _, p := range pkgs {
 		found := false
 		for _, d := range defPkgs {
@@ -1155,11 +1342,16 @@ func validateAgainstDefaultPackages(jirix *jiri.X, opts []Opt, defaults []string
 				found = true
 			}
 		}
-		if !found {
+		if found {
+			limited = append(limited, p)
+		} else if !limit {
 			return nil, fmt.Errorf("requested packages %v is not one of %v", p, defaults)
 		}
 	}
-	po := pkgsOpt(pkgs)
+	if limit && len(limited) == 0 {
+		return pkgsOpt(defaults), nil
+	}
+	po := pkgsOpt(limited)
 	return po, nil
 }
 
@@ -1174,6 +1366,17 @@ func getNumWorkersOpt(opts []Opt) numWorkersOpt {
 	return numWorkersOpt(runtime.NumCPU())
 }
 
+// getSigquitGraceOpt gets the SigquitGraceOpt from the given Opt slice
+func getSigquitGraceOpt(opts []Opt) sigquitGraceOpt {
+	for _, opt := range opts {
+		switch v := opt.(type) {
+		case SigquitGraceOpt:
+			return sigquitGraceOpt(v)
+		}
+	}
+	return sigquitGraceOpt(defaultSigquitGrace)
+}
+
 // getDefaultPkgsOpt gets the default packages from the given Opt slice
 func getDefaultPkgsOpt(opts []Opt) []string {
 	for _, opt := range opts {
@@ -1222,7 +1425,8 @@ func thirdPartyGoBuild(jirix *jiri.X, testName string, opts ...Opt) (_ *test.Res
 // thirdPartyGoTest runs Go tests for the third-party projects.
 func thirdPartyGoTest(jirix *jiri.X, testName string, opts ...Opt) (_ *test.Result, e error) {
 	// Initialize the test.
-	cleanup, err := initTest(jirix, testName, []string{"v23:base"})
+	repro := getReproOpt(opts)
+	cleanup, err := initTest(jirix, testName, []string{"v23:base"}, repro)
 	if err != nil {
 		return nil, newInternalError(err, "Init")
 	}
@@ -1238,13 +1442,14 @@ func thirdPartyGoTest(jirix *jiri.X, testName string, opts ...Opt) (_ *test.Resu
 		return nil, err
 	}
 	suffix := suffixOpt(genTestNameSuffix("GoTest"))
-	return goTestAndReport(jirix, testName, suffix, exclusionsOpt(goExclusions), validatedPkgs)
+	return goTestAndReport(jirix, testName, suffix, exclusionsOpt(goExclusions), validatedPkgs, getSigquitGraceOpt(opts), repro)
 }
 
 // thirdPartyGoRace runs Go data-race tests for third-party projects.
 func thirdPartyGoRace(jirix *jiri.X, testName string, opts ...Opt) (_ *test.Result, e error) {
 	// Initialize the test.
-	cleanup, err := initTest(jirix, testName, []string{"v23:base"})
+	repro := getReproOpt(opts)
+	cleanup, err := initTest(jirix, testName, []string{"v23:base"}, repro)
 	if err != nil {
 		return nil, newInternalError(err, "Init")
 	}
@@ -1266,7 +1471,7 @@ func thirdPartyGoRace(jirix *jiri.X, testName string, opts ...Opt) (_ *test.Resu
 	args := argsOpt([]string{"-race"})
 	exclusions := append(goExclusions, goRaceExclusions...)
 	suffix := suffixOpt(genTestNameSuffix("GoRace"))
-	return goTestAndReport(jirix, testName, suffix, args, timeoutOpt("1h"), exclusionsOpt(exclusions), partPkgs)
+	return goTestAndReport(jirix, testName, suffix, args, timeoutOpt("1h"), exclusionsOpt(exclusions), partPkgs, getSigquitGraceOpt(opts), repro)
 }
 
 // thirdPartyPkgs returns a list of Go expressions that describe all
@@ -1362,10 +1567,37 @@ func vanadiumGoBench(jirix *jiri.X, testName string, opts ...Opt) (_ *test.Resul
 	if err != nil {
 		return nil, err
 	}
-	args := argsOpt([]string{"-bench", "."})
+	config, err := loadBenchConfig(testName)
+	if err != nil {
+		return nil, err
+	}
+	args := argsOpt([]string{"-bench", ".", "-benchmem", "-count", strconv.Itoa(config.Count)})
 	matcher := funcMatcherOpt{&matchGoTestFunc{testNameRE: goBenchNameRE}}
 	timeout := timeoutOpt("1h")
-	return goTestAndReport(jirix, testName, args, matcher, timeout, pkgs)
+
+	// The go2xunit tool used for parsing output of Go tests ignores
+	// output of Go benchmarks. We dump output of benchmarks to stdout
+	// to persist this information in the console logs of our CI and
+	// parse the benchmark results out of it.
+	run := benchRun{Timestamp: time.Now()}
+	handler := outputHandlerOpt(func(pkg, output string) {
+		fmt.Fprintf(jirix.Stdout(), "%s", output)
+		run.Results = append(run.Results, parseBenchOutput(pkg, output)...)
+	})
+	result, suites, err := goTest(jirix, testName, args, matcher, timeout, pkgs, handler, getSigquitGraceOpt(opts))
+	if err != nil {
+		return nil, err
+	}
+
+	// Compare the results against the baseline window of the benchmark
+	// history and record them.
+	complete := len(pkgs) == 1 && pkgs[0] == "v.io/..."
+	regressions, err := checkBenchRun(jirix, config, run, result, complete)
+	if err != nil {
+		return nil, err
+	}
+	suites = append(suites, benchRegressionSuites(regressions)...)
+	return result, xunit.CreateReport(jirix, testName, suites)
 }
 
 // vanadiumGoBuild runs Go build for the vanadium projects.
@@ -1406,12 +1638,29 @@ func vanadiumGoCoverage(jirix *jiri.X, testName string, opts ...Opt) (_ *test.Re
 	}
 	defer collect.Error(func() error { return cleanup() }, &e)
 
-	// Compute coverage for Vanadium Go packages.
+	// Compute coverage for Vanadium Go packages, including the coverage
+	// provided by integration tests.
 	pkgs, err := validateAgainstDefaultPackages(jirix, opts, []string{"v.io/..."})
 	if err != nil {
 		return nil, err
 	}
-	return goCoverage(jirix, testName, pkgs)
+	partPkgs, err := identifyPackagesToTest(jirix, testName, opts, pkgs)
+	if err != nil {
+		return nil, err
+	}
+	coverageOpts := []goCoverageOpt{partPkgs, exclusionsOpt(goExclusions), v23TestsOpt(goIntegrationExclusions)}
+	for _, opt := range opts {
+		switch typedOpt := opt.(type) {
+		case PartOpt:
+			coverageOpts = append(coverageOpts, typedOpt)
+		case CoverageBaseOpt:
+			coverageOpts = append(coverageOpts, typedOpt)
+		}
+	}
+	env := jirix.Env()
+	env["V23_BIN_DIR"] = binDirPath()
+	newCtx := jirix.Clone(tool.ContextOpts{Env: env})
+	return goCoverage(newCtx, testName, coverageOpts...)
 }
 
 // vanadiumGoDepcop runs Go dependency checks for vanadium projects.
@@ -1607,7 +1856,8 @@ func vanadiumGoGenerate(jirix *jiri.X, testName string, opts ...Opt) (_ *test.Re
 // vanadiumGoRace runs Go data-race tests for vanadium projects.
 func vanadiumGoRace(jirix *jiri.X, testName string, opts ...Opt) (_ *test.Result, e error) {
 	// Initialize the test.
-	cleanup, err := initTest(jirix, testName, []string{"v23:base"})
+	repro := getReproOpt(opts)
+	cleanup, err := initTest(jirix, testName, []string{"v23:base"}, repro)
 	if err != nil {
 		return nil, newInternalError(err, "Init")
 	}
@@ -1625,7 +1875,7 @@ func vanadiumGoRace(jirix *jiri.X, testName string, opts ...Opt) (_ *test.Result
 	args := argsOpt([]string{"-race"})
 	timeout := timeoutOpt("30m")
 	suffix := suffixOpt(genTestNameSuffix("GoRace"))
-	return goTestAndReport(jirix, testName, args, timeout, suffix, exclusionsOpt(exclusions), partPkgs)
+	return goTestAndReport(jirix, testName, args, timeout, suffix, exclusionsOpt(exclusions), partPkgs, getSigquitGraceOpt(opts), repro)
 }
 
 // identifyPackagesToTest returns a slice of packages to test using the
@@ -1740,7 +1990,8 @@ func vanadiumGoVet(jirix *jiri.X, testName string, _ ...Opt) (_ *test.Result, e
 // vanadiumGoTest runs Go tests for vanadium projects.
 func vanadiumGoTest(jirix *jiri.X, testName string, opts ...Opt) (_ *test.Result, e error) {
 	// Initialize the test.
-	cleanup, err := initTest(jirix, testName, []string{"v23:base"})
+	repro := getReproOpt(opts)
+	cleanup, err := initTest(jirix, testName, []string{"v23:base"}, repro)
 	if err != nil {
 		return nil, newInternalError(err, "Init")
 	}
@@ -1754,7 +2005,7 @@ func vanadiumGoTest(jirix *jiri.X, testName string, opts ...Opt) (_ *test.Result
 	}
 	args := argsOpt([]string{})
 	suffix := suffixOpt(genTestNameSuffix("GoTest"))
-	return goTestAndReport(jirix, testName, suffix, exclusionsOpt(goExclusions), getNumWorkersOpt(opts), pkgs, args)
+	return goTestAndReport(jirix, testName, suffix, exclusionsOpt(goExclusions), getNumWorkersOpt(opts), getSigquitGraceOpt(opts), pkgs, args, repro)
 }
 
 // vanadiumIntegrationTest runs integration tests for Vanadium
@@ -1764,7 +2015,8 @@ func vanadiumIntegrationTest(jirix *jiri.X, testName string, opts ...Opt) (_ *te
 	// We need a shorter root/tmp dir to keep the length of unix domain socket
 	// path under limit (108 for linux and 104 for darwin).
 	shorterRootDir := filepath.Join(os.Getenv("HOME"), "tmp", "vit")
-	cleanup, err := initTest(jirix, testName, []string{"v23:base"}, rootDirOpt(shorterRootDir))
+	repro := getReproOpt(opts)
+	cleanup, err := initTest(jirix, testName, []string{"v23:base"}, rootDirOpt(shorterRootDir), repro)
 	if err != nil {
 		return nil, newInternalError(err, "Init")
 	}
@@ -1780,7 +2032,7 @@ func vanadiumIntegrationTest(jirix *jiri.X, testName string, opts ...Opt) (_ *te
 	env := jirix.Env()
 	env["V23_BIN_DIR"] = binDirPath()
 	newCtx := jirix.Clone(tool.ContextOpts{Env: env})
-	return goTestAndReport(newCtx, testName, suffix, getNumWorkersOpt(opts), nonTestArgs, matcher, exclusionsOpt(goIntegrationExclusions), pkgs)
+	return goTestAndReport(newCtx, testName, suffix, getNumWorkersOpt(opts), getSigquitGraceOpt(opts), nonTestArgs, matcher, exclusionsOpt(goIntegrationExclusions), pkgs, repro)
 }
 
 // binOrder determines if the regression tests use
@@ -155,6 +155,9 @@ func vanadiumPresubmitResult(jirix *jiri.X, testName string, _ ...Opt) (_ *test.
 		"-refs", os.Getenv("REFS"),
 		"-projects", os.Getenv("PROJECTS"),
 	)
+	if reused := os.Getenv("REUSED_RESULTS"); reused != "" {
+		args = append(args, "-reused-results", reused)
+	}
 	if err := jirix.NewSeq().Capture(jirix.Stdout(), jirix.Stderr()).Last("presubmit", args...); err != nil {
 		return nil, err
 	}
@@ -0,0 +1,88 @@
+// Copyright 2015 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package test
+
+import (
+	"fmt"
+
+	"v.io/jiri"
+	"v.io/jiri/runutil"
+	"v.io/x/devtools/internal/test"
+	"v.io/x/devtools/tooldata"
+)
+
+// TestFunc is the type of functions that implement tests.
+type TestFunc func(jirix *jiri.X, testName string, opts ...Opt) (*test.Result, error)
+
+// Metadata describes a registered test.
+type Metadata struct {
+	// Description is a short description of the test.
+	Description string
+	// Hidden determines whether the test is omitted by ListTests.
+	Hidden bool
+}
+
+type registeredTest struct {
+	fn       TestFunc
+	metadata Metadata
+}
+
+// registry maps test names to registered tests.
+var registry = map[string]registeredTest{}
+
+func init() {
+	for name, fn := range builtinTests {
+		Register(name, fn, Metadata{})
+	}
+	Register("ignore-this", testMock, Metadata{
+		Description: "Test that always passes, used to test the test runner.",
+		Hidden:      true,
+	})
+}
+
+// Register makes the given test function available under the given
+// name. It is intended to be called from init functions and panics if
+// a test with the given name has already been registered.
+func Register(name string, fn TestFunc, metadata Metadata) {
+	if fn == nil {
+		panic(fmt.Sprintf("test %v: nil test function", name))
+	}
+	if _, ok := registry[name]; ok {
+		panic(fmt.Sprintf("test %v registered twice", name))
+	}
+	registry[name] = registeredTest{fn: fn, metadata: metadata}
+}
+
+// lookupTest returns the function that implements the given test.
+// Registered tests take precedence over the script tests defined by the
+// tools configuration.
+func lookupTest(jirix *jiri.X, name string) (TestFunc, error) {
+	if t, ok := registry[name]; ok {
+		return t.fn, nil
+	}
+	scriptTests, err := loadScriptTests(jirix)
+	if err != nil {
+		return nil, err
+	}
+	if script, ok := scriptTests[name]; ok {
+		return func(jirix *jiri.X, testName string, opts ...Opt) (*test.Result, error) {
+			return runScriptTest(jirix, testName, script, opts...)
+		}, nil
+	}
+	return nil, fmt.Errorf("test %v does not exist", name)
+}
+
+// loadScriptTests returns the script tests defined by the tools
+// configuration. A missing configuration file defines no script tests.
+func loadScriptTests(jirix *jiri.X) (map[string]tooldata.ScriptTest, error) {
+	config, err := tooldata.LoadConfig(jirix)
+	if err != nil {
+		if runutil.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return config.ScriptTests(), nil
+}
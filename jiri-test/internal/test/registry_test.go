@@ -0,0 +1,72 @@
+// Copyright 2015 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package test
+
+import (
+	"testing"
+
+	"v.io/jiri/jiritest"
+	"v.io/x/devtools/tooldata"
+)
+
+func TestListTests(t *testing.T) {
+	fake, cleanup := jiritest.NewFakeJiriRoot(t)
+	defer cleanup()
+
+	config := tooldata.NewConfig(tooldata.ScriptTestsOpt(map[string]tooldata.ScriptTest{
+		"test-script": {
+			Name:    "test-script",
+			Dir:     "test",
+			Command: "make test",
+		},
+		// Registered tests take precedence over script tests.
+		"vanadium-go-build": {
+			Name:    "vanadium-go-build",
+			Command: "false",
+		},
+	}))
+	if err := tooldata.SaveConfig(fake.X, config); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	tests, err := ListTests(fake.X)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	count := map[string]int{}
+	for _, name := range tests {
+		count[name]++
+	}
+	if got, want := len(tests), len(builtinTests)+1; got != want {
+		t.Fatalf("got %v tests, want %v", got, want)
+	}
+	for _, name := range []string{"test-script", "vanadium-go-build", "vanadium-go-test"} {
+		if got, want := count[name], 1; got != want {
+			t.Fatalf("test %v listed %v times, want %v", name, got, want)
+		}
+	}
+	if _, ok := count["ignore-this"]; ok {
+		t.Fatalf("hidden test ignore-this is listed")
+	}
+
+	if _, err := lookupTest(fake.X, "test-script"); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if _, err := lookupTest(fake.X, "ignore-this"); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if _, err := lookupTest(fake.X, "does-not-exist"); err == nil {
+		t.Fatalf("looking up a test that does not exist did not fail")
+	}
+}
+
+func TestRegisterTwice(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Fatalf("registering a test twice did not panic")
+		}
+	}()
+	Register("vanadium-go-build", testMock, Metadata{})
+}
@@ -0,0 +1,193 @@
+// Copyright 2015 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package test
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"v.io/jiri"
+	"v.io/x/devtools/internal/test"
+	"v.io/x/devtools/internal/xunit"
+	"v.io/x/lib/envvar"
+)
+
+// reproducing is set while ReproTest runs a test. A test can only be
+// reproduced if it passes its reproOpt to initTest, which means that it
+// records the commands it would run. Other tests run commands that are
+// not recorded, such as builds, deployments and Makefile targets, and
+// initTest refuses to set them up before they run any of them.
+var reproducing bool
+
+// notReproducibleError is returned by initTest when it sets up a test
+// that cannot be reproduced.
+type notReproducibleError string
+
+func (e notReproducibleError) Error() string {
+	return fmt.Sprintf("test %v cannot be reproduced: only Go tests run by the Go test runner and script tests record their commands", string(e))
+}
+
+// ReproCommand represents a command run by a test.
+type ReproCommand struct {
+	// Dir is the directory in which the command runs.
+	Dir string
+	// Env holds the environment variables the test sets for the
+	// command on top of the environment of the current process.
+	Env map[string]string
+	// Args holds the command name and its arguments.
+	Args []string
+}
+
+// String returns a shell command line that runs the command.
+func (c ReproCommand) String() string {
+	keys := []string{}
+	for key := range c.Env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	words := []string{"cd", shellQuote(c.Dir), "&&"}
+	for _, key := range keys {
+		words = append(words, key+"="+shellQuote(c.Env[key]))
+	}
+	for _, arg := range c.Args {
+		words = append(words, shellQuote(arg))
+	}
+	return strings.Join(words, " ")
+}
+
+var shellSafeRE = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+
+// shellQuote quotes the given string for use in a shell command line.
+func shellQuote(s string) string {
+	if shellSafeRE.MatchString(s) {
+		return s
+	}
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// reproduction collects the commands of a test that is being
+// reproduced. While a test is being reproduced, it goes through the
+// same setup as in CI but its commands are recorded instead of run.
+type reproduction struct {
+	// baseEnv holds the environment of the current process before the
+	// test was set up.
+	baseEnv map[string]string
+	// runRE, if not nil, restricts the Go tests to reproduce.
+	runRE *regexp.Regexp
+	// commands holds the recorded commands.
+	commands []ReproCommand
+}
+
+// reproOpt is an option that makes a test record the commands it would
+// run in the given reproduction instead of running them.
+type reproOpt struct{ *reproduction }
+
+func (reproOpt) Opt()         {}
+func (reproOpt) initTestOpt() {}
+func (reproOpt) goTestOpt()   {}
+
+// getReproOpt gets the reproOpt from the given Opt slice. Its
+// reproduction is nil unless the test is being reproduced.
+func getReproOpt(opts []Opt) reproOpt {
+	for _, opt := range opts {
+		switch v := opt.(type) {
+		case reproOpt:
+			return v
+		}
+	}
+	return reproOpt{}
+}
+
+// record records the given command, which runs in the given directory
+// using the given environment.
+func (r *reproduction) record(dir string, env map[string]string, args ...string) {
+	cmdEnv := map[string]string{}
+	for key, value := range envvar.MergeMaps(envvar.SliceToMap(os.Environ()), env) {
+		if baseValue, ok := r.baseEnv[key]; !ok || baseValue != value {
+			cmdEnv[key] = value
+		}
+	}
+	r.commands = append(r.commands, ReproCommand{Dir: dir, Env: cmdEnv, Args: args})
+}
+
+// ReproTest sets up the given test in the same way as CI does, but
+// instead of running the test, it returns the commands the test would
+// run. Profiles are neither installed nor updated, so the reproduction
+// works offline against the local checkout.
+func ReproTest(jirix *jiri.X, env map[string]string, testName string, opts ...Opt) ([]ReproCommand, error) {
+	testFn, err := lookupTest(jirix, testName)
+	if err != nil {
+		return nil, err
+	}
+	r := &reproduction{baseEnv: envvar.SliceToMap(os.Environ())}
+	for _, opt := range opts {
+		switch typedOpt := opt.(type) {
+		case RunOpt:
+			if typedOpt != "" {
+				re, err := regexp.Compile(string(typedOpt))
+				if err != nil {
+					return nil, fmt.Errorf("Compile(%v) failed: %v", typedOpt, err)
+				}
+				r.runRE = re
+			}
+		}
+	}
+	opts = append(opts, reproOpt{r})
+	reproducing = true
+	defer func() { reproducing = false }()
+	if _, err := testFn(newTestContext(jirix, env), testName, opts...); err != nil {
+		if e, ok := err.(internalTestError); ok {
+			if _, ok := e.err.(notReproducibleError); ok {
+				return nil, e.err
+			}
+		}
+		return nil, err
+	}
+	if len(r.commands) == 0 {
+		return nil, fmt.Errorf("test %v does not run any commands to reproduce", testName)
+	}
+	return r.commands, nil
+}
+
+// reproGoTest records in the given reproduction the commands that goTest
+// would run for the given packages.
+func reproGoTest(jirix *jiri.X, repro *reproduction, timeout string, args, nonTestArgs, pkgs []string, exclusions []exclusion, matcher funcMatcher, opts []Opt) (*test.Result, []xunit.TestSuite, error) {
+	pkgList, pkgAndFuncList, err := goListPackagesAndFuncs(jirix, opts, pkgs, matcher)
+	if err != nil {
+		return nil, nil, err
+	}
+	dir, err := os.Getwd()
+	if err != nil {
+		return nil, nil, fmt.Errorf("Getwd() failed: %v", err)
+	}
+	excludedTests := map[string][]string{}
+	for _, pkg := range pkgList {
+		testThisPkg, specificTests, excluded := filterExcludedTests(pkg, pkgAndFuncList[pkg], exclusions)
+		if len(excluded) > 0 {
+			excludedTests[pkg] = excluded
+		}
+		if !testThisPkg {
+			continue
+		}
+		if repro.runRE != nil {
+			matching := []string{}
+			for _, name := range specificTests {
+				if repro.runRE.MatchString(name) {
+					matching = append(matching, name)
+				}
+			}
+			if len(matching) == 0 {
+				continue
+			}
+			specificTests = matching
+		}
+		task := goTestTask{pkg: pkg, specificTests: specificTests, excludedTests: excluded}
+		repro.record(dir, jirix.Env(), append([]string{"jiri"}, goTestTaskArgs(timeout, args, nonTestArgs, task)...)...)
+	}
+	return &test.Result{Status: test.Passed, ExcludedTests: excludedTests}, nil, nil
+}
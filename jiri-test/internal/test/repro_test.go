@@ -0,0 +1,110 @@
+// Copyright 2015 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package test
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+
+	"v.io/jiri/jiritest"
+	"v.io/x/devtools/tooldata"
+	"v.io/x/lib/envvar"
+)
+
+func TestReproCommandString(t *testing.T) {
+	task := goTestTask{pkg: "v.io/x/foo", specificTests: []string{"TestA", "TestB"}}
+	command := ReproCommand{
+		Dir: "/tmp/vanadium-go-test",
+		Env: map[string]string{
+			"V23_BIN_DIR": "/tmp/bin",
+			"MSG":         "it's",
+		},
+		Args: append([]string{"jiri"}, goTestTaskArgs("5m", []string{"-run", "TestC"}, []string{"-v23.tests"}, task)...),
+	}
+	want := `cd /tmp/vanadium-go-test && MSG='it'\''s' V23_BIN_DIR=/tmp/bin jiri go test -tags=leveldb -timeout 5m -v -run '^(TestA|TestB)$' v.io/x/foo -v23.tests`
+	if got := command.String(); got != want {
+		t.Fatalf("unexpected command line:\ngot\n%v\nwant\n%v", got, want)
+	}
+}
+
+// TestReproTestFailure checks that the commands recorded by ReproTest
+// replay the failure of a test.
+func TestReproTestFailure(t *testing.T) {
+	fake, cleanup := jiritest.NewFakeJiriRoot(t)
+	defer cleanup()
+
+	config := tooldata.NewConfig(tooldata.ScriptTestsOpt(map[string]tooldata.ScriptTest{
+		"test-failing-script": {
+			Name:    "test-failing-script",
+			Dir:     "test",
+			Env:     []string{"MSG=failed"},
+			Command: `echo "$MSG" > out; exit 3`,
+		},
+	}))
+	if err := tooldata.SaveConfig(fake.X, config); err != nil {
+		t.Fatalf("%v", err)
+	}
+	dir := filepath.Join(fake.X.Root, "test")
+	if err := os.MkdirAll(dir, os.FileMode(0755)); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// The test is set up, but its command is recorded instead of run.
+	commands, err := ReproTest(fake.X, nil, "test-failing-script")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if got, want := len(commands), 1; got != want {
+		t.Fatalf("got %v commands, want %v", got, want)
+	}
+	command := commands[0]
+	if got, want := command.Args, []string{"sh", "-c", `echo "$MSG" > out; exit 3`}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "out")); !os.IsNotExist(err) {
+		t.Fatalf("the command of the test was run: %v", err)
+	}
+
+	// Replaying the recorded command fails as the test does.
+	cmd := exec.Command(command.Args[0], command.Args[1:]...)
+	cmd.Dir = command.Dir
+	cmd.Env = envvar.MapToSlice(envvar.MergeMaps(envvar.SliceToMap(os.Environ()), command.Env))
+	err = cmd.Run()
+	exitErr, ok := err.(*exec.ExitError)
+	if !ok {
+		t.Fatalf("got error %v, want an exit error", err)
+	}
+	if got, want := exitErr.Sys().(syscall.WaitStatus).ExitStatus(), 3; got != want {
+		t.Fatalf("got exit status %v, want %v", got, want)
+	}
+	bytes, err := ioutil.ReadFile(filepath.Join(dir, "out"))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if got, want := strings.TrimSpace(string(bytes)), "failed"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+// TestReproTestNotReproducible checks that ReproTest fails to reproduce
+// a test that does not record its commands before it runs any of them.
+func TestReproTestNotReproducible(t *testing.T) {
+	fake, cleanup := jiritest.NewFakeJiriRoot(t)
+	defer cleanup()
+
+	_, err := ReproTest(fake.X, nil, "vanadium-go-build")
+	if got, want := err, notReproducibleError("vanadium-go-build"); got != want {
+		t.Fatalf("got error %v, want %v", got, want)
+	}
+	if reproducing {
+		t.Fatalf("reproduction still in progress after ReproTest returned")
+	}
+}
@@ -16,6 +16,7 @@ import (
 	"path/filepath"
 	"sort"
 	"strings"
+	"time"
 
 	"v.io/jiri"
 	"v.io/jiri/collect"
@@ -67,9 +68,10 @@ var testMock = func(*jiri.X, string, ...Opt) (*test.Result, error) {
 	return &test.Result{Status: test.Passed}, nil
 }
 
-var testFunctions = map[string]func(*jiri.X, string, ...Opt) (*test.Result, error){
+// builtinTests lists the tests implemented by this package. They are
+// registered when the package is initialized.
+var builtinTests = map[string]TestFunc{
 	// TODO(jsimsa,cnicolaou): consider getting rid of the vanadium- prefix.
-	"ignore-this":                                     testMock,
 	"baku-android-build":                              bakuAndroidBuild,
 	"baku-java-test":                                  bakuJavaTest,
 	"madb-go-format":                                  madbGoFormat,
@@ -222,23 +224,61 @@ type PkgsOpt []string
 
 func (PkgsOpt) Opt() {}
 
+// LimitPkgsOpt is an option that specifies whether the packages of
+// PkgsOpt limit the default packages of Go tests instead of replacing
+// them: requested packages that are not amongst the defaults of a test
+// are ignored, and a test none of whose defaults are requested tests
+// all of its defaults.
+type LimitPkgsOpt bool
+
+func (LimitPkgsOpt) Opt() {}
+
 // MergePoliciesOpt is an option that specifies merge policies for use
 // when merging environment variables from the environment and from profiles.
 type MergePoliciesOpt profilesreader.MergePolicies
 
 func (MergePoliciesOpt) Opt() {}
 
+// CoverageBaseOpt is an option that specifies the git revision against
+// which the coverage of changed lines is computed by Go coverage tests.
+type CoverageBaseOpt string
+
+func (CoverageBaseOpt) Opt() {}
+
+// SigquitGraceOpt is an option that specifies how long before killing a
+// hung Go test binary it is sent SIGQUIT to dump its goroutines.
+type SigquitGraceOpt time.Duration
+
+func (SigquitGraceOpt) Opt() {}
+
+// RunOpt is an option that specifies a regular expression that
+// restricts the Go tests reproduced by ReproTest.
+type RunOpt string
+
+func (RunOpt) Opt() {}
+
 // DefaultPkgsOpt is an option that specifies which default packages
 // should be used to validate the test packages against.
 type DefaultPkgsOpt []string
 
 func (DefaultPkgsOpt) Opt() {}
 
-// ListTests returns a list of all tests known by the test package.
-func ListTests() ([]string, error) {
+// ListTests returns a list of all tests known by the test package,
+// which includes both registered tests and the script tests defined by
+// the tools configuration.
+func ListTests(jirix *jiri.X) ([]string, error) {
 	result := []string{}
-	for name := range testFunctions {
-		if !strings.HasPrefix(name, "ignore") {
+	for name, t := range registry {
+		if !t.metadata.Hidden {
+			result = append(result, name)
+		}
+	}
+	scriptTests, err := loadScriptTests(jirix)
+	if err != nil {
+		return nil, err
+	}
+	for name := range scriptTests {
+		if _, ok := registry[name]; !ok {
 			result = append(result, name)
 		}
 	}
@@ -355,14 +395,17 @@ func runTests(jirix *jiri.X, tests []string, results map[string]*test.Result, op
 	}
 
 	// Validate all tests before running any tests.
+	testFns := map[string]TestFunc{}
 	for _, t := range tests {
-		if _, ok := testFunctions[t]; !ok {
-			return fmt.Errorf("test %v does not exist", t)
+		testFn, err := lookupTest(jirix, t)
+		if err != nil {
+			return err
 		}
+		testFns[t] = testFn
 	}
 
 	for _, t := range tests {
-		testFn := testFunctions[t]
+		testFn := testFns[t]
 		fmt.Fprintf(jirix.Stdout(), "##### Running test %q #####\n", t)
 
 		// Create a 1MB buffer to capture the test function output.
@@ -374,7 +417,10 @@ func runTests(jirix *jiri.X, tests []string, results map[string]*test.Result, op
 			Stderr: io.MultiWriter(&out, jirix.Stderr()),
 		})
 
-		// Run the test and collect the test results.
+		// Run the test and collect the test results, measuring the
+		// resources used by the processes it runs.
+		start := time.Now()
+		startCPUTime, measured := childrenUsage()
 		result, err := testFn(newX, t, opts...)
 		if result != nil && result.Status == test.TimedOut {
 			writeTimedOutTestReport(newX, t, *result)
@@ -390,6 +436,12 @@ func runTests(jirix *jiri.X, tests []string, results map[string]*test.Result, op
 			}
 			result = r
 		}
+		if cpuTime, ok := childrenUsage(); measured && ok {
+			result.Usage = &test.Usage{
+				WallTime: time.Since(start),
+				CPUTime:  cpuTime - startCPUTime,
+			}
+		}
 		results[t] = result
 		if _, err := outputFile.Write(out.Bytes()); err != nil {
 			return err
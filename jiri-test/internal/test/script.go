@@ -0,0 +1,103 @@
+// Copyright 2015 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package test
+
+import (
+	"encoding/xml"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"v.io/jiri"
+	"v.io/jiri/collect"
+	"v.io/jiri/runutil"
+	"v.io/x/devtools/internal/test"
+	"v.io/x/devtools/internal/xunit"
+	"v.io/x/devtools/tooldata"
+	"v.io/x/lib/envvar"
+)
+
+// runScriptTest runs the given script test. If the script test
+// identifies the xUnit reports generated by its command, the reports
+// are merged into the xUnit report of the test. Otherwise, a failure of
+// the command is reported as an internal error.
+func runScriptTest(jirix *jiri.X, testName string, script tooldata.ScriptTest, opts ...Opt) (_ *test.Result, e error) {
+	timeout := test.DefaultTimeout
+	if script.Timeout != "" {
+		var err error
+		if timeout, err = time.ParseDuration(script.Timeout); err != nil {
+			return nil, newInternalError(fmt.Errorf("ParseDuration(%v) failed: %v", script.Timeout, err), "Timeout")
+		}
+	}
+
+	// Install base profile first, before any test-specific profiles.
+	profiles := append([]string{"v23:base"}, script.Profiles...)
+
+	// Initialize the test.
+	repro := getReproOpt(opts)
+	cleanup, err := initTest(jirix, testName, profiles, repro)
+	if err != nil {
+		return nil, newInternalError(err, "Init")
+	}
+	defer collect.Error(func() error { return cleanup() }, &e)
+
+	// Run the command.
+	dir := filepath.Join(jirix.Root, script.Dir)
+	env := envvar.MergeMaps(jirix.Env(), envvar.SliceToMap(script.Env))
+	if repro.reproduction != nil {
+		repro.record(dir, env, "sh", "-c", script.Command)
+		return &test.Result{Status: test.Passed}, nil
+	}
+	runErr := jirix.NewSeq().Pushd(dir).Verbose(true).Timeout(timeout).Env(env).Last("sh", "-c", script.Command)
+	if runErr != nil && runutil.IsTimeout(runErr) {
+		return &test.Result{
+			Status:       test.TimedOut,
+			TimeoutValue: timeout,
+		}, nil
+	}
+	if script.Results == "" {
+		if runErr != nil {
+			return nil, newInternalError(runErr, "Run")
+		}
+		return &test.Result{Status: test.Passed}, nil
+	}
+
+	// Merge the xUnit reports generated by the command.
+	pattern := filepath.Join(dir, script.Results)
+	files, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, newInternalError(fmt.Errorf("Glob(%v) failed: %v", pattern, err), "Results")
+	}
+	if len(files) == 0 {
+		if runErr != nil {
+			return nil, newInternalError(runErr, "Run")
+		}
+		return nil, newInternalError(fmt.Errorf("no xUnit reports match %v", pattern), "Results")
+	}
+	suites, failed := []xunit.TestSuite{}, runErr != nil
+	for _, file := range files {
+		bytes, err := jirix.NewSeq().ReadFile(file)
+		if err != nil {
+			return nil, newInternalError(err, "Results")
+		}
+		var fileSuites xunit.TestSuites
+		if err := xml.Unmarshal(bytes, &fileSuites); err != nil {
+			return nil, newInternalError(fmt.Errorf("Unmarshal(%v) failed: %v", file, err), "Results")
+		}
+		for _, suite := range fileSuites.Suites {
+			if suite.Failures > 0 || suite.Errors > 0 {
+				failed = true
+			}
+			suites = append(suites, suite)
+		}
+	}
+	if err := xunit.CreateReport(jirix, testName, suites); err != nil {
+		return nil, err
+	}
+	if failed {
+		return &test.Result{Status: test.Failed}, nil
+	}
+	return &test.Result{Status: test.Passed}, nil
+}
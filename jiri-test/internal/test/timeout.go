@@ -0,0 +1,131 @@
+// Copyright 2015 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package test
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultSigquitGrace is the default time between sending SIGQUIT to a
+// hung Go test binary (which makes the Go runtime dump the stacks of all
+// goroutines) and killing it, which can be changed via SigquitGraceOpt.
+const defaultSigquitGrace = 30 * time.Second
+
+var (
+	// goroutineHeaderRE matches the first line of the stack of a
+	// goroutine. In the dumps triggered by SIGQUIT, recent Go runtimes
+	// add the addresses of the goroutine and of its thread before the
+	// state.
+	goroutineHeaderRE = regexp.MustCompile(`^goroutine (\d+) (?:[^\[]* )?\[([^\]]*)\]:$`)
+	// goroutineWaitRE matches the wait duration that the Go runtime
+	// appends to the state of long blocked goroutines.
+	goroutineWaitRE = regexp.MustCompile(`, \d+ minutes`)
+	// stackArgsRE matches the arguments of a function call in a stack
+	// trace.
+	stackArgsRE = regexp.MustCompile(`\([^()]+\)$`)
+	// stackOffsetRE matches the PC offset of a stack trace location,
+	// and the frame addresses that recent Go runtimes add after it.
+	stackOffsetRE = regexp.MustCompile(` \+0x[0-9a-f]+( .*)?$`)
+	runLineRE     = regexp.MustCompile(`^=== RUN\s+(\S+)`)
+)
+
+// goroutineGroup represents a set of goroutines with identical stacks.
+type goroutineGroup struct {
+	state string
+	stack []string
+	ids   []int
+}
+
+// parseGoroutineDump extracts the goroutines of the stack dump found in
+// the given output and groups the goroutines that are in the same state
+// and have the same stack (modulo function arguments and PC offsets).
+// The groups are ordered from the largest to the smallest.
+func parseGoroutineDump(output string) []goroutineGroup {
+	groups, index := []goroutineGroup{}, map[string]int{}
+	var id int
+	var state string
+	var stack []string
+	flush := func() {
+		if stack == nil {
+			return
+		}
+		key := state + "\n" + strings.Join(stack, "\n")
+		if i, ok := index[key]; ok {
+			groups[i].ids = append(groups[i].ids, id)
+		} else {
+			index[key] = len(groups)
+			groups = append(groups, goroutineGroup{state: state, stack: stack, ids: []int{id}})
+		}
+		stack = nil
+	}
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if matches := goroutineHeaderRE.FindStringSubmatch(line); matches != nil {
+			flush()
+			id, _ = strconv.Atoi(matches[1])
+			state = goroutineWaitRE.ReplaceAllString(matches[2], "")
+			stack = []string{}
+			continue
+		}
+		if stack == nil {
+			continue
+		}
+		if strings.TrimSpace(line) == "" {
+			flush()
+			continue
+		}
+		if strings.HasPrefix(line, "\t") {
+			line = "\t" + stackOffsetRE.ReplaceAllString(strings.TrimSpace(line), "")
+		} else {
+			line = stackArgsRE.ReplaceAllString(line, "(...)")
+		}
+		stack = append(stack, line)
+	}
+	flush()
+	sort.Stable(goroutineGroupsBySize(groups))
+	return groups
+}
+
+type goroutineGroupsBySize []goroutineGroup
+
+func (g goroutineGroupsBySize) Len() int           { return len(g) }
+func (g goroutineGroupsBySize) Swap(i, j int)      { g[i], g[j] = g[j], g[i] }
+func (g goroutineGroupsBySize) Less(i, j int) bool { return len(g[i].ids) > len(g[j].ids) }
+
+// formatGoroutineGroups formats the given goroutine groups for
+// inclusion in an xUnit report.
+func formatGoroutineGroups(groups []goroutineGroup) string {
+	var buf bytes.Buffer
+	total := 0
+	for _, g := range groups {
+		total += len(g.ids)
+	}
+	fmt.Fprintf(&buf, "%d goroutines in %d groups:\n", total, len(groups))
+	for _, g := range groups {
+		fmt.Fprintf(&buf, "\n%d goroutine(s) [%s]: %v\n", len(g.ids), g.state, g.ids)
+		for _, line := range g.stack {
+			fmt.Fprintf(&buf, "%s\n", line)
+		}
+	}
+	return buf.String()
+}
+
+// lastRunningTest returns the name of the test identified by the last
+// "=== RUN" line of the given "go test -v" output.
+func lastRunningTest(output string) string {
+	name := ""
+	for _, line := range strings.Split(output, "\n") {
+		if matches := runLineRE.FindStringSubmatch(line); matches != nil {
+			name = matches[1]
+		}
+	}
+	return name
+}
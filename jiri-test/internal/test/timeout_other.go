@@ -0,0 +1,24 @@
+// Copyright 2015 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !darwin,!linux
+
+package test
+
+import (
+	"io"
+	"time"
+
+	"v.io/jiri"
+	"v.io/jiri/runutil"
+)
+
+// runWithTimeoutDiagnostics is supposed to make the Go test binaries
+// started by the given command dump their goroutines before the command
+// is killed for exceeding the given timeout. However, this
+// implementation only kills the command.
+func runWithTimeoutDiagnostics(jirix *jiri.X, out io.Writer, timeout, grace time.Duration, name string, args ...string) (bool, error) {
+	err := jirix.NewSeq().Capture(out, out).Verbose(false).Timeout(timeout).Last(name, args...)
+	return runutil.IsTimeout(err), err
+}
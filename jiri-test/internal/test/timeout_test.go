@@ -0,0 +1,94 @@
+// Copyright 2015 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const hungTestOutput = `=== RUN   TestFoo
+--- PASS: TestFoo (0.00s)
+=== RUN   TestHang
+SIGQUIT: quit
+PC=0x45f1c1 m=0
+
+goroutine 0 [idle]:
+runtime.futex(0x5d3f88, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x45a7fd, 0xc820026000, ...)
+	/usr/lib/go/src/runtime/sys_linux_amd64.s:288 +0x21
+
+goroutine 1 [chan receive, 5 minutes]:
+testing.RunTests(0x4ef8a8, 0x5d1340, 0x2, 0x2, 0x1)
+	/usr/lib/go/src/testing/testing.go:583 +0x8d2
+main.main()
+	v.io/x/foo/_test/_testmain.go:56 +0x116
+
+goroutine 17 [select, 5 minutes]:
+v.io/x/foo.worker(0xc82001a0c0)
+	/jiri/release/go/src/v.io/x/foo/foo.go:12 +0x83
+created by v.io/x/foo.TestHang
+	/jiri/release/go/src/v.io/x/foo/foo_test.go:20 +0x5f
+
+goroutine 18 [select]:
+v.io/x/foo.worker(0xc82001a180)
+	/jiri/release/go/src/v.io/x/foo/foo.go:12 +0x83
+created by v.io/x/foo.TestHang
+	/jiri/release/go/src/v.io/x/foo/foo_test.go:20 +0x5f
+
+rax    0xca
+rbx    0x0
+exit status 2
+FAIL	v.io/x/foo	600.012s
+`
+
+func TestLastRunningTest(t *testing.T) {
+	if got, want := lastRunningTest(hungTestOutput), "TestHang"; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got, want := lastRunningTest("ok v.io/x/foo 0.1s\n"), ""; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestParseGoroutineDump(t *testing.T) {
+	got := parseGoroutineDump(hungTestOutput)
+	want := []goroutineGroup{
+		{
+			state: "select",
+			stack: []string{
+				"v.io/x/foo.worker(...)",
+				"\t/jiri/release/go/src/v.io/x/foo/foo.go:12",
+				"created by v.io/x/foo.TestHang",
+				"\t/jiri/release/go/src/v.io/x/foo/foo_test.go:20",
+			},
+			ids: []int{17, 18},
+		},
+		{
+			state: "idle",
+			stack: []string{
+				"runtime.futex(...)",
+				"\t/usr/lib/go/src/runtime/sys_linux_amd64.s:288",
+			},
+			ids: []int{0},
+		},
+		{
+			state: "chan receive",
+			stack: []string{
+				"testing.RunTests(...)",
+				"\t/usr/lib/go/src/testing/testing.go:583",
+				"main.main()",
+				"\tv.io/x/foo/_test/_testmain.go:56",
+			},
+			ids: []int{1},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected groups:\ngot\n%#v\nwant\n%#v", got, want)
+	}
+	if report := formatGoroutineGroups(got); !strings.HasPrefix(report, "4 goroutines in 3 groups:\n") {
+		t.Fatalf("unexpected report:\n%v", report)
+	}
+}
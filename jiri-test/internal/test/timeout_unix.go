@@ -0,0 +1,111 @@
+// Copyright 2015 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build darwin linux
+
+package test
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"v.io/jiri"
+	"v.io/x/lib/envvar"
+)
+
+// runWithTimeoutDiagnostics runs the given command, writing its output
+// to the given writer. If the command does not finish within the given
+// timeout, the Go test binaries it started are sent SIGQUIT and, after
+// the given grace period, the process group of the command is killed.
+// The function reports whether the command timed out.
+//
+// The command is not run via jirix.NewSeq(), which cannot run it in its
+// own process group or signal the processes it starts.
+func runWithTimeoutDiagnostics(jirix *jiri.X, out io.Writer, timeout, grace time.Duration, name string, args ...string) (bool, error) {
+	cmd := exec.Command(name, args...)
+	cmd.Stdout, cmd.Stderr = out, out
+	cmd.Env = envvar.MapToSlice(envvar.MergeMaps(envvar.SliceToMap(os.Environ()), jirix.Env()))
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		return false, fmt.Errorf("Start(%v) failed: %v", name, err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+	if grace > timeout {
+		grace = timeout
+	}
+	select {
+	case err := <-done:
+		return false, err
+	case <-time.After(timeout - grace):
+	}
+
+	// Ask the test binaries to dump their goroutines.
+	pgid := cmd.Process.Pid
+	pids, err := goTestBinaryPids(jirix, pgid)
+	if err != nil || len(pids) == 0 {
+		if err != nil {
+			fmt.Fprintf(jirix.Stderr(), "failed to find test binaries of process %d: %v\n", pgid, err)
+		}
+		syscall.Kill(-pgid, syscall.SIGQUIT)
+	}
+	for _, pid := range pids {
+		syscall.Kill(pid, syscall.SIGQUIT)
+	}
+	select {
+	case err := <-done:
+		return true, err
+	case <-time.After(grace):
+	}
+
+	// Kill everything that is left.
+	syscall.Kill(-pgid, syscall.SIGKILL)
+	return true, <-done
+}
+
+// goTestBinaryPids returns the IDs of the Go test binaries (whose names
+// end with ".test") among the descendants of the given process.
+func goTestBinaryPids(jirix *jiri.X, pid int) ([]int, error) {
+	var out bytes.Buffer
+	if err := jirix.NewSeq().Capture(&out, nil).Verbose(false).Last("ps", "-A", "-o", "pid=,ppid=,args="); err != nil {
+		return nil, err
+	}
+	children, binaries := map[int][]int{}, map[int]string{}
+	scanner := bufio.NewScanner(&out)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 {
+			continue
+		}
+		childPid, err := strconv.Atoi(fields[0])
+		if err != nil {
+			continue
+		}
+		parentPid, err := strconv.Atoi(fields[1])
+		if err != nil {
+			continue
+		}
+		children[parentPid] = append(children[parentPid], childPid)
+		binaries[childPid] = fields[2]
+	}
+	result, queue := []int{}, children[pid]
+	for len(queue) > 0 {
+		p := queue[0]
+		queue = append(queue[1:], children[p]...)
+		if strings.HasSuffix(binaries[p], ".test") {
+			result = append(result, p)
+		}
+	}
+	return result, nil
+}
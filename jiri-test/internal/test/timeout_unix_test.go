@@ -0,0 +1,64 @@
+// Copyright 2015 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build darwin linux
+
+package test
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"v.io/jiri/jiritest"
+)
+
+// hangEnv is the environment variable that makes TestHangingHelper
+// hang, so that TestRunWithTimeoutDiagnostics can run a hanging Go test
+// binary.
+const hangEnv = "JIRI_TEST_HANG"
+
+func TestHangingHelper(t *testing.T) {
+	if os.Getenv(hangEnv) == "" {
+		return
+	}
+	time.Sleep(time.Hour)
+}
+
+func TestRunWithTimeoutDiagnostics(t *testing.T) {
+	fake, cleanup := jiritest.NewFakeJiriRoot(t)
+	defer cleanup()
+	if err := os.Setenv(hangEnv, "1"); err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.Unsetenv(hangEnv)
+
+	// Run the test binary so that it hangs in TestHangingHelper, and
+	// check that it dumps its goroutines before it gets killed.
+	var out bytes.Buffer
+	timedOut, err := runWithTimeoutDiagnostics(fake.X, &out, 10*time.Second, 8*time.Second, os.Args[0], "-test.run=^TestHangingHelper$", "-test.v")
+	if err == nil {
+		t.Fatalf("hanging test binary did not fail:\n%s", out.String())
+	}
+	if !timedOut {
+		t.Fatalf("hanging test binary did not time out: %v\n%s", err, out.String())
+	}
+	output := out.String()
+	if got, want := lastRunningTest(output), "TestHangingHelper"; got != want {
+		t.Fatalf("got %v, want %v\n%s", got, want, output)
+	}
+	found := false
+	for _, group := range parseGoroutineDump(output) {
+		for _, line := range group.stack {
+			if strings.HasPrefix(line, "v.io/x/devtools/jiri-test/internal/test.TestHangingHelper(") {
+				found = true
+			}
+		}
+	}
+	if !found {
+		t.Fatalf("no goroutine of TestHangingHelper found in the goroutine dump:\n%s", output)
+	}
+}
@@ -29,6 +29,13 @@ func vanadiumVkubeIntegrationTest(jirix *jiri.X, testName string, opts ...Opt) (
 	if project == "" {
 		return nil, newInternalError(fmt.Errorf("project not defined in %s environment variable", projectEnvVar), "Env")
 	}
+
+	cleanup, err := initTest(jirix, testName, []string{"v23:base"})
+	if err != nil {
+		return nil, newInternalError(err, "Init")
+	}
+	defer collect.Error(cleanup, &e)
+
 	s := jirix.NewSeq()
 	if err := s.Last("docker", "info"); err != nil {
 		return nil, newInternalError(errors.New("this test requires docker"), err.Error())
@@ -40,12 +47,6 @@ func vanadiumVkubeIntegrationTest(jirix *jiri.X, testName string, opts ...Opt) (
 		return nil, newInternalError(errors.New("failed to clean up old namespaces"), err.Error())
 	}
 
-	cleanup, err := initTest(jirix, testName, []string{"v23:base"})
-	if err != nil {
-		return nil, newInternalError(err, "Init")
-	}
-	defer collect.Error(cleanup, &e)
-
 	args := []string{
 		"go", "test", "-v=1", "-run=TestV23Vkube", "-timeout=30m",
 		"v.io/x/ref/services/cluster/vkube",
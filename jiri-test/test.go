@@ -10,8 +10,11 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"os"
+	"os/exec"
 	"runtime"
 	"strings"
+	"time"
 
 	"v.io/jiri"
 	"v.io/jiri/profiles/profilescmdline"
@@ -21,12 +24,15 @@ import (
 	jiriTest "v.io/x/devtools/jiri-test/internal/test"
 	"v.io/x/devtools/tooldata"
 	"v.io/x/lib/cmdline"
+	"v.io/x/lib/envvar"
 	"v.io/x/lib/set"
 )
 
 var (
 	blessingsRootFlag    string
 	cleanGoFlag          bool
+	coverageBaseFlag     string
+	limitPkgsFlag        bool
 	mockTestFilePaths    string
 	mockTestFileContents string
 	namespaceRootFlag    string
@@ -34,6 +40,9 @@ var (
 	outputDirFlag        string
 	partFlag             int
 	pkgsFlag             string
+	runFlag              string
+	shellFlag            bool
+	sigquitGraceFlag     time.Duration
 	oauthBlesserFlag     string
 	adminRoleFlag        string
 	publisherRoleFlag    string
@@ -48,9 +57,16 @@ func init() {
 	cmdTestRun.Flags.StringVar(&outputDirFlag, "output-dir", "", "Directory to output test results into.")
 	cmdTestRun.Flags.IntVar(&partFlag, "part", -1, "Specify which part of the test to run.")
 	cmdTestRun.Flags.StringVar(&pkgsFlag, "pkgs", "", "Comma-separated list of Go package expressions that identify a subset of tests to run; only relevant for Go-based tests. Example usage: jiri test run -pkgs v.io/x/ref vanadium-go-test")
+	cmdTestRun.Flags.BoolVar(&limitPkgsFlag, "limit-pkgs", false, "Whether -pkgs limits the default packages of each Go-based test instead of replacing them: requested packages outside of the defaults of a test are ignored, and a test none of whose defaults are requested tests all of them.")
+	cmdTestRun.Flags.DurationVar(&sigquitGraceFlag, "sigquit-grace", 30*time.Second, "How long before killing a hung Go test binary to send it SIGQUIT, which makes it dump the stacks of all goroutines; only relevant for Go-based tests.")
 	cmdTestRun.Flags.BoolVar(&cleanGoFlag, "clean-go", true, "Specify whether to remove Go object files and binaries before running the tests. Setting this flag to 'false' may lead to faster Go builds, but it may also result in some source code changes not being reflected in the tests (e.g., if the change was made in a different Go workspace).")
+	cmdTestRun.Flags.StringVar(&coverageBaseFlag, "coverage-base", "", "Git revision against which to compute the coverage of changed lines; only relevant for Go coverage tests. If empty, the coverage of changed lines is not computed.")
 	cmdTestRun.Flags.StringVar(&mockTestFilePaths, "mock-file-paths", "", "Colon-separated file paths to read when testing presubmit test. This flag is only used when running presubmit end-to-end test.")
 	cmdTestRun.Flags.StringVar(&mockTestFileContents, "mock-file-contents", "", "Colon-separated file contents to check when testing presubmit test. This flag is only used when running presubmit end-to-end test.")
+	cmdTestRepro.Flags.IntVar(&partFlag, "part", -1, "Specify which part of the test to reproduce.")
+	cmdTestRepro.Flags.StringVar(&pkgsFlag, "pkgs", "", "Comma-separated list of Go package expressions that identify a subset of tests to reproduce; only relevant for Go-based tests.")
+	cmdTestRepro.Flags.StringVar(&runFlag, "run", "", "Regular expression that identifies the Go tests to reproduce; only relevant for Go-based tests.")
+	cmdTestRepro.Flags.BoolVar(&shellFlag, "shell", false, "Start a shell in the environment of the test after printing its commands.")
 	tool.InitializeRunFlags(&cmdTest.Flags)
 	tool.InitializeProjectFlags(&cmdProjectPoll.Flags)
 	profilescmdline.RegisterReaderFlags(&cmdTest.Flags, &readerFlags, "v23:base", jiri.ProfilesDBDir)
@@ -61,7 +77,7 @@ var cmdTest = &cmdline.Command{
 	Name:     "test",
 	Short:    "Manage vanadium tests",
 	Long:     "Manage vanadium tests.",
-	Children: []*cmdline.Command{cmdProjectPoll, cmdTestProject, cmdTestRun, cmdTestList},
+	Children: []*cmdline.Command{cmdProjectPoll, cmdTestProject, cmdTestRun, cmdTestRepro, cmdTestList},
 }
 
 // cmdTestProject represents the "jiri test project" command.
@@ -126,6 +142,69 @@ func runTestRun(jirix *jiri.X, args []string) error {
 	return nil
 }
 
+// cmdTestRepro represents the "jiri test repro" command.
+var cmdTestRepro = &cmdline.Command{
+	Runner: jiri.RunnerFunc(runTestRepro),
+	Name:   "repro",
+	Short:  "Reproduce a vanadium test locally",
+	Long: `
+Sets up a vanadium test in the same way as the continuous integration
+system does and prints the commands the test would run, so that a test
+failure can be reproduced locally. Profiles are neither installed nor
+updated, so the command works offline against the local checkout.
+
+Only tests that record the commands they would run can be reproduced:
+the Go tests run by the Go test runner, such as vanadium-go-test,
+vanadium-go-race and vanadium-integration-test, and the script tests
+defined by the tools configuration. Other tests, such as builds,
+deployments and Makefile-based tests, run commands that cannot be
+recorded, and reproducing them fails before any of their commands
+runs.
+`,
+	ArgsName: "<name>",
+	ArgsLong: "<name> identifies the test to reproduce.",
+}
+
+func runTestRepro(jirix *jiri.X, args []string) error {
+	jiriTest.ProfilesDBFilename = readerFlags.DBFilename
+	if len(args) != 1 {
+		return jirix.UsageErrorf("unexpected number of arguments")
+	}
+	opts := []jiriTest.Opt{
+		jiriTest.PkgsOpt(splitPkgs(pkgsFlag)),
+		jiriTest.RunOpt(runFlag),
+		jiriTest.MergePoliciesOpt(readerFlags.MergePolicies),
+	}
+	if partFlag >= 0 {
+		opts = append(opts, jiriTest.PartOpt(partFlag))
+	}
+	commands, err := jiriTest.ReproTest(jirix, nil, args[0], opts...)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(jirix.Stdout(), "##### Commands of test %q #####\n", args[0])
+	for _, command := range commands {
+		fmt.Fprintf(jirix.Stdout(), "%v\n", command)
+	}
+	if !shellFlag {
+		return nil
+	}
+
+	// Start a shell in the directory and environment of the last
+	// command.
+	command := commands[len(commands)-1]
+	shell := os.Getenv("SHELL")
+	if shell == "" {
+		shell = "sh"
+	}
+	fmt.Fprintf(jirix.Stdout(), "##### Starting %v in %v #####\n", shell, command.Dir)
+	cmd := exec.Command(shell)
+	cmd.Dir = command.Dir
+	cmd.Env = envvar.MapToSlice(envvar.MergeMaps(envvar.SliceToMap(os.Environ()), command.Env))
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, jirix.Stdout(), jirix.Stderr()
+	return cmd.Run()
+}
+
 // cmdProjectPoll represents the "jiri project poll" command.
 var cmdProjectPoll = &cmdline.Command{
 	Runner: jiri.RunnerFunc(runProjectPoll),
@@ -193,19 +272,16 @@ func optsFromFlags() (opts []jiriTest.Opt) {
 		opt := jiriTest.PartOpt(partFlag)
 		opts = append(opts, opt)
 	}
-	pkgs := []string{}
-	for _, pkg := range strings.Split(pkgsFlag, ",") {
-		if len(pkg) > 0 {
-			pkgs = append(pkgs, pkg)
-		}
-	}
-	opts = append(opts, jiriTest.PkgsOpt(pkgs))
+	opts = append(opts, jiriTest.PkgsOpt(splitPkgs(pkgsFlag)))
 	opts = append(opts,
+		jiriTest.LimitPkgsOpt(limitPkgsFlag),
 		jiriTest.BlessingsRootOpt(blessingsRootFlag),
 		jiriTest.NamespaceRootOpt(namespaceRootFlag),
 		jiriTest.NumWorkersOpt(numWorkersFlag),
 		jiriTest.OutputDirOpt(outputDirFlag),
 		jiriTest.CleanGoOpt(cleanGoFlag),
+		jiriTest.CoverageBaseOpt(coverageBaseFlag),
+		jiriTest.SigquitGraceOpt(sigquitGraceFlag),
 		jiriTest.MergePoliciesOpt(readerFlags.MergePolicies),
 	)
 	if mockTestFilePaths != "" && mockTestFileContents != "" {
@@ -217,6 +293,18 @@ func optsFromFlags() (opts []jiriTest.Opt) {
 	return
 }
 
+// splitPkgs splits the given comma-separated list of Go package
+// expressions.
+func splitPkgs(list string) []string {
+	pkgs := []string{}
+	for _, pkg := range strings.Split(list, ",") {
+		if len(pkg) > 0 {
+			pkgs = append(pkgs, pkg)
+		}
+	}
+	return pkgs
+}
+
 func printSummary(jirix *jiri.X, results map[string]*test.Result) {
 	fmt.Fprintf(jirix.Stdout(), "SUMMARY:\n")
 	for name, result := range results {
@@ -244,7 +332,7 @@ var cmdTestList = &cmdline.Command{
 
 func runTestList(jirix *jiri.X, _ []string) error {
 	jiriTest.ProfilesDBFilename = readerFlags.DBFilename
-	testList, err := jiriTest.ListTests()
+	testList, err := jiriTest.ListTests(jirix)
 	if err != nil {
 		fmt.Fprintf(jirix.Stderr(), "%v\n", err)
 		return err
@@ -94,7 +94,7 @@ func TestTestList(t *testing.T) {
 	if err := runTestList(fake.X, []string{}); err != nil {
 		t.Fatalf("%v", err)
 	}
-	testList, err := test.ListTests()
+	testList, err := test.ListTests(fake.X)
 	if err != nil {
 		t.Fatalf("%v", err)
 	}
@@ -1218,16 +1218,33 @@ Jiri oncall - Manage vanadium oncall schedule
 Manage vanadium oncall schedule. If no subcommand is given, it shows the LDAP of
 the current oncall.
 
+The schedule consists of shifts, which are generated for the members of the
+rotation, and of overrides, which record one-off substitutions without changing
+the shifts.
+
+Dates on the command line are formatted as 2006-01-02, 2006-01-02 15:04, or
+2006-01-02T15:04:05-07:00. Dates without a timezone are in the timezone of the
+rotation.
+
 Usage:
    jiri oncall [flags]
    jiri oncall [flags] <command>
 
 The jiri oncall commands are:
    list        List available oncall schedule
+   generate    Generate oncall shifts
+   swap        Swap the oncalls of two shifts
+   override    Substitute the oncall for a period
+   ics         Export the oncall schedule as an iCalendar file
 
 The jiri oncall flags are:
  -color=true
    Use color to format output.
+ -timezone=
+   The IANA timezone of the dates of the rotation, such as America/Los_Angeles.
+   Defaults to the timezone of the rotation if it has one, and to the local
+   timezone otherwise. Setting it for the generate command records it in the
+   rotation.
  -v=false
    Print verbose output.
 
@@ -1241,6 +1258,119 @@ Usage:
 The jiri oncall list flags are:
  -color=true
    Use color to format output.
+ -timezone=
+   The IANA timezone of the dates of the rotation, such as America/Los_Angeles.
+   Defaults to the timezone of the rotation if it has one, and to the local
+   timezone otherwise. Setting it for the generate command records it in the
+   rotation.
+ -v=false
+   Print verbose output.
+
+Jiri oncall generate - Generate oncall shifts
+
+Generate oncall shifts for the given members, replacing the shifts that start at
+or after the start of the first generated shift.
+
+The rotation is fair: the primary of each shift is the available member who has
+been primary the least often, and the secondary is the other available member
+who has been secondary the least often, counting the shifts that are kept.
+
+Usage:
+   jiri oncall generate [flags]
+
+The jiri oncall generate flags are:
+ -color=true
+   Use color to format output.
+ -from=
+   The start of the first generated shift. Defaults to the end of the current
+   schedule.
+ -length=1w
+   The length of a shift, as a number of weeks (1w), days (3d) or a duration
+   (12h).
+ -members=
+   Comma-separated list of the LDAPs of the members of the rotation.
+ -shifts=0
+   The number of shifts to generate. Defaults to the number of members.
+ -timezone=
+   The IANA timezone of the dates of the rotation, such as America/Los_Angeles.
+   Defaults to the timezone of the rotation if it has one, and to the local
+   timezone otherwise. Setting it for the generate command records it in the
+   rotation.
+ -unavailable=
+   Comma-separated list of <ldap>@<start>..<end> periods during which members
+   cannot be oncall.
+ -v=false
+   Print verbose output.
+
+Jiri oncall swap - Swap the oncalls of two shifts
+
+Swap the primary and secondary oncalls of the shifts at the given dates, by
+recording an override for each shift.
+
+Usage:
+   jiri oncall swap [flags] <date> <date>
+
+<date> <date> are dates in the two shifts to swap.
+
+The jiri oncall swap flags are:
+ -color=true
+   Use color to format output.
+ -reason=
+   The reason for the swap.
+ -timezone=
+   The IANA timezone of the dates of the rotation, such as America/Los_Angeles.
+   Defaults to the timezone of the rotation if it has one, and to the local
+   timezone otherwise. Setting it for the generate command records it in the
+   rotation.
+ -v=false
+   Print verbose output.
+
+Jiri oncall override - Substitute the oncall for a period
+
+Record a substitution of the primary and/or secondary oncall for the given
+period.
+
+Usage:
+   jiri oncall override [flags] <start> [<end>]
+
+<start> [<end>] is the period of the substitution, which ends with the shift at
+<start> if <end> is not given.
+
+The jiri oncall override flags are:
+ -color=true
+   Use color to format output.
+ -primary=
+   The LDAP of the substitute primary oncall.
+ -reason=
+   The reason for the override.
+ -secondary=
+   The LDAP of the substitute secondary oncall.
+ -timezone=
+   The IANA timezone of the dates of the rotation, such as America/Los_Angeles.
+   Defaults to the timezone of the rotation if it has one, and to the local
+   timezone otherwise. Setting it for the generate command records it in the
+   rotation.
+ -v=false
+   Print verbose output.
+
+Jiri oncall ics - Export the oncall schedule as an iCalendar file
+
+Print the oncall schedule, with the overrides applied, as an iCalendar (.ics)
+file that calendar applications can import or subscribe to.
+
+Usage:
+   jiri oncall ics [flags]
+
+The jiri oncall ics flags are:
+ -color=true
+   Use color to format output.
+ -member=
+   If set, only the shifts of the member with the given LDAP are exported.
+ -timezone=
+   The IANA timezone of the dates of the rotation, such as America/Los_Angeles.
+   Defaults to the timezone of the rotation if it has one, and to the local
+   timezone otherwise. Setting it for the generate command records it in the
+   rotation.
  -v=false
    Print verbose output.
 
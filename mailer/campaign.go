@@ -0,0 +1,283 @@
+// Copyright 2015 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"v.io/jiri"
+)
+
+// emailVar is the variable of recipients that holds their email
+// address.
+const emailVar = "email"
+
+// recipient holds the variables of a recipient of a campaign.
+type recipient map[string]string
+
+// campaign is a campaign as defined in the campaigns file.
+type campaign struct {
+	From string `json:"from"`
+	// Subject is a text/template template.
+	Subject string `json:"subject"`
+	// HTML is the path to the html/template template of the HTML body.
+	HTML string `json:"html"`
+	// Text is the path to the text/template template of the optional
+	// plain text body.
+	Text string `json:"text,omitempty"`
+	// Attachments are the paths to the files attached to the emails.
+	Attachments []string `json:"attachments,omitempty"`
+}
+
+// attachment is a file attached to the emails of a campaign.
+type attachment struct {
+	name string
+	data []byte
+}
+
+// compiledCampaign is a campaign with its templates parsed and its
+// attachments read.
+type compiledCampaign struct {
+	from        string
+	subject     *template.Template
+	html        *htmltemplate.Template
+	text        *template.Template
+	attachments []attachment
+}
+
+// loadCampaigns reads the campaigns of the given campaigns file, and
+// compiles them.
+func loadCampaigns(jirix *jiri.X, path string) (map[string]*compiledCampaign, error) {
+	s := jirix.NewSeq()
+	bytes, err := s.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var campaigns map[string]campaign
+	if err := json.Unmarshal(bytes, &campaigns); err != nil {
+		return nil, fmt.Errorf("Unmarshal(%v) failed: %v", path, err)
+	}
+	dir := filepath.Dir(path)
+	resolve := func(p string) string {
+		if filepath.IsAbs(p) {
+			return p
+		}
+		return filepath.Join(dir, p)
+	}
+	result := map[string]*compiledCampaign{}
+	for name, c := range campaigns {
+		if c.From == "" || c.Subject == "" || c.HTML == "" {
+			return nil, fmt.Errorf("campaign %q must have a sender, a subject and an HTML body", name)
+		}
+		compiled := &compiledCampaign{from: c.From}
+		if compiled.subject, err = template.New("subject").Option("missingkey=error").Parse(c.Subject); err != nil {
+			return nil, fmt.Errorf("Parse(%v) failed: %v", c.Subject, err)
+		}
+		html, err := s.ReadFile(resolve(c.HTML))
+		if err != nil {
+			return nil, err
+		}
+		if compiled.html, err = htmltemplate.New("html").Option("missingkey=error").Parse(string(html)); err != nil {
+			return nil, fmt.Errorf("Parse(%v) failed: %v", c.HTML, err)
+		}
+		if c.Text != "" {
+			text, err := s.ReadFile(resolve(c.Text))
+			if err != nil {
+				return nil, err
+			}
+			if compiled.text, err = template.New("text").Option("missingkey=error").Parse(string(text)); err != nil {
+				return nil, fmt.Errorf("Parse(%v) failed: %v", c.Text, err)
+			}
+		}
+		for _, a := range c.Attachments {
+			data, err := s.ReadFile(resolve(a))
+			if err != nil {
+				return nil, err
+			}
+			compiled.attachments = append(compiled.attachments, attachment{name: filepath.Base(a), data: data})
+		}
+		result[name] = compiled
+	}
+	return result, nil
+}
+
+// loadRecipients reads the recipients of the given CSV or JSON file.
+// CSV files have a header row with the names of the variables.
+func loadRecipients(jirix *jiri.X, path string) ([]recipient, error) {
+	data, err := jirix.NewSeq().ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	recipients := []recipient{}
+	switch filepath.Ext(path) {
+	case ".csv":
+		records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+		if err != nil {
+			return nil, fmt.Errorf("ReadAll(%v) failed: %v", path, err)
+		}
+		if len(records) == 0 {
+			return nil, fmt.Errorf("%v has no header row", path)
+		}
+		header := records[0]
+		for _, record := range records[1:] {
+			r := recipient{}
+			for i, name := range header {
+				r[strings.TrimSpace(name)] = strings.TrimSpace(record[i])
+			}
+			recipients = append(recipients, r)
+		}
+	case ".json":
+		if err := json.Unmarshal(data, &recipients); err != nil {
+			return nil, fmt.Errorf("Unmarshal(%v) failed: %v", path, err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown format of recipients file %v", path)
+	}
+	for i, r := range recipients {
+		if _, err := mail.ParseAddress(r[emailVar]); err != nil {
+			return nil, fmt.Errorf("recipient %d of %v has invalid email %q: %v", i+1, path, r[emailVar], err)
+		}
+	}
+	return recipients, nil
+}
+
+// render returns the subject and the MIME message of the email of the
+// campaign to the given recipient.
+func (c *compiledCampaign) render(r recipient, now time.Time) (string, []byte, error) {
+	var subject, html, text bytes.Buffer
+	if err := c.subject.Execute(&subject, r); err != nil {
+		return "", nil, fmt.Errorf("Execute() failed: %v", err)
+	}
+	if err := c.html.Execute(&html, r); err != nil {
+		return "", nil, fmt.Errorf("Execute() failed: %v", err)
+	}
+	if c.text != nil {
+		if err := c.text.Execute(&text, r); err != nil {
+			return "", nil, fmt.Errorf("Execute() failed: %v", err)
+		}
+	}
+
+	var msg bytes.Buffer
+	mixed := multipart.NewWriter(&msg)
+	headers := [][2]string{
+		{"From", c.from},
+		{"To", r[emailVar]},
+		{"Subject", mime.QEncoding.Encode("utf-8", subject.String())},
+		{"Date", now.Format(time.RFC1123Z)},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", "multipart/mixed; boundary=" + mixed.Boundary()},
+	}
+	for _, h := range headers {
+		fmt.Fprintf(&msg, "%s: %s\r\n", h[0], h[1])
+	}
+	fmt.Fprintf(&msg, "\r\n")
+
+	// The bodies are alternatives of each other.
+	var bodies bytes.Buffer
+	alternative := multipart.NewWriter(&bodies)
+	if c.text != nil {
+		if err := writeQuotedPrintable(alternative, "text/plain; charset=utf-8", text.Bytes()); err != nil {
+			return "", nil, err
+		}
+	}
+	if err := writeQuotedPrintable(alternative, "text/html; charset=utf-8", html.Bytes()); err != nil {
+		return "", nil, err
+	}
+	if err := alternative.Close(); err != nil {
+		return "", nil, fmt.Errorf("Close() failed: %v", err)
+	}
+	part, err := mixed.CreatePart(textproto.MIMEHeader{
+		"Content-Type": {"multipart/alternative; boundary=" + alternative.Boundary()},
+	})
+	if err != nil {
+		return "", nil, fmt.Errorf("CreatePart() failed: %v", err)
+	}
+	if _, err := part.Write(bodies.Bytes()); err != nil {
+		return "", nil, fmt.Errorf("Write() failed: %v", err)
+	}
+
+	for _, a := range c.attachments {
+		contentType := mime.TypeByExtension(filepath.Ext(a.name))
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		part, err := mixed.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {contentType},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": a.name})},
+			"Content-Transfer-Encoding": {"base64"},
+		})
+		if err != nil {
+			return "", nil, fmt.Errorf("CreatePart() failed: %v", err)
+		}
+		if err := writeBase64(part, a.data); err != nil {
+			return "", nil, err
+		}
+	}
+	if err := mixed.Close(); err != nil {
+		return "", nil, fmt.Errorf("Close() failed: %v", err)
+	}
+	return subject.String(), msg.Bytes(), nil
+}
+
+func writeQuotedPrintable(w *multipart.Writer, contentType string, data []byte) error {
+	part, err := w.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {contentType},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return fmt.Errorf("CreatePart() failed: %v", err)
+	}
+	qp := quotedprintable.NewWriter(part)
+	if _, err := qp.Write(data); err != nil {
+		return fmt.Errorf("Write() failed: %v", err)
+	}
+	if err := qp.Close(); err != nil {
+		return fmt.Errorf("Close() failed: %v", err)
+	}
+	return nil
+}
+
+// writeBase64 writes the given data in base64 in lines of 76
+// characters, as required by RFC 2045.
+func writeBase64(w io.Writer, data []byte) error {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 0 {
+		n := 76
+		if len(encoded) < n {
+			n = len(encoded)
+		}
+		if _, err := io.WriteString(w, encoded[:n]+"\r\n"); err != nil {
+			return fmt.Errorf("WriteString() failed: %v", err)
+		}
+		encoded = encoded[n:]
+	}
+	return nil
+}
+
+// names returns the sorted names of the given campaigns.
+func names(campaigns map[string]*compiledCampaign) []string {
+	result := []string{}
+	for name := range campaigns {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
@@ -6,17 +6,63 @@
 // DO NOT UPDATE MANUALLY
 
 /*
-Command mailer sends the vanadium welcome email, including the NDA as an
-attachment.  The email is sent via smtp-relay.gmail.com.
+Command mailer sends the emails of a campaign, such as the vanadium welcome
+email, to a list of recipients. The emails are sent via smtp-relay.gmail.com by
+default.
 
-Due to legacy reasons(?), the configuration is via environment variables:
+Campaigns are defined by the JSON file passed to the -campaigns flag, which maps
+the names of the campaigns to their sender, subject, templates and attachments.
+The subject and text bodies are text/template templates and the HTML bodies are
+html/template templates, which are executed with the variables of each
+recipient. Paths are relative to the directory of the campaigns file. For
+example:
+
+{
+  "welcome": {
+    "from": "Vanadium Team <welcome@v.io>",
+    "subject": "Vanadium early access activated for {{.name}}",
+    "html": "welcome.html",
+    "text": "welcome.txt",
+    "attachments": ["google-agreement.pdf"]
+  }
+}
+
+The recipients are listed in a CSV file with a header row, or in a JSON file
+holding a list of objects. Both must have an "email" variable, and the other
+columns or fields are variables of the templates.
+
+Campaigns are sent to each recipient at most once, unless the -resend flag is
+set: the emails sent are recorded in the -sent-log file.
+
+The credentials of the SMTP server are read from the environment variables:
 	EMAIL_USERNAME: Sender email username.
 	EMAIL_PASSWORD: Sender email password.
-	EMAILS:         Space-separated list of recipient email addresses.
-	NDA_BUCKET:     Google-storage bucket that contains the NDA.
 
 Usage:
-   mailer [flags]
+   mailer [flags] <campaign>
+
+<campaign> is the name of the campaign to send.
+
+The mailer flags are:
+ -campaigns=
+   The path to the JSON file that defines the campaigns.
+ -delay=1s
+   The minimum delay between two emails.
+ -dry-run=false
+   Render the emails to the -output-dir directory instead of sending them.
+ -output-dir=
+   The directory that dry runs render the emails to. Defaults to a new temporary
+   directory.
+ -recipients=
+   The path to the CSV or JSON file that lists the recipients and their
+   variables. Defaults to the space-separated addresses in the EMAILS
+   environment variable.
+ -resend=false
+   Send the campaign to recipients that were already sent it.
+ -sent-log=${HOME}/tmp/mailer_sent.log
+   The file that records the emails sent to each recipient.
+ -smtp-server=smtp-relay.gmail.com:587
+   The address of the SMTP server.
 
 The global flags are:
  -metadata=<just specify -metadata to activate>
@@ -8,136 +8,148 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
-	"errors"
 	"fmt"
 	"os"
 	"strings"
+	"time"
 
-	gomail "gopkg.in/gomail.v1"
 	"v.io/jiri"
-	"v.io/jiri/tool"
-	"v.io/x/devtools/internal/cache"
 	"v.io/x/lib/cmdline"
 )
 
+const (
+	defaultSMTPServer  = "smtp-relay.gmail.com:587"
+	defaultSentLogFile = "${HOME}/tmp/mailer_sent.log"
+)
+
+var (
+	campaignsFlag  string
+	delayFlag      string
+	dryRunFlag     bool
+	outputDirFlag  string
+	recipientsFlag string
+	resendFlag     bool
+	sentLogFlag    string
+	smtpServerFlag string
+)
+
+func init() {
+	cmdMailer.Flags.StringVar(&campaignsFlag, "campaigns", "", "The path to the JSON file that defines the campaigns.")
+	cmdMailer.Flags.StringVar(&delayFlag, "delay", "1s", "The minimum delay between two emails.")
+	cmdMailer.Flags.BoolVar(&dryRunFlag, "dry-run", false, "Render the emails to the -output-dir directory instead of sending them.")
+	cmdMailer.Flags.StringVar(&outputDirFlag, "output-dir", "", "The directory that dry runs render the emails to. Defaults to a new temporary directory.")
+	cmdMailer.Flags.StringVar(&recipientsFlag, "recipients", "", "The path to the CSV or JSON file that lists the recipients and their variables. Defaults to the space-separated addresses in the EMAILS environment variable.")
+	cmdMailer.Flags.BoolVar(&resendFlag, "resend", false, "Send the campaign to recipients that were already sent it.")
+	cmdMailer.Flags.StringVar(&sentLogFlag, "sent-log", os.ExpandEnv(defaultSentLogFile), "The file that records the emails sent to each recipient.")
+	cmdMailer.Flags.Lookup("sent-log").DefValue = defaultSentLogFile
+	cmdMailer.Flags.StringVar(&smtpServerFlag, "smtp-server", defaultSMTPServer, "The address of the SMTP server.")
+}
+
 var cmdMailer = &cmdline.Command{
 	Runner: jiri.RunnerFunc(runMailer),
 	Name:   "mailer",
-	Short:  "sends vanadium welcome email",
+	Short:  "sends vanadium campaign emails",
 	Long: `
-Command mailer sends the vanadium welcome email, including the NDA as an
-attachment.  The email is sent via smtp-relay.gmail.com.
+Command mailer sends the emails of a campaign, such as the vanadium welcome
+email, to a list of recipients. The emails are sent via smtp-relay.gmail.com by
+default.
+
+Campaigns are defined by the JSON file passed to the -campaigns flag, which maps
+the names of the campaigns to their sender, subject, templates and attachments.
+The subject and text bodies are text/template templates and the HTML bodies are
+html/template templates, which are executed with the variables of each
+recipient. Paths are relative to the directory of the campaigns file. For
+example:
+
+{
+  "welcome": {
+    "from": "Vanadium Team <welcome@v.io>",
+    "subject": "Vanadium early access activated for {{.name}}",
+    "html": "welcome.html",
+    "text": "welcome.txt",
+    "attachments": ["google-agreement.pdf"]
+  }
+}
+
+The recipients are listed in a CSV file with a header row, or in a JSON file
+holding a list of objects. Both must have an "email" variable, and the other
+columns or fields are variables of the templates.
+
+Campaigns are sent to each recipient at most once, unless the -resend flag is
+set: the emails sent are recorded in the -sent-log file.
 
-Due to legacy reasons(?), the configuration is via environment variables:
+The credentials of the SMTP server are read from the environment variables:
 	EMAIL_USERNAME: Sender email username.
 	EMAIL_PASSWORD: Sender email password.
-	EMAILS:         Space-separated list of recipient email addresses.
-	NDA_BUCKET:     Google-storage bucket that contains the NDA.
 `,
+	ArgsName: "<campaign>",
+	ArgsLong: "<campaign> is the name of the campaign to send.",
 }
 
 func main() {
 	cmdline.Main(cmdMailer)
 }
 
-const mailerTemplateFile = "gs://vanadium-mailer/template.json"
-
-type link struct {
-	Url  string
-	Name string
-}
-
-type message struct {
-	Lines []string
-	Links map[string]link
-}
-
-func (m message) plain() string {
-	result := strings.Join(m.Lines, "\n\n")
-
-	for key, link := range m.Links {
-		result = strings.Replace(result, key, fmt.Sprintf("%v (%v)", link.Name, link.Url), -1)
-	}
-	return result
-}
-
-func (m message) html() string {
-	result := ""
-	for _, line := range m.Lines {
-		result += fmt.Sprintf("<p>\n%v\n</p>\n", line)
+func runMailer(jirix *jiri.X, args []string) error {
+	if len(args) != 1 {
+		return jirix.UsageErrorf("unexpected number of arguments")
 	}
-
-	for key, link := range m.Links {
-		result = strings.Replace(result, key, fmt.Sprintf("<a href=%q>%v</a>", link.Url, link.Name), -1)
+	if campaignsFlag == "" {
+		return jirix.UsageErrorf("the -campaigns flag is not set")
 	}
-	return result
-}
-
-func runMailer(jirix *jiri.X, args []string) error {
-	emailUsername := jirix.Env()["EMAIL_USERNAME"]
-	emailPassword := jirix.Env()["EMAIL_PASSWORD"]
-	emails := strings.Split(jirix.Env()["EMAILS"], " ")
-	bucket := jirix.Env()["NDA_BUCKET"]
-
-	fmt.Fprintf(os.Stderr, "ENV: %v\n", jirix.Env())
-
-	// Download the NDA attachement from Google Cloud Storage
-	attachment, err := cache.StoreGoogleStorageFile(jirix, jirix.Root, bucket, "google-agreement.pdf")
+	name := args[0]
+	campaigns, err := loadCampaigns(jirix, campaignsFlag)
 	if err != nil {
 		return err
 	}
-
-	// Use the Google Apps SMTP relay to send the welcome email, this has been
-	// pre-configured to allow authentiated v.io accounts to send mail.
-	mailer := gomail.NewMailer("smtp-relay.gmail.com", emailUsername, emailPassword, 587)
-	messages := []string{}
-	for _, email := range emails {
-		if strings.TrimSpace(email) == "" {
-			continue
+	c, ok := campaigns[name]
+	if !ok {
+		return fmt.Errorf("campaign %q not found in %v, which defines %v", name, campaignsFlag, strings.Join(names(campaigns), ", "))
+	}
+	var recipients []recipient
+	if recipientsFlag != "" {
+		if recipients, err = loadRecipients(jirix, recipientsFlag); err != nil {
+			return err
 		}
-
-		if err := sendWelcomeEmail(jirix.Context, mailer, email, attachment); err != nil {
-			messages = append(messages, err.Error())
+	} else {
+		for _, email := range strings.Split(jirix.Env()["EMAILS"], " ") {
+			if email = strings.TrimSpace(email); email != "" {
+				recipients = append(recipients, recipient{emailVar: email})
+			}
 		}
 	}
-
-	// Return errors from sending the email messages.
-	if len(messages) > 0 {
-		return errors.New(strings.Join(messages, "\n\n"))
-	}
-	return nil
-}
-
-func sendWelcomeEmail(ctx *tool.Context, mailer *gomail.Mailer, email string, attachment string) error {
-	// Read message data from Google Storage bucket and parse it.
-	var m message
-	var out bytes.Buffer
-	if err := ctx.NewSeq().Capture(&out, nil).Last("gsutil", "-q", "cat", mailerTemplateFile); err != nil {
-		return err
-	}
-	if err := json.Unmarshal(out.Bytes(), &m); err != nil {
-		return fmt.Errorf("Unmarshal() failed: %v", err)
-	}
-
-	message := gomail.NewMessage()
-	message.SetHeader("From", "Vanadium Team <welcome@v.io>")
-	message.SetHeader("To", email)
-	message.SetHeader("Subject", "Vanadium early access activated")
-	message.SetBody("text/plain", m.plain())
-	message.AddAlternative("text/html", m.html())
-
-	file, err := gomail.OpenFile(attachment)
+	delay, err := time.ParseDuration(delayFlag)
 	if err != nil {
-		return fmt.Errorf("OpenFile(%v) failed: %v", attachment, err)
+		return fmt.Errorf("ParseDuration(%v) failed: %v", delayFlag, err)
 	}
 
-	message.Attach(file)
-
-	if err := mailer.Send(message); err != nil {
-		return fmt.Errorf("Send(%v) failed: %v", message, err)
+	m := &mailer{
+		delay:  delay,
+		dryRun: dryRunFlag,
+		resend: resendFlag,
+		log:    &sentLog{path: sentLogFlag},
+		sleep:  time.Sleep,
+		now:    time.Now,
 	}
-
-	return nil
+	if dryRunFlag {
+		dir := outputDirFlag
+		if dir == "" {
+			if dir, err = jirix.NewSeq().TempDir("", "mailer"); err != nil {
+				return err
+			}
+		}
+		fmt.Fprintf(jirix.Stdout(), "Rendering emails to %v\n", dir)
+		m.sender = &dryRunSender{dir: dir}
+		m.delay = 0
+	} else {
+		// Use the Google Apps SMTP relay by default, which has been
+		// pre-configured to allow authentiated v.io accounts to send
+		// mail.
+		m.sender = &smtpSender{
+			server:   smtpServerFlag,
+			username: jirix.Env()["EMAIL_USERNAME"],
+			password: jirix.Env()["EMAIL_PASSWORD"],
+		}
+	}
+	return m.send(jirix, name, c, recipients)
 }
@@ -0,0 +1,277 @@
+// Copyright 2015 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"v.io/jiri/jiritest"
+)
+
+// fakeSMTPMessage is a message received by a fakeSMTPServer.
+type fakeSMTPMessage struct {
+	from string
+	to   []string
+	data string
+}
+
+// fakeSMTPServer is an in-process SMTP server that records the messages
+// it receives. It supports the commands used by net/smtp, without
+// authentication or TLS.
+type fakeSMTPServer struct {
+	listener net.Listener
+	mu       sync.Mutex
+	messages []fakeSMTPMessage
+}
+
+func newFakeSMTPServer(t *testing.T) *fakeSMTPServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	s := &fakeSMTPServer{listener: listener}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeSMTPServer) serve(conn net.Conn) {
+	defer conn.Close()
+	text := textproto.NewConn(conn)
+	text.PrintfLine("220 fake ESMTP")
+	var msg fakeSMTPMessage
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		command := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch command {
+		case "EHLO", "HELO":
+			text.PrintfLine("250 fake")
+		case "MAIL":
+			msg = fakeSMTPMessage{from: strings.Trim(strings.TrimPrefix(line[5:], "FROM:"), "<>")}
+			text.PrintfLine("250 OK")
+		case "RCPT":
+			to := strings.Trim(strings.TrimPrefix(line[5:], "TO:"), "<>")
+			if strings.HasPrefix(to, "bounce") {
+				text.PrintfLine("550 no such user")
+				continue
+			}
+			msg.to = append(msg.to, to)
+			text.PrintfLine("250 OK")
+		case "DATA":
+			text.PrintfLine("354 go ahead")
+			data, err := text.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			msg.data = string(data)
+			s.mu.Lock()
+			s.messages = append(s.messages, msg)
+			s.mu.Unlock()
+			text.PrintfLine("250 OK")
+		case "QUIT":
+			text.PrintfLine("221 bye")
+			return
+		default:
+			text.PrintfLine("502 not implemented")
+		}
+	}
+}
+
+// parts returns the given message and its decoded parts, indexed by
+// their content type, or by the file names of attachments.
+func parts(t *testing.T, data string) (*mail.Message, map[string]string) {
+	msg, err := mail.ReadMessage(strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	result := map[string]string{}
+	var walk func(contentType string, body *bufio.Reader)
+	walk = func(contentType string, body *bufio.Reader) {
+		_, params, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		r := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := r.NextPart()
+			if err != nil {
+				break
+			}
+			partType := part.Header.Get("Content-Type")
+			if strings.HasPrefix(partType, "multipart/") {
+				walk(partType, bufio.NewReader(part))
+				continue
+			}
+			data, err := ioutil.ReadAll(part)
+			if err != nil {
+				t.Fatalf("%v", err)
+			}
+			if part.Header.Get("Content-Transfer-Encoding") == "base64" {
+				if data, err = base64.StdEncoding.DecodeString(strings.Replace(string(data), "\r\n", "", -1)); err != nil {
+					t.Fatalf("%v", err)
+				}
+			}
+			if name := part.FileName(); name != "" {
+				partType = "attachment " + name
+			}
+			result[partType] = strings.TrimSpace(string(data))
+		}
+	}
+	walk(msg.Header.Get("Content-Type"), bufio.NewReader(msg.Body))
+	return msg, result
+}
+
+func TestMailer(t *testing.T) {
+	jirix, cleanup := jiritest.NewX(t)
+	defer cleanup()
+
+	files := map[string]string{
+		"campaigns.json": `{
+  "welcome": {
+    "from": "Vanadium Team <welcome@v.io>",
+    "subject": "Welcome {{.name}}",
+    "html": "welcome.html",
+    "text": "welcome.txt",
+    "attachments": ["nda.pdf"]
+  }
+}`,
+		"welcome.html":   `<p>Hi {{.name}}, see <a href="{{.link}}">the docs</a>.</p>`,
+		"welcome.txt":    `Hi {{.name}}, see {{.link}}.`,
+		"nda.pdf":        "%PDF-1.4 fake",
+		"recipients.csv": "email,name,link\nalice@example.com,Alice,https://v.io\nBob <bob@example.com>,<Bob>,https://v.io/docs\nbounce@example.com,Bounce,https://v.io\n",
+	}
+	for name, contents := range files {
+		if err := ioutil.WriteFile(filepath.Join(jirix.Root, name), []byte(contents), os.FileMode(0644)); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+	campaigns, err := loadCampaigns(jirix, filepath.Join(jirix.Root, "campaigns.json"))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	recipients, err := loadRecipients(jirix, filepath.Join(jirix.Root, "recipients.csv"))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	server := newFakeSMTPServer(t)
+	defer server.listener.Close()
+	sleeps := []time.Duration{}
+	now := time.Date(2015, time.October, 1, 12, 0, 0, 0, time.UTC)
+	m := &mailer{
+		sender: &smtpSender{server: server.listener.Addr().String()},
+		log:    &sentLog{path: filepath.Join(jirix.Root, "log", "sent.log")},
+		delay:  time.Second,
+		sleep:  func(d time.Duration) { sleeps = append(sleeps, d) },
+		now:    func() time.Time { return now },
+	}
+
+	// The email to the unknown recipient fails, the others are sent,
+	// one second apart.
+	if err := m.send(jirix, "welcome", campaigns["welcome"], recipients); err == nil || !strings.Contains(err.Error(), "bounce@example.com") {
+		t.Fatalf("got error %v, want a failure to send to bounce@example.com", err)
+	}
+	if want := []time.Duration{time.Second, time.Second}; !reflect.DeepEqual(sleeps, want) {
+		t.Fatalf("got sleeps %v, want %v", sleeps, want)
+	}
+	if got, want := len(server.messages), 2; got != want {
+		t.Fatalf("got %v messages, want %v", got, want)
+	}
+	sent := server.messages[1]
+	if sent.from != "welcome@v.io" || !reflect.DeepEqual(sent.to, []string{"bob@example.com"}) {
+		t.Fatalf("got message from %v to %v", sent.from, sent.to)
+	}
+	msg, got := parts(t, sent.data)
+	if got, want := msg.Header.Get("To"), "Bob <bob@example.com>"; got != want {
+		t.Fatalf("got To header %q, want %q", got, want)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if want := "Welcome <Bob>"; subject != want {
+		t.Fatalf("got subject %q, want %q", subject, want)
+	}
+	want := map[string]string{
+		"text/plain; charset=utf-8": "Hi <Bob>, see https://v.io/docs.",
+		"text/html; charset=utf-8":  `<p>Hi &lt;Bob&gt;, see <a href="https://v.io/docs">the docs</a>.</p>`,
+		"attachment nda.pdf":        "%PDF-1.4 fake",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got parts %v, want %v", got, want)
+	}
+
+	// Recipients are sent a campaign only once.
+	server.messages = nil
+	if err := m.send(jirix, "welcome", campaigns["welcome"], recipients[:2]); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if got := len(server.messages); got != 0 {
+		t.Fatalf("got %v messages, want none", got)
+	}
+	m.resend = true
+	if err := m.send(jirix, "welcome", campaigns["welcome"], recipients[:1]); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if got := len(server.messages); got != 1 {
+		t.Fatalf("got %v messages, want 1", got)
+	}
+
+	// Dry runs render the emails to disk and do not record them.
+	dir := filepath.Join(jirix.Root, "dry-run")
+	m = &mailer{
+		sender: &dryRunSender{dir: dir},
+		log:    &sentLog{path: filepath.Join(jirix.Root, "log", "sent.log")},
+		dryRun: true,
+		resend: true,
+		now:    func() time.Time { return now },
+	}
+	if err := m.send(jirix, "welcome", campaigns["welcome"], recipients[:2]); err != nil {
+		t.Fatalf("%v", err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "bob@example.com.eml"))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if _, got := parts(t, string(data)); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got parts %v, want %v", got, want)
+	}
+	log, err := m.log.sent(jirix, "welcome")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if want := map[string]bool{"alice@example.com": true, "bob@example.com": true}; !reflect.DeepEqual(log, want) {
+		t.Fatalf("got sent log %v, want %v", log, want)
+	}
+
+	// Templates must only use the variables of the recipients.
+	if _, _, err := campaigns["welcome"].render(recipient{emailVar: "carol@example.com"}, now); err == nil {
+		t.Fatalf("rendered an email without the variables of the recipient")
+	}
+}
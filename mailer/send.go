@@ -0,0 +1,205 @@
+// Copyright 2015 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"v.io/jiri"
+	"v.io/jiri/runutil"
+)
+
+// mailSender sends emails.
+type mailSender interface {
+	// send sends the given MIME message from the given address to the
+	// given addresses.
+	send(jirix *jiri.X, from string, to []string, msg []byte) error
+}
+
+// smtpSender is a mailSender that sends emails through an SMTP server,
+// authenticating with the given credentials if they are set.
+type smtpSender struct {
+	server   string
+	username string
+	password string
+}
+
+func (s *smtpSender) send(jirix *jiri.X, from string, to []string, msg []byte) error {
+	var auth smtp.Auth
+	if s.username != "" {
+		host := s.server
+		if i := strings.LastIndex(host, ":"); i != -1 {
+			host = host[:i]
+		}
+		auth = smtp.PlainAuth("", s.username, s.password, host)
+	}
+	if err := smtp.SendMail(s.server, auth, from, to, msg); err != nil {
+		return fmt.Errorf("SendMail(%v, %v) failed: %v", s.server, to, err)
+	}
+	return nil
+}
+
+// dryRunSender is a mailSender that writes the emails to the
+// "<recipient>.eml" files of a directory instead of sending them.
+type dryRunSender struct {
+	dir string
+}
+
+func (d *dryRunSender) send(jirix *jiri.X, from string, to []string, msg []byte) error {
+	file := filepath.Join(d.dir, strings.Join(to, ",")+".eml")
+	return jirix.NewSeq().
+		MkdirAll(d.dir, os.FileMode(0755)).
+		WriteFile(file, msg, os.FileMode(0644)).Done()
+}
+
+// sentLogEntry records that a campaign was sent to a recipient.
+type sentLogEntry struct {
+	Campaign string
+	Email    string
+	Time     time.Time
+}
+
+// sentLog is a file that records the emails sent, one JSON encoded
+// sentLogEntry per line.
+type sentLog struct {
+	path string
+}
+
+// sent returns the emails the given campaign was sent to.
+func (l *sentLog) sent(jirix *jiri.X, campaign string) (map[string]bool, error) {
+	result := map[string]bool{}
+	file, err := jirix.NewSeq().Open(l.path)
+	if err != nil {
+		if runutil.IsNotExist(err) {
+			return result, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry sentLogEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("Unmarshal(%v) failed: %v", scanner.Text(), err)
+		}
+		if entry.Campaign == campaign {
+			result[entry.Email] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Scan() failed: %v", err)
+	}
+	return result, nil
+}
+
+// record appends the given entry to the log.
+func (l *sentLog) record(jirix *jiri.X, entry sentLogEntry) error {
+	bytes, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("Marshal(%v) failed: %v", entry, err)
+	}
+	if err := jirix.NewSeq().MkdirAll(filepath.Dir(l.path), os.FileMode(0755)).Done(); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, os.FileMode(0644))
+	if err != nil {
+		return fmt.Errorf("OpenFile(%v) failed: %v", l.path, err)
+	}
+	if _, err := file.Write(append(bytes, '\n')); err != nil {
+		file.Close()
+		return fmt.Errorf("Write(%v) failed: %v", l.path, err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("Close(%v) failed: %v", l.path, err)
+	}
+	return nil
+}
+
+// mailer sends the emails of campaigns.
+type mailer struct {
+	sender mailSender
+	log    *sentLog
+	// delay is the minimum delay between two emails.
+	delay time.Duration
+	// dryRun means that the emails sent are not recorded in the log.
+	dryRun bool
+	// resend means that the emails are sent to the recipients the log
+	// records the campaign was already sent to.
+	resend bool
+	// sleep waits for the given duration. It can be mocked in tests.
+	sleep func(time.Duration)
+	// now returns the current time. It can be mocked in tests.
+	now func() time.Time
+}
+
+// send sends the given campaign to the given recipients. Errors are
+// reported for each recipient, and do not stop the emails to the
+// other recipients.
+func (m *mailer) send(jirix *jiri.X, name string, c *compiledCampaign, recipients []recipient) error {
+	sent, err := m.log.sent(jirix, name)
+	if err != nil {
+		return err
+	}
+	from, err := mail.ParseAddress(c.from)
+	if err != nil {
+		return fmt.Errorf("ParseAddress(%v) failed: %v", c.from, err)
+	}
+	messages := []string{}
+	first := true
+	for _, r := range recipients {
+		// The email is sent to, and recorded for, the address of the
+		// recipient only, and its display form is kept for the To
+		// header.
+		addr, err := mail.ParseAddress(r[emailVar])
+		if err != nil {
+			messages = append(messages, fmt.Sprintf("ParseAddress(%v) failed: %v", r[emailVar], err))
+			continue
+		}
+		email := addr.Address
+		if sent[email] && !m.resend {
+			fmt.Fprintf(jirix.Stdout(), "Skipping %v, who was already sent %q\n", email, name)
+			continue
+		}
+		if !first && m.delay > 0 {
+			m.sleep(m.delay)
+		}
+		first = false
+		t := m.now()
+		subject, msg, err := c.render(r, t)
+		if err != nil {
+			messages = append(messages, fmt.Sprintf("%v: %v", email, err))
+			continue
+		}
+		fmt.Fprintf(jirix.Stdout(), "Sending %q to %v\n", subject, email)
+		if err := m.sender.send(jirix, from.Address, []string{email}, msg); err != nil {
+			messages = append(messages, err.Error())
+			continue
+		}
+		if m.dryRun {
+			continue
+		}
+		// Stop on errors recording the emails sent, so that they are
+		// not sent again.
+		if err := m.log.record(jirix, sentLogEntry{Campaign: name, Email: email, Time: t}); err != nil {
+			return err
+		}
+		sent[email] = true
+	}
+
+	// Return errors from sending the email messages.
+	if len(messages) > 0 {
+		return errors.New(strings.Join(messages, "\n\n"))
+	}
+	return nil
+}
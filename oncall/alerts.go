@@ -0,0 +1,727 @@
+// Copyright 2015 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"v.io/jiri"
+	"v.io/jiri/runutil"
+	"v.io/x/devtools/tooldata"
+)
+
+// The kinds of metrics that alert rules apply to.
+const (
+	alertMetricLatency  = "latency"
+	alertMetricQPS      = "qps"
+	alertMetricCounters = "counters"
+	alertMetricMetadata = "metadata"
+)
+
+// The states of alerts.
+const (
+	// alertStatePending means the condition of the rule holds, but has
+	// not held for the duration of the rule yet.
+	alertStatePending = "pending"
+	// alertStateFiring means the condition of the rule has held for the
+	// duration of the rule. The oncall is notified.
+	alertStateFiring = "firing"
+	// alertStateResolved means the condition of the rule of a firing
+	// alert no longer holds.
+	alertStateResolved = "resolved"
+)
+
+// The severities of alert rules.
+const (
+	// Critical alerts are sent to the primary and secondary oncall.
+	alertSeverityCritical = "critical"
+	// Warning alerts are sent to the primary oncall only.
+	alertSeverityWarning = "warning"
+)
+
+const (
+	defaultAlertInterval       = time.Minute
+	defaultAlertRepeatInterval = time.Hour
+	// alertWindow is the period of the metrics collected to evaluate
+	// the alert rules. Only the latest point of each metric is used.
+	alertWindow = 10 * time.Minute
+	// resolvedAlertRetention is how long resolved alerts are kept.
+	resolvedAlertRetention = 24 * time.Hour
+	alertStateFileName     = "alerts.json"
+)
+
+// alertRule is a declarative rule that raises an alert for each pod of
+// a service whose metric crosses a threshold for a given duration.
+type alertRule struct {
+	// Name identifies the rule.
+	Name string `json:"name"`
+	// Metric is the kind of metric the rule applies to: one of
+	// "latency", "qps", "counters" or "metadata".
+	Metric string `json:"metric"`
+	// Service is the metric name the rule applies to, such as
+	// "mounttable". If empty, the rule applies to all metric names of
+	// its kind.
+	Service string `json:"service,omitempty"`
+	// Op is one of ">" or "<", and defaults to ">".
+	Op string `json:"op,omitempty"`
+	// Threshold is the value the metric is compared with.
+	Threshold float64 `json:"threshold"`
+	// Duration is how long the condition must hold before the alert
+	// fires, such as "5m". Defaults to firing right away.
+	Duration string `json:"duration,omitempty"`
+	// Severity is one of "critical" or "warning", and defaults to
+	// "warning".
+	Severity string `json:"severity,omitempty"`
+}
+
+// holds checks whether the condition of the rule holds for the given
+// value of the metric.
+func (r *alertRule) holds(value float64) bool {
+	if r.Op == "<" {
+		return value < r.Threshold
+	}
+	return value > r.Threshold
+}
+
+// results returns the metrics of the given data that the rule applies
+// to, indexed by metric names.
+func (r *alertRule) results(data *getDataResult) map[string]getMetricResults {
+	switch r.Metric {
+	case alertMetricLatency:
+		return data.ServiceLatency
+	case alertMetricQPS:
+		return data.ServiceQPS
+	case alertMetricCounters:
+		return data.ServiceCounters
+	case alertMetricMetadata:
+		return data.ServiceMetadata
+	}
+	return nil
+}
+
+// alertsConfig configures the alert rules, how often they are
+// evaluated, and how the oncall is notified.
+type alertsConfig struct {
+	Rules []alertRule `json:"rules"`
+	// Interval is how often the rules are evaluated, such as "1m".
+	Interval string `json:"interval,omitempty"`
+	// RepeatInterval is how often the oncall is notified again of firing
+	// alerts that are not acknowledged, such as "1h".
+	RepeatInterval string `json:"repeatInterval,omitempty"`
+	// StateFile is the JSON file that keeps the state of the alerts and
+	// the silences across restarts. Defaults to a file in the cache
+	// directory.
+	StateFile string         `json:"stateFile,omitempty"`
+	SMTP      *smtpConfig    `json:"smtp,omitempty"`
+	Webhook   *webhookConfig `json:"webhook,omitempty"`
+}
+
+// alert is the state of a rule for a pod.
+type alert struct {
+	Key        string
+	Rule       string
+	MetricName string
+	Instance   string
+	Severity   string
+	State      string
+	// Value is the latest value of the metric.
+	Value     float64
+	Op        string
+	Threshold float64
+	// Since is when the condition of the rule started to hold.
+	Since      time.Time
+	FiredAt    time.Time
+	ResolvedAt time.Time
+	// NotifiedAt is when the oncall was last notified of the alert.
+	NotifiedAt time.Time
+	AckedBy    string
+	AckedAt    time.Time
+	// Silenced is set if a silence matches the alert.
+	Silenced bool
+}
+
+// summary returns a one-line description of the alert.
+func (a *alert) summary() string {
+	return fmt.Sprintf("[%s] %s (%s): %s on %s is %v (%s %v)", strings.ToUpper(a.State), a.Rule, a.Severity, a.MetricName, a.Instance, a.Value, a.Op, a.Threshold)
+}
+
+// silence mutes the notifications of the alerts of the given rule and
+// instance until the given time. An empty rule or instance matches all
+// rules or instances.
+type silence struct {
+	ID       int
+	Rule     string
+	Instance string
+	Until    time.Time
+	Creator  string
+	Comment  string
+}
+
+func (s *silence) matches(a *alert) bool {
+	return (s.Rule == "" || s.Rule == a.Rule) && (s.Instance == "" || s.Instance == a.Instance)
+}
+
+// alertState is the persistent state of the alert manager.
+type alertState struct {
+	Alerts        map[string]*alert
+	Silences      []*silence
+	NextSilenceID int
+}
+
+type alertsByState []*alert
+
+func (a alertsByState) Len() int      { return len(a) }
+func (a alertsByState) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a alertsByState) Less(i, j int) bool {
+	order := map[string]int{alertStateFiring: 0, alertStatePending: 1, alertStateResolved: 2}
+	if order[a[i].State] != order[a[j].State] {
+		return order[a[i].State] < order[a[j].State]
+	}
+	return a[i].Key < a[j].Key
+}
+
+// alertManager evaluates the alert rules against the collected metrics,
+// keeps the state of the alerts, and notifies the oncall.
+type alertManager struct {
+	mu        sync.Mutex
+	config    alertsConfig
+	durations map[string]time.Duration
+	interval  time.Duration
+	repeat    time.Duration
+	path      string
+	state     alertState
+	notifiers []notifier
+	// oncall returns the oncall shift at the given time. It can be
+	// mocked in tests.
+	oncall func(jirix *jiri.X, t time.Time) (*tooldata.OncallShift, error)
+	now    func() time.Time
+}
+
+// newAlertManager validates the given configuration and returns an
+// alert manager that keeps its state in the configured state file, or
+// in the given directory.
+func newAlertManager(jirix *jiri.X, c alertsConfig, root string) (*alertManager, error) {
+	m := &alertManager{
+		config:    c,
+		durations: map[string]time.Duration{},
+		interval:  defaultAlertInterval,
+		repeat:    defaultAlertRepeatInterval,
+		path:      c.StateFile,
+		state:     alertState{Alerts: map[string]*alert{}, NextSilenceID: 1},
+		oncall:    tooldata.Oncall,
+		now:       time.Now,
+	}
+	if m.path == "" {
+		m.path = filepath.Join(root, alertStateFileName)
+	}
+	// Defaults are filled in a copy of the rules.
+	m.config.Rules = append([]alertRule(nil), c.Rules...)
+	for i := range m.config.Rules {
+		rule := &m.config.Rules[i]
+		if rule.Name == "" {
+			return nil, fmt.Errorf("alert rule %d has no name", i)
+		}
+		if _, ok := m.durations[rule.Name]; ok {
+			return nil, fmt.Errorf("duplicate alert rule %q", rule.Name)
+		}
+		switch rule.Metric {
+		case alertMetricLatency, alertMetricQPS, alertMetricCounters, alertMetricMetadata:
+		default:
+			return nil, fmt.Errorf("alert rule %q has unknown metric %q", rule.Name, rule.Metric)
+		}
+		switch rule.Op {
+		case "":
+			rule.Op = ">"
+		case ">", "<":
+		default:
+			return nil, fmt.Errorf("alert rule %q has unknown op %q", rule.Name, rule.Op)
+		}
+		switch rule.Severity {
+		case "":
+			rule.Severity = alertSeverityWarning
+		case alertSeverityCritical, alertSeverityWarning:
+		default:
+			return nil, fmt.Errorf("alert rule %q has unknown severity %q", rule.Name, rule.Severity)
+		}
+		duration := time.Duration(0)
+		if rule.Duration != "" {
+			d, err := time.ParseDuration(rule.Duration)
+			if err != nil {
+				return nil, fmt.Errorf("ParseDuration(%v) failed: %v", rule.Duration, err)
+			}
+			duration = d
+		}
+		m.durations[rule.Name] = duration
+	}
+	for _, d := range []struct {
+		value string
+		dest  *time.Duration
+	}{{c.Interval, &m.interval}, {c.RepeatInterval, &m.repeat}} {
+		if d.value == "" {
+			continue
+		}
+		duration, err := time.ParseDuration(d.value)
+		if err != nil {
+			return nil, fmt.Errorf("ParseDuration(%v) failed: %v", d.value, err)
+		}
+		*d.dest = duration
+	}
+	if c.SMTP != nil {
+		m.notifiers = append(m.notifiers, newSMTPNotifier(*c.SMTP))
+	}
+	if c.Webhook != nil {
+		m.notifiers = append(m.notifiers, newWebhookNotifier(*c.Webhook))
+	}
+
+	// Load the state of the previous run, if any.
+	bytes, err := jirix.NewSeq().ReadFile(m.path)
+	if err != nil {
+		if !runutil.IsNotExist(err) {
+			return nil, err
+		}
+		return m, nil
+	}
+	if err := json.Unmarshal(bytes, &m.state); err != nil {
+		return nil, fmt.Errorf("Unmarshal(%v) failed: %v", m.path, err)
+	}
+	if m.state.Alerts == nil {
+		m.state.Alerts = map[string]*alert{}
+	}
+	return m, nil
+}
+
+// run evaluates the alert rules periodically. It never returns.
+func (m *alertManager) run(jirix *jiri.X, sources *dataSources) {
+	for {
+		now := m.now()
+		data, err := collectData(jirix, sources, now.Add(-alertWindow), now)
+		if err != nil {
+			fmt.Fprintf(jirix.Stderr(), "%v\n", err)
+		} else if err := m.evaluate(jirix, data); err != nil {
+			fmt.Fprintf(jirix.Stderr(), "%v\n", err)
+		}
+		time.Sleep(m.interval)
+	}
+}
+
+// evaluate evaluates the alert rules against the given data, updates
+// the state of the alerts, and notifies the oncall of the alerts that
+// fire or resolve.
+func (m *alertManager) evaluate(jirix *jiri.X, data *getDataResult) error {
+	m.mu.Lock()
+	now := m.now()
+	notifications := []alert{}
+	seen := map[string]bool{}
+	for _, rule := range m.config.Rules {
+		for metricName, results := range rule.results(data) {
+			if rule.Service != "" && rule.Service != metricName {
+				continue
+			}
+			for _, result := range results {
+				key := fmt.Sprintf("%s/%s/%s", rule.Name, metricName, result.Instance)
+				seen[key] = true
+				a := m.state.Alerts[key]
+				if len(result.HistoryValues) == 0 {
+					// Keep the state of alerts of metrics that could
+					// not be read.
+					continue
+				}
+				if !rule.holds(result.CurrentValue) {
+					if a != nil {
+						m.resolve(key, a, now, &notifications)
+					}
+					continue
+				}
+				if a == nil || a.State == alertStateResolved {
+					a = &alert{
+						Key:        key,
+						Rule:       rule.Name,
+						MetricName: metricName,
+						Instance:   result.Instance,
+						Severity:   rule.Severity,
+						State:      alertStatePending,
+						Op:         rule.Op,
+						Threshold:  rule.Threshold,
+						Since:      now,
+					}
+					m.state.Alerts[key] = a
+				}
+				a.Value = result.CurrentValue
+				if a.State == alertStatePending && now.Sub(a.Since) >= m.durations[rule.Name] {
+					a.State = alertStateFiring
+					a.FiredAt = now
+				}
+				if a.State == alertStateFiring && a.AckedBy == "" && !m.silenced(a, now) && now.Sub(a.NotifiedAt) >= m.repeat {
+					notifications = append(notifications, *a)
+				}
+			}
+		}
+	}
+	// Resolve the alerts of pods that are gone and of rules that were
+	// removed.
+	for key, a := range m.state.Alerts {
+		if !seen[key] && a.State != alertStateResolved {
+			m.resolve(key, a, now, &notifications)
+		}
+	}
+	m.prune(now)
+	err := m.save(jirix)
+	m.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	sent, err := m.notify(jirix, notifications, now)
+	if len(sent) == 0 {
+		return err
+	}
+	// Record when the oncall was notified of the firing alerts only once
+	// the notifications are sent, so that failed ones are sent again.
+	m.mu.Lock()
+	for key := range sent {
+		if a, ok := m.state.Alerts[key]; ok && a.State == alertStateFiring {
+			a.NotifiedAt = now
+		}
+	}
+	saveErr := m.save(jirix)
+	m.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return saveErr
+}
+
+// resolve resolves the given alert. Pending alerts are dropped, and the
+// oncall is notified of firing alerts it was notified of.
+func (m *alertManager) resolve(key string, a *alert, now time.Time, notifications *[]alert) {
+	switch a.State {
+	case alertStatePending:
+		delete(m.state.Alerts, key)
+	case alertStateFiring:
+		a.State = alertStateResolved
+		a.ResolvedAt = now
+		if !a.NotifiedAt.IsZero() && !m.silenced(a, now) {
+			*notifications = append(*notifications, *a)
+		}
+	}
+}
+
+// silenced checks whether a silence matches the given alert at the
+// given time.
+func (m *alertManager) silenced(a *alert, now time.Time) bool {
+	for _, s := range m.state.Silences {
+		if now.Before(s.Until) && s.matches(a) {
+			return true
+		}
+	}
+	return false
+}
+
+// prune drops old resolved alerts and expired silences.
+func (m *alertManager) prune(now time.Time) {
+	for key, a := range m.state.Alerts {
+		if a.State == alertStateResolved && now.Sub(a.ResolvedAt) > resolvedAlertRetention {
+			delete(m.state.Alerts, key)
+		}
+	}
+	silences := []*silence{}
+	for _, s := range m.state.Silences {
+		if now.Before(s.Until) {
+			silences = append(silences, s)
+		}
+	}
+	m.state.Silences = silences
+}
+
+// save writes the state of the alert manager to its state file.
+func (m *alertManager) save(jirix *jiri.X) error {
+	bytes, err := json.MarshalIndent(&m.state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("MarshalIndent() failed: %v", err)
+	}
+	tmpFile := m.path + ".tmp"
+	return jirix.NewSeq().
+		MkdirAll(filepath.Dir(m.path), os.FileMode(0755)).
+		WriteFile(tmpFile, bytes, os.FileMode(0644)).
+		Rename(tmpFile, m.path).Done()
+}
+
+// notify sends the given alerts to the oncall at the given time through
+// all notifiers, and returns the keys of the firing alerts that at least
+// one notifier sent.
+func (m *alertManager) notify(jirix *jiri.X, alerts []alert, now time.Time) (map[string]bool, error) {
+	sent := map[string]bool{}
+	if len(alerts) == 0 || len(m.notifiers) == 0 {
+		return sent, nil
+	}
+	shift, err := m.oncall(jirix, now)
+	if err != nil {
+		return sent, err
+	}
+	if shift == nil {
+		return sent, fmt.Errorf("no oncall at %v", now)
+	}
+	var lastErr error
+	for i := range alerts {
+		recipients := []string{shift.Primary}
+		if alerts[i].Severity == alertSeverityCritical && shift.Secondary != "" && shift.Secondary != shift.Primary {
+			recipients = append(recipients, shift.Secondary)
+		}
+		for _, n := range m.notifiers {
+			if err := n.notify(jirix, &alerts[i], recipients); err != nil {
+				// Try the other notifiers and alerts.
+				fmt.Fprintf(jirix.Stderr(), "%v\n", err)
+				lastErr = err
+				continue
+			}
+			if alerts[i].State == alertStateFiring {
+				sent[alerts[i].Key] = true
+			}
+		}
+	}
+	return sent, lastErr
+}
+
+// list returns the alerts, firing alerts first.
+func (m *alertManager) list() []*alert {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	alerts := []*alert{}
+	for _, a := range m.state.Alerts {
+		c := *a
+		c.Silenced = m.silenced(a, now)
+		alerts = append(alerts, &c)
+	}
+	sort.Sort(alertsByState(alerts))
+	return alerts
+}
+
+// silences returns the active silences.
+func (m *alertManager) silences() []*silence {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	silences := []*silence{}
+	for _, s := range m.state.Silences {
+		if now.Before(s.Until) {
+			c := *s
+			silences = append(silences, &c)
+		}
+	}
+	return silences
+}
+
+// ack acknowledges the given firing alert on behalf of the given user,
+// which stops its repeated notifications until it resolves.
+func (m *alertManager) ack(jirix *jiri.X, key, user string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	a, ok := m.state.Alerts[key]
+	if !ok || a.State != alertStateFiring {
+		return fmt.Errorf("no firing alert %q", key)
+	}
+	a.AckedBy = user
+	a.AckedAt = m.now()
+	return m.save(jirix)
+}
+
+// silence adds a silence created by the given user for the given
+// duration, and returns it.
+func (m *alertManager) silence(jirix *jiri.X, rule, instance string, duration time.Duration, user, comment string) (*silence, error) {
+	if duration <= 0 {
+		return nil, fmt.Errorf("invalid silence duration %v", duration)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := &silence{
+		ID:       m.state.NextSilenceID,
+		Rule:     rule,
+		Instance: instance,
+		Until:    m.now().Add(duration),
+		Creator:  user,
+		Comment:  comment,
+	}
+	m.state.NextSilenceID++
+	m.state.Silences = append(m.state.Silences, s)
+	if err := m.save(jirix); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// unsilence removes the silence with the given ID.
+func (m *alertManager) unsilence(jirix *jiri.X, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, s := range m.state.Silences {
+		if s.ID == id {
+			m.state.Silences = append(m.state.Silences[:i], m.state.Silences[i+1:]...)
+			return m.save(jirix)
+		}
+	}
+	return fmt.Errorf("no silence %d", id)
+}
+
+var alertsPageTemplate = template.Must(template.New("alerts").Funcs(template.FuncMap{
+	"formatTime": func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.Format(time.RFC1123)
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<title>Vanadium Oncall Alerts</title>
+<style>
+body { font-family: sans-serif; font-size: 13px; }
+table { border-collapse: collapse; margin-bottom: 20px; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; }
+tr.firing { background-color: #ffdddd; }
+tr.pending { background-color: #fff3cc; }
+tr.resolved { color: #888; }
+</style>
+</head>
+<body>
+<h2>Alerts</h2>
+<table>
+<tr><th>State</th><th>Rule</th><th>Severity</th><th>Metric</th><th>Instance</th><th>Value</th><th>Since</th><th>Acknowledged</th><th></th></tr>
+{{range .Alerts}}<tr class="{{.State}}">
+<td>{{.State}}{{if .Silenced}} (silenced){{end}}</td>
+<td>{{.Rule}}</td>
+<td>{{.Severity}}</td>
+<td>{{.MetricName}}</td>
+<td>{{.Instance}}</td>
+<td>{{.Value}} ({{.Op}} {{.Threshold}})</td>
+<td>{{formatTime .Since}}</td>
+<td>{{if .AckedBy}}{{.AckedBy}} at {{formatTime .AckedAt}}{{end}}</td>
+<td>
+{{if and (eq .State "firing") (not .AckedBy)}}<form method="post" action="alerts/ack">
+<input type="hidden" name="key" value="{{.Key}}">
+<input type="text" name="by" placeholder="ldap">
+<input type="submit" value="Acknowledge">
+</form>{{end}}
+</td>
+</tr>
+{{else}}<tr><td colspan="9">No alerts.</td></tr>
+{{end}}</table>
+<h2>Silences</h2>
+<table>
+<tr><th>Rule</th><th>Instance</th><th>Until</th><th>Creator</th><th>Comment</th><th></th></tr>
+{{range .Silences}}<tr>
+<td>{{if .Rule}}{{.Rule}}{{else}}*{{end}}</td>
+<td>{{if .Instance}}{{.Instance}}{{else}}*{{end}}</td>
+<td>{{formatTime .Until}}</td>
+<td>{{.Creator}}</td>
+<td>{{.Comment}}</td>
+<td><form method="post" action="alerts/unsilence">
+<input type="hidden" name="id" value="{{.ID}}">
+<input type="submit" value="Remove">
+</form></td>
+</tr>
+{{end}}</table>
+<form method="post" action="alerts/silence">
+<input type="text" name="rule" placeholder="rule (all if empty)">
+<input type="text" name="instance" placeholder="instance (all if empty)">
+<input type="text" name="duration" value="1h">
+<input type="text" name="by" placeholder="ldap">
+<input type="text" name="comment" placeholder="comment">
+<input type="submit" value="Silence">
+</form>
+</body>
+</html>
+`))
+
+func alertsHandler(jirix *jiri.X, alerts *alertManager, w http.ResponseWriter, r *http.Request) {
+	data := struct {
+		Alerts   []*alert
+		Silences []*silence
+	}{
+		Alerts:   alerts.list(),
+		Silences: alerts.silences(),
+	}
+	w.Header().Set("Content-Type", "text/html")
+	if err := alertsPageTemplate.Execute(w, data); err != nil {
+		respondWithError(jirix, fmt.Errorf("Execute() failed: %v", err), w)
+	}
+}
+
+func ackHandler(jirix *jiri.X, alerts *alertManager, w http.ResponseWriter, r *http.Request) {
+	if !checkPost(w, r) {
+		return
+	}
+	f, err := parseForm(r, "key", "by")
+	if err != nil {
+		respondWithError(jirix, err, w)
+		return
+	}
+	if err := alerts.ack(jirix, f["key"], f["by"]); err != nil {
+		respondWithError(jirix, err, w)
+		return
+	}
+	http.Redirect(w, r, "../alerts", http.StatusSeeOther)
+}
+
+func silenceHandler(jirix *jiri.X, alerts *alertManager, w http.ResponseWriter, r *http.Request) {
+	if !checkPost(w, r) {
+		return
+	}
+	f, err := parseForm(r, "duration", "by")
+	if err != nil {
+		respondWithError(jirix, err, w)
+		return
+	}
+	duration, err := time.ParseDuration(f["duration"])
+	if err != nil {
+		respondWithError(jirix, fmt.Errorf("ParseDuration(%v) failed: %v", f["duration"], err), w)
+		return
+	}
+	if _, err := alerts.silence(jirix, r.Form.Get("rule"), r.Form.Get("instance"), duration, f["by"], r.Form.Get("comment")); err != nil {
+		respondWithError(jirix, err, w)
+		return
+	}
+	http.Redirect(w, r, "../alerts", http.StatusSeeOther)
+}
+
+func unsilenceHandler(jirix *jiri.X, alerts *alertManager, w http.ResponseWriter, r *http.Request) {
+	if !checkPost(w, r) {
+		return
+	}
+	f, err := parseForm(r, "id")
+	if err != nil {
+		respondWithError(jirix, err, w)
+		return
+	}
+	id, err := strconv.Atoi(f["id"])
+	if err != nil {
+		respondWithError(jirix, fmt.Errorf("Atoi(%v) failed: %v", f["id"], err), w)
+		return
+	}
+	if err := alerts.unsilence(jirix, id); err != nil {
+		respondWithError(jirix, err, w)
+		return
+	}
+	http.Redirect(w, r, "../alerts", http.StatusSeeOther)
+}
+
+// checkPost checks that the given request is a POST request, and
+// responds with an error otherwise.
+func checkPost(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != "POST" {
+		http.Error(w, "405 method not allowed", http.StatusMethodNotAllowed)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,257 @@
+// Copyright 2015 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"v.io/jiri"
+	"v.io/jiri/jiritest"
+	"v.io/x/devtools/tooldata"
+)
+
+// fakeNotifier records the notifications it is asked to send, and fails
+// to send them if err is set.
+type fakeNotifier struct {
+	sent []string
+	err  error
+}
+
+func (f *fakeNotifier) notify(jirix *jiri.X, a *alert, recipients []string) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.sent = append(f.sent, fmt.Sprintf("%s %s %s", a.State, a.Key, strings.Join(recipients, ",")))
+	return nil
+}
+
+// alertTestData returns the data of a mounttable pod with the given
+// latency and QPS. Negative values mean no data.
+func alertTestData(latency, qps float64) *getDataResult {
+	result := func(value float64) getMetricResults {
+		r := getMetricResult{Instance: "mt-1", CurrentValue: -1}
+		if value >= 0 {
+			r.CurrentValue = value
+			r.HistoryValues = []float64{value}
+		}
+		return getMetricResults{r}
+	}
+	return &getDataResult{
+		ServiceLatency: map[string]getMetricResults{"mounttable": result(latency)},
+		ServiceQPS:     map[string]getMetricResults{"mounttable": result(qps)},
+	}
+}
+
+func TestAlerts(t *testing.T) {
+	jirix, cleanup := jiritest.NewX(t)
+	defer cleanup()
+
+	c := alertsConfig{
+		Rules: []alertRule{
+			{Name: "high-latency", Metric: "latency", Service: "mounttable", Threshold: 100, Duration: "5m", Severity: "critical"},
+			{Name: "low-qps", Metric: "qps", Op: "<", Threshold: 1},
+		},
+	}
+	start := time.Date(2015, time.October, 1, 12, 0, 0, 0, time.UTC)
+	now := start
+	fake := &fakeNotifier{}
+	newManager := func() *alertManager {
+		m, err := newAlertManager(jirix, c, jirix.Root)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		m.now = func() time.Time { return now }
+		m.oncall = func(*jiri.X, time.Time) (*tooldata.OncallShift, error) {
+			return &tooldata.OncallShift{Primary: "alice", Secondary: "bob"}, nil
+		}
+		m.notifiers = []notifier{fake}
+		return m
+	}
+	m := newManager()
+	states := func() map[string]string {
+		result := map[string]string{}
+		for _, a := range m.list() {
+			result[a.Key] = a.State
+		}
+		return result
+	}
+	latencyKey, qpsKey := "high-latency/mounttable/mt-1", "low-qps/mounttable/mt-1"
+
+	steps := []struct {
+		offset       time.Duration
+		data         *getDataResult
+		action       func() error
+		wantStates   map[string]string
+		wantNotified []string
+	}{
+		// The QPS alert fires right away and is sent to the primary
+		// only, the latency alert is pending.
+		{
+			offset:       0,
+			data:         alertTestData(150, 0.5),
+			wantStates:   map[string]string{latencyKey: "pending", qpsKey: "firing"},
+			wantNotified: []string{"firing " + qpsKey + " alice"},
+		},
+		// The critical latency alert fires after 5 minutes and is sent
+		// to both oncalls.
+		{
+			offset:       5 * time.Minute,
+			data:         alertTestData(150, 0.5),
+			wantStates:   map[string]string{latencyKey: "firing", qpsKey: "firing"},
+			wantNotified: []string{"firing " + latencyKey + " alice,bob"},
+		},
+		// Missing data keeps the alerts as they are.
+		{
+			offset:     10 * time.Minute,
+			data:       alertTestData(-1, -1),
+			wantStates: map[string]string{latencyKey: "firing", qpsKey: "firing"},
+		},
+		// Unacknowledged alerts are sent again after the repeat
+		// interval, unless they are silenced.
+		{
+			offset: 70 * time.Minute,
+			data:   alertTestData(150, 0.5),
+			action: func() error {
+				_, err := m.silence(jirix, "low-qps", "", 2*time.Hour, "alice", "known issue")
+				return err
+			},
+			wantStates:   map[string]string{latencyKey: "firing", qpsKey: "firing"},
+			wantNotified: []string{"firing " + latencyKey + " alice,bob"},
+		},
+		// Acknowledged alerts are not sent again.
+		{
+			offset: 140 * time.Minute,
+			data:   alertTestData(150, 0.5),
+			action: func() error {
+				return m.ack(jirix, latencyKey, "alice")
+			},
+			wantStates: map[string]string{latencyKey: "firing", qpsKey: "firing"},
+		},
+		// The resolution of the latency alert is sent, the one of the
+		// silenced QPS alert is not.
+		{
+			offset:       150 * time.Minute,
+			data:         alertTestData(50, 2),
+			wantStates:   map[string]string{latencyKey: "resolved", qpsKey: "resolved"},
+			wantNotified: []string{"resolved " + latencyKey + " alice,bob"},
+		},
+		// A new alert starts as pending, and resolved alerts are dropped
+		// after a day.
+		{
+			offset:     27 * time.Hour,
+			data:       alertTestData(150, 2),
+			wantStates: map[string]string{latencyKey: "pending"},
+		},
+		// Pending alerts are dropped when their condition no longer holds.
+		{
+			offset:     27*time.Hour + time.Minute,
+			data:       alertTestData(50, 2),
+			wantStates: map[string]string{},
+		},
+	}
+	for i, step := range steps {
+		now = start.Add(step.offset)
+		if step.action != nil {
+			if err := step.action(); err != nil {
+				t.Fatalf("step %d: %v", i, err)
+			}
+		}
+		fake.sent = nil
+		if err := m.evaluate(jirix, step.data); err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+		if got, want := states(), step.wantStates; !reflect.DeepEqual(got, want) {
+			t.Fatalf("step %d: got states %v, want %v", i, got, want)
+		}
+		if got, want := fake.sent, step.wantNotified; !reflect.DeepEqual(got, want) {
+			t.Fatalf("step %d: got notifications %v, want %v", i, got, want)
+		}
+		// The state is kept across restarts.
+		m = newManager()
+		if got, want := states(), step.wantStates; !reflect.DeepEqual(got, want) {
+			t.Fatalf("step %d: got states %v after reloading, want %v", i, got, want)
+		}
+	}
+
+	// Acknowledging an alert that is not firing fails.
+	if err := m.ack(jirix, latencyKey, "alice"); err == nil {
+		t.Fatalf("acknowledged a pending alert")
+	}
+	// Expired silences are dropped.
+	if got := m.silences(); len(got) != 0 {
+		t.Fatalf("got silences %v, want none", got)
+	}
+}
+
+func TestAlertsNotifyFailure(t *testing.T) {
+	jirix, cleanup := jiritest.NewX(t)
+	defer cleanup()
+
+	c := alertsConfig{Rules: []alertRule{{Name: "low-qps", Metric: "qps", Op: "<", Threshold: 1}}}
+	m, err := newAlertManager(jirix, c, jirix.Root)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	now := time.Date(2015, time.October, 1, 12, 0, 0, 0, time.UTC)
+	m.now = func() time.Time { return now }
+	m.oncall = func(*jiri.X, time.Time) (*tooldata.OncallShift, error) {
+		return &tooldata.OncallShift{Primary: "alice"}, nil
+	}
+	fake := &fakeNotifier{err: fmt.Errorf("SendMail() failed")}
+	m.notifiers = []notifier{fake}
+
+	// An alert whose notification failed is sent again at the next
+	// evaluation, before the repeat interval.
+	if err := m.evaluate(jirix, alertTestData(-1, 0.5)); err == nil {
+		t.Fatalf("evaluate() did not fail")
+	}
+	fake.err = nil
+	now = now.Add(time.Minute)
+	if err := m.evaluate(jirix, alertTestData(-1, 0.5)); err != nil {
+		t.Fatalf("%v", err)
+	}
+	key := "low-qps/mounttable/mt-1"
+	if got, want := fake.sent, []string{"firing " + key + " alice"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got notifications %v, want %v", got, want)
+	}
+	if got, want := m.state.Alerts[key].NotifiedAt, now; !got.Equal(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	// Once sent, it is not sent again until the repeat interval.
+	fake.sent = nil
+	now = now.Add(time.Minute)
+	if err := m.evaluate(jirix, alertTestData(-1, 0.5)); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if len(fake.sent) != 0 {
+		t.Fatalf("got notifications %v, want none", fake.sent)
+	}
+}
+
+func TestAlertsConfig(t *testing.T) {
+	jirix, cleanup := jiritest.NewX(t)
+	defer cleanup()
+
+	invalid := []alertRule{
+		{Metric: "latency"},
+		{Name: "a", Metric: "cpu"},
+		{Name: "a", Metric: "qps", Op: ">="},
+		{Name: "a", Metric: "qps", Severity: "page"},
+		{Name: "a", Metric: "qps", Duration: "5 minutes"},
+	}
+	for _, rule := range invalid {
+		if _, err := newAlertManager(jirix, alertsConfig{Rules: []alertRule{rule}}, jirix.Root); err == nil {
+			t.Errorf("rule %#v is valid", rule)
+		}
+	}
+	rules := []alertRule{{Name: "a", Metric: "qps"}, {Name: "a", Metric: "latency"}}
+	if _, err := newAlertManager(jirix, alertsConfig{Rules: rules}, jirix.Root); err == nil {
+		t.Errorf("rules with the same name are valid")
+	}
+}
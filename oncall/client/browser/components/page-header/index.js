@@ -11,6 +11,8 @@
  * - At the center, we show the current view level and navigation links to go
  *   back to higher levels.
  * - At the right side, we show the pictures of the current oncalls.
+ * - Below the time, we show a link to the alerts page when there are firing
+ *   alerts that are not acknowledged or silenced.
  */
 
 var hg = require('mercury');
@@ -32,6 +34,9 @@ function create(data) {
     // IDs of current oncalls.
     oncallIds: hg.array(data.oncallIds),
 
+    // The number of firing alerts that need attention.
+    numFiringAlerts: hg.value(data.numFiringAlerts),
+
     // Whether the data is being loaded.
     loadingData: hg.value(data.loadingData),
 
@@ -84,15 +89,23 @@ function render(state) {
     timeClass += '.failure';
   }
 
+  var titleAndTime = [
+    h('div.title', 'Vanadium Oncall Dashboard'),
+    h('div' + timeClass, strTime)
+  ];
+  if (state.numFiringAlerts > 0) {
+    titleAndTime.push(h('a.alerts', {
+      'href': 'alerts'
+    }, state.numFiringAlerts + ' FIRING ALERT' +
+        (state.numFiringAlerts > 1 ? 'S' : '')));
+  }
+
   return h('div.header', [
       h('div' + infoClass, [
         h('div.dashboard-title', [
           h('div#logo', ''),
         ]),
-        h('div.title-and-time', [
-          h('div.title', 'Vanadium Oncall Dashboard'),
-          h('div' + timeClass, strTime)
-        ]),
+        h('div.title-and-time', titleAndTime),
         h('div.pics', pics)
       ])
   ]);
@@ -34,6 +34,7 @@ var state = hg.state({
     startTimestamp: -1,
     endTimestamp: -1,
     oncallIds: ['_unknown', '_unknown'],
+    numFiringAlerts: 0,
     loadingData: false,
     hasLoadingFailure: false
   }),
@@ -162,6 +163,10 @@ function updateComponents() {
   // Update page header.
   state.pageHeader.endTimestamp.set(curData.MaxTime);
   state.pageHeader.oncallIds.set(curData.Oncalls);
+  var numFiringAlerts = (curData.Alerts || []).filter(function(alert) {
+    return alert.State === 'firing' && !alert.AckedBy && !alert.Silenced;
+  }).length;
+  state.pageHeader.numFiringAlerts.set(numFiringAlerts);
 
   // Update status table.
   var statusTableData = statusTableComponent({
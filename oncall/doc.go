@@ -31,6 +31,34 @@ Oncall serve - Serve oncall dashboard data from Google Storage
 
 Serve oncall dashboard data from Google Storage.
 
+The metrics of the services are read from Google Cloud Monitoring, from a
+Prometheus-compatible query API, or from a static JSON file, and their pods and
+nodes are read from Kubernetes clusters through kubectl, or from a static JSON
+file. The -config flag points to a JSON file that chooses these sources, and the
+services and metrics to show. For example:
+
+{
+  "metrics": {"type": "prometheus", "url": "http://prometheus:9090", "step": "1m"},
+  "clusters": {"type": "kubectl", "locations": [{"project": "vanadium-staging", "zone": "us-central1-c"}]},
+  "descriptors": {"latency": "service-latency", "qps": "service-qps-total"},
+  "services": [{"name": "mounttable", "podLabel": "mounttable"}]
+}
+
+The "alerts" section of the configuration declares alert rules that are
+evaluated every minute against the latest collected metrics. An alert is pending
+while the condition of its rule holds, fires once it has held for the duration
+of the rule, and resolves when it no longer holds. The current primary oncall is
+notified of firing and resolved alerts by email and/or through a webhook, and so
+is the secondary oncall for critical alerts. Firing alerts are sent again every
+hour until they are acknowledged. Alerts can be acknowledged and silenced from
+the /alerts page. For example:
+
+"alerts": {
+  "rules": [{"name": "mounttable-latency", "metric": "latency", "service": "mounttable", "op": ">", "threshold": 2000, "duration": "5m", "severity": "critical"}],
+  "smtp": {"server": "localhost:25", "from": "oncall@example.com", "domain": "example.com"},
+  "webhook": {"url": "http://chat.example.com/hooks/oncall"}
+}
+
 Usage:
    oncall serve [flags]
 
@@ -39,6 +67,10 @@ The oncall serve flags are:
    Listening address for the server.
  -cache=
    Directory to use for caching files.
+ -config=
+   The path to the JSON file that configures the sources of the dashboard data
+   and the services and metrics to show. Defaults to the Vanadium production
+   services.
  -key=
    The path to the service account's JSON credentials file.
  -static=
@@ -0,0 +1,102 @@
+// Copyright 2015 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	cloudmonitoring "google.golang.org/api/monitoring/v3"
+
+	"v.io/jiri"
+	"v.io/x/lib/gcm"
+)
+
+// gcmSource is a metricSource that reads the metrics of a Google Cloud
+// Monitoring project.
+type gcmSource struct {
+	project string
+	service *cloudmonitoring.Service
+
+	mu          sync.Mutex
+	descriptors map[string]*cloudmonitoring.MetricDescriptor
+}
+
+func newGCMSource(project, keyFile string) (*gcmSource, error) {
+	s, err := gcm.Authenticate(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &gcmSource{
+		project:     project,
+		service:     s,
+		descriptors: map[string]*cloudmonitoring.MetricDescriptor{},
+	}, nil
+}
+
+// descriptor returns the metric descriptor with the given name.
+func (g *gcmSource) descriptor(name string) (*cloudmonitoring.MetricDescriptor, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if md, ok := g.descriptors[name]; ok {
+		return md, nil
+	}
+	md, err := gcm.GetMetric(name, g.project)
+	if err != nil {
+		return nil, err
+	}
+	g.descriptors[name] = md
+	return md, nil
+}
+
+func (g *gcmSource) timeSeries(jirix *jiri.X, query metricQuery, start, end time.Time) ([]point, error) {
+	md, err := g.descriptor(query.descriptor)
+	if err != nil {
+		return nil, err
+	}
+	filters := []string{
+		fmt.Sprintf("metric.type=%q", md.Type),
+		fmt.Sprintf("metric.label.metric_name=%q", query.metricName),
+		fmt.Sprintf("metric.label.gce_instance=%q", query.pod.Metadata.Name),
+		fmt.Sprintf("metric.label.gce_zone=%q", query.pod.zone),
+	}
+	for labelKey, labelValue := range query.labels {
+		filters = append(filters, fmt.Sprintf("metric.label.%s=%q", labelKey, labelValue))
+	}
+	nextPageToken := ""
+	points := []*cloudmonitoring.Point{}
+	for {
+		resp, err := g.service.Projects.TimeSeries.List(fmt.Sprintf("projects/%s", g.project)).
+			IntervalStartTime(start.UTC().Format(time.RFC3339)).
+			IntervalEndTime(end.UTC().Format(time.RFC3339)).
+			Filter(strings.Join(filters, " AND ")).
+			PageToken(nextPageToken).Do()
+		if err != nil {
+			return nil, fmt.Errorf("List() failed: %v", err)
+		}
+		if len(resp.TimeSeries) > 0 {
+			// We should only get one timeseries.
+			ts := resp.TimeSeries[0]
+			points = append(points, ts.Points...)
+		}
+		nextPageToken = resp.NextPageToken
+		if nextPageToken == "" {
+			break
+		}
+	}
+	// GCM returns the most recent points first.
+	result := []point{}
+	for i := len(points) - 1; i >= 0; i-- {
+		pt := points[i]
+		epochTime, err := time.Parse(time.RFC3339, pt.Interval.EndTime)
+		if err != nil {
+			return nil, fmt.Errorf("Parse(%s) failed: %v", pt.Interval.EndTime, err)
+		}
+		result = append(result, point{Time: epochTime.Unix(), Value: pt.Value.DoubleValue})
+	}
+	return result, nil
+}
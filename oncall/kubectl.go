@@ -0,0 +1,172 @@
+// Copyright 2015 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"v.io/jiri"
+	"v.io/jiri/tool"
+)
+
+const (
+	getKubeDataTaskTypePod  = "getKubeDataTaskTypePod"
+	getKubeDataTaskTypeNode = "getKubeDataTaskTypeNode"
+)
+
+// kubectlSource is a clusterSource that reads the pods and nodes of
+// Kubernetes clusters of Google Container Engine through kubectl.
+type kubectlSource struct {
+	locations []clusterLocation
+}
+
+// Task and result for getKubeDataWorker.
+type getKubeDataTask struct {
+	location clusterLocation
+	taskType string
+}
+type getKubeDataResult struct {
+	pods     []*podSpec
+	nodes    []*nodeSpec
+	taskType string
+	err      error
+}
+
+func (k *kubectlSource) pods(jirix *jiri.X) ([]*podSpec, error) {
+	results := k.getKubeData(jirix, getKubeDataTaskTypePod)
+	pods := []*podSpec{}
+	for _, result := range results {
+		pods = append(pods, result.pods...)
+	}
+	return pods, nil
+}
+
+func (k *kubectlSource) nodes(jirix *jiri.X) ([]*nodeSpec, error) {
+	results := k.getKubeData(jirix, getKubeDataTaskTypeNode)
+	nodes := []*nodeSpec{}
+	for _, result := range results {
+		nodes = append(nodes, result.nodes...)
+	}
+	return nodes, nil
+}
+
+func (k *kubectlSource) logs(jirix *jiri.X, location clusterLocation, pod, container string) ([]byte, error) {
+	return runKubeCtl(jirix, location, []string{"logs", pod, container})
+}
+
+func (k *kubectlSource) podConfig(jirix *jiri.X, location clusterLocation, pod string) ([]byte, error) {
+	return runKubeCtl(jirix, location, []string{"get", "pods", pod, "-o=json"})
+}
+
+// getKubeData gets the data of the given type from all clusters in
+// parallel. Clusters whose data cannot be read are skipped.
+func (k *kubectlSource) getKubeData(jirix *jiri.X, taskType string) []getKubeDataResult {
+	numTasks := len(k.locations)
+	tasks := make(chan getKubeDataTask, numTasks)
+	taskResults := make(chan getKubeDataResult, numTasks)
+	for i := 0; i < numTasks; i++ {
+		go getKubeDataWorker(jirix, tasks, taskResults)
+	}
+	for _, loc := range k.locations {
+		tasks <- getKubeDataTask{
+			location: loc,
+			taskType: taskType,
+		}
+	}
+	close(tasks)
+
+	results := []getKubeDataResult{}
+	for i := 0; i < numTasks; i++ {
+		result := <-taskResults
+		if result.err != nil {
+			// Show the data of the other clusters.
+			fmt.Fprintf(jirix.Stderr(), "%v\n", result.err)
+			continue
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+func getKubeDataWorker(jirix *jiri.X, tasks <-chan getKubeDataTask, results chan<- getKubeDataResult) {
+	for task := range tasks {
+		kubeCtlArgs := []string{"get", "pods", "-o=json"}
+		if task.taskType == getKubeDataTaskTypeNode {
+			kubeCtlArgs = []string{"get", "nodes", "-o=json"}
+		}
+		var podItems struct {
+			Items []*podSpec `json:"items"`
+		}
+		var nodeItems struct {
+			Items []*nodeSpec `json:"items"`
+		}
+		out, err := runKubeCtl(jirix, task.location, kubeCtlArgs)
+		if err != nil {
+			results <- getKubeDataResult{
+				err: err,
+			}
+			continue
+		}
+		switch task.taskType {
+		case getKubeDataTaskTypePod:
+			if err := json.Unmarshal(out, &podItems); err != nil {
+				results <- getKubeDataResult{
+					err: fmt.Errorf("Unmarshal() failed: %v", err),
+				}
+				continue
+			}
+			for _, item := range podItems.Items {
+				item.zone = task.location.Zone
+				item.project = task.location.Project
+			}
+			results <- getKubeDataResult{
+				taskType: getKubeDataTaskTypePod,
+				pods:     podItems.Items,
+			}
+		case getKubeDataTaskTypeNode:
+			if err := json.Unmarshal(out, &nodeItems); err != nil {
+				results <- getKubeDataResult{
+					err: fmt.Errorf("Unmarshal() failed: %v", err),
+				}
+				continue
+			}
+			results <- getKubeDataResult{
+				taskType: getKubeDataTaskTypeNode,
+				nodes:    nodeItems.Items,
+			}
+		}
+	}
+}
+
+func runKubeCtl(jirix *jiri.X, location clusterLocation, kubeCtlArgs []string) ([]byte, error) {
+	f, err := ioutil.TempFile("", "")
+	if err != nil {
+		return nil, nil
+	}
+	defer jirix.NewSeq().RemoveAll(f.Name())
+	s := tool.NewContext(tool.ContextOpts{
+		Env: map[string]string{
+			"KUBECONFIG": f.Name(),
+		},
+	}).NewSeq()
+	var out bytes.Buffer
+	getCredsArgs := []string{
+		"container",
+		"clusters",
+		"get-credentials",
+		"vanadium",
+		"--project",
+		location.Project,
+		"--zone",
+		location.Zone,
+	}
+	if err := s.Run("gcloud", getCredsArgs...).Capture(&out, &out).Last("kubectl", kubeCtlArgs...); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
@@ -0,0 +1,123 @@
+// Copyright 2015 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"v.io/jiri"
+)
+
+const webhookRequestTimeout = 30 * time.Second
+
+// notifier notifies the oncall of alerts.
+type notifier interface {
+	// notify sends the given alert to the given recipients, which are
+	// the ldaps of the oncall.
+	notify(jirix *jiri.X, a *alert, recipients []string) error
+}
+
+// smtpConfig configures the notifications sent by email.
+type smtpConfig struct {
+	// Server is the address of the SMTP server, such as
+	// "localhost:25".
+	Server string `json:"server"`
+	// From is the sender of the emails.
+	From string `json:"from"`
+	// Domain is appended to the ldaps of the oncall to get their email
+	// addresses.
+	Domain string `json:"domain"`
+}
+
+// smtpNotifier is a notifier that sends emails through an SMTP server.
+type smtpNotifier struct {
+	config smtpConfig
+	// sendMail sends an email. It can be mocked in tests.
+	sendMail func(addr string, a smtp.Auth, from string, to []string, msg []byte) error
+}
+
+func newSMTPNotifier(c smtpConfig) *smtpNotifier {
+	return &smtpNotifier{config: c, sendMail: smtp.SendMail}
+}
+
+func (s *smtpNotifier) notify(jirix *jiri.X, a *alert, recipients []string) error {
+	to := []string{}
+	for _, r := range recipients {
+		if !strings.Contains(r, "@") {
+			r = r + "@" + s.config.Domain
+		}
+		to = append(to, r)
+	}
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", s.config.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", a.summary())
+	fmt.Fprintf(&msg, "\r\n")
+	fmt.Fprintf(&msg, "Rule: %s\r\n", a.Rule)
+	fmt.Fprintf(&msg, "Severity: %s\r\n", a.Severity)
+	fmt.Fprintf(&msg, "Metric: %s\r\n", a.MetricName)
+	fmt.Fprintf(&msg, "Instance: %s\r\n", a.Instance)
+	fmt.Fprintf(&msg, "Value: %v (%s %v)\r\n", a.Value, a.Op, a.Threshold)
+	fmt.Fprintf(&msg, "Since: %s\r\n", a.Since.Format(time.RFC1123))
+	if a.State == alertStateResolved {
+		fmt.Fprintf(&msg, "Resolved: %s\r\n", a.ResolvedAt.Format(time.RFC1123))
+	}
+	if err := s.sendMail(s.config.Server, nil, s.config.From, to, msg.Bytes()); err != nil {
+		return fmt.Errorf("SendMail(%v) failed: %v", s.config.Server, err)
+	}
+	return nil
+}
+
+// webhookConfig configures the notifications posted to a webhook.
+type webhookConfig struct {
+	URL string `json:"url"`
+}
+
+// webhookNotifier is a notifier that posts the alerts as JSON objects
+// to a URL.
+type webhookNotifier struct {
+	url    string
+	client *http.Client
+}
+
+// webhookMessage is the JSON object posted by a webhookNotifier.
+type webhookMessage struct {
+	Summary    string
+	Alert      *alert
+	Recipients []string
+}
+
+func newWebhookNotifier(c webhookConfig) *webhookNotifier {
+	return &webhookNotifier{
+		url:    c.URL,
+		client: &http.Client{Timeout: webhookRequestTimeout},
+	}
+}
+
+func (w *webhookNotifier) notify(jirix *jiri.X, a *alert, recipients []string) error {
+	body, err := json.Marshal(webhookMessage{
+		Summary:    a.summary(),
+		Alert:      a,
+		Recipients: recipients,
+	})
+	if err != nil {
+		return fmt.Errorf("Marshal() failed: %v", err)
+	}
+	resp, err := w.client.Post(w.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("Post(%v) failed: %v", w.url, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("Post(%v) failed: %v", w.url, resp.Status)
+	}
+	return nil
+}
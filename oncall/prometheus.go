@@ -0,0 +1,134 @@
+// Copyright 2015 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"v.io/jiri"
+)
+
+const (
+	defaultPrometheusStep    = time.Minute
+	prometheusRequestTimeout = 30 * time.Second
+)
+
+// prometheusSource is a metricSource that reads metrics through the
+// range query API of Prometheus, or of a server compatible with it.
+//
+// A metric is read from the time series named after its descriptor,
+// with dashes replaced by underscores, and labeled with the same labels
+// as in GCM: metric_name, gce_instance and gce_zone.
+type prometheusSource struct {
+	url    string
+	step   time.Duration
+	client *http.Client
+}
+
+func newPrometheusSource(baseURL, step string) (*prometheusSource, error) {
+	if baseURL == "" {
+		return nil, fmt.Errorf("the URL of the Prometheus source is not set")
+	}
+	p := &prometheusSource{
+		url:    strings.TrimSuffix(baseURL, "/"),
+		step:   defaultPrometheusStep,
+		client: &http.Client{Timeout: prometheusRequestTimeout},
+	}
+	if step != "" {
+		d, err := time.ParseDuration(step)
+		if err != nil {
+			return nil, fmt.Errorf("ParseDuration(%v) failed: %v", step, err)
+		}
+		p.step = d
+	}
+	return p, nil
+}
+
+// prometheusQuery returns the Prometheus expression that selects the
+// time series identified by the given query.
+func prometheusQuery(query metricQuery) string {
+	labels := map[string]string{
+		"metric_name":  query.metricName,
+		"gce_instance": query.pod.Metadata.Name,
+		"gce_zone":     query.pod.zone,
+	}
+	for key, value := range query.labels {
+		labels[key] = value
+	}
+	keys := []string{}
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	matchers := []string{}
+	for _, key := range keys {
+		matchers = append(matchers, fmt.Sprintf("%s=%s", key, strconv.Quote(labels[key])))
+	}
+	name := strings.Replace(query.descriptor, "-", "_", -1)
+	return fmt.Sprintf("%s{%s}", name, strings.Join(matchers, ","))
+}
+
+func (p *prometheusSource) timeSeries(jirix *jiri.X, query metricQuery, start, end time.Time) ([]point, error) {
+	params := url.Values{}
+	params.Set("query", prometheusQuery(query))
+	params.Set("start", strconv.FormatInt(start.Unix(), 10))
+	params.Set("end", strconv.FormatInt(end.Unix(), 10))
+	params.Set("step", strconv.FormatFloat(p.step.Seconds(), 'f', -1, 64))
+	queryURL := p.url + "/api/v1/query_range?" + params.Encode()
+	resp, err := p.client.Get(queryURL)
+	if err != nil {
+		return nil, fmt.Errorf("Get(%v) failed: %v", queryURL, err)
+	}
+	defer resp.Body.Close()
+	bytes, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("ReadAll() failed: %v", err)
+	}
+	var response struct {
+		Status string `json:"status"`
+		Error  string `json:"error"`
+		Data   struct {
+			Result []struct {
+				Values [][2]interface{} `json:"values"`
+			} `json:"result"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(bytes, &response); err != nil {
+		return nil, fmt.Errorf("Unmarshal(%v) failed: %v", string(bytes), err)
+	}
+	if response.Status != "success" {
+		return nil, fmt.Errorf("query %v failed: %v", queryURL, response.Error)
+	}
+	points := []point{}
+	if len(response.Data.Result) == 0 {
+		return points, nil
+	}
+	// We should only get one timeseries. Its values are pairs of a
+	// timestamp and a string-encoded value.
+	for _, value := range response.Data.Result[0].Values {
+		t, ok := value[0].(float64)
+		if !ok {
+			return nil, fmt.Errorf("invalid timestamp %v", value[0])
+		}
+		s, ok := value[1].(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid value %v", value[1])
+		}
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, fmt.Errorf("ParseFloat(%v) failed: %v", s, err)
+		}
+		points = append(points, point{Time: int64(t), Value: v})
+	}
+	return points, nil
+}
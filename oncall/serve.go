@@ -17,15 +17,10 @@ import (
 	"strings"
 	"time"
 
-	cloudmonitoring "google.golang.org/api/monitoring/v3"
-
 	"v.io/jiri"
 	"v.io/jiri/collect"
-	"v.io/jiri/tool"
 	"v.io/x/devtools/internal/cache"
-	"v.io/x/devtools/internal/monitoring"
 	"v.io/x/lib/cmdline"
-	"v.io/x/lib/gcm"
 )
 
 const (
@@ -35,15 +30,13 @@ const (
 	resultTypeServiceCounters = "resultTypeServiceCounters"
 	resultTypeServiceMetadata = "resultTypeServiceMetadata"
 
-	getKubeDataTaskTypePod  = "getKubeDataTaskTypePod"
-	getKubeDataTaskTypeNode = "getKubeDataTaskTypeNode"
-
 	logsStyle = "font-family: monospace; font-size: 12px; white-space:pre-wrap; word-wrap: break-word;"
 )
 
 var (
 	addressFlag   string
 	cacheFlag     string
+	configFlag    string
 	keyFileFlag   string
 	staticDirFlag string
 )
@@ -80,27 +73,16 @@ type nodeSpec struct {
 	} `json:"spec"`
 }
 
+// clusterLocation identifies a Kubernetes cluster.
 type clusterLocation struct {
-	project string
-	zone    string
-}
-
-// Task and result for getKubeData.
-type getKubeDataTask struct {
-	location clusterLocation
-	taskType string
-}
-type getKubeDataResult struct {
-	pods     []*podSpec
-	nodes    []*nodeSpec
-	taskType string
-	err      error
+	Project string `json:"project"`
+	Zone    string `json:"zone"`
 }
 
 // Task and result for getMetricWorker.
 type getMetricTask struct {
 	resultType  string
-	md          *cloudmonitoring.MetricDescriptor
+	descriptor  string
 	metricName  string
 	pod         *podSpec
 	extraLabels map[string]string
@@ -145,27 +127,16 @@ type getDataResult struct {
 	Oncalls   []string
 	MinTime   int64
 	MaxTime   int64
-}
 
-// Current service locations.
-var locations = []clusterLocation{
-	{
-		project: "vanadium-production",
-		zone:    "us-central1-c",
-	},
-	{
-		project: "vanadium-production",
-		zone:    "us-east1-c",
-	},
-	{
-		project: "vanadium-auth-production",
-		zone:    "us-central1-c",
-	},
+	// Alerts are the pending, firing and recently resolved alerts, if
+	// alert rules are configured.
+	Alerts []*alert `json:",omitempty"`
 }
 
 func init() {
 	cmdServe.Flags.StringVar(&addressFlag, "address", ":8000", "Listening address for the server.")
 	cmdServe.Flags.StringVar(&cacheFlag, "cache", "", "Directory to use for caching files.")
+	cmdServe.Flags.StringVar(&configFlag, "config", "", "The path to the JSON file that configures the sources of the dashboard data and the services and metrics to show. Defaults to the Vanadium production services.")
 	cmdServe.Flags.StringVar(&keyFileFlag, "key", "", "The path to the service account's JSON credentials file.")
 	cmdServe.Flags.StringVar(&staticDirFlag, "static", "", "Directory to use for serving static files.")
 }
@@ -175,7 +146,37 @@ var cmdServe = &cmdline.Command{
 	Runner: cmdline.RunnerFunc(runServe),
 	Name:   "serve",
 	Short:  "Serve oncall dashboard data from Google Storage",
-	Long:   "Serve oncall dashboard data from Google Storage.",
+	Long: `
+Serve oncall dashboard data from Google Storage.
+
+The metrics of the services are read from Google Cloud Monitoring, from a
+Prometheus-compatible query API, or from a static JSON file, and their pods and
+nodes are read from Kubernetes clusters through kubectl, or from a static JSON
+file. The -config flag points to a JSON file that chooses these sources, and the
+services and metrics to show. For example:
+
+{
+  "metrics": {"type": "prometheus", "url": "http://prometheus:9090", "step": "1m"},
+  "clusters": {"type": "kubectl", "locations": [{"project": "vanadium-staging", "zone": "us-central1-c"}]},
+  "descriptors": {"latency": "service-latency", "qps": "service-qps-total"},
+  "services": [{"name": "mounttable", "podLabel": "mounttable"}]
+}
+
+The "alerts" section of the configuration declares alert rules that are
+evaluated every minute against the latest collected metrics. An alert is pending
+while the condition of its rule holds, fires once it has held for the duration
+of the rule, and resolves when it no longer holds. The current primary oncall is
+notified of firing and resolved alerts by email and/or through a webhook, and so
+is the secondary oncall for critical alerts. Firing alerts are sent again every
+hour until they are acknowledged. Alerts can be acknowledged and silenced from
+the /alerts page. For example:
+
+"alerts": {
+  "rules": [{"name": "mounttable-latency", "metric": "latency", "service": "mounttable", "op": ">", "threshold": 2000, "duration": "5m", "severity": "critical"}],
+  "smtp": {"server": "localhost:25", "from": "oncall@example.com", "domain": "example.com"},
+  "webhook": {"url": "http://chat.example.com/hooks/oncall"}
+}
+`,
 }
 
 func runServe(env *cmdline.Env, _ []string) (e error) {
@@ -195,15 +196,41 @@ func runServe(env *cmdline.Env, _ []string) (e error) {
 		root = tmpDir
 	}
 
+	sources, err := newDataSources(jirix, configFlag)
+	if err != nil {
+		return err
+	}
+
+	// Start evaluating alert rules, if any.
+	var alerts *alertManager
+	if len(sources.config.Alerts.Rules) > 0 {
+		if alerts, err = newAlertManager(jirix, sources.config.Alerts, root); err != nil {
+			return err
+		}
+		go alerts.run(jirix, sources)
+		http.HandleFunc("/alerts", func(w http.ResponseWriter, r *http.Request) {
+			alertsHandler(jirix, alerts, w, r)
+		})
+		http.HandleFunc("/alerts/ack", func(w http.ResponseWriter, r *http.Request) {
+			ackHandler(jirix, alerts, w, r)
+		})
+		http.HandleFunc("/alerts/silence", func(w http.ResponseWriter, r *http.Request) {
+			silenceHandler(jirix, alerts, w, r)
+		})
+		http.HandleFunc("/alerts/unsilence", func(w http.ResponseWriter, r *http.Request) {
+			unsilenceHandler(jirix, alerts, w, r)
+		})
+	}
+
 	// Start server.
 	http.HandleFunc("/data", func(w http.ResponseWriter, r *http.Request) {
-		dataHandler(jirix, root, w, r)
+		dataHandler(jirix, sources, alerts, w, r)
 	})
 	http.HandleFunc("/logs", func(w http.ResponseWriter, r *http.Request) {
-		logsHandler(jirix, root, w, r)
+		logsHandler(jirix, sources, w, r)
 	})
 	http.HandleFunc("/cfg", func(w http.ResponseWriter, r *http.Request) {
-		cfgHandler(jirix, root, w, r)
+		cfgHandler(jirix, sources, w, r)
 	})
 	http.HandleFunc("/pic", func(w http.ResponseWriter, r *http.Request) {
 		picHandler(jirix, root, w, r)
@@ -217,13 +244,7 @@ func runServe(env *cmdline.Env, _ []string) (e error) {
 	return nil
 }
 
-func dataHandler(jirix *jiri.X, root string, w http.ResponseWriter, r *http.Request) {
-	s, err := gcm.Authenticate(keyFileFlag)
-	if err != nil {
-		respondWithError(jirix, err, w)
-		return
-	}
-
+func dataHandler(jirix *jiri.X, sources *dataSources, alerts *alertManager, w http.ResponseWriter, r *http.Request) {
 	// Get start and end timestamps.
 	if err := r.ParseForm(); err != nil {
 		respondWithError(jirix, err, w)
@@ -251,91 +272,93 @@ func dataHandler(jirix *jiri.X, root string, w http.ResponseWriter, r *http.Requ
 		}
 	}
 
-	// Get currently running pods and nodes from vanadium production clusters.
-	podsByServices, nodes, err := getKubeData(jirix)
+	result, err := collectData(jirix, sources, time.Unix(startTimestamp, 0), time.Unix(endTimestamp, 0))
 	if err != nil {
 		respondWithError(jirix, err, w)
 		return
 	}
 
-	// Create tasks of getting metrics from GCM.
-	allTasks := []getMetricTask{}
-	mdServiceLatency, err := gcm.GetMetric("service-latency", "vanadium-production")
+	// Get oncalls.
+	oncalls, err := getOncalls(jirix)
 	if err != nil {
 		respondWithError(jirix, err, w)
 		return
 	}
-	mdServiceQPS, err := gcm.GetMetric("service-qps-total", "vanadium-production")
-	if err != nil {
-		respondWithError(jirix, err, w)
-		return
+	result.Oncalls = oncalls
+	if alerts != nil {
+		result.Alerts = alerts.list()
 	}
-	mdServiceCounters, err := gcm.GetMetric("service-counters", "vanadium-production")
+
+	// Convert results to json and return it.
+	b, err := json.MarshalIndent(result, "", "  ")
 	if err != nil {
 		respondWithError(jirix, err, w)
 		return
 	}
-	mdServiceMetadata, err := gcm.GetMetric("service-metadata", "vanadium-production")
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+// collectData collects the metrics of the pods of the configured
+// services between the given times.
+func collectData(jirix *jiri.X, sources *dataSources, start, end time.Time) (*getDataResult, error) {
+	// Get currently running pods and nodes of the services.
+	podsByServices, nodes, err := getClusterData(jirix, sources)
 	if err != nil {
-		respondWithError(jirix, err, w)
-		return
+		return nil, err
 	}
-	for serviceName, pods := range podsByServices {
-		for _, pod := range pods {
-			allTasks = append(allTasks,
-				// Metadata.
-				getMetricTask{
+
+	// Create tasks of getting metrics.
+	allTasks := []getMetricTask{}
+	descriptors := sources.config.Descriptors
+	for _, service := range sources.config.Services {
+		for _, pod := range podsByServices[service.Name] {
+			// Metadata.
+			if descriptors.Metadata != "" {
+				allTasks = append(allTasks, getMetricTask{
 					resultType: resultTypeServiceMetadata,
-					md:         mdServiceMetadata,
-					metricName: serviceName,
+					descriptor: descriptors.Metadata,
+					metricName: service.Name,
 					pod:        pod,
 					extraLabels: map[string]string{
 						"metadata_name": "build age",
 					},
-				},
-				// QPS.
-				getMetricTask{
+				})
+			}
+			// QPS.
+			if descriptors.QPS != "" {
+				allTasks = append(allTasks, getMetricTask{
 					resultType: resultTypeServiceQPS,
-					md:         mdServiceQPS,
-					metricName: serviceName,
+					descriptor: descriptors.QPS,
+					metricName: service.Name,
 					pod:        pod,
 				})
+			}
 			// Latency.
-			if serviceName == monitoring.SNIdentity {
-				for _, n := range []string{monitoring.SNMacaroon, monitoring.SNBinaryDischarger} {
-					allTasks = append(allTasks,
-						getMetricTask{
-							resultType: resultTypeServiceLatency,
-							md:         mdServiceLatency,
-							metricName: n,
-							pod:        pod,
-						})
+			if descriptors.Latency != "" {
+				names := service.Latency
+				if len(names) == 0 {
+					names = []string{service.Name}
 				}
-			} else {
-				allTasks = append(allTasks,
-					getMetricTask{
+				for _, n := range names {
+					allTasks = append(allTasks, getMetricTask{
 						resultType: resultTypeServiceLatency,
-						md:         mdServiceLatency,
-						metricName: serviceName,
+						descriptor: descriptors.Latency,
+						metricName: n,
 						pod:        pod,
 					})
+				}
 			}
 			// Counters.
-			if serviceName == monitoring.SNMounttable {
-				allTasks = append(allTasks,
-					getMetricTask{
-						resultType: resultTypeServiceCounters,
-						md:         mdServiceCounters,
-						metricName: monitoring.MNMounttableMountedServers,
-						pod:        pod,
-					},
-					getMetricTask{
+			if descriptors.Counters != "" {
+				for _, n := range service.Counters {
+					allTasks = append(allTasks, getMetricTask{
 						resultType: resultTypeServiceCounters,
-						md:         mdServiceCounters,
-						metricName: monitoring.MNMounttableNodes,
+						descriptor: descriptors.Counters,
+						metricName: n,
 						pod:        pod,
-					},
-				)
+					})
+				}
 			}
 		}
 	}
@@ -345,7 +368,7 @@ func dataHandler(jirix *jiri.X, root string, w http.ResponseWriter, r *http.Requ
 	tasks := make(chan getMetricTask, numTasks)
 	taskResults := make(chan getMetricResult, numTasks)
 	for i := 0; i < numWorkers; i++ {
-		go getMetricWorker(jirix, s, time.Unix(startTimestamp, 0), time.Unix(endTimestamp, 0), tasks, taskResults)
+		go getMetricWorker(jirix, sources.metrics, start, end, tasks, taskResults)
 	}
 	for _, task := range allTasks {
 		tasks <- task
@@ -353,7 +376,7 @@ func dataHandler(jirix *jiri.X, root string, w http.ResponseWriter, r *http.Requ
 	close(tasks)
 
 	// Process results.
-	result := getDataResult{
+	result := &getDataResult{
 		ServiceLatency:  map[string]getMetricResults{},
 		ServiceQPS:      map[string]getMetricResults{},
 		ServiceCounters: map[string]getMetricResults{},
@@ -384,26 +407,10 @@ func dataHandler(jirix *jiri.X, root string, w http.ResponseWriter, r *http.Requ
 	fnSortMetrics(result.ServiceCounters)
 	fnSortMetrics(result.ServiceMetadata)
 
-	result.MinTime = startTimestamp
-	result.MaxTime = endTimestamp
+	result.MinTime = start.Unix()
+	result.MaxTime = end.Unix()
 	result.Instances = nodes
-
-	// Get oncalls.
-	oncalls, err := getOncalls(jirix)
-	if err != nil {
-		respondWithError(jirix, err, w)
-		return
-	}
-	result.Oncalls = oncalls
-
-	// Convert results to json and return it.
-	b, err := json.MarshalIndent(&result, "", "  ")
-	if err != nil {
-		respondWithError(jirix, err, w)
-		return
-	}
-	w.Header().Set("Content-Type", "application/json")
-	w.Write(b)
+	return result, nil
 }
 
 func dataHandler2(jirix *jiri.X, root string, w http.ResponseWriter, r *http.Request) {
@@ -412,7 +419,7 @@ func dataHandler2(jirix *jiri.X, root string, w http.ResponseWriter, r *http.Req
 	w.Write(b)
 }
 
-func getMetricWorker(jirix *jiri.X, s *cloudmonitoring.Service, startTime, endTime time.Time, tasks <-chan getMetricTask, results chan<- getMetricResult) {
+func getMetricWorker(jirix *jiri.X, source metricSource, startTime, endTime time.Time, tasks <-chan getMetricTask, results chan<- getMetricResult) {
 	for task := range tasks {
 		result := getMetricResult{
 			ResultType:     task.resultType,
@@ -431,55 +438,22 @@ func getMetricWorker(jirix *jiri.X, s *cloudmonitoring.Service, startTime, endTi
 			MainContainer:  task.pod.Spec.Containers[0].Name,
 			ServiceVersion: task.pod.Metadata.Labels.Version,
 		}
-		filters := []string{
-			fmt.Sprintf("metric.type=%q", task.md.Type),
-			fmt.Sprintf("metric.label.metric_name=%q", task.metricName),
-			fmt.Sprintf("metric.label.gce_instance=%q", task.pod.Metadata.Name),
-			fmt.Sprintf("metric.label.gce_zone=%q", task.pod.zone),
-		}
-		for labelKey, labelValue := range task.extraLabels {
-			filters = append(filters, fmt.Sprintf("metric.label.%s=%q", labelKey, labelValue))
-		}
-		nextPageToken := ""
-		points := []*cloudmonitoring.Point{}
 		timestamps := []int64{}
 		values := []float64{}
-		for {
-			resp, err := s.Projects.TimeSeries.List("projects/vanadium-production").
-				IntervalStartTime(startTime.UTC().Format(time.RFC3339)).
-				IntervalEndTime(endTime.UTC().Format(time.RFC3339)).
-				Filter(strings.Join(filters, " AND ")).
-				PageToken(nextPageToken).Do()
-			if err != nil {
-				result.ErrMsg = fmt.Sprintf("List() failed: %v", err)
-				break
-			}
-			if len(resp.TimeSeries) > 0 {
-				// We should only get one timeseries.
-				ts := resp.TimeSeries[0]
-				points = append(points, ts.Points...)
-			}
-			if result.ErrMsg != "" {
-				break
-			}
-			nextPageToken = resp.NextPageToken
-			if nextPageToken == "" {
-				break
-			}
+		points, err := source.timeSeries(jirix, metricQuery{
+			descriptor: task.descriptor,
+			metricName: task.metricName,
+			pod:        task.pod,
+			labels:     task.extraLabels,
+		}, startTime, endTime)
+		if err != nil {
+			result.ErrMsg = err.Error()
 		}
-		for i := len(points) - 1; i >= 0; i-- {
-			pt := points[i]
-			epochTime, err := time.Parse(time.RFC3339, pt.Interval.EndTime)
-			if err != nil {
-				result.ErrMsg = fmt.Sprintf("Parse(%s) failed: %v", pt.Interval.EndTime)
-				break
-			}
-			timestamp := epochTime.Unix()
-			timestamps = append(timestamps, timestamp)
-			value := pt.Value.DoubleValue
-			values = append(values, value)
-			result.MaxValue = math.Max(result.MaxValue, value)
-			result.MinValue = math.Min(result.MinValue, value)
+		for _, pt := range points {
+			timestamps = append(timestamps, pt.Time)
+			values = append(values, pt.Value)
+			result.MaxValue = math.Max(result.MaxValue, pt.Value)
+			result.MinValue = math.Min(result.MinValue, pt.Value)
 		}
 		result.HistoryTimestamps = timestamps
 		result.HistoryValues = values
@@ -490,59 +464,30 @@ func getMetricWorker(jirix *jiri.X, s *cloudmonitoring.Service, startTime, endTi
 	}
 }
 
-// getKubeData gets:
-// - pod data indexed by service names.
+// getClusterData gets:
+// - pod data of the configured services indexed by service names.
 // - node external ids indexed by names.
-func getKubeData(jirix *jiri.X) (map[string][]*podSpec, map[string]string, error) {
+func getClusterData(jirix *jiri.X, sources *dataSources) (map[string][]*podSpec, map[string]string, error) {
 	retPods := map[string][]*podSpec{}
 	retNodes := map[string]string{}
 
-	// Get pods data.
-	numTasks := len(locations) * 2
-	tasks := make(chan getKubeDataTask, numTasks)
-	taskResults := make(chan getKubeDataResult, numTasks)
-	for i := 0; i < numTasks; i++ {
-		go getKubeDataWorker(jirix, tasks, taskResults)
-	}
-	for _, loc := range locations {
-		tasks <- getKubeDataTask{
-			location: loc,
-			taskType: getKubeDataTaskTypePod,
-		}
-		tasks <- getKubeDataTask{
-			location: loc,
-			taskType: getKubeDataTaskTypeNode,
-		}
+	pods, err := sources.clusters.pods(jirix)
+	if err != nil {
+		return nil, nil, err
 	}
-	close(tasks)
-
-	pods := []*podSpec{}
-	nodes := []*nodeSpec{}
-	for i := 0; i < numTasks; i++ {
-		result := <-taskResults
-		switch result.taskType {
-		case getKubeDataTaskTypePod:
-			pods = append(pods, result.pods...)
-		case getKubeDataTaskTypeNode:
-			nodes = append(nodes, result.nodes...)
-		}
+	nodes, err := sources.clusters.nodes(jirix)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	// Index pods data by service name.
+	serviceNames := map[string]string{}
+	for _, service := range sources.config.Services {
+		serviceNames[service.PodLabel] = service.Name
+	}
 	for _, pod := range pods {
-		switch pod.Metadata.Labels.Service {
-		case "auth":
-			retPods[monitoring.SNIdentity] = append(retPods[monitoring.SNIdentity], pod)
-		case "benchmarks":
-			retPods[monitoring.SNBenchmark] = append(retPods[monitoring.SNBenchmark], pod)
-		case "mounttable":
-			retPods[monitoring.SNMounttable] = append(retPods[monitoring.SNMounttable], pod)
-		case "proxy":
-			retPods[monitoring.SNProxy] = append(retPods[monitoring.SNProxy], pod)
-		case "role":
-			retPods[monitoring.SNRole] = append(retPods[monitoring.SNRole], pod)
-		case "sb-allocator":
-			retPods[monitoring.SNAllocator] = append(retPods[monitoring.SNAllocator], pod)
+		if name, ok := serviceNames[pod.Metadata.Labels.Service]; ok {
+			retPods[name] = append(retPods[name], pod)
 		}
 	}
 	// Index nodes names by ids.
@@ -553,84 +498,6 @@ func getKubeData(jirix *jiri.X) (map[string][]*podSpec, map[string]string, error
 	return retPods, retNodes, nil
 }
 
-func getKubeDataWorker(jirix *jiri.X, tasks <-chan getKubeDataTask, results chan<- getKubeDataResult) {
-	for task := range tasks {
-		kubeCtlArgs := []string{"get", "pods", "-o=json"}
-		if task.taskType == getKubeDataTaskTypeNode {
-			kubeCtlArgs = []string{"get", "nodes", "-o=json"}
-		}
-		var podItems struct {
-			Items []*podSpec `json:"items"`
-		}
-		var nodeItems struct {
-			Items []*nodeSpec `json:"items"`
-		}
-		out, err := runKubeCtl(jirix, task.location, kubeCtlArgs)
-		if err != nil {
-			results <- getKubeDataResult{
-				err: err,
-			}
-			continue
-		}
-		switch task.taskType {
-		case getKubeDataTaskTypePod:
-			if err := json.Unmarshal(out, &podItems); err != nil {
-				results <- getKubeDataResult{
-					err: fmt.Errorf("Unmarshal() failed: %v", err),
-				}
-				continue
-			}
-			for _, item := range podItems.Items {
-				item.zone = task.location.zone
-				item.project = task.location.project
-			}
-			results <- getKubeDataResult{
-				taskType: getKubeDataTaskTypePod,
-				pods:     podItems.Items,
-			}
-		case getKubeDataTaskTypeNode:
-			if err := json.Unmarshal(out, &nodeItems); err != nil {
-				results <- getKubeDataResult{
-					err: fmt.Errorf("Unmarshal() failed: %v", err),
-				}
-				continue
-			}
-			results <- getKubeDataResult{
-				taskType: getKubeDataTaskTypeNode,
-				nodes:    nodeItems.Items,
-			}
-		}
-	}
-}
-
-func runKubeCtl(jirix *jiri.X, location clusterLocation, kubeCtlArgs []string) ([]byte, error) {
-	f, err := ioutil.TempFile("", "")
-	if err != nil {
-		return nil, nil
-	}
-	defer jirix.NewSeq().RemoveAll(f.Name())
-	s := tool.NewContext(tool.ContextOpts{
-		Env: map[string]string{
-			"KUBECONFIG": f.Name(),
-		},
-	}).NewSeq()
-	var out bytes.Buffer
-	getCredsArgs := []string{
-		"container",
-		"clusters",
-		"get-credentials",
-		"vanadium",
-		"--project",
-		location.project,
-		"--zone",
-		location.zone,
-	}
-	if err := s.Run("gcloud", getCredsArgs...).Capture(&out, &out).Last("kubectl", kubeCtlArgs...); err != nil {
-		return nil, err
-	}
-	return out.Bytes(), nil
-}
-
 func getOncalls(jirix *jiri.X) ([]string, error) {
 	s := jirix.NewSeq()
 	var out bytes.Buffer
@@ -640,7 +507,7 @@ func getOncalls(jirix *jiri.X) ([]string, error) {
 	return strings.Split(strings.TrimSpace(out.String()), ","), nil
 }
 
-func logsHandler(jirix *jiri.X, root string, w http.ResponseWriter, r *http.Request) {
+func logsHandler(jirix *jiri.X, sources *dataSources, w http.ResponseWriter, r *http.Request) {
 	// Parse project, zone, pod name, and container.
 	f, err := parseForm(r, "p", "z", "d", "c")
 	if err != nil {
@@ -648,10 +515,10 @@ func logsHandler(jirix *jiri.X, root string, w http.ResponseWriter, r *http.Requ
 		return
 	}
 	project, zone, pod, container := f["p"], f["z"], f["d"], f["c"]
-	out, err := runKubeCtl(jirix, clusterLocation{
-		project: project,
-		zone:    zone,
-	}, []string{"logs", pod, container})
+	out, err := sources.clusters.logs(jirix, clusterLocation{
+		Project: project,
+		Zone:    zone,
+	}, pod, container)
 	if err != nil {
 		respondWithError(jirix, err, w)
 		return
@@ -662,7 +529,7 @@ func logsHandler(jirix *jiri.X, root string, w http.ResponseWriter, r *http.Requ
 	w.Write([]byte(content))
 }
 
-func cfgHandler(jirix *jiri.X, root string, w http.ResponseWriter, r *http.Request) {
+func cfgHandler(jirix *jiri.X, sources *dataSources, w http.ResponseWriter, r *http.Request) {
 	// Parse project, zone, and pod name.
 	f, err := parseForm(r, "p", "z", "d")
 	if err != nil {
@@ -670,10 +537,10 @@ func cfgHandler(jirix *jiri.X, root string, w http.ResponseWriter, r *http.Reque
 		return
 	}
 	project, zone, pod := f["p"], f["z"], f["d"]
-	out, err := runKubeCtl(jirix, clusterLocation{
-		project: project,
-		zone:    zone,
-	}, []string{"get", "pods", pod, "-o=json"})
+	out, err := sources.clusters.podConfig(jirix, clusterLocation{
+		Project: project,
+		Zone:    zone,
+	}, pod)
 	if err != nil {
 		respondWithError(jirix, err, w)
 		return
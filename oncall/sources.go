@@ -0,0 +1,216 @@
+// Copyright 2015 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"v.io/jiri"
+	"v.io/x/devtools/internal/monitoring"
+)
+
+// The types of metric and cluster sources.
+const (
+	sourceTypeGCM        = "gcm"
+	sourceTypeKubectl    = "kubectl"
+	sourceTypePrometheus = "prometheus"
+	sourceTypeStatic     = "static"
+)
+
+// point is a point of a metric time series.
+type point struct {
+	Time  int64 // In seconds since the epoch.
+	Value float64
+}
+
+// metricQuery identifies the time series of a metric of a pod.
+type metricQuery struct {
+	// descriptor is the name of the metric descriptor, such as
+	// "service-latency".
+	descriptor string
+	// metricName is the value of the "metric_name" label of the metric.
+	metricName string
+	pod        *podSpec
+	labels     map[string]string
+}
+
+// metricSource provides the time series of the metrics of pods.
+type metricSource interface {
+	// timeSeries returns the points of the time series identified by the
+	// given query between the given times, sorted by time.
+	timeSeries(jirix *jiri.X, query metricQuery, start, end time.Time) ([]point, error)
+}
+
+// clusterSource provides the inventory of the pods and nodes that run
+// the services, and the details of the pods.
+type clusterSource interface {
+	// pods returns the pods of all clusters.
+	pods(jirix *jiri.X) ([]*podSpec, error)
+	// nodes returns the nodes of all clusters.
+	nodes(jirix *jiri.X) ([]*nodeSpec, error)
+	// logs returns the logs of the given container of the given pod.
+	logs(jirix *jiri.X, location clusterLocation, pod, container string) ([]byte, error)
+	// podConfig returns the configuration of the given pod.
+	podConfig(jirix *jiri.X, location clusterLocation, pod string) ([]byte, error)
+}
+
+// sourceConfig configures a metric or cluster source.
+type sourceConfig struct {
+	// Type is one of "gcm" or "prometheus" for metric sources, one of
+	// "kubectl" for cluster sources, or "static" for either.
+	Type string `json:"type"`
+	// Project is the GCM project of the metrics.
+	Project string `json:"project,omitempty"`
+	// URL is the base URL of the Prometheus-compatible query API.
+	URL string `json:"url,omitempty"`
+	// Step is the resolution of Prometheus range queries, such as "1m".
+	Step string `json:"step,omitempty"`
+	// File is the JSON file that static sources read from.
+	File string `json:"file,omitempty"`
+	// Locations are the Kubernetes clusters.
+	Locations []clusterLocation `json:"locations,omitempty"`
+}
+
+// descriptorsConfig holds the names of the metric descriptors of the
+// kinds of metrics shown for services. Kinds with an empty name are not
+// shown.
+type descriptorsConfig struct {
+	Latency  string `json:"latency"`
+	QPS      string `json:"qps"`
+	Counters string `json:"counters"`
+	Metadata string `json:"metadata"`
+}
+
+// serviceConfig configures a service shown on the oncall dashboard.
+type serviceConfig struct {
+	// Name is the name of the service in its metrics.
+	Name string `json:"name"`
+	// PodLabel is the value of the "service" label of the pods of the
+	// service.
+	PodLabel string `json:"podLabel"`
+	// Latency lists the metric names of the latencies of the service,
+	// which default to its name.
+	Latency []string `json:"latency,omitempty"`
+	// Counters lists the metric names of the counters of the service.
+	Counters []string `json:"counters,omitempty"`
+}
+
+// config configures the sources of the oncall dashboard data, the
+// services and metrics it shows, and the alerts raised on them.
+type config struct {
+	Metrics     sourceConfig      `json:"metrics"`
+	Clusters    sourceConfig      `json:"clusters"`
+	Descriptors descriptorsConfig `json:"descriptors"`
+	Services    []serviceConfig   `json:"services"`
+	Alerts      alertsConfig      `json:"alerts"`
+}
+
+// defaultConfig is the configuration of the Vanadium production
+// services.
+var defaultConfig = config{
+	Metrics: sourceConfig{
+		Type:    sourceTypeGCM,
+		Project: "vanadium-production",
+	},
+	Clusters: sourceConfig{
+		Type: sourceTypeKubectl,
+		Locations: []clusterLocation{
+			{Project: "vanadium-production", Zone: "us-central1-c"},
+			{Project: "vanadium-production", Zone: "us-east1-c"},
+			{Project: "vanadium-auth-production", Zone: "us-central1-c"},
+		},
+	},
+	Descriptors: descriptorsConfig{
+		Latency:  "service-latency",
+		QPS:      "service-qps-total",
+		Counters: "service-counters",
+		Metadata: "service-metadata",
+	},
+	Services: []serviceConfig{
+		{
+			Name:     monitoring.SNIdentity,
+			PodLabel: "auth",
+			Latency:  []string{monitoring.SNMacaroon, monitoring.SNBinaryDischarger},
+		},
+		{Name: monitoring.SNBenchmark, PodLabel: "benchmarks"},
+		{
+			Name:     monitoring.SNMounttable,
+			PodLabel: "mounttable",
+			Counters: []string{monitoring.MNMounttableMountedServers, monitoring.MNMounttableNodes},
+		},
+		{Name: monitoring.SNProxy, PodLabel: "proxy"},
+		{Name: monitoring.SNRole, PodLabel: "role"},
+		{Name: monitoring.SNAllocator, PodLabel: "sb-allocator"},
+	},
+}
+
+// dataSources holds the configuration of the oncall dashboard and the
+// sources it configures.
+type dataSources struct {
+	config   *config
+	metrics  metricSource
+	clusters clusterSource
+}
+
+// newDataSources returns the sources configured by the given JSON
+// file, or by the default configuration if the path is empty.
+func newDataSources(jirix *jiri.X, path string) (*dataSources, error) {
+	c := defaultConfig
+	if path != "" {
+		c = config{}
+		if err := readJSONFile(jirix, path, &c); err != nil {
+			return nil, err
+		}
+	}
+	metrics, err := newMetricSource(c.Metrics)
+	if err != nil {
+		return nil, err
+	}
+	clusters, err := newClusterSource(c.Clusters)
+	if err != nil {
+		return nil, err
+	}
+	return &dataSources{config: &c, metrics: metrics, clusters: clusters}, nil
+}
+
+// newMetricSource returns the metric source with the given
+// configuration.
+func newMetricSource(c sourceConfig) (metricSource, error) {
+	switch c.Type {
+	case sourceTypeGCM:
+		return newGCMSource(c.Project, keyFileFlag)
+	case sourceTypePrometheus:
+		return newPrometheusSource(c.URL, c.Step)
+	case sourceTypeStatic:
+		return &staticMetricSource{file: c.File}, nil
+	}
+	return nil, fmt.Errorf("unknown metric source type %q", c.Type)
+}
+
+// newClusterSource returns the cluster source with the given
+// configuration.
+func newClusterSource(c sourceConfig) (clusterSource, error) {
+	switch c.Type {
+	case sourceTypeKubectl:
+		return &kubectlSource{locations: c.Locations}, nil
+	case sourceTypeStatic:
+		return &staticClusterSource{file: c.File}, nil
+	}
+	return nil, fmt.Errorf("unknown cluster source type %q", c.Type)
+}
+
+// readJSONFile reads the given value from the given JSON file.
+func readJSONFile(jirix *jiri.X, path string, value interface{}) error {
+	bytes, err := jirix.NewSeq().ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(bytes, value); err != nil {
+		return fmt.Errorf("Unmarshal(%v) failed: %v", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+// Copyright 2015 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"v.io/jiri/jiritest"
+)
+
+func TestStaticSources(t *testing.T) {
+	jirix, cleanup := jiritest.NewX(t)
+	defer cleanup()
+
+	files := map[string]string{
+		"clusters.json": `[
+  {
+    "project": "vanadium-staging",
+    "zone": "us-central1-c",
+    "pods": [
+      {"metadata": {"name": "mt-1", "labels": {"service": "mounttable"}}, "spec": {"nodeName": "node-1", "containers": [{"name": "mounttable"}]}},
+      {"metadata": {"name": "web-1", "labels": {"service": "web"}}, "spec": {"nodeName": "node-1", "containers": [{"name": "web"}]}}
+    ],
+    "nodes": [{"metadata": {"name": "node-1"}, "spec": {"externalID": "1234"}}]
+  }
+]`,
+		"metrics.json": `[
+  {"descriptor": "service-latency", "metricName": "mounttable", "instance": "mt-1", "points": [{"time": 100, "value": 1}, {"time": 200, "value": 2}, {"time": 300, "value": 3}]},
+  {"descriptor": "service-qps-total", "metricName": "mounttable", "instance": "mt-1", "points": [{"time": 200, "value": 10}]}
+]`,
+		"config.json": `{
+  "metrics": {"type": "static", "file": "METRICS"},
+  "clusters": {"type": "static", "file": "CLUSTERS"},
+  "descriptors": {"latency": "service-latency", "qps": "service-qps-total"},
+  "services": [{"name": "mounttable", "podLabel": "mounttable"}]
+}`,
+	}
+	for name, contents := range files {
+		contents = strings.NewReplacer(
+			"METRICS", filepath.Join(jirix.Root, "metrics.json"),
+			"CLUSTERS", filepath.Join(jirix.Root, "clusters.json")).Replace(contents)
+		if err := ioutil.WriteFile(filepath.Join(jirix.Root, name), []byte(contents), os.FileMode(0644)); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+
+	sources, err := newDataSources(jirix, filepath.Join(jirix.Root, "config.json"))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	pods, nodes, err := getClusterData(jirix, sources)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	// Only the pods of the configured services are shown.
+	if got, want := len(pods), 1; got != want {
+		t.Fatalf("got %v services, want %v: %v", got, want, pods)
+	}
+	pod := pods["mounttable"][0]
+	if pod.Metadata.Name != "mt-1" || pod.project != "vanadium-staging" || pod.zone != "us-central1-c" {
+		t.Fatalf("got %#v", pod)
+	}
+	if want := map[string]string{"node-1": "1234"}; !reflect.DeepEqual(nodes, want) {
+		t.Fatalf("got %v, want %v", nodes, want)
+	}
+
+	query := metricQuery{descriptor: "service-latency", metricName: "mounttable", pod: pod}
+	points, err := sources.metrics.timeSeries(jirix, query, time.Unix(150, 0), time.Unix(300, 0))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if want := []point{{200, 2}, {300, 3}}; !reflect.DeepEqual(points, want) {
+		t.Fatalf("got %v, want %v", points, want)
+	}
+	query.labels = map[string]string{"metadata_name": "build age"}
+	if points, err := sources.metrics.timeSeries(jirix, query, time.Unix(0, 0), time.Unix(300, 0)); err != nil || len(points) != 0 {
+		t.Fatalf("got %v, %v, want no points", points, err)
+	}
+
+	location := clusterLocation{Project: "vanadium-staging", Zone: "us-central1-c"}
+	config, err := sources.clusters.podConfig(jirix, location, "mt-1")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if !strings.Contains(string(config), `"nodeName": "node-1"`) {
+		t.Fatalf("got %s", config)
+	}
+	if _, err := sources.clusters.podConfig(jirix, location, "mt-2"); err == nil {
+		t.Fatalf("got the configuration of an unknown pod")
+	}
+}
+
+func TestPrometheusSource(t *testing.T) {
+	jirix, cleanup := jiritest.NewX(t)
+	defer cleanup()
+
+	wantQuery := `service_metadata{gce_instance="mt-1",gce_zone="us-central1-c",metadata_name="build age",metric_name="mounttable"}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/query_range" {
+			http.NotFound(w, r)
+			return
+		}
+		if got := r.FormValue("query"); got != wantQuery {
+			fmt.Fprintf(w, `{"status": "error", "error": "unexpected query %s"}`, got)
+			return
+		}
+		if got, want := r.FormValue("start")+" "+r.FormValue("end")+" "+r.FormValue("step"), "100 400 30"; got != want {
+			fmt.Fprintf(w, `{"status": "error", "error": "unexpected range %s"}`, got)
+			return
+		}
+		fmt.Fprint(w, `{"status": "success", "data": {"resultType": "matrix", "result": [{"metric": {}, "values": [[100, "1.5"], [130, "2"]]}]}}`)
+	}))
+	defer server.Close()
+
+	source, err := newPrometheusSource(server.URL+"/", "30s")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	pod := &podSpec{zone: "us-central1-c"}
+	pod.Metadata.Name = "mt-1"
+	query := metricQuery{
+		descriptor: "service-metadata",
+		metricName: "mounttable",
+		pod:        pod,
+		labels:     map[string]string{"metadata_name": "build age"},
+	}
+	points, err := source.timeSeries(jirix, query, time.Unix(100, 0), time.Unix(400, 0))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if want := []point{{100, 1.5}, {130, 2}}; !reflect.DeepEqual(points, want) {
+		t.Fatalf("got %v, want %v", points, want)
+	}
+	query.metricName = "proxy service"
+	if _, err := source.timeSeries(jirix, query, time.Unix(100, 0), time.Unix(400, 0)); err == nil {
+		t.Fatalf("query of %v did not fail", query)
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright 2015 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"v.io/jiri"
+)
+
+// staticSeries is a time series in the file of a staticMetricSource.
+type staticSeries struct {
+	Descriptor string            `json:"descriptor"`
+	MetricName string            `json:"metricName"`
+	Instance   string            `json:"instance"`
+	Labels     map[string]string `json:"labels,omitempty"`
+	Points     []point           `json:"points"`
+}
+
+// staticMetricSource is a metricSource that reads the time series from
+// a JSON file holding a list of staticSeries, whose points are sorted by
+// time. It is meant for demos, tests, and environments without a
+// monitoring service.
+type staticMetricSource struct {
+	file string
+}
+
+func (s *staticMetricSource) timeSeries(jirix *jiri.X, query metricQuery, start, end time.Time) ([]point, error) {
+	var series []staticSeries
+	if err := readJSONFile(jirix, s.file, &series); err != nil {
+		return nil, err
+	}
+	points := []point{}
+	for _, ts := range series {
+		if ts.Descriptor != query.descriptor || ts.MetricName != query.metricName || ts.Instance != query.pod.Metadata.Name {
+			continue
+		}
+		matches := true
+		for key, value := range query.labels {
+			if ts.Labels[key] != value {
+				matches = false
+				break
+			}
+		}
+		if !matches {
+			continue
+		}
+		for _, p := range ts.Points {
+			if p.Time >= start.Unix() && p.Time <= end.Unix() {
+				points = append(points, p)
+			}
+		}
+		break
+	}
+	return points, nil
+}
+
+// staticCluster holds the pods and nodes of a cluster in the file of a
+// staticClusterSource. Pods and nodes are in the format of kubectl.
+type staticCluster struct {
+	clusterLocation
+	Pods  []*podSpec  `json:"pods"`
+	Nodes []*nodeSpec `json:"nodes"`
+}
+
+// staticClusterSource is a clusterSource that reads the pods and nodes
+// from a JSON file holding a list of staticCluster.
+type staticClusterSource struct {
+	file string
+}
+
+func (s *staticClusterSource) clusters(jirix *jiri.X) ([]staticCluster, error) {
+	var clusters []staticCluster
+	if err := readJSONFile(jirix, s.file, &clusters); err != nil {
+		return nil, err
+	}
+	for _, cluster := range clusters {
+		for _, pod := range cluster.Pods {
+			pod.zone = cluster.Zone
+			pod.project = cluster.Project
+		}
+	}
+	return clusters, nil
+}
+
+func (s *staticClusterSource) pods(jirix *jiri.X) ([]*podSpec, error) {
+	clusters, err := s.clusters(jirix)
+	if err != nil {
+		return nil, err
+	}
+	pods := []*podSpec{}
+	for _, cluster := range clusters {
+		pods = append(pods, cluster.Pods...)
+	}
+	return pods, nil
+}
+
+func (s *staticClusterSource) nodes(jirix *jiri.X) ([]*nodeSpec, error) {
+	clusters, err := s.clusters(jirix)
+	if err != nil {
+		return nil, err
+	}
+	nodes := []*nodeSpec{}
+	for _, cluster := range clusters {
+		nodes = append(nodes, cluster.Nodes...)
+	}
+	return nodes, nil
+}
+
+func (s *staticClusterSource) logs(jirix *jiri.X, location clusterLocation, pod, container string) ([]byte, error) {
+	return nil, fmt.Errorf("the logs of pods are not available from %v", s.file)
+}
+
+func (s *staticClusterSource) podConfig(jirix *jiri.X, location clusterLocation, pod string) ([]byte, error) {
+	clusters, err := s.clusters(jirix)
+	if err != nil {
+		return nil, err
+	}
+	for _, cluster := range clusters {
+		if cluster.clusterLocation != location {
+			continue
+		}
+		for _, p := range cluster.Pods {
+			if p.Metadata.Name == pod {
+				bytes, err := json.MarshalIndent(p, "", "  ")
+				if err != nil {
+					return nil, fmt.Errorf("MarshalIndent() failed: %v", err)
+				}
+				return bytes, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("pod %v not found in %v", pod, location)
+}
@@ -0,0 +1,304 @@
+// Copyright 2015 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"v.io/jiri"
+	"v.io/jiri/project"
+	"v.io/jiri/runutil"
+)
+
+var (
+	changelogFlag   string
+	fromFlag        string
+	toFlag          string
+	vcsCommandsFlag = vcsCommands{}
+)
+
+func init() {
+	cmdPoll.Flags.StringVar(&changelogFlag, "changelog", "", "The file to write a JSON changelog of the detected changes to, listing the changed projects with their commit ranges, commits, authors and changed paths, and the removed projects.")
+	cmdPoll.Flags.StringVar(&fromFlag, "from", "", "The snapshot to detect changes from. Defaults to the snapshot of the second latest update.")
+	cmdPoll.Flags.StringVar(&toFlag, "to", "", "The snapshot to detect changes up to, which the started builds record as the snapshot they test. Defaults to the master branches of the local projects, with the builds recording the snapshot of the latest update.")
+	cmdPoll.Flags.Var(&vcsCommandsFlag, "vcs-command", "A <protocol>=<command> pair that identifies the command that detects the changes of projects using the given protocol. The command runs in the project directory with the old and new revisions as arguments (the new revision is empty for the current state of the project), and prints a JSON list of commits with the Revision, Author, Time, Subject and Paths fields. Can be repeated.")
+}
+
+// vcsCommands maps project protocols to the commands that detect their
+// changes.
+type vcsCommands map[string]string
+
+func (c *vcsCommands) String() string {
+	pairs := []string{}
+	for protocol, command := range *c {
+		pairs = append(pairs, protocol+"="+command)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (c *vcsCommands) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 || parts[0] == "" || strings.TrimSpace(parts[1]) == "" {
+		return fmt.Errorf("invalid VCS command %q, want <protocol>=<command>", value)
+	}
+	(*c)[parts[0]] = parts[1]
+	return nil
+}
+
+// vcs is the interface of version control systems that detect the
+// changes of projects.
+type vcs interface {
+	// Changes returns the commits of the given project between the
+	// given revisions, oldest first. An empty "to" revision identifies
+	// the current state of the project.
+	Changes(jirix *jiri.X, p project.Project, from, to string) ([]commit, error)
+}
+
+// newVCS returns the version control system for the given protocol, or
+// nil if the protocol is not supported.
+func newVCS(protocol string, commands vcsCommands) vcs {
+	if command, ok := commands[protocol]; ok {
+		return commandVCS{command: command}
+	}
+	if protocol == "git" {
+		return gitVCS{}
+	}
+	return nil
+}
+
+// gitVCS implements the vcs interface for git projects. It also lists
+// the commits that culprits are searched among (see commitsBetween).
+type gitVCS struct{}
+
+// commitMarker starts the lines of "git log" output that describe
+// commits, as opposed to the paths they change.
+const commitMarker = "\x01"
+
+func (gitVCS) Changes(jirix *jiri.X, p project.Project, from, to string) ([]commit, error) {
+	if to == "" {
+		to = "master"
+	}
+	var out bytes.Buffer
+	s := jirix.NewSeq()
+	if err := s.Pushd(p.Path).Capture(&out, nil).Last("git", "log", "--reverse", "--topo-order", "--name-only", "--format="+commitMarker+"%H%x00%ae%x00%ct%x00%s", from+".."+to); err != nil {
+		return nil, err
+	}
+	return parseGitLog(p.Name, out.String())
+}
+
+// parseGitLog parses the output of "git log" for the given project,
+// which describes each commit on a line that starts with commitMarker,
+// followed by the paths it changes.
+func parseGitLog(projectName, output string) ([]commit, error) {
+	commits := []commit{}
+	for _, line := range strings.Split(output, "\n") {
+		if strings.HasPrefix(line, commitMarker) {
+			fields := strings.SplitN(strings.TrimPrefix(line, commitMarker), "\x00", 4)
+			if len(fields) != 4 {
+				return nil, fmt.Errorf("unexpected git log output: %q", line)
+			}
+			timestamp, err := strconv.ParseInt(fields[2], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("ParseInt(%v) failed: %v", fields[2], err)
+			}
+			commits = append(commits, commit{
+				Project:  projectName,
+				Revision: fields[0],
+				Author:   fields[1],
+				Time:     timestamp,
+				Subject:  fields[3],
+			})
+			continue
+		}
+		if line = strings.TrimSpace(line); line != "" && len(commits) > 0 {
+			last := &commits[len(commits)-1]
+			last.Paths = append(last.Paths, line)
+		}
+	}
+	return commits, nil
+}
+
+// commandVCS implements the vcs interface using a command (see the
+// -vcs-command flag).
+type commandVCS struct {
+	command string
+}
+
+func (c commandVCS) Changes(jirix *jiri.X, p project.Project, from, to string) ([]commit, error) {
+	args := strings.Fields(c.command)
+	args = append(args, from, to)
+	var out bytes.Buffer
+	s := jirix.NewSeq()
+	if err := s.Pushd(p.Path).Capture(&out, jirix.Stderr()).Last(args[0], args[1:]...); err != nil {
+		return nil, err
+	}
+	commits := []commit{}
+	if err := json.Unmarshal(out.Bytes(), &commits); err != nil {
+		return nil, fmt.Errorf("Unmarshal(%v) failed: %v", out.String(), err)
+	}
+	for i := range commits {
+		commits[i].Project = p.Name
+	}
+	return commits, nil
+}
+
+// changelog describes the changes between two states of the projects.
+type changelog struct {
+	// From and To identify the snapshots that were compared. An empty
+	// To identifies the master branches of the local projects.
+	From, To string
+	// Projects lists the changed projects, sorted by name.
+	Projects []projectChanges
+}
+
+// projectChanges describes the changes of a project.
+type projectChanges struct {
+	Name     string
+	Protocol string
+	// From and To identify the compared revisions. An empty From
+	// identifies projects that are new, and an empty To identifies the
+	// current state of the project.
+	From, To string
+	// Removed identifies projects that no longer exist.
+	Removed bool `json:",omitempty"`
+	// Commits lists the commits between the revisions, oldest first.
+	Commits []commit
+	// Paths lists the paths changed by the commits.
+	Paths []string
+}
+
+// projectNames returns the names of the changed projects.
+func (c *changelog) projectNames() []string {
+	names := []string{}
+	for _, p := range c.Projects {
+		names = append(names, p.Name)
+	}
+	return names
+}
+
+type projectChangesByName []projectChanges
+
+func (p projectChangesByName) Len() int           { return len(p) }
+func (p projectChangesByName) Less(i, j int) bool { return p[i].Name < p[j].Name }
+func (p projectChangesByName) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+// detectChanges returns the changes of the projects between the given
+// snapshots. If toSnapshot is empty, changes up to the master branches
+// of the local projects are detected.
+func detectChanges(jirix *jiri.X, fromSnapshot, toSnapshot string, commands vcsCommands) (*changelog, error) {
+	from, _, err := project.LoadSnapshotFile(jirix, fromSnapshot)
+	if err != nil {
+		return nil, err
+	}
+	var to project.Projects
+	if toSnapshot != "" {
+		if to, _, err = project.LoadSnapshotFile(jirix, toSnapshot); err != nil {
+			return nil, err
+		}
+	}
+	result := &changelog{From: fromSnapshot, To: toSnapshot}
+	if result.Projects, err = diffProjects(jirix, from, to, commands); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// diffProjects returns the changes of the given projects, sorted by
+// name, between their "from" and "to" states. If "to" is nil, changes up
+// to the master branches of the local projects are detected.
+func diffProjects(jirix *jiri.X, from, to project.Projects, commands vcsCommands) ([]projectChanges, error) {
+	result := []projectChanges{}
+	for key, p := range from {
+		toRevision := ""
+		removed := false
+		if to != nil {
+			toProject, ok := to[key]
+			if ok && toProject.Revision == p.Revision {
+				continue
+			}
+			toRevision, removed = toProject.Revision, !ok
+		} else if _, err := jirix.NewSeq().Stat(p.Path); err != nil {
+			if !runutil.IsNotExist(err) {
+				return nil, err
+			}
+			removed = true
+		}
+		if removed {
+			result = append(result, projectChanges{
+				Name:     p.Name,
+				Protocol: p.Protocol,
+				From:     p.Revision,
+				Removed:  true,
+				Commits:  []commit{},
+				Paths:    []string{},
+			})
+			continue
+		}
+		system := newVCS(p.Protocol, commands)
+		if system == nil {
+			fmt.Fprintf(jirix.Stderr(), "Cannot detect changes of project %v: unsupported protocol %q.\n", p.Name, p.Protocol)
+			continue
+		}
+		commits, err := system.Changes(jirix, p, p.Revision, toRevision)
+		if err != nil {
+			return nil, err
+		}
+		if len(commits) == 0 {
+			continue
+		}
+		paths := map[string]struct{}{}
+		for _, c := range commits {
+			for _, path := range c.Paths {
+				paths[path] = struct{}{}
+			}
+		}
+		changes := projectChanges{
+			Name:     p.Name,
+			Protocol: p.Protocol,
+			From:     p.Revision,
+			To:       toRevision,
+			Commits:  commits,
+			Paths:    []string{},
+		}
+		for path := range paths {
+			changes.Paths = append(changes.Paths, path)
+		}
+		sort.Strings(changes.Paths)
+		result = append(result, changes)
+	}
+	// Projects that are new in the "to" snapshot are changed as a whole.
+	for key, p := range to {
+		if _, ok := from[key]; !ok {
+			result = append(result, projectChanges{
+				Name:     p.Name,
+				Protocol: p.Protocol,
+				To:       p.Revision,
+				Commits:  []commit{},
+				Paths:    []string{},
+			})
+		}
+	}
+	sort.Sort(projectChangesByName(result))
+	return result, nil
+}
+
+// writeChangelog writes the given changelog to the given file.
+func writeChangelog(jirix *jiri.X, path string, c *changelog) error {
+	bytes, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return fmt.Errorf("MarshalIndent(%v) failed: %v", c, err)
+	}
+	s := jirix.NewSeq()
+	return s.MkdirAll(filepath.Dir(path), os.FileMode(0755)).
+		WriteFile(path, bytes, os.FileMode(0644)).Done()
+}
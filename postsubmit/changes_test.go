@@ -0,0 +1,117 @@
+// Copyright 2015 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"v.io/jiri/jiritest"
+	"v.io/jiri/project"
+)
+
+func TestParseGitLog(t *testing.T) {
+	output := "\x01aaa\x00a@example.com\x00100\x00Add foo\n\nfoo/foo.go\nfoo/foo_test.go\n\x01bbb\x00b@example.com\x00200\x00Empty\n\x01ccc\x00c@example.com\x00300\x00Fix bar\n\nbar.go\n"
+	got, err := parseGitLog("release.go.core", output)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	want := []commit{
+		{Project: "release.go.core", Revision: "aaa", Author: "a@example.com", Time: 100, Subject: "Add foo", Paths: []string{"foo/foo.go", "foo/foo_test.go"}},
+		{Project: "release.go.core", Revision: "bbb", Author: "b@example.com", Time: 200, Subject: "Empty"},
+		{Project: "release.go.core", Revision: "ccc", Author: "c@example.com", Time: 300, Subject: "Fix bar", Paths: []string{"bar.go"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %#v, want %#v", got, want)
+	}
+}
+
+func TestCommandVCS(t *testing.T) {
+	jirix, cleanup := jiritest.NewX(t)
+	defer cleanup()
+
+	commands := vcsCommands{}
+	if err := commands.Set("hg"); err == nil {
+		t.Fatalf("setting a VCS command without a protocol did not fail")
+	}
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+	script := filepath.Join(dir, "changes.sh")
+	contents := `#!/bin/sh
+echo "[{\"Revision\": \"$2\", \"Author\": \"a@example.com\", \"Subject\": \"From $1\", \"Paths\": [\"$(basename $(pwd))\"]}]"
+`
+	if err := ioutil.WriteFile(script, []byte(contents), os.FileMode(0755)); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := commands.Set("hg=" + script); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if got, want := newVCS("git", commands), (gitVCS{}); got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got := newVCS("svn", commands); got != nil {
+		t.Fatalf("got %v, want no VCS", got)
+	}
+	system := newVCS("hg", commands)
+	if system == nil {
+		t.Fatalf("no VCS for protocol hg")
+	}
+	p := project.Project{Name: "third_party", Path: dir, Protocol: "hg"}
+	got, err := system.Changes(jirix, p, "1", "2")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	want := []commit{{Project: "third_party", Revision: "2", Author: "a@example.com", Subject: "From 1", Paths: []string{filepath.Base(dir)}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %#v, want %#v", got, want)
+	}
+}
+
+func TestDiffProjects(t *testing.T) {
+	jirix, cleanup := jiritest.NewX(t)
+	defer cleanup()
+
+	from := project.Projects{
+		"a": project.Project{Name: "a", Path: filepath.Join(jirix.Root, "a"), Protocol: "git", Revision: "1"},
+		"b": project.Project{Name: "b", Path: filepath.Join(jirix.Root, "b"), Protocol: "git", Revision: "1"},
+	}
+	to := project.Projects{
+		"a": project.Project{Name: "a", Path: filepath.Join(jirix.Root, "a"), Protocol: "git", Revision: "1"},
+		"c": project.Project{Name: "c", Path: filepath.Join(jirix.Root, "c"), Protocol: "git", Revision: "2"},
+	}
+	got, err := diffProjects(jirix, from, to, vcsCommands{})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	want := []projectChanges{
+		{Name: "b", Protocol: "git", From: "1", Removed: true, Commits: []commit{}, Paths: []string{}},
+		{Name: "c", Protocol: "git", To: "2", Commits: []commit{}, Paths: []string{}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %#v, want %#v", got, want)
+	}
+
+	// Without a "to" snapshot, projects missing from the local checkout
+	// are removed.
+	if err := os.MkdirAll(filepath.Join(jirix.Root, "a"), os.FileMode(0755)); err != nil {
+		t.Fatalf("%v", err)
+	}
+	from["a"] = project.Project{Name: "a", Path: filepath.Join(jirix.Root, "a"), Protocol: "none", Revision: "1"}
+	if got, err = diffProjects(jirix, from, nil, vcsCommands{}); err != nil {
+		t.Fatalf("%v", err)
+	}
+	want = []projectChanges{
+		{Name: "b", Protocol: "git", From: "1", Removed: true, Commits: []commit{}, Paths: []string{}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %#v, want %#v", got, want)
+	}
+}
@@ -9,12 +9,13 @@ package main
 
 import (
 	"fmt"
+	"net/url"
+	"path/filepath"
 	"strings"
 
 	"v.io/jiri"
-	"v.io/jiri/gitutil"
-	"v.io/jiri/project"
 	"v.io/jiri/tool"
+	"v.io/x/devtools/internal/ci"
 	"v.io/x/devtools/internal/test"
 	"v.io/x/devtools/tooldata"
 	"v.io/x/lib/cmdline"
@@ -22,10 +23,12 @@ import (
 
 var (
 	jenkinsHostFlag string
+	localQueueFlag  string
 )
 
 func init() {
 	cmdRoot.Flags.StringVar(&jenkinsHostFlag, "host", "", "The Jenkins host. Presubmit will not send any CLs to an empty host.")
+	cmdRoot.Flags.StringVar(&localQueueFlag, "local-queue", "", "Directory of a local build queue to use instead of the Jenkins host.")
 
 	tool.InitializeProjectFlags(&cmdPoll.Flags)
 	tool.InitializeRunFlags(&cmdRoot.Flags)
@@ -50,14 +53,48 @@ var cmdPoll = &cmdline.Command{
 	Runner: jiri.RunnerFunc(runPoll),
 	Name:   "poll",
 	Short:  "Poll changes and start corresponding builds on Jenkins",
-	Long:   "Poll changes and start corresponding builds on Jenkins.",
+	Long: `
+Poll changes and start corresponding builds on Jenkins.
+
+With -find-culprits, poll also watches the tests it starts builds of. When a
+test goes from passing to failing, poll bisects the commits between the
+snapshots of the two builds, running only the failing test at each step in the
+separate checkout given by -bisect-root, and reports the first failing commit
+to the oncall by email and to the review thread of the commit. Builds record
+their snapshot in the SNAPSHOT parameter, which the Jenkins jobs must define.
+
+Changes are detected between the -from and -to snapshots, which default to the
+snapshot of the second latest update and the master branches of the local
+projects. Git projects are supported out of the box; projects that use other
+version control systems are supported through commands given with the
+-vcs-command flag. With -changelog, poll writes the detected changes to a JSON
+file, listing the changed projects with their commit ranges, commits, authors
+and changed paths, and the removed projects.
+`,
 }
 
 func runPoll(jirix *jiri.X, _ []string) error {
-	projects, err := getChangedProjectsFromSnapshot(jirix, jirix.UpdateHistorySecondLatestLink())
+	// Look for the culprits of tests that started failing.
+	if findCulpritsFlag {
+		if err := findCulprits(jirix); err != nil {
+			fmt.Fprintf(jirix.Stderr(), "%v\n", err)
+		}
+	}
+
+	fromSnapshot := fromFlag
+	if fromSnapshot == "" {
+		fromSnapshot = jirix.UpdateHistorySecondLatestLink()
+	}
+	changes, err := detectChanges(jirix, fromSnapshot, toFlag, vcsCommandsFlag)
 	if err != nil {
 		return err
 	}
+	if changelogFlag != "" {
+		if err := writeChangelog(jirix, changelogFlag, changes); err != nil {
+			return err
+		}
+	}
+	projects := changes.projectNames()
 	if len(projects) == 0 {
 		fmt.Fprintf(jirix.Stdout(), "No changes.\n")
 		return nil
@@ -73,39 +110,16 @@ func runPoll(jirix *jiri.X, _ []string) error {
 
 	// Start Jenkins tests.
 	fmt.Fprintf(jirix.Stdout(), "\nStarting new builds:\n")
-	if err := startJenkinsTests(jirix, jenkinsTests); err != nil {
+	if err := startJenkinsTests(jirix, jenkinsTests, toFlag); err != nil {
 		return err
 	}
-
-	return nil
-}
-
-// getChangedProjectsFromSnapshot returns a slice of projects that have changes
-// by comparing the revisions in the given snapshot with master branches.
-func getChangedProjectsFromSnapshot(jirix *jiri.X, snapshotFile string) ([]string, error) {
-	projects, _, err := project.LoadSnapshotFile(jirix, snapshotFile)
-	if err != nil {
-		return nil, err
-	}
-
-	// Use "git log" to detect changes for each project.
-	//
-	// TODO(jingjin, jsimsa): Add support for non-git projects.
-	changedProjects := []string{}
-	for _, project := range projects {
-		switch project.Protocol {
-		case "git":
-			git := gitutil.New(jirix.NewSeq(), gitutil.RootDirOpt(project.Path))
-			commits, err := git.Log("master", project.Revision, "")
-			if err != nil {
-				return nil, err
-			}
-			if len(commits) != 0 {
-				changedProjects = append(changedProjects, project.Name)
-			}
+	if findCulpritsFlag {
+		if err := watchTests(jirix, jenkinsTests); err != nil {
+			return err
 		}
 	}
-	return changedProjects, nil
+
+	return nil
 }
 
 // jenkinsTestsToStart returns a list of jenkins tests that need to be
@@ -121,17 +135,38 @@ func jenkinsTestsToStart(jirix *jiri.X, projects []string) ([]string, error) {
 	return config.ProjectTests(projects), nil
 }
 
-// startJenkinsTests uses Jenkins API to start a build to each of the
-// given Jenkins tests.
-func startJenkinsTests(jirix *jiri.X, tests []string) error {
-	jenkins, err := jirix.Jenkins(jenkinsHostFlag)
+// newExecutor returns the executor that runs the builds of postsubmit
+// tests, as identified by the command-line flags.
+func newExecutor(jirix *jiri.X) (ci.Executor, error) {
+	if localQueueFlag != "" {
+		return ci.NewLocal(localQueueFlag)
+	}
+	return ci.NewJenkins(jirix, jenkinsHostFlag)
+}
+
+// startJenkinsTests starts a build of each of the given Jenkins tests.
+// The builds record the given snapshot, or if it is empty the snapshot
+// of the latest update, which identifies the revisions they test.
+func startJenkinsTests(jirix *jiri.X, tests []string, snapshot string) error {
+	executor, err := newExecutor(jirix)
 	if err != nil {
 		return err
 	}
 
+	if snapshot == "" {
+		snapshot = jirix.UpdateHistoryLatestLink()
+	}
+	var params url.Values
+	if path, err := filepath.EvalSymlinks(snapshot); err != nil {
+		fmt.Fprintf(jirix.Stderr(), "EvalSymlinks(%v) failed: %v\n", snapshot, err)
+	} else if path, err = filepath.Abs(path); err != nil {
+		fmt.Fprintf(jirix.Stderr(), "Abs(%v) failed: %v\n", path, err)
+	} else {
+		params = url.Values{"SNAPSHOT": {path}}
+	}
 	for _, t := range tests {
 		msg := fmt.Sprintf("add build to %q\n", t)
-		if err := jenkins.AddBuild(t); err == nil {
+		if err := executor.Enqueue(t, params); err == nil {
 			test.Pass(jirix.Context, "%s", msg)
 		} else {
 			test.Fail(jirix.Context, "%s", msg)
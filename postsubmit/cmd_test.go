@@ -5,10 +5,14 @@
 package main
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 
 	"v.io/jiri/jiritest"
+	"v.io/x/devtools/internal/ci"
 	"v.io/x/devtools/tooldata"
 )
 
@@ -64,3 +68,53 @@ func TestJenkinsTestsToStart(t *testing.T) {
 		}
 	}
 }
+
+func TestStartJenkinsTests(t *testing.T) {
+	fake, cleanup := jiritest.NewFakeJiriRoot(t)
+	defer cleanup()
+
+	queue, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(queue)
+	oldQueue := localQueueFlag
+	defer func() { localQueueFlag = oldQueue }()
+	localQueueFlag = queue
+	jobs := `{"vanadium-go-test": "true"}`
+	if err := ioutil.WriteFile(filepath.Join(queue, "jobs.json"), []byte(jobs), os.FileMode(0644)); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// The builds record the snapshot given by -to, resolved to the file
+	// it links to.
+	snapshot := filepath.Join(fake.X.Root, "snapshot")
+	if err := ioutil.WriteFile(snapshot, []byte("<manifest></manifest>"), os.FileMode(0644)); err != nil {
+		t.Fatalf("%v", err)
+	}
+	link := filepath.Join(fake.X.Root, "latest")
+	if err := os.Symlink(snapshot, link); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := startJenkinsTests(fake.X, []string{"vanadium-go-test"}, link); err != nil {
+		t.Fatalf("%v", err)
+	}
+	local, err := ci.NewLocal(queue)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	builds, err := local.Queued("vanadium-go-test")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if got, want := len(builds), 1; got != want {
+		t.Fatalf("got %v builds, want %v", got, want)
+	}
+	want, err := filepath.EvalSymlinks(snapshot)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if got := builds[0].Params.Get("SNAPSHOT"); got != want {
+		t.Fatalf("got snapshot %q, want %q", got, want)
+	}
+}
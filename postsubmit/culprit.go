@@ -0,0 +1,504 @@
+// Copyright 2015 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/smtp"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"v.io/jiri"
+	"v.io/jiri/project"
+	"v.io/jiri/runutil"
+	"v.io/x/devtools/internal/ci"
+	"v.io/x/devtools/internal/review"
+	"v.io/x/devtools/internal/test"
+	"v.io/x/devtools/tooldata"
+	"v.io/x/lib/envvar"
+)
+
+const (
+	defaultCulpritFile = "${HOME}/tmp/postsubmit_culprits.json"
+	defaultMailDomain  = "google.com"
+	defaultSMTPServer  = "localhost:25"
+)
+
+var (
+	bisectRootFlag   string
+	culpritFileFlag  string
+	findCulpritsFlag bool
+	mailDomainFlag   string
+	mailFromFlag     string
+	smtpServerFlag   string
+)
+
+func init() {
+	cmdPoll.Flags.StringVar(&bisectRootFlag, "bisect-root", "", "The root of a separate jiri checkout, with the same projects as the checkout of poll, in which the commits are bisected to find culprits. Required by -find-culprits.")
+	cmdPoll.Flags.StringVar(&culpritFileFlag, "culprit-file", os.ExpandEnv(defaultCulpritFile), "The file that stores the latest builds of the tests watched for culprits.")
+	cmdPoll.Flags.Lookup("culprit-file").DefValue = defaultCulpritFile
+	cmdPoll.Flags.BoolVar(&findCulpritsFlag, "find-culprits", false, "Find the commit that broke a test when it starts failing, by bisecting the commits between the snapshots of its last passing build and its failing build, and report it to the oncall by email and to the review thread of the commit.")
+	cmdPoll.Flags.StringVar(&mailDomainFlag, "mail-domain", defaultMailDomain, "The domain appended to the ldaps of the oncall to get their email addresses.")
+	cmdPoll.Flags.StringVar(&mailFromFlag, "mail-from", "", "The sender of the culprit reports sent to the oncall. Defaults to postsubmit@<mail-domain>.")
+	cmdPoll.Flags.StringVar(&smtpServerFlag, "smtp-server", defaultSMTPServer, "The address of the SMTP server that sends the culprit reports to the oncall.")
+}
+
+// testBuild records a completed build of a watched test.
+type testBuild struct {
+	// Number is the build number.
+	Number int
+	// Result is the result of the build.
+	Result string
+	// Snapshot identifies the snapshot the build tested.
+	Snapshot string
+}
+
+// culpritState maps the watched tests to their latest builds.
+type culpritState map[string]testBuild
+
+// loadCulpritState reads the state stored in the given file. A missing
+// file represents no watched tests.
+func loadCulpritState(jirix *jiri.X, path string) (culpritState, error) {
+	state := culpritState{}
+	bytes, err := jirix.NewSeq().ReadFile(path)
+	if err != nil {
+		if runutil.IsNotExist(err) {
+			return state, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(bytes, &state); err != nil {
+		return nil, fmt.Errorf("Unmarshal(%v) failed: %v", path, err)
+	}
+	return state, nil
+}
+
+// saveCulpritState writes the given state to the given file.
+func saveCulpritState(jirix *jiri.X, path string, state culpritState) error {
+	bytes, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("MarshalIndent(%v) failed: %v", state, err)
+	}
+	tmpPath := path + ".tmp"
+	s := jirix.NewSeq()
+	return s.MkdirAll(filepath.Dir(path), os.FileMode(0755)).
+		WriteFile(tmpPath, bytes, os.FileMode(0644)).
+		Rename(tmpPath, path).Done()
+}
+
+// watchTests adds the given tests to the tests watched for culprits.
+func watchTests(jirix *jiri.X, tests []string) error {
+	state, err := loadCulpritState(jirix, culpritFileFlag)
+	if err != nil {
+		return err
+	}
+	for _, test := range tests {
+		if _, ok := state[test]; !ok {
+			state[test] = testBuild{}
+		}
+	}
+	return saveCulpritState(jirix, culpritFileFlag, state)
+}
+
+// findCulprits checks the latest builds of the watched tests, and looks
+// for the culprits of the tests that went from passing to failing.
+func findCulprits(jirix *jiri.X) error {
+	if bisectRootFlag == "" {
+		return jirix.UsageErrorf("-find-culprits requires -bisect-root")
+	}
+	if filepath.Clean(bisectRootFlag) == filepath.Clean(jirix.Root) {
+		return jirix.UsageErrorf("-bisect-root must not be the root of the checkout of poll")
+	}
+	state, err := loadCulpritState(jirix, culpritFileFlag)
+	if err != nil {
+		return err
+	}
+	executor, err := newExecutor(jirix)
+	if err != nil {
+		return err
+	}
+	reporter := newCulpritReporter()
+	finder := culpritFinder{
+		commitsFn: commitsBetween,
+		runTestFn: runTestAt,
+		reportFn:  reporter.report,
+	}
+	tests := []string{}
+	for test := range state {
+		tests = append(tests, test)
+	}
+	sort.Strings(tests)
+	for _, test := range tests {
+		prev := state[test]
+		build, err := executor.LastCompleted(test, nil)
+		if err != nil {
+			fmt.Fprintf(jirix.Stderr(), "%v\n", err)
+			continue
+		}
+		if build.Number <= prev.Number || (build.Result != ci.ResultSuccess && build.Result != ci.ResultFailure) {
+			continue
+		}
+		cur := testBuild{Number: build.Number, Result: build.Result, Snapshot: build.Params.Get("SNAPSHOT")}
+		if prev.Result == ci.ResultSuccess && cur.Result == ci.ResultFailure {
+			if prev.Snapshot == "" || cur.Snapshot == "" {
+				fmt.Fprintf(jirix.Stderr(), "Cannot find the culprit of %v: build %d or %d does not record its snapshot.\n", test, prev.Number, cur.Number)
+			} else if err := finder.findAndReport(jirix, test, prev.Snapshot, cur.Snapshot); err != nil {
+				fmt.Fprintf(jirix.Stderr(), "%v\n", err)
+			}
+		}
+		state[test] = cur
+	}
+	return saveCulpritState(jirix, culpritFileFlag, state)
+}
+
+// commit represents a commit of a project.
+type commit struct {
+	Project  string
+	Revision string
+	Author   string
+	Time     int64
+	Subject  string
+	// Paths lists the paths the commit changes, if known.
+	Paths []string `json:",omitempty"`
+}
+
+// culpritFinder finds the commits that break tests.
+type culpritFinder struct {
+	// commitsFn returns the commits between the given good and bad
+	// projects, oldest first.
+	commitsFn func(jirix *jiri.X, good, bad project.Projects) ([]commit, error)
+	// runTestFn runs the given test at the given revisions of the given
+	// projects and returns whether the test passed.
+	runTestFn func(jirix *jiri.X, test string, projects project.Projects) (bool, error)
+	// reportFn reports the given culprit of the given test, which is a
+	// commit of the given project.
+	reportFn func(jirix *jiri.X, test string, culprit commit, p project.Project) error
+}
+
+// findAndReport finds and reports the culprit of the given test between
+// the given snapshots.
+func (f *culpritFinder) findAndReport(jirix *jiri.X, test, goodSnapshot, badSnapshot string) error {
+	good, _, err := project.LoadSnapshotFile(jirix, goodSnapshot)
+	if err != nil {
+		return err
+	}
+	bad, _, err := project.LoadSnapshotFile(jirix, badSnapshot)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(jirix.Stdout(), "\nFinding the culprit of %v:\n", test)
+	culprit, err := f.find(jirix, test, good, bad)
+	if err != nil {
+		return err
+	}
+	if culprit == nil {
+		fmt.Fprintf(jirix.Stdout(), "No culprit found: %v does not fail at the revisions of %v.\n", test, badSnapshot)
+		return nil
+	}
+	for _, p := range bad {
+		if p.Name == culprit.Project {
+			return f.reportFn(jirix, test, *culprit, p)
+		}
+	}
+	return fmt.Errorf("project %q of culprit %v not found in %v", culprit.Project, culprit.Revision, badSnapshot)
+}
+
+// find bisects the commits between the given good projects, at which the
+// given test passed, and the given bad projects, at which it failed, to
+// identify the first commit at which the test fails. It returns nil if
+// the test does not fail at the bad projects when it is run again, for
+// example because it is flaky.
+func (f *culpritFinder) find(jirix *jiri.X, test string, good, bad project.Projects) (*commit, error) {
+	commits, err := f.commitsFn(jirix, good, bad)
+	if err != nil {
+		return nil, err
+	}
+	if len(commits) == 0 {
+		return nil, nil
+	}
+	passes := func(n int) (bool, error) {
+		projects := revisionsAfter(good, bad, commits[:n])
+		passed, err := f.runTestFn(jirix, test, projects)
+		if err != nil {
+			return false, err
+		}
+		fmt.Fprintf(jirix.Stdout(), "%v at %d of %d commits: passed=%v\n", test, n, len(commits), passed)
+		return passed, nil
+	}
+	// Check that the failure reproduces.
+	if passed, err := passes(len(commits)); err != nil || passed {
+		return nil, err
+	}
+	// The test passes with the first "lo" commits and fails with the
+	// first "hi" commits.
+	lo, hi := 0, len(commits)
+	for hi-lo > 1 {
+		mid := (lo + hi) / 2
+		passed, err := passes(mid)
+		if err != nil {
+			return nil, err
+		}
+		if passed {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return &commits[hi-1], nil
+}
+
+// revisionsAfter returns the bad projects with the revisions of the
+// projects that the given commits do not touch reset to the good
+// revisions, and the revisions of the other projects set to their last
+// given commit.
+func revisionsAfter(good, bad project.Projects, commits []commit) project.Projects {
+	projects := project.Projects{}
+	for key, p := range bad {
+		if goodProject, ok := good[key]; ok {
+			p.Revision = goodProject.Revision
+		}
+		projects[key] = p
+	}
+	for _, c := range commits {
+		for key, p := range projects {
+			if p.Name == c.Project {
+				p.Revision = c.Revision
+				projects[key] = p
+			}
+		}
+	}
+	return projects
+}
+
+// commitsBetween returns the commits of git projects between the given
+// good and bad projects. Commits of different projects are ordered by
+// their commit time, and commits of the same project are kept in the
+// order of their history.
+func commitsBetween(jirix *jiri.X, good, bad project.Projects) ([]commit, error) {
+	commitsByProject := [][]commit{}
+	for key, p := range bad {
+		goodProject, ok := good[key]
+		if !ok || p.Protocol != "git" || goodProject.Revision == p.Revision {
+			continue
+		}
+		commits, err := gitVCS{}.Changes(jirix, p, goodProject.Revision, p.Revision)
+		if err != nil {
+			return nil, err
+		}
+		if len(commits) > 0 {
+			commitsByProject = append(commitsByProject, commits)
+		}
+	}
+	return mergeCommits(commitsByProject), nil
+}
+
+// mergeCommits merges the given commit sequences into one sequence
+// ordered by commit time, preserving the order within each sequence.
+func mergeCommits(sequences [][]commit) []commit {
+	// Sort the sequences by project name, so that commits with the same
+	// time are merged deterministically.
+	nonEmpty := commitSequences{}
+	for _, sequence := range sequences {
+		if len(sequence) > 0 {
+			nonEmpty = append(nonEmpty, sequence)
+		}
+	}
+	sort.Sort(nonEmpty)
+	sequences = nonEmpty
+	merged := []commit{}
+	for {
+		next := -1
+		for i, sequence := range sequences {
+			if len(sequence) > 0 && (next == -1 || sequence[0].Time < sequences[next][0].Time) {
+				next = i
+			}
+		}
+		if next == -1 {
+			return merged
+		}
+		merged = append(merged, sequences[next][0])
+		sequences[next] = sequences[next][1:]
+	}
+}
+
+type commitSequences [][]commit
+
+func (s commitSequences) Len() int           { return len(s) }
+func (s commitSequences) Less(i, j int) bool { return s[i][0].Project < s[j][0].Project }
+func (s commitSequences) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// runTestAt checks out the revisions of the given projects in the
+// checkout rooted at -bisect-root, and runs the given test there. The
+// revisions are fetched from the projects of the checkout of poll, which
+// is left untouched.
+func runTestAt(jirix *jiri.X, test string, projects project.Projects) (bool, error) {
+	for _, p := range projects {
+		if p.Protocol != "git" {
+			continue
+		}
+		relPath, err := filepath.Rel(jirix.Root, p.Path)
+		if err != nil {
+			return false, fmt.Errorf("Rel(%v, %v) failed: %v", jirix.Root, p.Path, err)
+		}
+		bisectPath := filepath.Join(bisectRootFlag, relPath)
+		s := jirix.NewSeq()
+		if err := s.Pushd(bisectPath).
+			Run("git", "fetch", "--quiet", p.Path).
+			Last("git", "checkout", "--quiet", "--detach", p.Revision); err != nil {
+			return false, err
+		}
+	}
+	env := envvar.MergeMaps(jirix.Env(), map[string]string{"JIRI_ROOT": bisectRootFlag})
+	err := jirix.NewSeq().Env(env).Pushd(bisectRootFlag).Capture(jirix.Stdout(), jirix.Stderr()).Last("jiri-test", "run", test)
+	if err == nil {
+		return true, nil
+	}
+	// Only test failures, which include failures to build the tested
+	// code, count against the checked out commits. Other errors, such as
+	// a failure to set up the test, abort the bisection.
+	if isTestFailure(err) {
+		return false, nil
+	}
+	return false, err
+}
+
+// isTestFailure determines whether the given error of a "jiri-test"
+// command reports failed tests, as opposed to a failure to run them.
+func isTestFailure(err error) bool {
+	exiterr, ok := runutil.GetOriginalError(err).(*exec.ExitError)
+	if !ok {
+		return false
+	}
+	status, ok := exiterr.Sys().(syscall.WaitStatus)
+	return ok && status.ExitStatus() == test.FailedExitCode
+}
+
+// culpritReporter reports culprits to the oncall and to the review
+// threads of the culprits.
+type culpritReporter struct {
+	// oncallFn returns the current oncall shift.
+	oncallFn func(jirix *jiri.X) (*tooldata.OncallShift, error)
+	// sendMailFn sends an email.
+	sendMailFn func(addr string, a smtp.Auth, from string, to []string, msg []byte) error
+	// reviewSystemFn returns the code review system of the given Gerrit
+	// host.
+	reviewSystemFn func(jirix *jiri.X, host string) (review.System, error)
+}
+
+func newCulpritReporter() *culpritReporter {
+	return &culpritReporter{
+		oncallFn: func(jirix *jiri.X) (*tooldata.OncallShift, error) {
+			return tooldata.Oncall(jirix, time.Now())
+		},
+		sendMailFn: smtp.SendMail,
+		reviewSystemFn: func(jirix *jiri.X, host string) (review.System, error) {
+			u, err := url.Parse(host)
+			if err != nil {
+				return nil, fmt.Errorf("Parse(%q) failed: %v", host, err)
+			}
+			return review.NewGerrit(jirix, u), nil
+		},
+	}
+}
+
+// report reports the given culprit of the given test, which is a commit
+// of the given project, to the oncall by email and to the review thread
+// of the culprit on the Gerrit host of the project.
+func (r *culpritReporter) report(jirix *jiri.X, test string, culprit commit, p project.Project) error {
+	oncall, recipients := "unknown", []string{}
+	if shift, err := r.oncallFn(jirix); err != nil {
+		fmt.Fprintf(jirix.Stderr(), "%v\n", err)
+	} else if shift != nil {
+		oncall = fmt.Sprintf("%s, %s", shift.Primary, shift.Secondary)
+		for _, ldap := range []string{shift.Primary, shift.Secondary} {
+			if ldap != "" {
+				recipients = append(recipients, ldap)
+			}
+		}
+	}
+	subject := fmt.Sprintf("Postsubmit test %s started failing at commit %s of %s", test, shortRevision(culprit.Revision), culprit.Project)
+	message := fmt.Sprintf("Postsubmit test %s started failing at commit %s of %s by %s:\n%s\n\nCurrent Oncall: %s\n", test, culprit.Revision, culprit.Project, culprit.Author, culprit.Subject, oncall)
+	fmt.Fprintf(jirix.Stdout(), "\n### CULPRIT FOUND\n%s", message)
+
+	errs := []string{}
+	if err := r.mailOncall(recipients, subject, message); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if err := r.postReview(jirix, culprit, p, message); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to report the culprit of %v:\n%v", test, strings.Join(errs, "\n"))
+	}
+	return nil
+}
+
+// mailOncall sends the given report to the given oncall ldaps.
+func (r *culpritReporter) mailOncall(ldaps []string, subject, message string) error {
+	if len(ldaps) == 0 {
+		return fmt.Errorf("no oncall to send the culprit report to")
+	}
+	from := mailFromFlag
+	if from == "" {
+		from = "postsubmit@" + mailDomainFlag
+	}
+	to := []string{}
+	for _, ldap := range ldaps {
+		if !strings.Contains(ldap, "@") {
+			ldap += "@" + mailDomainFlag
+		}
+		to = append(to, ldap)
+	}
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", from)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&msg, "\r\n")
+	msg.WriteString(strings.Replace(message, "\n", "\r\n", -1))
+	if err := r.sendMailFn(smtpServerFlag, nil, from, to, msg.Bytes()); err != nil {
+		return fmt.Errorf("SendMail(%v) failed: %v", smtpServerFlag, err)
+	}
+	return nil
+}
+
+// postReview posts the given report to the review thread of the given
+// culprit, which is a commit of the given project.
+func (r *culpritReporter) postReview(jirix *jiri.X, culprit commit, p project.Project, message string) error {
+	if p.GerritHost == "" {
+		fmt.Fprintf(jirix.Stdout(), "No review thread for commit %s: project %s has no Gerrit host.\n", culprit.Revision, p.Name)
+		return nil
+	}
+	system, err := r.reviewSystemFn(jirix, p.GerritHost)
+	if err != nil {
+		return err
+	}
+	changes, err := system.Query(fmt.Sprintf("commit:%s", culprit.Revision))
+	if err != nil {
+		return err
+	}
+	if len(changes) == 0 {
+		fmt.Fprintf(jirix.Stdout(), "No review thread found for commit %s.\n", culprit.Revision)
+		return nil
+	}
+	// The culprit was submitted, so the verification vote of its
+	// presubmit tests is left alone.
+	return system.PostMessage(changes[0], message)
+}
+
+// shortRevision returns the abbreviated form of the given revision.
+func shortRevision(revision string) string {
+	if len(revision) > 12 {
+		return revision[:12]
+	}
+	return revision
+}
@@ -0,0 +1,203 @@
+// Copyright 2015 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"net/smtp"
+	"reflect"
+	"strings"
+	"testing"
+
+	"v.io/jiri"
+	"v.io/jiri/jiritest"
+	"v.io/jiri/project"
+	"v.io/x/devtools/internal/review"
+	"v.io/x/devtools/tooldata"
+)
+
+func TestMergeCommits(t *testing.T) {
+	a := []commit{{Project: "a", Revision: "a1", Time: 1}, {Project: "a", Revision: "a2", Time: 5}}
+	// Commits of a project are not reordered, even if their times are not
+	// increasing.
+	b := []commit{{Project: "b", Revision: "b1", Time: 3}, {Project: "b", Revision: "b2", Time: 2}, {Project: "b", Revision: "b3", Time: 6}}
+	got := []string{}
+	for _, c := range mergeCommits([][]commit{b, nil, a}) {
+		got = append(got, c.Revision)
+	}
+	if want := []string{"a1", "b1", "b2", "a2", "b3"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestFindCulprit(t *testing.T) {
+	jirix, cleanup := jiritest.NewX(t)
+	defer cleanup()
+
+	newProjects := func(revisions map[string]string) project.Projects {
+		projects := project.Projects{}
+		for name, revision := range revisions {
+			projects[project.ProjectKey(name)] = project.Project{Name: name, Protocol: "git", Revision: revision}
+		}
+		return projects
+	}
+	good := newProjects(map[string]string{"release.go.core": "go0", "release.js.core": "js0"})
+	bad := newProjects(map[string]string{"release.go.core": "go3", "release.js.core": "js2"})
+	commits := []commit{
+		{Project: "release.go.core", Revision: "go1", Time: 1},
+		{Project: "release.js.core", Revision: "js1", Time: 2},
+		{Project: "release.go.core", Revision: "go2", Time: 3},
+		{Project: "release.go.core", Revision: "go3", Time: 4},
+		{Project: "release.js.core", Revision: "js2", Time: 5},
+	}
+	index := map[string]int{"go0": -1, "js0": -1}
+	for i, c := range commits {
+		index[c.Revision] = i
+	}
+
+	for culprit := range commits {
+		runs := 0
+		finder := culpritFinder{
+			commitsFn: func(jirix *jiri.X, gotGood, gotBad project.Projects) ([]commit, error) {
+				if !reflect.DeepEqual(gotGood, good) || !reflect.DeepEqual(gotBad, bad) {
+					return nil, fmt.Errorf("unexpected projects")
+				}
+				return commits, nil
+			},
+			// The test fails at the revisions that include the culprit.
+			runTestFn: func(jirix *jiri.X, test string, projects project.Projects) (bool, error) {
+				runs++
+				p := projects[project.ProjectKey(commits[culprit].Project)]
+				return index[p.Revision] < culprit, nil
+			},
+		}
+		got, err := finder.find(jirix, "vanadium-go-test", good, bad)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		if got == nil || got.Revision != commits[culprit].Revision {
+			t.Fatalf("got culprit %v, want %v", got, commits[culprit])
+		}
+		// Bisecting 5 commits takes at most 3 runs, after checking that
+		// the failure reproduces.
+		if runs > 4 {
+			t.Fatalf("got %d runs, want at most 4", runs)
+		}
+	}
+
+	// No culprit is found if the failure does not reproduce.
+	finder := culpritFinder{
+		commitsFn: func(*jiri.X, project.Projects, project.Projects) ([]commit, error) { return commits, nil },
+		runTestFn: func(*jiri.X, string, project.Projects) (bool, error) { return true, nil },
+	}
+	if got, err := finder.find(jirix, "vanadium-go-test", good, bad); err != nil || got != nil {
+		t.Fatalf("got %v, %v, want no culprit", got, err)
+	}
+
+	// Errors other than test failures abort the bisection.
+	runs := 0
+	finder.runTestFn = func(*jiri.X, string, project.Projects) (bool, error) {
+		runs++
+		if runs > 1 {
+			return false, fmt.Errorf("checkout failed")
+		}
+		return false, nil
+	}
+	if got, err := finder.find(jirix, "vanadium-go-test", good, bad); err == nil || got != nil {
+		t.Fatalf("got %v, %v, want an error", got, err)
+	}
+	if got, want := runs, 2; got != want {
+		t.Fatalf("got %d runs, want %d", got, want)
+	}
+}
+
+// fakeReviewSystem records the reviews posted to the changes of a fake
+// code review system.
+type fakeReviewSystem struct {
+	changes []review.Change
+	queries []string
+	reviews map[int]string
+}
+
+func (s *fakeReviewSystem) Query(query string) ([]review.Change, error) {
+	s.queries = append(s.queries, query)
+	return s.changes, nil
+}
+
+func (s *fakeReviewSystem) PostReview(change review.Change, message string, verified bool) error {
+	return fmt.Errorf("unexpected vote on change %d", change.Number)
+}
+
+func (s *fakeReviewSystem) PostMessage(change review.Change, message string) error {
+	s.reviews[change.Number] = message
+	return nil
+}
+
+func (s *fakeReviewSystem) Submit(change review.Change) error {
+	return fmt.Errorf("unexpected submit of change %d", change.Number)
+}
+
+func TestReportCulprit(t *testing.T) {
+	jirix, cleanup := jiritest.NewX(t)
+	defer cleanup()
+
+	system := &fakeReviewSystem{
+		changes: []review.Change{{Number: 1000, Patchset: 2, Project: "release.go.core"}},
+		reviews: map[int]string{},
+	}
+	var mailTo []string
+	var mail string
+	reporter := culpritReporter{
+		oncallFn: func(*jiri.X) (*tooldata.OncallShift, error) {
+			return &tooldata.OncallShift{Primary: "alice", Secondary: "bob@example.com"}, nil
+		},
+		sendMailFn: func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+			mailTo, mail = to, string(msg)
+			return nil
+		},
+		reviewSystemFn: func(jirix *jiri.X, host string) (review.System, error) {
+			if got, want := host, "https://vanadium-review.googlesource.com"; got != want {
+				return nil, fmt.Errorf("got host %v, want %v", got, want)
+			}
+			return system, nil
+		},
+	}
+	culprit := commit{Project: "release.go.core", Revision: "0123456789abcdef", Author: "carol@example.com", Subject: "Break the test"}
+	p := project.Project{Name: "release.go.core", GerritHost: "https://vanadium-review.googlesource.com"}
+	if err := reporter.report(jirix, "vanadium-go-test", culprit, p); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// The oncall is notified by email.
+	if got, want := mailTo, []string{"alice@" + mailDomainFlag, "bob@example.com"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got recipients %v, want %v", got, want)
+	}
+	for _, want := range []string{
+		"Subject: Postsubmit test vanadium-go-test started failing at commit 0123456789ab of release.go.core\r\n",
+		"by carol@example.com:\r\nBreak the test\r\n",
+		"Current Oncall: alice, bob@example.com\r\n",
+	} {
+		if !strings.Contains(mail, want) {
+			t.Fatalf("%q not found in:\n%v", want, mail)
+		}
+	}
+	// The report is posted to the review thread of the culprit.
+	if got, want := system.queries, []string{"commit:0123456789abcdef"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got queries %v, want %v", got, want)
+	}
+	if got := system.reviews[1000]; !strings.Contains(got, "started failing at commit 0123456789abcdef") {
+		t.Fatalf("got reviews %v", system.reviews)
+	}
+
+	// Projects without a Gerrit host have no review thread.
+	system.queries = nil
+	p.GerritHost = ""
+	if err := reporter.report(jirix, "vanadium-go-test", culprit, p); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if len(system.queries) != 0 {
+		t.Fatalf("got queries %v, want none", system.queries)
+	}
+}
@@ -20,6 +20,8 @@ The postsubmit flags are:
    Use color to format output.
  -host=
    The Jenkins host. Presubmit will not send any CLs to an empty host.
+ -local-queue=
+   Directory of a local build queue to use instead of the Jenkins host.
  -v=false
    Print verbose output.
 
@@ -33,17 +35,70 @@ Postsubmit poll - Poll changes and start corresponding builds on Jenkins
 
 Poll changes and start corresponding builds on Jenkins.
 
+With -find-culprits, poll also watches the tests it starts builds of. When a
+test goes from passing to failing, poll bisects the commits between the
+snapshots of the two builds, running only the failing test at each step in the
+separate checkout given by -bisect-root, and reports the first failing commit
+to the oncall by email and to the review thread of the commit. Builds record
+their snapshot in the SNAPSHOT parameter, which the Jenkins jobs must define.
+
+Changes are detected between the -from and -to snapshots, which default to the
+snapshot of the second latest update and the master branches of the local
+projects. Git projects are supported out of the box; projects that use other
+version control systems are supported through commands given with the
+-vcs-command flag. With -changelog, poll writes the detected changes to a JSON
+file, listing the changed projects with their commit ranges, commits, authors
+and changed paths, and the removed projects.
+
 Usage:
    postsubmit poll [flags]
 
 The postsubmit poll flags are:
+ -bisect-root=
+   The root of a separate jiri checkout, with the same projects as the checkout
+   of poll, in which the commits are bisected to find culprits. Required by
+   -find-culprits.
+ -changelog=
+   The file to write a JSON changelog of the detected changes to, listing the
+   changed projects with their commit ranges, commits, authors and changed
+   paths, and the removed projects.
+ -culprit-file=${HOME}/tmp/postsubmit_culprits.json
+   The file that stores the latest builds of the tests watched for culprits.
+ -find-culprits=false
+   Find the commit that broke a test when it starts failing, by bisecting the
+   commits between the snapshots of its last passing build and its failing
+   build, and report it to the oncall by email and to the review thread of the
+   commit.
+ -from=
+   The snapshot to detect changes from. Defaults to the snapshot of the second
+   latest update.
+ -mail-domain=google.com
+   The domain appended to the ldaps of the oncall to get their email addresses.
+ -mail-from=
+   The sender of the culprit reports sent to the oncall. Defaults to
+   postsubmit@<mail-domain>.
  -manifest=
    Name of the project manifest.
+ -smtp-server=localhost:25
+   The address of the SMTP server that sends the culprit reports to the oncall.
+ -to=
+   The snapshot to detect changes up to, which the started builds record as the
+   snapshot they test. Defaults to the master branches of the local projects,
+   with the builds recording the snapshot of the latest update.
+ -vcs-command=
+   A <protocol>=<command> pair that identifies the command that detects the
+   changes of projects using the given protocol. The command runs in the project
+   directory with the old and new revisions as arguments (the new revision is
+   empty for the current state of the project), and prints a JSON list of
+   commits with the Revision, Author, Time, Subject and Paths fields. Can be
+   repeated.
 
  -color=true
    Use color to format output.
  -host=
    The Jenkins host. Presubmit will not send any CLs to an empty host.
+ -local-queue=
+   Directory of a local build queue to use instead of the Jenkins host.
  -v=false
    Print verbose output.
 
@@ -13,7 +13,9 @@ import (
 	"regexp"
 	"strings"
 
+	"v.io/jiri"
 	"v.io/jiri/tool"
+	"v.io/x/devtools/internal/ci"
 	"v.io/x/lib/cmdline"
 )
 
@@ -29,12 +31,16 @@ var (
 	gerritBaseUrlFlag      string
 	jenkinsHostFlag        string
 	jenkinsBuildNumberFlag int
+	localQueueFlag         string
+	mergeQueueFlag         bool
 	presubmitTestJobFlag   string
 )
 
 func init() {
 	cmdRoot.Flags.StringVar(&gerritBaseUrlFlag, "url", defaultGerritBaseUrl, "The base url of the gerrit instance.")
 	cmdRoot.Flags.StringVar(&jenkinsHostFlag, "host", "", "The Jenkins host. Presubmit will not send any CLs to an empty host.")
+	cmdRoot.Flags.StringVar(&localQueueFlag, "local-queue", "", "Directory of a local build queue to use instead of the Jenkins host. Builds added to the queue are run by the work command.")
+	cmdRoot.Flags.BoolVar(&mergeQueueFlag, "merge-queue", false, "Submit CLs through the merge queue, which tests batches of approved CLs on top of master, instead of submitting each CL after its presubmit tests pass.")
 	cmdRoot.Flags.StringVar(&presubmitTestJobFlag, "job", defaultPresubmitTestJob, "The name of the Jenkins job to add presubmit-test builds to.")
 
 	tool.InitializeRunFlags(&cmdRoot.Flags)
@@ -49,6 +55,15 @@ func main() {
 	cmdline.Main(cmdRoot)
 }
 
+// newExecutor returns the continuous integration system identified by
+// the command-line flags.
+func newExecutor(jirix *jiri.X) (ci.Executor, error) {
+	if localQueueFlag != "" {
+		return ci.NewLocal(localQueueFlag)
+	}
+	return ci.NewJenkins(jirix, jenkinsHostFlag)
+}
+
 // printf outputs the given message prefixed by outputPrefix, adding a
 // blank line before any messages that start with "###".
 func printf(out io.Writer, format string, args ...interface{}) {
@@ -66,5 +81,5 @@ var cmdRoot = &cmdline.Command{
 	Long: `
 Command presubmit performs Vanadium presubmit related functions.
 `,
-	Children: []*cmdline.Command{cmdQuery, cmdResult, cmdTest},
+	Children: []*cmdline.Command{cmdLocal, cmdQuery, cmdResult, cmdTest, cmdWork},
 }
@@ -11,6 +11,7 @@ import (
 
 	"v.io/jiri"
 	"v.io/jiri/gerrit"
+	"v.io/x/devtools/internal/review"
 	"v.io/x/devtools/internal/test"
 )
 
@@ -22,6 +23,16 @@ func gerritBaseUrl() (*url.URL, error) {
 	return u, nil
 }
 
+// newReviewSystem returns the code review system identified by the
+// command-line flags.
+func newReviewSystem(jirix *jiri.X) (review.System, error) {
+	u, err := gerritBaseUrl()
+	if err != nil {
+		return nil, err
+	}
+	return review.NewGerrit(jirix, u), nil
+}
+
 func genStartPresubmitBuildLink(strRefs, strProjects, strTests string) string {
 	return fmt.Sprintf("%s/%s/buildWithParameters?REFS=%s&PROJECTS=%s&TESTS=%s",
 		jenkinsBaseJobUrl,
@@ -31,53 +42,104 @@ func genStartPresubmitBuildLink(strRefs, strProjects, strTests string) string {
 		url.QueryEscape(strTests))
 }
 
-// postMessage posts the given message to Gerrit.
-func postMessage(jirix *jiri.X, message string, refs []string, success bool) error {
-	refsUsingVerifiedLabel, err := getRefsUsingVerifiedLabel(jirix)
-	if err != nil {
-		return err
-	}
-	value := "1"
-	if !success {
-		value = "-" + value
+// clFromChange converts the given change of the code review system to
+// the Gerrit representation that presubmit records and tests changes
+// in.
+func clFromChange(change review.Change) gerrit.Change {
+	files := gerrit.Files{}
+	for _, file := range change.Files {
+		files[file] = struct{}{}
 	}
-	for _, ref := range refs {
-		labels := map[string]string{}
-		if _, ok := refsUsingVerifiedLabel[ref]; ok {
-			labels["Verified"] = value
+	labels := map[string]map[string]interface{}{}
+	for label, states := range change.Labels {
+		labels[label] = map[string]interface{}{}
+		for _, state := range states {
+			labels[label][state] = struct{}{}
 		}
-		gUrl, err := gerritBaseUrl()
-		if err != nil {
-			return err
-		}
-		if err := jirix.Gerrit(gUrl).PostReview(ref, message, labels); err != nil {
-			return err
+	}
+	cl := gerrit.Change{
+		Change_id:        change.ID,
+		Current_revision: change.Revision,
+		Project:          change.Project,
+		Topic:            change.Topic,
+		Revisions: gerrit.Revisions{
+			change.Revision: gerrit.Revision{
+				Fetch: gerrit.Fetch{Http: gerrit.Http{Ref: change.Ref}},
+				Files: files,
+			},
+		},
+		Owner:         gerrit.Owner{Email: change.Owner},
+		Labels:        labels,
+		AutoSubmit:    change.AutoSubmit,
+		PresubmitTest: gerrit.PresubmitTestType(change.PresubmitTest),
+	}
+	if change.MultiPart != nil {
+		cl.MultiPart = &gerrit.MultiPartCLInfo{
+			Topic: change.MultiPart.Topic,
+			Index: change.MultiPart.Index,
+			Total: change.MultiPart.Total,
 		}
-		test.Pass(jirix.Context, "review posted for %q with labels %v.\n", ref, labels)
 	}
-	return nil
+	return cl
 }
 
-func getRefsUsingVerifiedLabel(jirix *jiri.X) (map[string]struct{}, error) {
-	// Query all open CLs.
-	gUrl, err := gerritBaseUrl()
+// queryCLs returns the CLs that match the given query of the code
+// review system.
+func queryCLs(jirix *jiri.X, query string) (gerrit.CLList, error) {
+	system, err := newReviewSystem(jirix)
 	if err != nil {
 		return nil, err
 	}
-	cls, err := jirix.Gerrit(gUrl).Query(defaultQueryString)
+	changes, err := system.Query(query)
 	if err != nil {
 		return nil, err
 	}
+	cls := gerrit.CLList{}
+	for _, change := range changes {
+		cls = append(cls, clFromChange(change))
+	}
+	return cls, nil
+}
 
-	// Identify the refs that use the "Verified" label.
-	ret := map[string]struct{}{}
-	for _, cl := range cls {
-		if _, ok := cl.Labels["Verified"]; ok {
-			ret[cl.Reference()] = struct{}{}
-		}
+// changeFromRef returns the change of the code review system whose
+// current patchset is identified by the given reference.
+func changeFromRef(ref string) (review.Change, error) {
+	number, patchset, err := gerrit.ParseRefString(ref)
+	if err != nil {
+		return review.Change{}, err
 	}
+	return review.Change{Number: number, Patchset: patchset, Ref: ref}, nil
+}
 
-	return ret, nil
+// changeFromCL returns the change of the code review system that
+// corresponds to the given CL.
+func changeFromCL(cl gerrit.Change) (review.Change, error) {
+	change, err := changeFromRef(cl.Reference())
+	if err != nil {
+		return review.Change{}, err
+	}
+	change.ID = cl.Change_id
+	change.Project = cl.Project
+	return change, nil
+}
+
+// postMessage posts the given message to the code review system.
+func postMessage(jirix *jiri.X, message string, refs []string, success bool) error {
+	system, err := newReviewSystem(jirix)
+	if err != nil {
+		return err
+	}
+	for _, ref := range refs {
+		change, err := changeFromRef(ref)
+		if err != nil {
+			return err
+		}
+		if err := system.PostReview(change, message, success); err != nil {
+			return err
+		}
+		test.Pass(jirix.Context, "review posted for %q (verified=%v).\n", ref, success)
+	}
+	return nil
 }
 
 // getSubmittableCLs extracts CLs that have the AutoSubmit label in the commit
@@ -154,14 +216,18 @@ func getSubmittableCLs(jirix *jiri.X, cls gerrit.CLList) []gerrit.CLList {
 
 // submitCLs submits the given CLs.
 func submitCLs(jirix *jiri.X, cls gerrit.CLList) error {
+	system, err := newReviewSystem(jirix)
+	if err != nil {
+		return err
+	}
 	for _, cl := range cls {
 		curRef := cl.Reference()
 		msg := fmt.Sprintf("submit CL: %s\n", curRef)
-		gUrl, err := gerritBaseUrl()
+		change, err := changeFromCL(cl)
 		if err != nil {
 			return err
 		}
-		if err := jirix.Gerrit(gUrl).Submit(cl.Change_id); err != nil {
+		if err := system.Submit(change); err != nil {
 			test.Fail(jirix.Context, msg)
 			fmt.Fprintf(jirix.Stderr(), "%v\n", err)
 			if err := postMessage(jirix, fmt.Sprintf("Failed to submit CL:\n%v\n", err), []string{curRef}, true); err != nil {
@@ -5,11 +5,15 @@
 package main
 
 import (
+	"io/ioutil"
+	"os"
 	"reflect"
+	"strings"
 	"testing"
 
 	"v.io/jiri/gerrit"
 	"v.io/jiri/jiritest"
+	"v.io/x/devtools/internal/review/reviewtest"
 )
 
 func TestGenStartPresubmitBuildLink(t *testing.T) {
@@ -254,3 +258,108 @@ func TestTestNameWithPartSuffix(t *testing.T) {
 		}
 	}
 }
+
+// TestQueryPostAndSubmitCLs checks that CLs queried from the code
+// review system can be reported to and submitted.
+func TestQueryPostAndSubmitCLs(t *testing.T) {
+	jirix, cleanup := jiritest.NewX(t)
+	defer cleanup()
+
+	fake := reviewtest.NewFakeGerrit()
+	defer fake.Close()
+	home, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(home)
+	if err := fake.WriteNetrc(home); err != nil {
+		t.Fatalf("%v", err)
+	}
+	oldHome, oldURL := os.Getenv("HOME"), gerritBaseUrlFlag
+	defer func() {
+		os.Setenv("HOME", oldHome)
+		gerritBaseUrlFlag = oldURL
+	}()
+	os.Setenv("HOME", home)
+	gerritBaseUrlFlag = fake.URL.String()
+
+	// CL 1000 can be submitted, CL 2000 is not approved yet and CL 3000
+	// is excluded from presubmit tests.
+	approved := map[string]map[string]interface{}{
+		"Code-Review": {"approved": struct{}{}},
+		"Verified":    {"approved": struct{}{}},
+	}
+	submittable := gerrit.GenCL(1000, 1, "release.go.core")
+	submittable.AutoSubmit, submittable.Labels = true, approved
+	unapproved := gerrit.GenCL(2000, 2, "release.go.core")
+	unapproved.AutoSubmit = true
+	unapproved.Labels = map[string]map[string]interface{}{
+		"Code-Review": {},
+		"Verified":    {"approved": struct{}{}},
+	}
+	experimental := gerrit.GenCL(3000, 1, "experimental")
+	for _, cl := range []gerrit.Change{submittable, unapproved, experimental} {
+		if err := fake.AddChange(cl); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+
+	// Query the CLs to test.
+	cls, err := queryCLs(jirix, defaultQueryString)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	refs := []string{}
+	for _, cl := range cls {
+		refs = append(refs, cl.Reference())
+	}
+	if got, want := refs, []string{submittable.Reference(), unapproved.Reference()}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got, want := getSubmittableCLs(jirix, cls), []gerrit.CLList{{cls[0]}}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	// Post the test results.
+	if err := postMessage(jirix, "tests failed", []string{refs[1]}, false); err != nil {
+		t.Fatalf("%v", err)
+	}
+	reviews := fake.Reviews()
+	if got, want := len(reviews), 1; got != want {
+		t.Fatalf("got %v reviews, want %v", got, want)
+	}
+	if got, want := reviews[0], (reviewtest.Review{CL: 2000, Patchset: 2, Message: "tests failed", Labels: map[string]string{"Verified": "-1"}}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %#v, want %#v", got, want)
+	}
+
+	// Only the submittable CL is submitted.
+	for _, ref := range refs {
+		if err := submitPresubmitCLs(jirix, []string{ref}); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+	if got, want := fake.Submitted(), []string{cls[0].Change_id}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i, want := range []bool{true, false} {
+		merged, err := isCLMerged(jirix, cls[i])
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		if got := merged; got != want {
+			t.Fatalf("CL %v: got merged %v, want %v", refs[i], got, want)
+		}
+	}
+
+	// Submitting the CL again fails, which is reported to the CL.
+	if err := submitCLs(jirix, cls[:1]); err != nil {
+		t.Fatalf("%v", err)
+	}
+	reviews = fake.Reviews()
+	if got, want := len(reviews), 2; got != want {
+		t.Fatalf("got %v reviews, want %v", got, want)
+	}
+	if got := reviews[1].Message; !strings.HasPrefix(got, "Failed to submit CL") {
+		t.Fatalf("unexpected message: %v", got)
+	}
+}
@@ -12,9 +12,11 @@ Usage:
    presubmit [flags] <command>
 
 The presubmit commands are:
+   local       Run presubmit tests for local changes
    query       Query open CLs from Gerrit
    result      Process and post test results
    test        Run tests for a CL
+   work        Run builds added to the local queue
    help        Display help for commands or topics
 
 The presubmit flags are:
@@ -24,6 +26,12 @@ The presubmit flags are:
    The Jenkins host. Presubmit will not send any CLs to an empty host.
  -job=vanadium-presubmit-test
    The name of the Jenkins job to add presubmit-test builds to.
+ -local-queue=
+   Directory of a local build queue to use instead of the Jenkins host. Builds
+   added to the queue are run by the work command.
+ -merge-queue=false
+   Submit CLs through the merge queue, which tests batches of approved CLs on
+   top of master, instead of submitting each CL after its presubmit tests pass.
  -url=https://vanadium-review.googlesource.com
    The base url of the gerrit instance.
  -v=false
@@ -35,6 +43,50 @@ The global flags are:
  -time=false
    Dump timing information to stderr before exiting the program.
 
+Presubmit local - Run presubmit tests for local changes
+
+This subcommand runs the tests that presubmit would run for the changes of the
+current branch, on the local checkout and without involving Gerrit or Jenkins.
+
+The changed projects are the local projects that have the current branch
+checked out, and their changes are the files that differ from the point where
+the branch diverged from the base branch, including uncommitted changes. The
+tests are selected from these files using the same configuration as for the
+CLs that presubmit tests, and are run by "jiri-test run". The results are
+summarized in the format of the reports that presubmit posts to Gerrit.
+
+Usage:
+   presubmit local [flags]
+
+The presubmit local flags are:
+ -base=master
+   The branch that the changes to test are based on.
+ -manifest=
+   Name of the project manifest.
+ -n=false
+   Only list the tests that presubmit would run, without running them.
+ -num-test-workers=<runtime.NumCPU()>
+   Set the number of test workers to use when running sub-tests.
+ -top-tests=5
+   The number of tests with the highest resource usage to report.
+
+ -color=true
+   Use color to format output.
+ -host=
+   The Jenkins host. Presubmit will not send any CLs to an empty host.
+ -job=vanadium-presubmit-test
+   The name of the Jenkins job to add presubmit-test builds to.
+ -local-queue=
+   Directory of a local build queue to use instead of the Jenkins host. Builds
+   added to the queue are run by the work command.
+ -merge-queue=false
+   Submit CLs through the merge queue, which tests batches of approved CLs on
+   top of master, instead of submitting each CL after its presubmit tests pass.
+ -url=https://vanadium-review.googlesource.com
+   The base url of the gerrit instance.
+ -v=false
+   Print verbose output.
+
 Presubmit query - Query open CLs from Gerrit
 
 This subcommand queries open CLs from Gerrit, calculates diffs from the previous
@@ -42,14 +94,38 @@ query results, and sends each one with related metadata (ref, project, changeId)
 to a Jenkins job which will run tests against the corresponding CL and post
 review with test results.
 
+When the -merge-queue flag is set, approved CLs are not submitted individually.
+Instead, they are added to a merge queue that tests batches of CLs on top of
+master using the job identified by the -merge-queue-job flag, and submits the
+batches that pass if all their CLs are still submittable, or tests them again
+without the CLs that are not. Batches that fail are bisected to find the CL
+that breaks the tests, which is removed from the queue and notified of the
+failing tests. The state of the queue, including the progress of the
+submission of a batch, is persisted in the file identified by the
+-merge-queue-file flag.
+
 Usage:
    presubmit query [flags]
 
 The presubmit query flags are:
+ -incremental=false
+   Test new patchsets incrementally: only re-run the tests that did not pass for
+   the previous patchset and the tests affected by the changes since then, and
+   reuse the results of the other tests.
+ -incremental-file=${HOME}/tmp/presubmit_incremental.json
+   The file that stores the test results of the latest tested patchsets, which
+   incremental runs reuse.
  -log-file=${HOME}/tmp/presubmit_log
    The file that stores the refs from the previous Gerrit query.
  -manifest=
    Name of the project manifest.
+ -merge-queue-batch-size=8
+   The maximum number of CLs (or multi-part CL sets) the merge queue tests
+   together.
+ -merge-queue-file=${HOME}/tmp/presubmit_merge_queue.json
+   The file that stores the state of the merge queue.
+ -merge-queue-job=vanadium-merge-queue-test
+   The name of the Jenkins job that tests batches of the merge queue.
  -query=(status:open -project:experimental)
    The string used to query Gerrit for open CLs.
 
@@ -59,6 +135,12 @@ The presubmit query flags are:
    The Jenkins host. Presubmit will not send any CLs to an empty host.
  -job=vanadium-presubmit-test
    The name of the Jenkins job to add presubmit-test builds to.
+ -local-queue=
+   Directory of a local build queue to use instead of the Jenkins host. Builds
+   added to the queue are run by the work command.
+ -merge-queue=false
+   Submit CLs through the merge queue, which tests batches of approved CLs on
+   top of master, instead of submitting each CL after its presubmit tests pass.
  -url=https://vanadium-review.googlesource.com
    The base url of the gerrit instance.
  -v=false
@@ -70,6 +152,11 @@ Result processes all the test statuses and results files collected from all the
 presubmit test configuration builds, creates a result summary, and posts the
 summary back to the corresponding Gerrit review thread.
 
+The summary also lists the tests with the highest resource usage (wall time, CPU
+time and peak resident set size), and warns about tests whose usage grows beyond
+the budgets defined in the tools configuration, compared to the median usage of
+their recent passing runs.
+
 Usage:
    presubmit result [flags]
 
@@ -78,6 +165,15 @@ The presubmit result flags are:
    The number of the Jenkins build.
  -dashboard-host=https://dashboard.v.io
    The host of the dashboard server.
+ -history-file=${HOME}/tmp/presubmit_test_history.json
+   The file that records the outcomes of postsubmit builds, used to tell new
+   failures from flaky tests. An empty value disables the history.
+ -history-window=50
+   The number of most recent postsubmit builds of a test that failures are
+   classified against.
+ -incremental-file=${HOME}/tmp/presubmit_incremental.json
+   The file that stores the test results of the latest tested patchsets, which
+   incremental runs reuse. An empty value disables recording the results.
  -manifest=
    Name of the project manifest.
  -projects=
@@ -85,6 +181,15 @@ The presubmit result flags are:
    separated by ':'.
  -refs=
    The review references separated by ':'.
+ -reused-results=
+   The JSON encoded results reused from the previous patchsets of the CLs, as
+   pinned when the build was added.
+ -top-tests=5
+   The number of tests with the highest resource usage to report.
+ -usage-file=${HOME}/tmp/presubmit_usage.json
+   The file that records the resource usage of recent test runs, which the
+   resource budgets of tests are checked against. An empty value disables the
+   budgets.
 
  -color=true
    Use color to format output.
@@ -92,6 +197,12 @@ The presubmit result flags are:
    The Jenkins host. Presubmit will not send any CLs to an empty host.
  -job=vanadium-presubmit-test
    The name of the Jenkins job to add presubmit-test builds to.
+ -local-queue=
+   Directory of a local build queue to use instead of the Jenkins host. Builds
+   added to the queue are run by the work command.
+ -merge-queue=false
+   Submit CLs through the merge queue, which tests batches of approved CLs on
+   top of master, instead of submitting each CL after its presubmit tests pass.
  -url=https://vanadium-review.googlesource.com
    The base url of the gerrit instance.
  -v=false
@@ -112,6 +223,9 @@ The presubmit test flags are:
    Name of the project manifest.
  -num-test-workers=<runtime.NumCPU()>
    Set the number of test workers to use when running sub-tests.
+ -pkgs=
+   Comma-separated list of Go packages that Go-based tests are limited to. By
+   default, all packages are tested.
  -projects=
    The base names of the remote projects containing the CLs pointed by the refs,
    separated by ':'.
@@ -126,6 +240,50 @@ The presubmit test flags are:
    The Jenkins host. Presubmit will not send any CLs to an empty host.
  -job=vanadium-presubmit-test
    The name of the Jenkins job to add presubmit-test builds to.
+ -local-queue=
+   Directory of a local build queue to use instead of the Jenkins host. Builds
+   added to the queue are run by the work command.
+ -merge-queue=false
+   Submit CLs through the merge queue, which tests batches of approved CLs on
+   top of master, instead of submitting each CL after its presubmit tests pass.
+ -url=https://vanadium-review.googlesource.com
+   The base url of the gerrit instance.
+ -v=false
+   Print verbose output.
+
+Presubmit work - Run builds added to the local queue
+
+This subcommand runs the builds added to the local queue identified by the
+-local-queue flag, one at a time and in the order they were queued. Together
+with "presubmit query -local-queue", it makes it possible to run the presubmit
+pipeline on a single machine without Jenkins.
+
+The queue directory must contain a "jobs.json" file that maps job names to the
+shell commands implementing them. Build parameters (such as REFS and TESTS) are
+passed to the commands as environment variables, along with the JOB_NAME,
+BUILD_NUMBER and WORKSPACE variables.
+
+Usage:
+   presubmit work [flags]
+
+The presubmit work flags are:
+ -once=false
+   Run the builds that are currently queued and exit.
+ -poll-interval=30s
+   How often to check the local queue for new builds.
+
+ -color=true
+   Use color to format output.
+ -host=
+   The Jenkins host. Presubmit will not send any CLs to an empty host.
+ -job=vanadium-presubmit-test
+   The name of the Jenkins job to add presubmit-test builds to.
+ -local-queue=
+   Directory of a local build queue to use instead of the Jenkins host. Builds
+   added to the queue are run by the work command.
+ -merge-queue=false
+   Submit CLs through the merge queue, which tests batches of approved CLs on
+   top of master, instead of submitting each CL after its presubmit tests pass.
  -url=https://vanadium-review.googlesource.com
    The base url of the gerrit instance.
  -v=false
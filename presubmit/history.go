@@ -0,0 +1,264 @@
+// Copyright 2015 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"v.io/jiri"
+	"v.io/jiri/runutil"
+	"v.io/x/devtools/internal/ci"
+	"v.io/x/devtools/tooldata"
+)
+
+const defaultHistoryFile = "${HOME}/tmp/presubmit_test_history.json"
+
+var (
+	historyFileFlag   string
+	historyWindowFlag int
+)
+
+func init() {
+	cmdResult.Flags.StringVar(&historyFileFlag, "history-file", os.ExpandEnv(defaultHistoryFile), "The file that records the outcomes of postsubmit builds, used to tell new failures from flaky tests. An empty value disables the history.")
+	cmdResult.Flags.Lookup("history-file").DefValue = defaultHistoryFile
+	cmdResult.Flags.IntVar(&historyWindowFlag, "history-window", 50, "The number of most recent postsubmit builds of a test that failures are classified against.")
+}
+
+// testHistoryRecord records the outcome of a build of a test.
+type testHistoryRecord struct {
+	// Test identifies the test and its configuration (see
+	// testResultInfo.key).
+	Test string
+	// Build is the build number.
+	Build int
+	// Result is the result of the build.
+	Result string
+	// Timestamp is the start time of the build in milliseconds since
+	// the epoch.
+	Timestamp int64
+	// Failures lists the test cases that failed in the build.
+	Failures []ci.TestCase
+}
+
+// failed determines whether the given test case failed in the build.
+func (r testHistoryRecord) failed(testCase ci.TestCase) bool {
+	for _, failure := range r.Failures {
+		if failure.Equal(testCase) {
+			return true
+		}
+	}
+	return false
+}
+
+type testHistoryRecords []testHistoryRecord
+
+func (r testHistoryRecords) Len() int           { return len(r) }
+func (r testHistoryRecords) Less(i, j int) bool { return r[i].Build < r[j].Build }
+func (r testHistoryRecords) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+
+// testHistory is a database of postsubmit build outcomes. It is
+// persisted in a file that holds one JSON-encoded testHistoryRecord per
+// line, which new records are appended to.
+type testHistory struct {
+	path    string
+	records map[string]testHistoryRecords
+}
+
+// loadTestHistory reads the history stored in the given file. A missing
+// file represents an empty history.
+func loadTestHistory(jirix *jiri.X, path string) (*testHistory, error) {
+	h := &testHistory{path: path, records: map[string]testHistoryRecords{}}
+	data, err := jirix.NewSeq().ReadFile(path)
+	if err != nil {
+		if runutil.IsNotExist(err) {
+			return h, nil
+		}
+		return nil, err
+	}
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		var record testHistoryRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			return nil, fmt.Errorf("Unmarshal(%v) failed: %v", string(line), err)
+		}
+		h.index(record)
+	}
+	return h, nil
+}
+
+// index adds the given record to the in-memory records, replacing any
+// record of the same build.
+func (h *testHistory) index(record testHistoryRecord) {
+	records := h.records[record.Test]
+	for i := range records {
+		if records[i].Build == record.Build {
+			records[i] = record
+			return
+		}
+	}
+	records = append(records, record)
+	sort.Sort(records)
+	h.records[record.Test] = records
+}
+
+// has determines whether the history records the given build of the
+// given test.
+func (h *testHistory) has(test string, build int) bool {
+	for _, record := range h.records[test] {
+		if record.Build == build {
+			return true
+		}
+	}
+	return false
+}
+
+// add appends the given records to the history file.
+func (h *testHistory) add(jirix *jiri.X, records ...testHistoryRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+	var buf bytes.Buffer
+	for _, record := range records {
+		bytes, err := json.Marshal(record)
+		if err != nil {
+			return fmt.Errorf("Marshal(%v) failed: %v", record, err)
+		}
+		buf.Write(bytes)
+		buf.WriteString("\n")
+	}
+	if err := jirix.NewSeq().MkdirAll(filepath.Dir(h.path), os.FileMode(0755)).Done(); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(h.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, os.FileMode(0644))
+	if err != nil {
+		return fmt.Errorf("OpenFile(%v) failed: %v", h.path, err)
+	}
+	// Records are written at once so that concurrent writers do not
+	// interleave their lines.
+	if _, err := file.Write(buf.Bytes()); err != nil {
+		file.Close()
+		return fmt.Errorf("Write(%v) failed: %v", h.path, err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("Close(%v) failed: %v", h.path, err)
+	}
+	for _, record := range records {
+		h.index(record)
+	}
+	return nil
+}
+
+// runs returns the most recent postsubmit builds of the given test that
+// started no later than the given timestamp, oldest first. At most
+// "window" builds are returned.
+func (h *testHistory) runs(test string, timestamp int64, window int) testHistoryRecords {
+	runs := testHistoryRecords{}
+	for _, record := range h.records[test] {
+		if record.Timestamp <= timestamp {
+			runs = append(runs, record)
+		}
+	}
+	if len(runs) > window {
+		runs = runs[len(runs)-window:]
+	}
+	return runs
+}
+
+// The minimum number of postsubmit runs, and of failures among them, a
+// failure is classified against before it is reported as likely flaky.
+const (
+	minFlakyRuns     = 5
+	minFlakyFailures = 2
+)
+
+// classify classifies the failure of the given test case in a presubmit
+// test using the given postsubmit runs of the same test. A test case
+// that failed in none of the runs is a new failure, and a test case that
+// failed in the last two runs (or the only run) is a known failure.
+// Otherwise, the test case failed in some runs, but passed in one of the
+// last two, so it is likely flaky, unless there are too few runs or
+// failures to tell, in which case it is reported as a new failure. The
+// number of runs the test case failed in is returned as well.
+func (runs testHistoryRecords) classify(testCase ci.TestCase) (failureType, int) {
+	failed := 0
+	for _, run := range runs {
+		if run.failed(testCase) {
+			failed++
+		}
+	}
+	if failed == 0 {
+		return newFailure, 0
+	}
+	last := len(runs) - 1
+	if runs[last].failed(testCase) && (last == 0 || runs[last-1].failed(testCase)) {
+		return knownFailure, failed
+	}
+	if len(runs) < minFlakyRuns || failed < minFlakyFailures {
+		return newFailure, failed
+	}
+	return flakyFailure, failed
+}
+
+// recordPostSubmitBuilds adds the postsubmit builds of the given tests
+// that are not in the history yet, going back at most "window" builds.
+// Builds whose failed test cases cannot be read are left out, so that
+// they are recorded by a later call.
+func recordPostSubmitBuilds(jirix *jiri.X, executor ci.Executor, history *testHistory, testResults []testResultInfo, matrixJobsConf map[string]tooldata.JenkinsMatrixJobInfo, window int) error {
+	records := []testHistoryRecord{}
+	for _, resultInfo := range testResults {
+		name, key := resultInfo.TestName, resultInfo.key()
+		var axisValuesMap map[string]string
+		if jobInfo, ok := matrixJobsConf[name]; ok {
+			axisValuesMap = resultInfo.AxisValues.AsMap(jobInfo)
+		}
+		lastBuild, err := executor.LastCompleted(name, axisValuesMap)
+		if err != nil {
+			fmt.Fprintf(jirix.Stderr(), "%v\n", err)
+			continue
+		}
+		lastId, err := strconv.Atoi(lastBuild.ID)
+		if err != nil {
+			fmt.Fprintf(jirix.Stderr(), "Atoi(%v) failed: %v\n", lastBuild.ID, err)
+			continue
+		}
+		for i := lastId; i > 0 && i > lastId-window; i-- {
+			if history.has(key, i) {
+				// Older builds may still be missing, if their failed
+				// test cases could not be read before.
+				continue
+			}
+			build, err := executor.Build(name, axisValuesMap, i)
+			if err != nil {
+				fmt.Fprintf(jirix.Stderr(), "%v\n", err)
+				break
+			}
+			if build.Result == ci.ResultAborted {
+				continue
+			}
+			cases, err := executor.FailedTestCases(name, axisValuesMap, i)
+			if err != nil {
+				fmt.Fprintf(jirix.Stderr(), "%v\n", err)
+				continue
+			}
+			records = append(records, testHistoryRecord{
+				Test:      key,
+				Build:     i,
+				Result:    build.Result,
+				Timestamp: build.Timestamp,
+				Failures:  cases,
+			})
+		}
+	}
+	return history.add(jirix, records...)
+}
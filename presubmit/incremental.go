@@ -0,0 +1,397 @@
+// Copyright 2015 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+
+	"v.io/jiri"
+	"v.io/jiri/gerrit"
+	"v.io/jiri/project"
+	"v.io/jiri/runutil"
+	"v.io/x/devtools/internal/test"
+)
+
+const defaultIncrementalFile = "${HOME}/tmp/presubmit_incremental.json"
+
+var (
+	incrementalFlag     bool
+	incrementalFileFlag string
+	reusedResultsFlag   string
+)
+
+func init() {
+	cmdQuery.Flags.BoolVar(&incrementalFlag, "incremental", false, "Test new patchsets incrementally: only re-run the tests that did not pass for the previous patchset and the tests affected by the changes since then, and reuse the results of the other tests.")
+	cmdQuery.Flags.StringVar(&incrementalFileFlag, "incremental-file", os.ExpandEnv(defaultIncrementalFile), "The file that stores the test results of the latest tested patchsets, which incremental runs reuse.")
+	cmdQuery.Flags.Lookup("incremental-file").DefValue = defaultIncrementalFile
+	cmdResult.Flags.StringVar(&incrementalFileFlag, "incremental-file", os.ExpandEnv(defaultIncrementalFile), "The file that stores the test results of the latest tested patchsets, which incremental runs reuse. An empty value disables recording the results.")
+	cmdResult.Flags.Lookup("incremental-file").DefValue = defaultIncrementalFile
+	cmdResult.Flags.StringVar(&reusedResultsFlag, "reused-results", "", "The JSON encoded results reused from the previous patchsets of the CLs, as pinned when the build was added.")
+}
+
+// patchsetResults records the test results of a set of patchsets.
+type patchsetResults struct {
+	// Refs identifies the tested patchsets.
+	Refs []string
+	// Results maps test names (with part suffixes) to their results,
+	// one for each configuration the test ran in.
+	Results map[string][]testResultInfo
+}
+
+// passed determines whether all configurations of the given test
+// passed.
+func (r patchsetResults) passed(name string) bool {
+	results := r.Results[name]
+	for _, result := range results {
+		if result.Result.Status != test.Passed {
+			return false
+		}
+	}
+	return len(results) > 0
+}
+
+// incrementalResults maps sets of CLs (see clSetKey) to the results of
+// their latest tested patchsets.
+type incrementalResults map[string]patchsetResults
+
+// loadIncrementalResults reads the results stored in the given file. A
+// missing file represents no results.
+func loadIncrementalResults(jirix *jiri.X, path string) (incrementalResults, error) {
+	results := incrementalResults{}
+	bytes, err := jirix.NewSeq().ReadFile(path)
+	if err != nil {
+		if runutil.IsNotExist(err) {
+			return results, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(bytes, &results); err != nil {
+		return nil, fmt.Errorf("Unmarshal(%v) failed: %v", path, err)
+	}
+	return results, nil
+}
+
+// saveIncrementalResults writes the given results to the given file.
+// Callers must hold the lock of the file (see updateIncrementalResults).
+func saveIncrementalResults(jirix *jiri.X, path string, results incrementalResults) error {
+	bytes, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		return fmt.Errorf("MarshalIndent(%v) failed: %v", results, err)
+	}
+	tmpPath := path + ".tmp"
+	s := jirix.NewSeq()
+	return s.MkdirAll(filepath.Dir(path), os.FileMode(0755)).
+		WriteFile(tmpPath, bytes, os.FileMode(0644)).
+		Rename(tmpPath, path).Done()
+}
+
+// updateIncrementalResults applies the given update to the results
+// stored in the given file. The file is locked for the duration of the
+// update, so that the result jobs and the query loop, which update
+// different entries concurrently, do not overwrite each other.
+func updateIncrementalResults(jirix *jiri.X, path string, update func(incrementalResults)) error {
+	if err := jirix.NewSeq().MkdirAll(filepath.Dir(path), os.FileMode(0755)).Done(); err != nil {
+		return err
+	}
+	lockPath := path + ".lock"
+	lock, err := os.OpenFile(lockPath, os.O_RDWR|os.O_CREATE, os.FileMode(0644))
+	if err != nil {
+		return fmt.Errorf("OpenFile(%v) failed: %v", lockPath, err)
+	}
+	defer lock.Close()
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		return fmt.Errorf("Flock(%v) failed: %v", lockPath, err)
+	}
+	defer syscall.Flock(int(lock.Fd()), syscall.LOCK_UN)
+	results, err := loadIncrementalResults(jirix, path)
+	if err != nil {
+		return err
+	}
+	update(results)
+	return saveIncrementalResults(jirix, path, results)
+}
+
+// setIncrementalResults sets the results of the CLs identified by the
+// given key, unless the results already stored are of newer patchsets.
+func setIncrementalResults(results incrementalResults, key string, cur patchsetResults) {
+	if prev, ok := results[key]; ok && newerPatchsets(cur.Refs, prev.Refs) {
+		return
+	}
+	results[key] = cur
+}
+
+// parseRefs parses the given review references.
+func parseRefs(refs []string) (clNumberToPatchsetMap, error) {
+	cls := clNumberToPatchsetMap{}
+	for _, ref := range refs {
+		cl, patchset, err := gerrit.ParseRefString(ref)
+		if err != nil {
+			return nil, err
+		}
+		cls[cl] = patchset
+	}
+	return cls, nil
+}
+
+// clSetKey returns the key that identifies the CLs of the given review
+// references, independently of their patchsets.
+func clSetKey(refs []string) (string, error) {
+	cls, err := parseRefs(refs)
+	if err != nil {
+		return "", err
+	}
+	numbers := []string{}
+	for _, cl := range sortedKeys(cls) {
+		numbers = append(numbers, fmt.Sprintf("%d", cl))
+	}
+	return strings.Join(numbers, ","), nil
+}
+
+// patchsetsString returns a short description of the patchsets
+// identified by the given review references, such as "1000/2".
+func patchsetsString(refs []string) string {
+	cls, err := parseRefs(refs)
+	if err != nil {
+		return strings.Join(refs, ":")
+	}
+	patchsets := []string{}
+	for _, cl := range sortedKeys(cls) {
+		patchsets = append(patchsets, fmt.Sprintf("%d/%d", cl, cls[cl]))
+	}
+	return strings.Join(patchsets, ", ")
+}
+
+// newerPatchsets determines whether the patchsets identified by refs
+// supersede the patchsets identified by prevRefs.
+func newerPatchsets(prevRefs, refs []string) bool {
+	prevCLs, err := parseRefs(prevRefs)
+	if err != nil {
+		return false
+	}
+	cls, err := parseRefs(refs)
+	if err != nil {
+		return false
+	}
+	newer := false
+	for cl, patchset := range cls {
+		prevPatchset, ok := prevCLs[cl]
+		if !ok || patchset < prevPatchset {
+			return false
+		}
+		if patchset > prevPatchset {
+			newer = true
+		}
+	}
+	return newer
+}
+
+// planIncrementalRun splits the given tests for the given CLs into the
+// tests to run and the tests whose results are reused from the latest
+// tested patchsets of the CLs. Tests are run again if they did not pass
+// for the previous patchsets, or if they are affected by the files that
+// changed since then. If no previous results can be reused, all tests
+// are run.
+func (s *clsSender) planIncrementalRun(jirix *jiri.X, cls gerrit.CLList, tests []string) ([]string, []string, *patchsetResults) {
+	if s.incrementalResults == nil {
+		return tests, nil, nil
+	}
+	refs := []string{}
+	for _, cl := range cls {
+		refs = append(refs, cl.Reference())
+	}
+	key, err := clSetKey(refs)
+	if err != nil {
+		printf(jirix.Stderr(), "%v\n", err)
+		return tests, nil, nil
+	}
+	prev, ok := s.incrementalResults[key]
+	if !ok || !newerPatchsets(prev.Refs, refs) {
+		return tests, nil, nil
+	}
+
+	// Identify the tests affected by the files that changed between the
+	// previous patchsets and the current ones.
+	prevRefs := map[int]string{}
+	for _, ref := range prev.Refs {
+		cl, _, err := gerrit.ParseRefString(ref)
+		if err != nil {
+			printf(jirix.Stderr(), "%v\n", err)
+			return tests, nil, nil
+		}
+		prevRefs[cl] = ref
+	}
+	files := map[string][]string{}
+	for _, cl := range cls {
+		number, _, err := gerrit.ParseRefString(cl.Reference())
+		if err != nil {
+			printf(jirix.Stderr(), "%v\n", err)
+			return tests, nil, nil
+		}
+		p, err := s.projects.FindUnique(cl.Project)
+		if err != nil {
+			printf(jirix.Stderr(), "%v\n", err)
+			return tests, nil, nil
+		}
+		changed, err := s.interdiffFn(jirix, p, prevRefs[number], cl.Reference())
+		if err != nil {
+			printf(jirix.Stderr(), "%v\n", err)
+			return tests, nil, nil
+		}
+		files[cl.Reference()] = changed
+	}
+	affected, _, err := s.selectTestsForFiles(jirix, cls, func(cl gerrit.Change) ([]string, bool) {
+		return files[cl.Reference()], true
+	})
+	if err != nil {
+		printf(jirix.Stderr(), "%v\n", err)
+		return tests, nil, nil
+	}
+	affectedSet := map[string]struct{}{}
+	for _, test := range affected {
+		affectedSet[test] = struct{}{}
+	}
+
+	rerun, reused := []string{}, []string{}
+	for _, test := range tests {
+		if _, ok := affectedSet[test]; ok || !prev.passed(test) {
+			rerun = append(rerun, test)
+		} else {
+			reused = append(reused, test)
+		}
+	}
+	return rerun, reused, &prev
+}
+
+// diffPatchsets returns the files of the given project that differ
+// between the two given review references.
+func diffPatchsets(jirix *jiri.X, p project.Project, prevRef, ref string) ([]string, error) {
+	revisions := []string{}
+	for _, r := range []string{prevRef, ref} {
+		var out bytes.Buffer
+		s := jirix.NewSeq()
+		if err := s.Pushd(p.Path).Run("git", "fetch", p.Remote, r).Capture(&out, nil).Last("git", "rev-parse", "FETCH_HEAD"); err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, strings.TrimSpace(out.String()))
+	}
+	var out bytes.Buffer
+	s := jirix.NewSeq()
+	if err := s.Pushd(p.Path).Capture(&out, nil).Last("git", "diff", "--name-only", revisions[0], revisions[1]); err != nil {
+		return nil, err
+	}
+	files := []string{}
+	for _, file := range strings.Split(out.String(), "\n") {
+		if file = strings.TrimSpace(file); file != "" {
+			files = append(files, file)
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// pinReusedResults returns the results of the given tests in the given
+// results of previous patchsets. The returned results are passed to the
+// build, so that the results reused are those the run was planned with,
+// whatever updates the incremental results file goes through meanwhile.
+func pinReusedResults(prev *patchsetResults, tests []string) *patchsetResults {
+	if prev == nil || len(tests) == 0 {
+		return nil
+	}
+	pinned := &patchsetResults{Refs: prev.Refs, Results: map[string][]testResultInfo{}}
+	for _, test := range tests {
+		pinned.Results[test] = prev.Results[test]
+	}
+	return pinned
+}
+
+// reusedTestResults returns the results encoded in the given JSON
+// encoded patchsetResults, as pinned by pinReusedResults.
+func reusedTestResults(data string) ([]testResultInfo, error) {
+	var prev patchsetResults
+	if err := json.Unmarshal([]byte(data), &prev); err != nil {
+		return nil, fmt.Errorf("Unmarshal(%v) failed: %v", data, err)
+	}
+	reused := []testResultInfo{}
+	for _, test := range sortedTests(prev.Results) {
+		prevResults := prev.Results[test]
+		if len(prevResults) == 0 {
+			return nil, fmt.Errorf("no result of test %v to reuse from %v", test, patchsetsString(prev.Refs))
+		}
+		for _, result := range prevResults {
+			// Results reused several times refer to the patchsets
+			// that produced them.
+			if result.ReusedFrom == "" {
+				result.ReusedFrom = patchsetsString(prev.Refs)
+			}
+			reused = append(reused, result)
+		}
+	}
+	return reused, nil
+}
+
+// sortedTests returns the sorted names of the tests of the given
+// results.
+func sortedTests(results map[string][]testResultInfo) []string {
+	tests := []string{}
+	for test := range results {
+		tests = append(tests, test)
+	}
+	sort.Strings(tests)
+	return tests
+}
+
+// recordTestResults records the given test results of the patchsets
+// identified by the given refs, for later incremental runs to reuse.
+func recordTestResults(jirix *jiri.X, refs []string, testResults []testResultInfo) error {
+	key, err := clSetKey(refs)
+	if err != nil {
+		return err
+	}
+	cur := patchsetResults{Refs: refs, Results: map[string][]testResultInfo{}}
+	for _, result := range testResults {
+		name := testNameWithPartSuffix(result.TestName, result.AxisValues.PartIndex)
+		cur.Results[name] = append(cur.Results[name], result)
+	}
+	return updateIncrementalResults(jirix, incrementalFileFlag, func(results incrementalResults) {
+		setIncrementalResults(results, key, cur)
+	})
+}
+
+// pruneIncrementalResults removes the results of CLs that are no longer
+// open from the given results. It returns the keys of the removed
+// results.
+func pruneIncrementalResults(results incrementalResults, openCLs gerrit.CLList) []string {
+	open := map[int]bool{}
+	for _, cl := range openCLs {
+		if number, _, err := gerrit.ParseRefString(cl.Reference()); err == nil {
+			open[number] = true
+		}
+	}
+	pruned := []string{}
+	for key, result := range results {
+		cls, err := parseRefs(result.Refs)
+		if err != nil {
+			delete(results, key)
+			pruned = append(pruned, key)
+			continue
+		}
+		for cl := range cls {
+			if !open[cl] {
+				delete(results, key)
+				pruned = append(pruned, key)
+				break
+			}
+		}
+	}
+	sort.Strings(pruned)
+	return pruned
+}
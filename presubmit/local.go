@@ -0,0 +1,280 @@
+// Copyright 2015 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"v.io/jiri"
+	"v.io/jiri/collect"
+	"v.io/jiri/gerrit"
+	"v.io/jiri/gitutil"
+	"v.io/jiri/project"
+	"v.io/jiri/runutil"
+	"v.io/jiri/tool"
+	"v.io/x/devtools/internal/test"
+	"v.io/x/lib/cmdline"
+)
+
+var (
+	baseBranchFlag string
+	listOnlyFlag   bool
+)
+
+func init() {
+	cmdLocal.Flags.StringVar(&baseBranchFlag, "base", "master", "The branch that the changes to test are based on.")
+	cmdLocal.Flags.BoolVar(&listOnlyFlag, "n", false, "Only list the tests that presubmit would run, without running them.")
+	cmdLocal.Flags.IntVar(&numWorkersFlag, "num-test-workers", runtime.NumCPU(), "Set the number of test workers to use when running sub-tests.")
+	cmdLocal.Flags.Lookup("num-test-workers").DefValue = "<runtime.NumCPU()>"
+	cmdLocal.Flags.IntVar(&topTestsFlag, "top-tests", 5, "The number of tests with the highest resource usage to report.")
+
+	tool.InitializeProjectFlags(&cmdLocal.Flags)
+}
+
+// cmdLocal represents the 'local' command of the presubmit tool.
+var cmdLocal = &cmdline.Command{
+	Name:  "local",
+	Short: "Run presubmit tests for local changes",
+	Long: `
+This subcommand runs the tests that presubmit would run for the changes of the
+current branch, on the local checkout and without involving Gerrit or Jenkins.
+
+The changed projects are the local projects that have the current branch
+checked out, and their changes are the files that differ from the point where
+the branch diverged from the base branch, including uncommitted changes. The
+tests are selected from these files using the same configuration as for the
+CLs that presubmit tests, and are run by "jiri-test run". The results are
+summarized in the format of the reports that presubmit posts to Gerrit.
+`,
+	Runner: jiri.RunnerFunc(runLocal),
+}
+
+// runLocal implements the 'local' subcommand.
+func runLocal(jirix *jiri.X, _ []string) error {
+	branch, err := gitutil.New(jirix.NewSeq()).CurrentBranchName()
+	if err != nil {
+		return err
+	}
+	if branch == baseBranchFlag {
+		return fmt.Errorf("the current branch is the base branch %q, switch to the branch of the changes to test", baseBranchFlag)
+	}
+	projects, err := project.LocalProjects(jirix, project.FastScan)
+	if err != nil {
+		return err
+	}
+	changes, err := localChanges(jirix, projects, branch, baseBranchFlag)
+	if err != nil {
+		return err
+	}
+	if len(changes) == 0 {
+		printf(jirix.Stdout(), "No changes on branch %q.\n", branch)
+		return nil
+	}
+
+	// Select the tests the way presubmit does for CLs, representing the
+	// changes of each project as a CL.
+	names := []string{}
+	for name := range changes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	cls := gerrit.CLList{}
+	for _, name := range names {
+		cls = append(cls, gerrit.Change{Project: name})
+	}
+	sender := clsSender{
+		projects:    projects,
+		importersFn: listGoImporters,
+	}
+	tests, pkgs, err := sender.selectTestsForFiles(jirix, cls, func(cl gerrit.Change) ([]string, bool) {
+		return changes[cl.Project], true
+	})
+	if err != nil {
+		return err
+	}
+	tests, err = stripTestParts(tests)
+	if err != nil {
+		return err
+	}
+	if len(tests) == 0 {
+		printf(jirix.Stdout(), "No tests to run for the changes of %v.\n", strings.Join(names, ", "))
+		return nil
+	}
+	printf(jirix.Stdout(), "Tests for the changes of %v: %v\n", strings.Join(names, ", "), strings.Join(tests, " "))
+	if pkgs != nil {
+		printf(jirix.Stdout(), "Go packages: %v\n", strings.Join(pkgs, " "))
+	}
+	if listOnlyFlag {
+		return nil
+	}
+
+	printf(jirix.Stdout(), "### Running the presubmit tests\n")
+	testResults, err := runLocalTests(jirix, tests, pkgs)
+	if err != nil {
+		return err
+	}
+	reporter := testReporter{
+		testResults: testResults,
+		report:      &bytes.Buffer{},
+		local:       true,
+	}
+	success, err := reporter.localReport(jirix)
+	if err != nil {
+		return err
+	}
+	printf(jirix.Stdout(), "### Test report\n")
+	fmt.Fprintf(jirix.Stdout(), "%s", reporter.report.String())
+	if !success {
+		return cmdline.ErrExitCode(test.FailedExitCode)
+	}
+	return nil
+}
+
+// localChanges returns a map from the names of the given projects that
+// have the given branch checked out to the files that differ from the
+// point where the branch diverged from the given base branch. Projects
+// without such files are omitted.
+func localChanges(jirix *jiri.X, projects project.Projects, branch, base string) (map[string][]string, error) {
+	changes := map[string][]string{}
+	for _, p := range projects {
+		current, err := gitutil.New(jirix.NewSeq(), gitutil.RootDirOpt(p.Path)).CurrentBranchName()
+		if err != nil {
+			return nil, err
+		}
+		if current != branch {
+			continue
+		}
+		var out bytes.Buffer
+		s := jirix.NewSeq()
+		if err := s.Pushd(p.Path).Capture(&out, nil).Last("git", "merge-base", base, branch); err != nil {
+			return nil, err
+		}
+		mergeBase := strings.TrimSpace(out.String())
+		out.Reset()
+		s = jirix.NewSeq()
+		if err := s.Pushd(p.Path).Capture(&out, nil).Last("git", "diff", "--name-only", mergeBase); err != nil {
+			return nil, err
+		}
+		files := []string{}
+		for _, file := range strings.Split(out.String(), "\n") {
+			if file = strings.TrimSpace(file); file != "" {
+				files = append(files, file)
+			}
+		}
+		if len(files) > 0 {
+			sort.Strings(files)
+			changes[p.Name] = files
+		}
+	}
+	return changes, nil
+}
+
+// stripTestParts removes the part suffixes from the given tests, which
+// run all of their parts locally.
+func stripTestParts(tests []string) ([]string, error) {
+	seen, stripped := map[string]bool{}, []string{}
+	for _, name := range tests {
+		name, _, err := processTestPartSuffix(name)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[name] {
+			seen[name] = true
+			stripped = append(stripped, name)
+		}
+	}
+	return stripped, nil
+}
+
+// runLocalTests runs the given tests via "jiri-test run", limiting Go
+// tests to the given packages unless they are nil, and returns their
+// results.
+func runLocalTests(jirix *jiri.X, tests, pkgs []string) (_ []testResultInfo, e error) {
+	s := jirix.NewSeq()
+	outputDir, err := s.TempDir("", "")
+	if err != nil {
+		return nil, err
+	}
+	defer collect.Error(func() error { return jirix.NewSeq().RemoveAll(outputDir).Done() }, &e)
+
+	args := []string{
+		"run",
+		"-output-dir", outputDir,
+		"-num-test-workers", fmt.Sprintf("%d", numWorkersFlag),
+	}
+	if pkgs != nil {
+		args = append(args, "-pkgs", strings.Join(pkgs, ","), "-limit-pkgs")
+	}
+	args = append(args, tests...)
+	timestamp := time.Now().UnixNano() / nanoToMiliSeconds
+	if err := s.Capture(jirix.Stdout(), jirix.Stderr()).Last("jiri-test", args...); err != nil && !isTestFailure(err) {
+		return nil, err
+	}
+
+	var results map[string]*test.Result
+	resultsFile := filepath.Join(outputDir, "results")
+	bytes, err := s.ReadFile(resultsFile)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(bytes, &results); err != nil {
+		return nil, fmt.Errorf("Unmarshal() failed: %v\n%v", err, string(bytes))
+	}
+	testResults := []testResultInfo{}
+	for _, name := range tests {
+		result, ok := results[name]
+		if !ok {
+			return nil, fmt.Errorf("no test result found for %q", name)
+		}
+		testResults = append(testResults, testResultInfo{
+			Result:    *result,
+			TestName:  name,
+			Timestamp: timestamp,
+			AxisValues: axisValuesInfo{
+				Arch:      runtime.GOARCH,
+				OS:        runtime.GOOS,
+				PartIndex: -1,
+			},
+			Partial: pkgs != nil,
+		})
+	}
+	return testResults, nil
+}
+
+// isTestFailure determines whether the given error of a "jiri-test"
+// command reports failed tests, as opposed to a failure to run them.
+func isTestFailure(err error) bool {
+	exiterr, ok := runutil.GetOriginalError(err).(*exec.ExitError)
+	if !ok {
+		return false
+	}
+	status, ok := exiterr.Sys().(syscall.WaitStatus)
+	return ok && status.ExitStatus() == test.FailedExitCode
+}
+
+// localReport generates a report of tests that ran on the local
+// checkout, in the format of the reports posted to Gerrit. It returns
+// whether the tests are considered successful.
+func (r *testReporter) localReport(jirix *jiri.X) (bool, error) {
+	failedTestNames := r.reportTestResultsSummary(jirix)
+	if len(failedTestNames) != 0 {
+		// Without postsubmit results to compare with, the failed test
+		// cases are reported as new failures.
+		if _, err := r.reportFailedTestCases(jirix); err != nil {
+			return false, err
+		}
+	}
+	r.reportUsage(jirix)
+	return len(failedTestNames) == 0, nil
+}
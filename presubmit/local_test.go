@@ -0,0 +1,101 @@
+// Copyright 2015 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"v.io/jiri/jiritest"
+	"v.io/x/devtools/internal/test"
+	"v.io/x/devtools/internal/xunit"
+)
+
+func TestStripTestParts(t *testing.T) {
+	got, err := stripTestParts([]string{"vanadium-go-build", "vanadium-go-race-part0", "vanadium-go-race-part1", "vanadium-go-test"})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if want := []string{"vanadium-go-build", "vanadium-go-race", "vanadium-go-test"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestLocalReport(t *testing.T) {
+	jirix, cleanup := jiritest.NewX(t)
+	defer cleanup()
+
+	// Local xUnit reports are found in the workspace.
+	workspace, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(workspace)
+	oldWorkspace := os.Getenv("WORKSPACE")
+	if err := os.Setenv("WORKSPACE", workspace); err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.Setenv("WORKSPACE", oldWorkspace)
+	report := `<?xml version="1.0" encoding="utf-8"?>
+<testsuites>
+  <testsuite name="ts" tests="2" errors="0" failures="1" skip="0">
+    <testcase classname="v.io/x/ref" name="TestFoo" time="0">
+      <failure message="error">failed</failure>
+    </testcase>
+    <testcase classname="v.io/x/ref" name="TestBar" time="0"></testcase>
+  </testsuite>
+</testsuites>`
+	if got, want := filepath.Dir(xunit.ReportPath("vanadium-go-test")), workspace; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if err := ioutil.WriteFile(xunit.ReportPath("vanadium-go-test"), []byte(report), os.FileMode(0644)); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	axisValues := axisValuesInfo{Arch: "amd64", OS: "linux", PartIndex: -1}
+	reporter := testReporter{
+		testResults: []testResultInfo{
+			{Result: test.Result{Status: test.Passed}, TestName: "vanadium-go-build", AxisValues: axisValues},
+			{Result: test.Result{Status: test.Failed}, TestName: "vanadium-go-test", AxisValues: axisValues},
+		},
+		report: &bytes.Buffer{},
+		local:  true,
+	}
+	success, err := reporter.localReport(jirix)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if success {
+		t.Fatalf("failed tests were reported as successful")
+	}
+	want := `Test results:
+? ➔ ✔: vanadium-go-build
+? ➔ ✖: vanadium-go-test
+
+NEW FAILURE:
+- v::io/x/ref::TestFoo
+
+`
+	if got := reporter.report.String(); got != want {
+		t.Fatalf("got:\n%v\nwant:\n%v", got, want)
+	}
+
+	reporter = testReporter{
+		testResults: reporter.testResults[:1],
+		report:      &bytes.Buffer{},
+		local:       true,
+	}
+	if success, err := reporter.localReport(jirix); err != nil || !success {
+		t.Fatalf("got %v, %v, want success", success, err)
+	}
+	if got := reporter.report.String(); strings.Contains(got, "FAILURE") {
+		t.Fatalf("unexpected failures in report:\n%v", got)
+	}
+}
@@ -5,9 +5,12 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/url"
 	"os"
+	"path"
+	"path/filepath"
 	"reflect"
 	"sort"
 	"strings"
@@ -17,6 +20,8 @@ import (
 	"v.io/jiri/gerrit"
 	"v.io/jiri/project"
 	"v.io/jiri/tool"
+	"v.io/x/devtools/internal/ci"
+	"v.io/x/devtools/internal/goutil"
 	"v.io/x/devtools/tooldata"
 	"v.io/x/lib/cmdline"
 )
@@ -55,6 +60,16 @@ This subcommand queries open CLs from Gerrit, calculates diffs from the previous
 query results, and sends each one with related metadata (ref, project, changeId)
 to a Jenkins job which will run tests against the corresponding CL and post
 review with test results.
+
+When the -merge-queue flag is set, approved CLs are not submitted individually.
+Instead, they are added to a merge queue that tests batches of CLs on top of
+master using the job identified by the -merge-queue-job flag, and submits the
+batches that pass if all their CLs are still submittable, or tests them again
+without the CLs that are not. Batches that fail are bisected to find the CL
+that breaks the tests, which is removed from the queue and notified of the
+failing tests. The state of the queue, including the progress of the
+submission of a batch, is persisted in the file identified by the
+-merge-queue-file flag.
 `,
 	Runner: jiri.RunnerFunc(runQuery),
 }
@@ -66,17 +81,17 @@ func runQuery(jirix *jiri.X, args []string) error {
 		printf(jirix.Stdout(), "%d sent.\n", numSentCLs)
 	}()
 
-	jenkinsObj, err := jirix.Jenkins(jenkinsHostFlag)
+	executor, err := newExecutor(jirix)
 	if err != nil {
 		return err
 	}
 
 	// Don't query anything if the last "presubmit-test" build failed.
-	lastBuildInfo, err := jenkinsObj.LastCompletedBuildStatus(presubmitTestJobFlag, nil)
+	lastBuild, err := executor.LastCompleted(presubmitTestJobFlag, nil)
 	if err != nil {
 		fmt.Fprintf(jirix.Stderr(), "%v\n", err)
 	} else {
-		if lastBuildInfo.Result == "FAILURE" {
+		if lastBuild.Result == ci.ResultFailure {
 			printf(jirix.Stdout(), "%s is failing. Skipping this round.\n", presubmitTestJobFlag)
 			return nil
 		}
@@ -88,12 +103,8 @@ func runQuery(jirix *jiri.X, args []string) error {
 		return err
 	}
 
-	// Query Gerrit.
-	gUrl, err := gerritBaseUrl()
-	if err != nil {
-		return err
-	}
-	curCLs, err := jirix.Gerrit(gUrl).Query(queryStringFlag)
+	// Query the code review system.
+	curCLs, err := queryCLs(jirix, queryStringFlag)
 	if err != nil {
 		return fmt.Errorf("Query(%q) failed: %v", queryStringFlag, err)
 	}
@@ -104,8 +115,9 @@ func runQuery(jirix *jiri.X, args []string) error {
 		return err
 	}
 
-	// Don't send anything if jenkins host is not specified.
-	if jenkinsHostFlag == "" {
+	// Don't send anything if neither a jenkins host nor a local queue
+	// is specified.
+	if jenkinsHostFlag == "" && localQueueFlag == "" {
 		printf(jirix.Stdout(), "Not sending CLs to run presubmit tests due to empty Jenkins host.\n")
 		return nil
 	}
@@ -144,14 +156,45 @@ func runQuery(jirix *jiri.X, args []string) error {
 		removeOutdatedFn: removeOutdatedBuilds,
 		addPresubmitFn:   addPresubmitTestBuild,
 		postMessageFn:    postMessage,
+		importersFn:      listGoImporters,
+		interdiffFn:      diffPatchsets,
+	}
+	if incrementalFlag {
+		if sender.incrementalResults, err = loadIncrementalResults(jirix, incrementalFileFlag); err != nil {
+			return err
+		}
+		sender.incrementalChanges = map[string]*patchsetResults{}
+		for _, key := range pruneIncrementalResults(sender.incrementalResults, curCLs) {
+			sender.incrementalChanges[key] = nil
+		}
 	}
 	if err := sender.sendCLListsToPresubmitTest(jirix); err != nil {
 		return err
 	}
 	numSentCLs += sender.clsSent
+	if len(sender.incrementalChanges) > 0 {
+		// Only apply the changes made by this query to the stored
+		// results: result jobs may have recorded new results since they
+		// were loaded.
+		if err := updateIncrementalResults(jirix, incrementalFileFlag, func(results incrementalResults) {
+			for key, cur := range sender.incrementalChanges {
+				if cur == nil {
+					delete(results, key)
+				} else {
+					setIncrementalResults(results, key, *cur)
+				}
+			}
+		}); err != nil {
+			return err
+		}
+	}
 
-	// Get all submittable CLs and submit them.
+	// Get all submittable CLs and submit them, either through the merge
+	// queue or directly.
 	submittableCLs := getSubmittableCLs(jirix, curCLs)
+	if mergeQueueFlag {
+		return runMergeQueue(jirix, submittableCLs)
+	}
 	if len(submittableCLs) > 0 {
 		fmt.Fprintf(jirix.Stdout(), "Submitting CLs...\n")
 	}
@@ -169,8 +212,18 @@ type clsSender struct {
 	projects         project.Projects
 	clsSent          int
 	removeOutdatedFn func(*jiri.X, clNumberToPatchsetMap) []error
-	addPresubmitFn   func(*jiri.X, gerrit.CLList, []string) error
+	addPresubmitFn   func(*jiri.X, gerrit.CLList, []string, []string, *patchsetResults) error
 	postMessageFn    func(*jiri.X, string, []string, bool) error
+	importersFn      func(*jiri.X, []string) ([]string, error)
+
+	// incrementalResults stores the results of previously tested
+	// patchsets, which are reused in incremental mode. It is nil
+	// otherwise.
+	incrementalResults incrementalResults
+	// incrementalChanges records the changes made to incrementalResults,
+	// by key. Nil values represent removed results.
+	incrementalChanges map[string]*patchsetResults
+	interdiffFn        func(*jiri.X, project.Project, string, string) ([]string, error)
 }
 
 // sendCLListsToPresubmitTest sends the given clLists to presubmit-test Jenkins
@@ -197,7 +250,7 @@ func (s *clsSender) sendCLListsToPresubmitTest(jirix *jiri.X) error {
 		}
 
 		// Skip if there is no tests to run.
-		tests, err := s.getTestsToRun(jirix, clListInfo.projects)
+		tests, pkgs, err := s.selectTests(jirix, curCLList)
 		if err != nil {
 			return err
 		}
@@ -221,6 +274,10 @@ func (s *clsSender) sendCLListsToPresubmitTest(jirix *jiri.X) error {
 			continue
 		}
 
+		// In incremental mode, reuse the results of the previous patchsets
+		// for the tests that passed and are not affected by the changes.
+		tests, reused, prev := s.planIncrementalRun(jirix, curCLList, tests)
+
 		// Check and cancel matched outdated builds.
 		for _, err := range s.removeOutdatedFn(jirix, clListInfo.clMap) {
 			if err != nil {
@@ -228,9 +285,31 @@ func (s *clsSender) sendCLListsToPresubmitTest(jirix *jiri.X) error {
 			}
 		}
 
+		// Don't send curCLList to presubmit-test if all test results are
+		// reused. Instead, the reused results become the results of the
+		// current patchsets.
+		if len(tests) == 0 {
+			message := fmt.Sprintf("Presubmit tests skipped: the results of all tests are reused from %s.\n", patchsetsString(prev.Refs))
+			if err := s.postMessageFn(jirix, message, clListInfo.refs, true); err != nil {
+				return err
+			}
+			key, err := clSetKey(clListInfo.refs)
+			if err != nil {
+				return err
+			}
+			cur := patchsetResults{Refs: clListInfo.refs, Results: prev.Results}
+			s.incrementalResults[key] = cur
+			s.incrementalChanges[key] = &cur
+			printf(jirix.Stdout(), "SKIP: Add %s (results reused)\n", clListInfo.clString)
+			continue
+		}
+
 		// Send curCLList to presubmit-test.
 		strCLs := fmt.Sprintf("Add %s", clListInfo.clString)
-		if err := s.addPresubmitFn(jirix, curCLList, tests); err != nil {
+		if len(reused) > 0 {
+			strCLs += fmt.Sprintf(" (reusing %d results of %s)", len(reused), patchsetsString(prev.Refs))
+		}
+		if err := s.addPresubmitFn(jirix, curCLList, tests, pkgs, pinReusedResults(prev, reused)); err != nil {
 			printf(jirix.Stdout(), "FAIL: %s\n", strCLs)
 			printf(jirix.Stderr(), "addPresubmitTestBuild failed: %v\n", err)
 		} else {
@@ -301,9 +380,13 @@ func (s *clsSender) getTestsToRun(jirix *jiri.X, projects []string) ([]string, e
 	if err != nil {
 		return nil, err
 	}
-	tmpTests := config.ProjectTests(projects)
+	return expandTestParts(config, config.ProjectTests(projects)), nil
+}
+
+// expandTestParts appends the part suffix to tests that have multiple
+// parts specified in the config file.
+func expandTestParts(config *tooldata.Config, tmpTests []string) []string {
 	tests := []string{}
-	// Append the part suffix to tests that have multiple parts specified in the config file.
 	for _, test := range tmpTests {
 		if parts := config.TestParts(test); parts != nil {
 			for i := 0; i <= len(parts); i++ {
@@ -314,7 +397,169 @@ func (s *clsSender) getTestsToRun(jirix *jiri.X, projects []string) ([]string, e
 		}
 	}
 	sort.Strings(tests)
-	return tests, nil
+	return tests
+}
+
+// selectTests identifies the tests to run for the given CLs from the
+// files they modify, using the path rules of their projects. It also
+// returns the Go packages that Go-based tests should be limited to: the
+// modified packages and their reverse dependencies. If any CL modifies
+// files that are not covered by the path rules of its project, or whose
+// rule requires it, all tests of the projects are selected and the Go
+// packages are not limited (nil). The Go packages are not limited either
+// if any CL modifies files of a Go project that cannot be mapped to a
+// package, such as non-Go files, or files of a project that is not a Go
+// project.
+func (s *clsSender) selectTests(jirix *jiri.X, cls gerrit.CLList) ([]string, []string, error) {
+	return s.selectTestsForFiles(jirix, cls, func(cl gerrit.Change) ([]string, bool) {
+		files := changedFiles(cl)
+		return files, len(files) > 0
+	})
+}
+
+// selectTestsForFiles is like selectTests, but uses the given function to
+// identify the files modified by each CL. If the function reports that
+// the files of a CL are unknown, all tests of the projects are selected.
+func (s *clsSender) selectTestsForFiles(jirix *jiri.X, cls gerrit.CLList, filesFn func(gerrit.Change) ([]string, bool)) ([]string, []string, error) {
+	config, err := tooldata.LoadConfig(jirix)
+	if err != nil {
+		return nil, nil, err
+	}
+	projects, testSet, goPkgs := []string{}, map[string]struct{}{}, map[string]struct{}{}
+	full, allPkgs := false, false
+	for _, cl := range cls {
+		projects = append(projects, cl.Project)
+		rules := config.PathTests(cl.Project)
+		files, ok := filesFn(cl)
+		if !ok {
+			full = true
+			continue
+		}
+		if len(files) == 0 {
+			continue
+		}
+		if len(rules) == 0 {
+			full = true
+			continue
+		}
+		_, goProject := s.goPackage(jirix, config, cl.Project, ".")
+		for _, file := range files {
+			rule := matchPathTest(rules, file)
+			if rule == nil || rule.All {
+				full = true
+				break
+			}
+			if len(rule.Tests) == 0 {
+				continue
+			}
+			for _, test := range rule.Tests {
+				if groupTests := config.GroupTests([]string{test}); len(groupTests) > 0 {
+					for _, groupTest := range groupTests {
+						testSet[groupTest] = struct{}{}
+					}
+				} else {
+					testSet[test] = struct{}{}
+				}
+			}
+			if !goProject {
+				// Changes to other projects can affect the tests of
+				// any package.
+				allPkgs = true
+				continue
+			}
+			pkg, ok := "", false
+			if strings.HasSuffix(file, ".go") {
+				pkg, ok = s.goPackage(jirix, config, cl.Project, path.Dir(file))
+			}
+			if !ok {
+				// Other files of Go projects, such as testdata or VDL
+				// files, can affect any package.
+				allPkgs = true
+				continue
+			}
+			goPkgs[pkg] = struct{}{}
+		}
+	}
+	if full {
+		tests, err := s.getTestsToRun(jirix, projects)
+		return tests, nil, err
+	}
+	tests := []string{}
+	for test := range testSet {
+		tests = append(tests, test)
+	}
+	if allPkgs || len(goPkgs) == 0 || s.importersFn == nil {
+		return expandTestParts(config, tests), nil, nil
+	}
+	targets := []string{}
+	for pkg := range goPkgs {
+		targets = append(targets, pkg)
+	}
+	sort.Strings(targets)
+	importers, err := s.importersFn(jirix, targets)
+	if err != nil {
+		// Fall back to testing all Go packages.
+		printf(jirix.Stderr(), "failed to list importers of %v: %v\n", targets, err)
+		return expandTestParts(config, tests), nil, nil
+	}
+	for _, pkg := range importers {
+		goPkgs[pkg] = struct{}{}
+	}
+	pkgs := []string{}
+	for pkg := range goPkgs {
+		pkgs = append(pkgs, pkg)
+	}
+	sort.Strings(pkgs)
+	return expandTestParts(config, tests), pkgs, nil
+}
+
+// changedFiles returns the files modified by the given CL, relative to
+// the root of its project.
+func changedFiles(cl gerrit.Change) []string {
+	files := []string{}
+	for _, revision := range cl.Revisions {
+		for file := range revision.Files {
+			// Gerrit lists the commit message as a file.
+			if file == "/COMMIT_MSG" {
+				continue
+			}
+			files = append(files, file)
+		}
+	}
+	sort.Strings(files)
+	return files
+}
+
+// matchPathTest returns the first of the given rules that matches the
+// given file, or nil if there is no such rule.
+func matchPathTest(rules []tooldata.PathTest, file string) *tooldata.PathTest {
+	for i := range rules {
+		if rules[i].Match(file) {
+			return &rules[i]
+		}
+	}
+	return nil
+}
+
+// goPackage returns the import path of the Go package in the given
+// directory, relative to the root of the given project.
+func (s *clsSender) goPackage(jirix *jiri.X, config *tooldata.Config, projectName, dir string) (string, bool) {
+	for _, p := range s.projects.Find(projectName) {
+		absDir := filepath.Join(p.Path, filepath.FromSlash(dir))
+		for _, workspace := range config.GoWorkspaces() {
+			src := filepath.Join(jirix.Root, workspace, "src") + string(filepath.Separator)
+			if strings.HasPrefix(absDir, src) {
+				return filepath.ToSlash(strings.TrimPrefix(absDir, src)), true
+			}
+		}
+	}
+	return "", false
+}
+
+// listGoImporters returns the Go packages affected by changes to the
+// given Go packages.
+func listGoImporters(jirix *jiri.X, pkgs []string) ([]string, error) {
+	return goutil.Importers(jirix, nil, pkgs, "v.io/...")
 }
 
 func (s *clsSender) handleNonGoogleOwner(jirix *jiri.X, refs, projects, tests []string) error {
@@ -350,19 +595,19 @@ func removeOutdatedBuilds(jirix *jiri.X, cls clNumberToPatchsetMap) (errs []erro
 }
 
 func removeQueuedOutdatedBuilds(jirix *jiri.X, cls clNumberToPatchsetMap) error {
-	jenkins, err := jirix.Jenkins(jenkinsHostFlag)
+	executor, err := newExecutor(jirix)
 	if err != nil {
 		return err
 	}
 
 	// Get queued outdated builds.
-	queuedBuilds, err := jenkins.QueuedBuilds(presubmitTestJobFlag)
+	queuedBuilds, err := executor.Queued(presubmitTestJobFlag)
 	if err != nil {
 		return err
 	}
 
 	for _, build := range queuedBuilds {
-		refs := build.ParseRefs()
+		refs := build.Refs()
 		if refs == "" {
 			return err
 		}
@@ -371,7 +616,7 @@ func removeQueuedOutdatedBuilds(jirix *jiri.X, cls clNumberToPatchsetMap) error
 			return err
 		}
 		if buildOutdated {
-			if err := jenkins.CancelQueuedBuild(fmt.Sprintf("%d", build.Id)); err != nil {
+			if err := executor.Cancel(build); err != nil {
 				return err
 			}
 			printf(jirix.Stdout(), "Cancelled build %s as it is no longer current.\n", refs)
@@ -381,21 +626,18 @@ func removeQueuedOutdatedBuilds(jirix *jiri.X, cls clNumberToPatchsetMap) error
 }
 
 func removeOngoingOutdatedBuilds(jirix *jiri.X, cls clNumberToPatchsetMap) error {
-	jenkins, err := jirix.Jenkins(jenkinsHostFlag)
+	executor, err := newExecutor(jirix)
 	if err != nil {
 		return err
 	}
 
-	buildInfos, err := jenkins.OngoingBuilds(presubmitTestJobFlag)
+	builds, err := executor.Ongoing(presubmitTestJobFlag)
 	if err != nil {
 		return err
 	}
 
-	for _, buildInfo := range buildInfos {
-		if !buildInfo.Building {
-			continue
-		}
-		refs := buildInfo.ParseRefs()
+	for _, build := range builds {
+		refs := build.Refs()
 		if refs != "" {
 			buildOutdated, err := isBuildOutdated(refs, cls)
 			if err != nil {
@@ -404,7 +646,7 @@ func removeOngoingOutdatedBuilds(jirix *jiri.X, cls clNumberToPatchsetMap) error
 			}
 			// Cancel outdated running build.
 			if buildOutdated {
-				if err := jenkins.CancelOngoingBuild(presubmitTestJobFlag, buildInfo.Number); err != nil {
+				if err := executor.Cancel(build); err != nil {
 					return err
 				}
 				printf(jirix.Stdout(), "Cancelled build %s as it is no longer current.\n", refs)
@@ -466,10 +708,11 @@ func sortedKeys(cls clNumberToPatchsetMap) []int {
 	return keys
 }
 
-// addPresubmitTestBuild uses Jenkins' remote access API to add a build for
-// a set of open CLs to run presubmit tests.
-func addPresubmitTestBuild(jirix *jiri.X, cls gerrit.CLList, tests []string) error {
-	jenkins, err := jirix.Jenkins(jenkinsHostFlag)
+// addPresubmitTestBuild adds a build for a set of open CLs to run
+// presubmit tests. The given results of previous patchsets of the CLs,
+// if any, are reused for the tests that do not run.
+func addPresubmitTestBuild(jirix *jiri.X, cls gerrit.CLList, tests, pkgs []string, reused *patchsetResults) error {
+	executor, err := newExecutor(jirix)
 	if err != nil {
 		return err
 	}
@@ -479,13 +722,24 @@ func addPresubmitTestBuild(jirix *jiri.X, cls gerrit.CLList, tests []string) err
 		refs = append(refs, cl.Reference())
 		projects = append(projects, cl.Project)
 	}
-	if err := jenkins.AddBuildWithParameter(presubmitTestJobFlag, url.Values{
+	params := url.Values{
 		"REFS":     {strings.Join(refs, ":")},
 		"PROJECTS": {strings.Join(projects, ":")},
 		// Separating by spaces is required by the Dynamic Axis plugin used in the
 		// new presubmit test target.
 		"TESTS": {strings.Join(tests, " ")},
-	}); err != nil {
+	}
+	if len(pkgs) > 0 {
+		params.Set("PKGS", strings.Join(pkgs, ","))
+	}
+	if reused != nil {
+		bytes, err := json.Marshal(reused)
+		if err != nil {
+			return fmt.Errorf("Marshal(%v) failed: %v", reused, err)
+		}
+		params.Set("REUSED_RESULTS", string(bytes))
+	}
+	if err := executor.Enqueue(presubmitTestJobFlag, params); err != nil {
 		return err
 	}
 	return nil
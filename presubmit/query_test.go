@@ -6,7 +6,9 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
+	"path/filepath"
 	"reflect"
 	"testing"
 
@@ -15,6 +17,7 @@ import (
 	"v.io/jiri/jiritest"
 	"v.io/jiri/project"
 	"v.io/jiri/tool"
+	"v.io/x/devtools/internal/test"
 	"v.io/x/devtools/tooldata"
 )
 
@@ -79,7 +82,7 @@ func TestSendCLListsToPresubmitTest(t *testing.T) {
 
 		// Mock out the addPresubmitTestBuild function.
 		// It will return error for the first clList.
-		addPresubmitFn: func(jirix *jiri.X, cls gerrit.CLList, tests []string) error {
+		addPresubmitFn: func(jirix *jiri.X, cls gerrit.CLList, tests, pkgs []string, reused *patchsetResults) error {
 			if reflect.DeepEqual(cls, clLists[0]) {
 				return fmt.Errorf("err")
 			} else {
@@ -160,6 +163,266 @@ func TestGetTestsToRun(t *testing.T) {
 	}
 }
 
+func TestSelectTests(t *testing.T) {
+	fake, cleanup := jiritest.NewFakeJiriRoot(t)
+	defer cleanup()
+
+	// Create a fake configuration file.
+	config := tooldata.NewConfig(
+		tooldata.GoWorkspacesOpt([]string{"release/go"}),
+		tooldata.PathTestsOpt(map[string][]tooldata.PathTest{
+			"release.go.core": []tooldata.PathTest{
+				{Pattern: "*.md"},
+				{Pattern: "Makefile", All: true},
+				{Pattern: "lib/...", Tests: []string{"go"}},
+			},
+			"release.js.core": []tooldata.PathTest{
+				{Pattern: "*.js", Tests: []string{"javascript", "vanadium-go-test"}},
+			},
+		}),
+		tooldata.ProjectTestsOpt(map[string][]string{
+			"release.go.core": []string{"go", "javascript"},
+			"release.js.core": []string{"javascript"},
+		}),
+		tooldata.TestGroupsOpt(map[string][]string{
+			"go": []string{"vanadium-go-build", "vanadium-go-test"},
+		}),
+	)
+	if err := tooldata.SaveConfig(fake.X, config); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	sender := clsSender{
+		projects: project.Projects{
+			project.ProjectKey("release.go.core"): project.Project{
+				Name: "release.go.core",
+				Path: filepath.Join(fake.X.Root, "release", "go", "src", "v.io", "x", "ref"),
+			},
+			project.ProjectKey("release.js.core"): project.Project{
+				Name: "release.js.core",
+				Path: filepath.Join(fake.X.Root, "release", "javascript", "core"),
+			},
+		},
+		// Mock out the listGoImporters function.
+		importersFn: func(jirix *jiri.X, pkgs []string) ([]string, error) {
+			if got, want := pkgs, []string{"v.io/x/ref/lib/foo"}; !reflect.DeepEqual(got, want) {
+				return nil, fmt.Errorf("got %v, want %v", got, want)
+			}
+			return []string{"v.io/x/ref/services/bar"}, nil
+		},
+	}
+	allTests := []string{"javascript", "vanadium-go-build", "vanadium-go-test"}
+	testCases := []struct {
+		files     []string
+		wantTests []string
+		wantPkgs  []string
+	}{
+		// Documentation changes require no tests.
+		{[]string{"README.md", "lib/README.md"}, []string{}, nil},
+		// Go changes test the modified packages and their importers.
+		{
+			[]string{"README.md", "lib/foo/foo.go"},
+			[]string{"vanadium-go-build", "vanadium-go-test"},
+			[]string{"v.io/x/ref/lib/foo", "v.io/x/ref/services/bar"},
+		},
+		// Other files of Go projects do not limit the packages.
+		{[]string{"lib/foo/foo.go", "lib/foo/testdata/foo.txt"}, []string{"vanadium-go-build", "vanadium-go-test"}, nil},
+		{[]string{"lib/foo/foo.vdl"}, []string{"vanadium-go-build", "vanadium-go-test"}, nil},
+		// Build files, files without rules and CLs without files
+		// require all tests.
+		{[]string{"Makefile", "lib/foo/foo.go"}, allTests, nil},
+		{[]string{"services/bar/bar.go"}, allTests, nil},
+		{nil, allTests, nil},
+	}
+	newCL := func(projectName string, changedFiles []string) gerrit.Change {
+		files := map[string]struct{}{"/COMMIT_MSG": struct{}{}}
+		for _, file := range changedFiles {
+			files[file] = struct{}{}
+		}
+		data, err := json.Marshal(map[string]interface{}{
+			"project":   projectName,
+			"revisions": map[string]interface{}{"1": map[string]interface{}{"files": files}},
+		})
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		var cl gerrit.Change
+		if err := json.Unmarshal(data, &cl); err != nil {
+			t.Fatalf("%v", err)
+		}
+		return cl
+	}
+	for _, test := range testCases {
+		cl := newCL("release.go.core", test.files)
+		tests, pkgs, err := sender.selectTests(fake.X, gerrit.CLList{cl})
+		if err != nil {
+			t.Fatalf("%v: %v", test.files, err)
+		}
+		if got, want := tests, test.wantTests; !reflect.DeepEqual(got, want) {
+			t.Errorf("%v: got tests %v, want %v", test.files, got, want)
+		}
+		if got, want := pkgs, test.wantPkgs; !reflect.DeepEqual(got, want) {
+			t.Errorf("%v: got packages %v, want %v", test.files, got, want)
+		}
+	}
+
+	// The Go-based tests selected for changes to other projects are not
+	// limited to the packages modified by the changes to Go projects.
+	cls := gerrit.CLList{
+		newCL("release.go.core", []string{"lib/foo/foo.go"}),
+		newCL("release.js.core", []string{"src/foo.js"}),
+	}
+	tests, pkgs, err := sender.selectTests(fake.X, cls)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if got, want := tests, allTests; !reflect.DeepEqual(got, want) {
+		t.Errorf("got tests %v, want %v", got, want)
+	}
+	if pkgs != nil {
+		t.Errorf("got packages %v, want nil", pkgs)
+	}
+}
+
+func TestPlanIncrementalRun(t *testing.T) {
+	fake, cleanup := jiritest.NewFakeJiriRoot(t)
+	defer cleanup()
+
+	// Create a fake configuration file.
+	config := tooldata.NewConfig(
+		tooldata.PathTestsOpt(map[string][]tooldata.PathTest{
+			"release.go.core": []tooldata.PathTest{
+				{Pattern: "*.md"},
+				{Pattern: "Makefile", All: true},
+				{Pattern: "lib/...", Tests: []string{"go"}},
+			},
+		}),
+		tooldata.ProjectTestsOpt(map[string][]string{
+			"release.go.core": []string{"go", "javascript"},
+		}),
+		tooldata.TestGroupsOpt(map[string][]string{
+			"go": []string{"vanadium-go-build", "vanadium-go-test"},
+		}),
+	)
+	if err := tooldata.SaveConfig(fake.X, config); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// The previous patchset passed all tests but vanadium-go-test.
+	prevCL := gerrit.GenCL(1000, 1, "release.go.core")
+	result := func(status test.Status) []testResultInfo {
+		return []testResultInfo{{Result: test.Result{Status: status}}}
+	}
+	prev := patchsetResults{
+		Refs: []string{prevCL.Reference()},
+		Results: map[string][]testResultInfo{
+			"javascript":        result(test.Passed),
+			"vanadium-go-build": result(test.Passed),
+			"vanadium-go-test":  result(test.Failed),
+		},
+	}
+	var interdiff []string
+	sender := clsSender{
+		projects: project.Projects{
+			project.ProjectKey("release.go.core"): project.Project{
+				Name: "release.go.core",
+			},
+		},
+		incrementalResults: incrementalResults{"1000": prev},
+		// Mock out the diffPatchsets function.
+		interdiffFn: func(jirix *jiri.X, p project.Project, prevRef, ref string) ([]string, error) {
+			if got, want := prevRef, prevCL.Reference(); got != want {
+				return nil, fmt.Errorf("got %v, want %v", got, want)
+			}
+			return interdiff, nil
+		},
+	}
+	allTests := []string{"javascript", "vanadium-go-build", "vanadium-go-test"}
+	testCases := []struct {
+		cl         gerrit.Change
+		interdiff  []string
+		wantTests  []string
+		wantReused []string
+	}{
+		// Tests that failed run again, even if the changes do not
+		// affect them.
+		{gerrit.GenCL(1000, 2, "release.go.core"), nil, []string{"vanadium-go-test"}, []string{"javascript", "vanadium-go-build"}},
+		{gerrit.GenCL(1000, 2, "release.go.core"), []string{"README.md"}, []string{"vanadium-go-test"}, []string{"javascript", "vanadium-go-build"}},
+		// Tests affected by the changes run again.
+		{gerrit.GenCL(1000, 3, "release.go.core"), []string{"lib/foo/foo.go"}, []string{"vanadium-go-build", "vanadium-go-test"}, []string{"javascript"}},
+		{gerrit.GenCL(1000, 3, "release.go.core"), []string{"Makefile"}, allTests, []string{}},
+		// Results of the same patchset or of other CLs are not reused.
+		{prevCL, nil, allTests, nil},
+		{gerrit.GenCL(2000, 2, "release.go.core"), nil, allTests, nil},
+	}
+	for _, test := range testCases {
+		interdiff = test.interdiff
+		tests, reused, _ := sender.planIncrementalRun(fake.X, gerrit.CLList{test.cl}, allTests)
+		if got, want := tests, test.wantTests; !reflect.DeepEqual(got, want) {
+			t.Errorf("%v %v: got tests %v, want %v", test.cl.Reference(), test.interdiff, got, want)
+		}
+		if got, want := reused, test.wantReused; !reflect.DeepEqual(got, want) {
+			t.Errorf("%v %v: got reused tests %v, want %v", test.cl.Reference(), test.interdiff, got, want)
+		}
+	}
+
+	// The results reused are pinned when the run is planned.
+	interdiff = nil
+	_, reused, planned := sender.planIncrementalRun(fake.X, gerrit.CLList{gerrit.GenCL(1000, 2, "release.go.core")}, allTests)
+	pinned, err := json.Marshal(pinReusedResults(planned, reused))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	incrementalFileFlag = filepath.Join(fake.X.Root, "incremental.json")
+	if err := recordTestResults(fake.X, []string{gerrit.GenCL(1000, 3, "release.go.core").Reference()}, result(test.Failed)); err != nil {
+		t.Fatalf("%v", err)
+	}
+	reusedResults, err := reusedTestResults(string(pinned))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if got, want := len(reusedResults), 2; got != want {
+		t.Fatalf("got %d reused results, want %d", got, want)
+	}
+	for _, r := range reusedResults {
+		if got, want := r.ReusedFrom, "1000/1"; r.Result.Status != test.Passed || got != want {
+			t.Fatalf("got result %v reused from %v, want a passed result reused from %v", r.Result.Status, got, want)
+		}
+	}
+
+	// Updates of the stored results do not overwrite the results of
+	// newer patchsets.
+	if err := recordTestResults(fake.X, []string{gerrit.GenCL(1000, 2, "release.go.core").Reference()}, result(test.Passed)); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := updateIncrementalResults(fake.X, incrementalFileFlag, func(results incrementalResults) {
+		setIncrementalResults(results, "2000", patchsetResults{Refs: []string{gerrit.GenCL(2000, 1, "release.go.core").Reference()}})
+	}); err != nil {
+		t.Fatalf("%v", err)
+	}
+	stored, err := loadIncrementalResults(fake.X, incrementalFileFlag)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if got, want := stored["1000"].Refs, []string{gerrit.GenCL(1000, 3, "release.go.core").Reference()}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got refs %v, want %v", got, want)
+	}
+	if _, ok := stored["2000"]; !ok {
+		t.Fatalf("results of 2000 were not stored")
+	}
+
+	// Results of CLs that are no longer open are pruned.
+	if got := pruneIncrementalResults(sender.incrementalResults, gerrit.CLList{gerrit.GenCL(1000, 4, "release.go.core")}); len(got) != 0 {
+		t.Fatalf("results of open CLs were pruned: %v", got)
+	}
+	if got, want := pruneIncrementalResults(sender.incrementalResults, gerrit.CLList{gerrit.GenCL(2000, 1, "release.go.core")}), []string{"1000"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got pruned results %v, want %v", got, want)
+	}
+	if got := len(sender.incrementalResults); got != 0 {
+		t.Fatalf("got %d results, want none", got)
+	}
+}
+
 func TestIsBuildOutdated(t *testing.T) {
 	type testCase struct {
 		refs     string
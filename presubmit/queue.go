@@ -0,0 +1,512 @@
+// Copyright 2015 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"v.io/jiri"
+	"v.io/jiri/gerrit"
+	"v.io/jiri/runutil"
+	"v.io/x/devtools/internal/ci"
+	"v.io/x/devtools/tooldata"
+)
+
+const (
+	defaultMergeQueueFile = "${HOME}/tmp/presubmit_merge_queue.json"
+	defaultMergeQueueJob  = "vanadium-merge-queue-test"
+)
+
+var (
+	mergeQueueBatchSizeFlag int
+	mergeQueueFileFlag      string
+	mergeQueueJobFlag       string
+)
+
+func init() {
+	cmdQuery.Flags.IntVar(&mergeQueueBatchSizeFlag, "merge-queue-batch-size", 8, "The maximum number of CLs (or multi-part CL sets) the merge queue tests together.")
+	cmdQuery.Flags.StringVar(&mergeQueueFileFlag, "merge-queue-file", os.ExpandEnv(defaultMergeQueueFile), "The file that stores the state of the merge queue.")
+	cmdQuery.Flags.Lookup("merge-queue-file").DefValue = defaultMergeQueueFile
+	cmdQuery.Flags.StringVar(&mergeQueueJobFlag, "merge-queue-job", defaultMergeQueueJob, "The name of the Jenkins job that tests batches of the merge queue.")
+}
+
+// Statuses of merge queue builds.
+const (
+	// buildMissing means that no build exists for the given CLs, for
+	// example because it was cancelled.
+	buildMissing = iota
+	buildRunning
+	buildPassed
+	buildFailed
+)
+
+// mergeQueueState is the persistent state of the merge queue.
+//
+// Approved CLs are added to Pending. Batches of pending CLs are moved
+// to Candidates and tested on top of master. If a batch passes and all
+// its CLs are still submittable, it is moved to Submitting and its CLs
+// are submitted one at a time; if some of its CLs are no longer
+// submittable, it is tested again without them. Otherwise, it is bisected to find a CL that breaks the
+// tests (the culprit): the first half of the candidates is tested, and
+// either submitted if it passes or bisected further if it fails. Once
+// the culprit is found, it is rejected and the remaining untested CLs
+// are returned to the front of Pending.
+type mergeQueueState struct {
+	// Pending holds the CL lists waiting to be tested, in the order they
+	// were approved. Multi-part CLs form a single CL list.
+	Pending []gerrit.CLList
+	// Candidates holds the CL lists of the current batch that have been
+	// neither submitted nor rejected.
+	Candidates []gerrit.CLList
+	// Testing is the number of leading candidates tested by the
+	// current build.
+	Testing int
+	// Bisecting determines whether the batch failed, which means that
+	// the candidates contain a culprit.
+	Bisecting bool
+	// Failures holds the test cases that failed in the last failed
+	// build of the batch.
+	Failures []ci.TestCase
+	// Rejected identifies the rejected CL lists that are still
+	// submittable, which are not queued again until a new patchset is
+	// uploaded.
+	Rejected []string
+	// Submitting holds the CL lists that passed their tests and are
+	// being submitted, without the CLs that have been submitted.
+	Submitting []gerrit.CLList
+	// InFlight is the reference of the first CL of Submitting if its
+	// submission started, in which case it may have been submitted
+	// even if it is still in Submitting.
+	InFlight string `json:",omitempty"`
+}
+
+// mergeQueue implements the merge queue.
+type mergeQueue struct {
+	state         mergeQueueState
+	batchSize     int
+	submittable   map[string]bool
+	startBuildFn  func(*jiri.X, []gerrit.CLList) error
+	buildStatusFn func(*jiri.X, []gerrit.CLList) (int, []ci.TestCase, error)
+	submitFn      func(*jiri.X, gerrit.Change) error
+	mergedFn      func(*jiri.X, gerrit.Change) (bool, error)
+	rejectFn      func(*jiri.X, gerrit.CLList, []ci.TestCase) error
+	saveFn        func(*jiri.X, mergeQueueState) error
+}
+
+// loadMergeQueueState reads the state of the merge queue from the given
+// file. A missing file represents an empty queue.
+func loadMergeQueueState(jirix *jiri.X, path string) (mergeQueueState, error) {
+	var state mergeQueueState
+	bytes, err := jirix.NewSeq().ReadFile(path)
+	if err != nil {
+		if runutil.IsNotExist(err) {
+			return state, nil
+		}
+		return state, err
+	}
+	if err := json.Unmarshal(bytes, &state); err != nil {
+		return state, fmt.Errorf("Unmarshal(%v) failed: %v", path, err)
+	}
+	return state, nil
+}
+
+// saveMergeQueueState writes the state of the merge queue to the given
+// file.
+func saveMergeQueueState(jirix *jiri.X, path string, state mergeQueueState) error {
+	bytes, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("MarshalIndent(%v) failed: %v", state, err)
+	}
+	tmpPath := path + ".tmp"
+	s := jirix.NewSeq()
+	return s.MkdirAll(filepath.Dir(path), os.FileMode(0755)).
+		WriteFile(tmpPath, bytes, os.FileMode(0644)).
+		Rename(tmpPath, path).Done()
+}
+
+// runMergeQueue advances the merge queue persisted in the file
+// identified by the command-line flags, given the currently submittable
+// CLs.
+func runMergeQueue(jirix *jiri.X, submittableCLs []gerrit.CLList) error {
+	state, err := loadMergeQueueState(jirix, mergeQueueFileFlag)
+	if err != nil {
+		return err
+	}
+	queue := mergeQueue{
+		state:         state,
+		batchSize:     mergeQueueBatchSizeFlag,
+		startBuildFn:  startMergeQueueBuild,
+		buildStatusFn: mergeQueueBuildStatus,
+		submitFn:      submitMergeQueueCL,
+		mergedFn:      isCLMerged,
+		rejectFn:      rejectMergeQueueCLs,
+		saveFn: func(jirix *jiri.X, state mergeQueueState) error {
+			return saveMergeQueueState(jirix, mergeQueueFileFlag, state)
+		},
+	}
+	processErr := queue.process(jirix, submittableCLs)
+	if err := saveMergeQueueState(jirix, mergeQueueFileFlag, queue.state); err != nil {
+		return err
+	}
+	return processErr
+}
+
+// process advances the merge queue: it adds the given submittable CLs
+// to the queue, handles the result of the current build, and starts the
+// next build.
+func (q *mergeQueue) process(jirix *jiri.X, submittableCLs []gerrit.CLList) error {
+	q.submittable = map[string]bool{}
+	for _, cls := range submittableCLs {
+		q.submittable[clListKey(cls)] = true
+	}
+	q.updatePending(submittableCLs)
+
+	// Finish submitting the CLs of the last batch that passed.
+	if err := q.submit(jirix); err != nil {
+		return err
+	}
+	if len(q.state.Candidates) > 0 {
+		status, failures, err := q.buildStatusFn(jirix, q.testing())
+		if err != nil {
+			return err
+		}
+		switch status {
+		case buildRunning:
+			return nil
+		case buildMissing:
+			return q.startBuildFn(jirix, q.testing())
+		case buildPassed:
+			if err := q.handlePass(jirix); err != nil {
+				return err
+			}
+		case buildFailed:
+			if err := q.handleFailure(jirix, failures); err != nil {
+				return err
+			}
+		}
+	}
+	if len(q.state.Candidates) > 0 {
+		return q.startBuildFn(jirix, q.testing())
+	}
+	return q.startBatch(jirix)
+}
+
+// updatePending adds the given submittable CLs that are not in the
+// queue yet to Pending and removes the pending CLs that are no longer
+// submittable, for example because a new patchset was uploaded.
+func (q *mergeQueue) updatePending(submittableCLs []gerrit.CLList) {
+	queued := map[string]bool{}
+	rejected := []string{}
+	for _, key := range q.state.Rejected {
+		if q.submittable[key] {
+			queued[key] = true
+			rejected = append(rejected, key)
+		}
+	}
+	q.state.Rejected = rejected
+	pending := []gerrit.CLList{}
+	for _, cls := range q.state.Pending {
+		if key := clListKey(cls); q.submittable[key] {
+			queued[key] = true
+			pending = append(pending, cls)
+		}
+	}
+	for _, cls := range append(append([]gerrit.CLList{}, q.state.Candidates...), q.state.Submitting...) {
+		queued[clListKey(cls)] = true
+	}
+	for _, cls := range submittableCLs {
+		if key := clListKey(cls); !queued[key] {
+			queued[key] = true
+			pending = append(pending, cls)
+		}
+	}
+	q.state.Pending = pending
+}
+
+// startBatch moves the next batch of pending CLs to the candidates and
+// starts testing it.
+func (q *mergeQueue) startBatch(jirix *jiri.X) error {
+	if len(q.state.Pending) == 0 {
+		return nil
+	}
+	n := len(q.state.Pending)
+	if q.batchSize > 0 && n > q.batchSize {
+		n = q.batchSize
+	}
+	q.state.Candidates = append([]gerrit.CLList{}, q.state.Pending[:n]...)
+	q.state.Pending = q.state.Pending[n:]
+	q.state.Testing = n
+	q.state.Bisecting = false
+	q.state.Failures = nil
+	printf(jirix.Stdout(), "Testing a batch of %d CL(s) in the merge queue.\n", n)
+	return q.startBuildFn(jirix, q.testing())
+}
+
+// handlePass submits the tested candidates if they are all still
+// submittable. Otherwise, the candidates that changed or lost their
+// approval while they were being tested are dropped, and the others are
+// tested again, as they were not tested without the dropped ones.
+func (q *mergeQueue) handlePass(jirix *jiri.X) error {
+	tested, stale := []gerrit.CLList{}, []string{}
+	for _, cls := range q.testing() {
+		if key := clListKey(cls); q.submittable[key] {
+			tested = append(tested, cls)
+		} else {
+			stale = append(stale, key)
+		}
+	}
+	if len(stale) > 0 {
+		printf(jirix.Stdout(), "Not submitting the batch as %s is no longer submittable; testing it again without it.\n", strings.Join(stale, ", "))
+		q.state.Candidates = append(tested, q.state.Candidates[q.state.Testing:]...)
+		q.state.Testing = len(tested)
+		switch {
+		case len(q.state.Candidates) == 0:
+			q.endBatch()
+		case q.state.Testing == 0:
+			q.state.Testing = len(q.state.Candidates)
+		}
+		return nil
+	}
+
+	q.state.Submitting = append(q.state.Submitting, tested...)
+	q.state.Candidates = q.state.Candidates[q.state.Testing:]
+	switch {
+	case len(q.state.Candidates) == 0:
+		q.endBatch()
+	case len(q.state.Candidates) == 1:
+		// The remaining candidate failed together with CLs that are
+		// being submitted.
+		if err := q.reject(jirix); err != nil {
+			return err
+		}
+	default:
+		q.state.Testing = len(q.state.Candidates) / 2
+	}
+	return q.submit(jirix)
+}
+
+// submit submits the CLs in Submitting one at a time. The state is saved
+// before each submission, so that CLs submitted before a failure or a
+// crash are not submitted again. If a CL fails to be submitted, the rest
+// of its CL list is dropped.
+func (q *mergeQueue) submit(jirix *jiri.X) error {
+	for len(q.state.Submitting) > 0 {
+		cl := q.state.Submitting[0][0]
+		ref := cl.Reference()
+		if q.state.InFlight == ref {
+			merged, err := q.mergedFn(jirix, cl)
+			if err != nil {
+				return err
+			}
+			if merged {
+				q.popSubmitted()
+				continue
+			}
+		}
+		q.state.InFlight = ref
+		if err := q.saveFn(jirix, q.state); err != nil {
+			return err
+		}
+		if err := q.submitFn(jirix, cl); err != nil {
+			q.state.Submitting = q.state.Submitting[1:]
+			q.state.InFlight = ""
+			return err
+		}
+		q.popSubmitted()
+	}
+	return nil
+}
+
+// popSubmitted removes the first CL of Submitting, which has been
+// submitted.
+func (q *mergeQueue) popSubmitted() {
+	if rest := q.state.Submitting[0][1:]; len(rest) > 0 {
+		q.state.Submitting[0] = rest
+	} else {
+		q.state.Submitting = q.state.Submitting[1:]
+	}
+	q.state.InFlight = ""
+}
+
+// handleFailure narrows down the candidates to the tested ones, which
+// contain a culprit.
+func (q *mergeQueue) handleFailure(jirix *jiri.X, failures []ci.TestCase) error {
+	q.state.Bisecting = true
+	q.state.Failures = failures
+	untested := q.state.Candidates[q.state.Testing:]
+	q.state.Candidates = q.state.Candidates[:q.state.Testing]
+	q.state.Pending = append(append([]gerrit.CLList{}, untested...), q.state.Pending...)
+	if len(q.state.Candidates) == 1 {
+		return q.reject(jirix)
+	}
+	q.state.Testing = len(q.state.Candidates) / 2
+	return nil
+}
+
+// reject rejects the only remaining candidate, which is the culprit of
+// the failure of the batch.
+func (q *mergeQueue) reject(jirix *jiri.X) error {
+	culprit := q.state.Candidates[0]
+	printf(jirix.Stdout(), "Removing %s from the merge queue as it breaks tests.\n", clListKey(culprit))
+	if err := q.rejectFn(jirix, culprit, q.state.Failures); err != nil {
+		return err
+	}
+	q.state.Rejected = append(q.state.Rejected, clListKey(culprit))
+	q.endBatch()
+	return nil
+}
+
+func (q *mergeQueue) endBatch() {
+	q.state.Candidates = nil
+	q.state.Testing = 0
+	q.state.Bisecting = false
+	q.state.Failures = nil
+}
+
+// testing returns the CL lists tested by the current build.
+func (q *mergeQueue) testing() []gerrit.CLList {
+	return q.state.Candidates[:q.state.Testing]
+}
+
+// clListKey returns a string that identifies the given CL list and its
+// patchsets.
+func clListKey(cls gerrit.CLList) string {
+	refs := []string{}
+	for _, cl := range cls {
+		refs = append(refs, cl.Reference())
+	}
+	return strings.Join(refs, ":")
+}
+
+// mergeQueueRefs returns the refs and projects of the given CL lists.
+func mergeQueueRefs(clLists []gerrit.CLList) ([]string, []string) {
+	refs, projects := []string{}, []string{}
+	for _, cls := range clLists {
+		for _, cl := range cls {
+			refs = append(refs, cl.Reference())
+			projects = append(projects, cl.Project)
+		}
+	}
+	return refs, projects
+}
+
+// startMergeQueueBuild adds a build that runs all tests of the projects
+// of the given CLs on top of master.
+func startMergeQueueBuild(jirix *jiri.X, clLists []gerrit.CLList) error {
+	executor, err := newExecutor(jirix)
+	if err != nil {
+		return err
+	}
+	config, err := tooldata.LoadConfig(jirix)
+	if err != nil {
+		return err
+	}
+	refs, projects := mergeQueueRefs(clLists)
+	tests := expandTestParts(config, config.ProjectTests(projects))
+	return executor.Enqueue(mergeQueueJobFlag, url.Values{
+		"REFS":     {strings.Join(refs, ":")},
+		"PROJECTS": {strings.Join(projects, ":")},
+		"TESTS":    {strings.Join(tests, " ")},
+	})
+}
+
+// mergeQueueBuildStatus returns the status of the build that tests the
+// given CLs. As the merge queue runs one build at a time, the build is
+// either queued, ongoing, or the last completed build of the job.
+func mergeQueueBuildStatus(jirix *jiri.X, clLists []gerrit.CLList) (int, []ci.TestCase, error) {
+	executor, err := newExecutor(jirix)
+	if err != nil {
+		return buildMissing, nil, err
+	}
+	refs, _ := mergeQueueRefs(clLists)
+	strRefs := strings.Join(refs, ":")
+	queued, err := executor.Queued(mergeQueueJobFlag)
+	if err != nil {
+		return buildMissing, nil, err
+	}
+	ongoing, err := executor.Ongoing(mergeQueueJobFlag)
+	if err != nil {
+		return buildMissing, nil, err
+	}
+	for _, build := range append(queued, ongoing...) {
+		if build.Refs() == strRefs {
+			return buildRunning, nil, nil
+		}
+	}
+	build, err := executor.LastCompleted(mergeQueueJobFlag, nil)
+	if err != nil || build.Refs() != strRefs {
+		return buildMissing, nil, nil
+	}
+	switch build.Result {
+	case ci.ResultSuccess:
+		return buildPassed, nil, nil
+	case ci.ResultAborted:
+		return buildMissing, nil, nil
+	}
+	// "failures" will be empty on error.
+	failures, _ := executor.FailedTestCases(mergeQueueJobFlag, nil, build.Number)
+	return buildFailed, failures, nil
+}
+
+// submitMergeQueueCL submits the given CL, and reports to it if it
+// cannot be submitted.
+func submitMergeQueueCL(jirix *jiri.X, cl gerrit.Change) error {
+	system, err := newReviewSystem(jirix)
+	if err != nil {
+		return err
+	}
+	change, err := changeFromCL(cl)
+	if err != nil {
+		return err
+	}
+	ref := cl.Reference()
+	printf(jirix.Stdout(), "Submitting %s.\n", ref)
+	if err := system.Submit(change); err != nil {
+		if err := postMessage(jirix, fmt.Sprintf("Failed to submit CL:\n%v\n", err), []string{ref}, true); err != nil {
+			fmt.Fprintf(jirix.Stderr(), "%v\n", err)
+		}
+		return fmt.Errorf("Submit(%v) failed: %v", ref, err)
+	}
+	return nil
+}
+
+// isCLMerged determines whether the given CL has been merged.
+func isCLMerged(jirix *jiri.X, cl gerrit.Change) (bool, error) {
+	system, err := newReviewSystem(jirix)
+	if err != nil {
+		return false, err
+	}
+	change, err := changeFromCL(cl)
+	if err != nil {
+		return false, err
+	}
+	changes, err := system.Query(fmt.Sprintf("change:%d status:merged", change.Number))
+	if err != nil {
+		return false, err
+	}
+	return len(changes) > 0, nil
+}
+
+// rejectMergeQueueCLs reports to the given CLs that they were removed
+// from the merge queue because of the given test failures.
+func rejectMergeQueueCLs(jirix *jiri.X, cls gerrit.CLList, failures []ci.TestCase) error {
+	message := "This change was removed from the merge queue because it breaks tests on top of master"
+	if len(failures) == 0 {
+		message += ".\n"
+	} else {
+		message += ":\n"
+		for _, failure := range failures {
+			message += fmt.Sprintf("- %s.%s\n", failure.ClassName, failure.Name)
+		}
+	}
+	message += "\nPlease fix the failures and upload a new patchset.\n"
+	refs, _ := mergeQueueRefs([]gerrit.CLList{cls})
+	return postMessage(jirix, message, refs, false)
+}
@@ -0,0 +1,287 @@
+// Copyright 2015 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"v.io/jiri"
+	"v.io/jiri/gerrit"
+	"v.io/jiri/jiritest"
+	"v.io/x/devtools/internal/ci"
+)
+
+// fakeMergeQueueCI simulates the builds of the merge queue. Builds fail
+// if they test the culprit and complete when their status is checked.
+// Submissions fail for the CLs in failSubmit, and submitting the CL
+// crash (if any) submits it but fails as if presubmit crashed.
+type fakeMergeQueueCI struct {
+	culprit    string
+	builds     []string
+	submitted  []string
+	rejected   []string
+	failures   []ci.TestCase
+	missing    bool
+	failSubmit map[string]bool
+	crash      string
+	saved      []mergeQueueState
+}
+
+func (f *fakeMergeQueueCI) queue(state mergeQueueState) *mergeQueue {
+	return &mergeQueue{
+		state:     state,
+		batchSize: 8,
+		startBuildFn: func(jirix *jiri.X, clLists []gerrit.CLList) error {
+			refs, _ := mergeQueueRefs(clLists)
+			f.builds = append(f.builds, strings.Join(refs, " "))
+			return nil
+		},
+		buildStatusFn: func(jirix *jiri.X, clLists []gerrit.CLList) (int, []ci.TestCase, error) {
+			if f.missing {
+				f.missing = false
+				return buildMissing, nil, nil
+			}
+			for _, cls := range clLists {
+				if clListKey(cls) == f.culprit {
+					return buildFailed, []ci.TestCase{{ClassName: "v.io/x/foo", Name: "TestFoo"}}, nil
+				}
+			}
+			return buildPassed, nil, nil
+		},
+		submitFn: func(jirix *jiri.X, cl gerrit.Change) error {
+			ref := cl.Reference()
+			if f.failSubmit[ref] {
+				return fmt.Errorf("failed to submit %v", ref)
+			}
+			f.submitted = append(f.submitted, ref)
+			if ref == f.crash {
+				f.crash = ""
+				return fmt.Errorf("crashed")
+			}
+			return nil
+		},
+		mergedFn: func(jirix *jiri.X, cl gerrit.Change) (bool, error) {
+			for _, ref := range f.submitted {
+				if ref == cl.Reference() {
+					return true, nil
+				}
+			}
+			return false, nil
+		},
+		saveFn: func(jirix *jiri.X, state mergeQueueState) error {
+			f.saved = append(f.saved, state)
+			return nil
+		},
+		rejectFn: func(jirix *jiri.X, cls gerrit.CLList, failures []ci.TestCase) error {
+			f.rejected = append(f.rejected, clListKey(cls))
+			f.failures = failures
+			return nil
+		},
+	}
+}
+
+func TestMergeQueueBisect(t *testing.T) {
+	jirix, cleanup := jiritest.NewX(t)
+	defer cleanup()
+
+	cls := []gerrit.CLList{}
+	for i := 1; i <= 4; i++ {
+		cls = append(cls, gerrit.CLList{gerrit.GenCL(1000+i, 1, "release.go.core")})
+	}
+	ref := func(i int) string { return cls[i][0].Reference() }
+	f := &fakeMergeQueueCI{culprit: ref(2)}
+	queue := f.queue(mergeQueueState{})
+
+	// Process the queue until it is empty. The culprit remains
+	// submittable, which must not make it queued again.
+	for i := 0; i < 10; i++ {
+		submittable := []gerrit.CLList{}
+		submitted := map[string]bool{}
+		for _, key := range f.submitted {
+			submitted[key] = true
+		}
+		for _, cl := range cls {
+			if !submitted[clListKey(cl)] {
+				submittable = append(submittable, cl)
+			}
+		}
+		if err := queue.process(jirix, submittable); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+
+	// The batch fails, its first half passes and is submitted, and the
+	// culprit is identified by testing it alone. The CL that was not
+	// tested is then tested in a new batch.
+	wantBuilds := []string{
+		strings.Join([]string{ref(0), ref(1), ref(2), ref(3)}, " "),
+		strings.Join([]string{ref(0), ref(1)}, " "),
+		ref(2),
+		ref(3),
+	}
+	if got, want := f.builds, wantBuilds; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got builds %v, want %v", got, want)
+	}
+	if got, want := f.submitted, []string{ref(0), ref(1), ref(3)}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got submitted %v, want %v", got, want)
+	}
+	if got, want := f.rejected, []string{ref(2)}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got rejected %v, want %v", got, want)
+	}
+	if got, want := f.failures, []ci.TestCase{{ClassName: "v.io/x/foo", Name: "TestFoo"}}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got failures %v, want %v", got, want)
+	}
+	if got, want := queue.state.Rejected, []string{ref(2)}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got := len(queue.state.Pending) + len(queue.state.Candidates); got != 0 {
+		t.Fatalf("got %d queued CLs, want none", got)
+	}
+
+	// A new patchset of the culprit is queued again.
+	fixed := gerrit.CLList{gerrit.GenCL(1003, 2, "release.go.core")}
+	if err := queue.process(jirix, []gerrit.CLList{cls[2], fixed}); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if got, want := f.builds[len(f.builds)-1], clListKey(fixed); got != want {
+		t.Fatalf("got build %v, want %v", got, want)
+	}
+}
+
+func TestMergeQueuePersistence(t *testing.T) {
+	jirix, cleanup := jiritest.NewX(t)
+	defer cleanup()
+
+	cls := []gerrit.CLList{
+		gerrit.CLList{gerrit.GenCL(1000, 1, "release.go.core")},
+		gerrit.CLList{
+			gerrit.GenMultiPartCL(1001, 1, "release.go.core", "t", 1, 2),
+			gerrit.GenMultiPartCL(1002, 1, "release.js.core", "t", 2, 2),
+		},
+	}
+	f := &fakeMergeQueueCI{}
+	queue := f.queue(mergeQueueState{})
+	if err := queue.process(jirix, cls); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// Save and restore the state, as if presubmit was restarted.
+	path := filepath.Join(jirix.Root, "merge_queue.json")
+	if err := saveMergeQueueState(jirix, path, queue.state); err != nil {
+		t.Fatalf("%v", err)
+	}
+	state, err := loadMergeQueueState(jirix, path)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if got, want := len(state.Candidates), 2; got != want {
+		t.Fatalf("got %v candidates, want %v", got, want)
+	}
+	if got, want := clListKey(state.Candidates[1]), clListKey(cls[1]); got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	// The build of the batch got lost, so it is started again, and
+	// then passes.
+	f.missing = true
+	queue = f.queue(state)
+	for i := 0; i < 2; i++ {
+		if err := queue.process(jirix, cls); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+	if got, want := len(f.builds), 2; got != want {
+		t.Fatalf("got %v builds, want %v", got, want)
+	}
+	if got, want := f.submitted, []string{cls[0][0].Reference(), cls[1][0].Reference(), cls[1][1].Reference()}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got submitted %v, want %v", got, want)
+	}
+
+	// A missing state file represents an empty queue.
+	if state, err = loadMergeQueueState(jirix, filepath.Join(jirix.Root, "missing.json")); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if !reflect.DeepEqual(state, mergeQueueState{}) {
+		t.Fatalf("got %v, want an empty state", state)
+	}
+}
+
+func TestMergeQueueStaleCandidates(t *testing.T) {
+	jirix, cleanup := jiritest.NewX(t)
+	defer cleanup()
+
+	cls := []gerrit.CLList{}
+	for i := 1; i <= 3; i++ {
+		cls = append(cls, gerrit.CLList{gerrit.GenCL(1000+i, 1, "release.go.core")})
+	}
+	f := &fakeMergeQueueCI{}
+	queue := f.queue(mergeQueueState{})
+	if err := queue.process(jirix, cls); err != nil {
+		t.Fatalf("%v", err)
+	}
+	// A CL of the batch gets a new patchset while the batch is tested,
+	// so the batch passes but is not submitted. It is tested again
+	// without that CL, and then submitted.
+	rest := []gerrit.CLList{cls[0], cls[2]}
+	for i := 0; i < 2; i++ {
+		if err := queue.process(jirix, rest); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+	wantBuilds := []string{
+		strings.Join([]string{clListKey(cls[0]), clListKey(cls[1]), clListKey(cls[2])}, " "),
+		strings.Join([]string{clListKey(cls[0]), clListKey(cls[2])}, " "),
+	}
+	if got, want := f.builds, wantBuilds; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got builds %v, want %v", got, want)
+	}
+	if got, want := f.submitted, []string{clListKey(cls[0]), clListKey(cls[2])}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got submitted %v, want %v", got, want)
+	}
+}
+
+func TestMergeQueueSubmitProgress(t *testing.T) {
+	jirix, cleanup := jiritest.NewX(t)
+	defer cleanup()
+
+	cls := []gerrit.CLList{
+		gerrit.CLList{gerrit.GenCL(1000, 1, "release.go.core")},
+		gerrit.CLList{
+			gerrit.GenMultiPartCL(1001, 1, "release.go.core", "t", 1, 2),
+			gerrit.GenMultiPartCL(1002, 1, "release.js.core", "t", 2, 2),
+		},
+		gerrit.CLList{gerrit.GenCL(1003, 1, "release.go.core")},
+	}
+	ref := func(i, j int) string { return cls[i][j].Reference() }
+	f := &fakeMergeQueueCI{crash: ref(1, 0), failSubmit: map[string]bool{ref(0, 0): true}}
+	queue := f.queue(mergeQueueState{})
+	if err := queue.process(jirix, cls); err != nil {
+		t.Fatalf("%v", err)
+	}
+	// The first CL fails to be submitted, which drops it.
+	if err := queue.process(jirix, cls); err == nil || !strings.Contains(err.Error(), "failed to submit") {
+		t.Fatalf("got error %v, want a submit failure", err)
+	}
+	// Presubmit crashes right after submitting the first part of the
+	// multi-part CL, so only the state saved before that is kept.
+	if err := queue.process(jirix, cls[1:]); err == nil || !strings.Contains(err.Error(), "crashed") {
+		t.Fatalf("got error %v, want a crash", err)
+	}
+	queue = f.queue(f.saved[len(f.saved)-1])
+	// The CLs submitted before the crash are not submitted again.
+	if err := queue.process(jirix, []gerrit.CLList{cls[0], cls[2]}); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if got, want := f.submitted, []string{ref(1, 0), ref(1, 1), ref(2, 0)}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got submitted %v, want %v", got, want)
+	}
+	if got := len(queue.state.Submitting); got != 0 || queue.state.InFlight != "" {
+		t.Fatalf("got %v CL lists being submitted, in flight %q, want none", got, queue.state.InFlight)
+	}
+}
@@ -20,8 +20,8 @@ import (
 	"time"
 
 	"v.io/jiri"
-	"v.io/jiri/jenkins"
 	"v.io/jiri/tool"
+	"v.io/x/devtools/internal/ci"
 	"v.io/x/devtools/internal/test"
 	"v.io/x/devtools/internal/xunit"
 	"v.io/x/devtools/tooldata"
@@ -66,6 +66,10 @@ type testResultSummary struct {
 	lastStatus         testStatus
 	curStatus          testStatus
 	timeoutValue       time.Duration
+	// reusedFrom identifies the patchsets whose results are reused for
+	// all parts of the test, if any.
+	reusedFrom string
+	ran        bool
 }
 
 var (
@@ -94,6 +98,11 @@ var cmdResult = &cmdline.Command{
 Result processes all the test statuses and results files collected from all the
 presubmit test configuration builds, creates a result summary, and posts the
 summary back to the corresponding Gerrit review thread.
+
+The summary also lists the tests with the highest resource usage (wall time, CPU
+time and peak resident set size), and warns about tests whose usage grows beyond
+the budgets defined in the tools configuration, compared to the median usage of
+their recent passing runs.
 `,
 	Runner: jiri.RunnerFunc(runResult),
 }
@@ -104,6 +113,12 @@ type testResultInfo struct {
 	Timestamp        int64
 	PostSubmitResult string
 	AxisValues       axisValuesInfo
+	// ReusedFrom identifies the patchsets whose result is reused, if the
+	// test did not run for the current patchsets.
+	ReusedFrom string `json:",omitempty"`
+	// Partial records whether the test was limited to some Go
+	// packages.
+	Partial bool `json:",omitempty"`
 }
 
 type axisValuesInfo struct {
@@ -220,21 +235,74 @@ func runResult(jirix *jiri.X, args []string) (e error) {
 		testResults = append(testResults, curResult)
 	}
 
-	// Post results.
+	// Add the results reused from the previous patchsets.
 	refs := strings.Split(reviewTargetRefsFlag, ":")
+	if reusedResultsFlag != "" {
+		reused, err := reusedTestResults(reusedResultsFlag)
+		if err != nil {
+			return err
+		}
+		testResults = append(testResults, reused...)
+	}
+
+	// Post results.
 	postSubmitResults, err := getPostSubmitBuildData(jirix, testResults, matrixJobsConf)
 	if err != nil {
 		return err
 	}
-	reporter := testReporter{matrixJobsConf, testResults, postSubmitResults, refs, &bytes.Buffer{}}
+	var history *testHistory
+	if historyFileFlag != "" {
+		if history, err = loadTestHistory(jirix, historyFileFlag); err != nil {
+			return err
+		}
+		executor, err := newExecutor(jirix)
+		if err != nil {
+			return err
+		}
+		if err := recordPostSubmitBuilds(jirix, executor, history, testResults, matrixJobsConf, historyWindowFlag); err != nil {
+			return err
+		}
+	}
+	var baselines usageBaselines
+	if usageFileFlag != "" {
+		if baselines, err = loadUsageBaselines(jirix, usageFileFlag); err != nil {
+			return err
+		}
+	}
+	reporter := testReporter{
+		matrixJobsConf:    matrixJobsConf,
+		testResults:       testResults,
+		postSubmitResults: postSubmitResults,
+		history:           history,
+		refs:              refs,
+		config:            config,
+		baselines:         baselines,
+		report:            &bytes.Buffer{},
+	}
 	if allTestsPassed, err := reporter.postReport(jirix); err != nil {
 		return err
-	} else if allTestsPassed {
+	} else if allTestsPassed && !mergeQueueFlag {
+		// In merge queue mode, "presubmit query" submits the CLs.
 		if err := submitPresubmitCLs(jirix, refs); err != nil {
 			fmt.Fprintf(jirix.Stderr(), "%v\n", err)
 		}
 	}
 
+	// Record the resource usage of the tests for later budget checks.
+	if baselines != nil {
+		baselines.record(testResults)
+		if err := saveUsageBaselines(jirix, usageFileFlag, baselines); err != nil {
+			fmt.Fprintf(jirix.Stderr(), "%v\n", err)
+		}
+	}
+
+	// Record the results for incremental runs of later patchsets.
+	if incrementalFileFlag != "" {
+		if err := recordTestResults(jirix, refs, testResults); err != nil {
+			fmt.Fprintf(jirix.Stderr(), "%v\n", err)
+		}
+	}
+
 	// Process result files.
 	return processRemoteTestResults(jirix)
 }
@@ -243,7 +311,7 @@ func runResult(jirix *jiri.X, args []string) (e error) {
 // corresponding postsubmit builds that ran before the recorded test result
 // timestamps.
 func getPostSubmitBuildData(jirix *jiri.X, testResults []testResultInfo, matrixJobsConf map[string]tooldata.JenkinsMatrixJobInfo) (map[string]*postSubmitBuildData, error) {
-	jenkinsObj, err := jirix.Jenkins(jenkinsHostFlag)
+	executor, err := newExecutor(jirix)
 	if err != nil {
 		return nil, err
 	}
@@ -259,12 +327,12 @@ outer:
 		if jobInfo, ok := matrixJobsConf[name]; ok {
 			axisValuesMap = resultInfo.AxisValues.AsMap(jobInfo)
 		}
-		buildInfo, err := jenkinsObj.LastCompletedBuildStatus(name, axisValuesMap)
+		lastBuild, err := executor.LastCompleted(name, axisValuesMap)
 		if err != nil {
 			test.Fail(jirix.Context, "%v\n", err)
 			continue
 		}
-		curIdStr := buildInfo.Id
+		curIdStr := lastBuild.ID
 		curId, err := strconv.Atoi(curIdStr)
 		if err != nil {
 			test.Fail(jirix.Context, "Atoi(%v) failed: %v\n", curIdStr, err)
@@ -272,20 +340,19 @@ outer:
 		}
 		for i := curId; i >= 0; i-- {
 			fmt.Fprintf(jirix.Stdout(), "Checking build %d...\n", i)
-			buildSpec := jenkins.GenBuildSpec(name, axisValuesMap, fmt.Sprintf("%d", i))
-			curBuildInfo, err := jenkinsObj.BuildInfoForSpec(buildSpec)
+			curBuild, err := executor.Build(name, axisValuesMap, i)
 			if err != nil {
 				test.Fail(jirix.Context, "%v\n", err)
 				continue outer
 			}
-			if curBuildInfo.Timestamp > timestamp {
+			if curBuild.Timestamp > timestamp {
 				continue
 			}
 			// "cases" will be empty on error.
-			cases, _ := jenkinsObj.FailedTestCasesForBuildSpec(buildSpec)
-			test.Pass(jirix.Context, "Got build status of build %d: %s\n", i, curBuildInfo.Result)
+			cases, _ := executor.FailedTestCases(name, axisValuesMap, i)
+			test.Pass(jirix.Context, "Got build status of build %d: %s\n", i, curBuild.Result)
 			data[resultInfo.key()] = &postSubmitBuildData{
-				result:          curBuildInfo.Result,
+				result:          curBuild.Result,
 				failedTestCases: cases,
 			}
 			break
@@ -302,15 +369,29 @@ type testReporter struct {
 	// postSubmitResults stores postsubmit results (indexed by test names) used to
 	// compare with the presubmit results.
 	postSubmitResults map[string]*postSubmitBuildData
+	// history stores the outcomes of recent postsubmit builds used to
+	// classify the failed test cases. If nil, failed test cases are only
+	// compared with the latest postsubmit build.
+	history *testHistory
 	// refs identifies the references to post the report to.
 	refs []string
+	// config provides the resource budgets of tests. If nil, the
+	// budgets are not checked.
+	config *tooldata.Config
+	// baselines stores the resource usage of recent test runs, which
+	// the budgets are checked against.
+	baselines usageBaselines
 	// report stores the report content.
 	report *bytes.Buffer
+	// local identifies reports of tests that ran on the local checkout
+	// (see the "local" command), whose xUnit reports are found in their
+	// default location and which have no dashboard pages to link to.
+	local bool
 }
 
 type postSubmitBuildData struct {
 	result          string
-	failedTestCases []jenkins.TestCase
+	failedTestCases []ci.TestCase
 }
 
 // postReport generates a test report and posts it to Gerrit.
@@ -345,6 +426,7 @@ func (r *testReporter) postReport(jirix *jiri.X) (bool, error) {
 		}
 	}
 
+	r.reportUsage(jirix)
 	r.reportUsefulLinks(failedTestNames)
 
 	printf(jirix.Stdout(), "### Posting test results to Gerrit\n")
@@ -362,18 +444,18 @@ func (r *testReporter) postReport(jirix *jiri.X) (bool, error) {
 // result reporting step (the cmdResult command implemented in this file),
 // but just in case.
 func (r *testReporter) reportFailedPresubmitBuild(jirix *jiri.X) bool {
-	jenkins, err := jirix.Jenkins(jenkinsHostFlag)
+	executor, err := newExecutor(jirix)
 	if err != nil {
 		fmt.Fprintf(jirix.Stderr(), "%v\n", err)
 		return false
 	}
 
-	masterJobInfo, err := jenkins.BuildInfo(presubmitTestJobFlag, jenkinsBuildNumberFlag)
+	masterBuild, err := executor.Build(presubmitTestJobFlag, nil, jenkinsBuildNumberFlag)
 	if err != nil {
 		fmt.Fprintf(jirix.Stderr(), "%v\n", err)
 		return false
 	}
-	if masterJobInfo.Result == "FAILURE" {
+	if masterBuild.Result == ci.ResultFailure {
 		fmt.Fprintf(r.report, "SOME TESTS FAILED TO RUN.\nRetrying...\n")
 		return true
 	}
@@ -451,6 +533,11 @@ func (r *testReporter) reportTestResultsSummary(jirix *jiri.X) map[string]struct
 			}
 			testResultSummaries[testKey] = summary
 		}
+		if resultInfo.ReusedFrom == "" {
+			summary.ran = true
+		} else {
+			summary.reusedFrom = resultInfo.ReusedFrom
+		}
 		if testFailed := r.mergeTestResults(resultInfo, summary); testFailed {
 			failedTests[testNameWithPartSuffix(name, resultInfo.AxisValues.PartIndex)] = struct{}{}
 		}
@@ -465,6 +552,8 @@ func (r *testReporter) reportTestResultsSummary(jirix *jiri.X) map[string]struct
 		fmt.Fprintf(&lineBuf, "%s ➔ %s: %s", summary.lastStatus.String(), summary.curStatus.String(), nameString)
 		if summary.timeoutValue > 0 {
 			fmt.Fprintf(&lineBuf, " [TIMED OUT after %s]\n", summary.timeoutValue)
+		} else if summary.reusedFrom != "" && !summary.ran {
+			fmt.Fprintf(&lineBuf, " [REUSED from %s]\n", summary.reusedFrom)
 		} else {
 			fmt.Fprintf(&lineBuf, "\n")
 		}
@@ -532,6 +621,7 @@ const (
 	fixedFailure failureType = iota
 	newFailure
 	knownFailure
+	flakyFailure
 )
 
 func (t failureType) String() string {
@@ -542,6 +632,8 @@ func (t failureType) String() string {
 		return "NEW FAILURE"
 	case knownFailure:
 		return "KNOWN FAILURE"
+	case flakyFailure:
+		return "LIKELY FLAKY FAILURE"
 	default:
 		return "UNKNOWN FAILURE TYPE"
 	}
@@ -551,7 +643,8 @@ func (t failureType) String() string {
 type failedTestLinksMap map[failureType][]string
 
 // reportFailedTestCasesByFailureTypes reports failed test cases grouped by
-// failure types: new failures, known failures, and fixed failures.
+// failure types: new failures, likely flaky failures, known failures, and
+// fixed failures.
 func (r *testReporter) reportFailedTestCases(jirix *jiri.X) (int, error) {
 	// Get groups.
 	groups, err := r.genFailedTestCasesGroupsForAllTests(jirix)
@@ -560,7 +653,7 @@ func (r *testReporter) reportFailedTestCases(jirix *jiri.X) (int, error) {
 	}
 
 	// Generate links for all groups.
-	for _, failureType := range []failureType{newFailure, knownFailure, fixedFailure} {
+	for _, failureType := range []failureType{newFailure, flakyFailure, knownFailure, fixedFailure} {
 		failedTestCaseInfos, ok := groups[failureType]
 		if !ok || len(failedTestCaseInfos) == 0 {
 			continue
@@ -571,7 +664,19 @@ func (r *testReporter) reportFailedTestCases(jirix *jiri.X) (int, error) {
 		}
 		curLinks := []string{}
 		for _, testCase := range failedTestCaseInfos {
-			curLink := genTestResultLink(testCase.suiteName, testCase.className, testCase.testCaseName, testCase.testName, testCase.axisValues)
+			var curLink string
+			if r.local {
+				curLink = "- " + genTestFullName(testCase.className, testCase.testCaseName)
+			} else {
+				curLink = genTestResultLink(testCase.suiteName, testCase.className, testCase.testCaseName, testCase.testName, testCase.axisValues)
+			}
+			if failureType == flakyFailure {
+				// Append to the line with the test name how often the
+				// test case failed in the recent postsubmit runs.
+				lines := strings.SplitN(curLink, "\n", 2)
+				lines[0] += fmt.Sprintf(" (failed %d of last %d runs)", testCase.failedRuns, testCase.runs)
+				curLink = strings.Join(lines, "\n")
+			}
 			curLinks = append(curLinks, curLink)
 		}
 		fmt.Fprintf(r.report, "\n%s:\n%s\n\n", failureTypeStr, strings.Join(curLinks, "\n"))
@@ -586,6 +691,10 @@ type failedTestCaseInfo struct {
 	testCaseName string
 	testName     string
 	axisValues   axisValuesInfo
+	// failedRuns and runs record how many of the recent postsubmit runs
+	// the test case failed in, for likely flaky failures.
+	failedRuns int
+	runs       int
 }
 
 type failedTestCasesGroups map[failureType][]failedTestCaseInfo
@@ -593,13 +702,18 @@ type failedTestCasesGroups map[failureType][]failedTestCaseInfo
 // genFailedTestCasesGroupsForAllTests iterate all tests from the given
 // testResults, compares the presubmit failed test cases (read from the given
 // xUnit report) with the postsubmit failed test cases, and groups the failed
-// tests into three groups: new failures, known failures, and fixed failures.
+// tests into four groups: new failures, likely flaky failures, known
+// failures, and fixed failures.
 // Each group has a slice of failedTestLinkInfo which is used to generate
 // dashboard links.
 func (r *testReporter) genFailedTestCasesGroupsForAllTests(jirix *jiri.X) (failedTestCasesGroups, error) {
 	groups := failedTestCasesGroups{}
 
 	for _, testResult := range r.testResults {
+		// Reused results passed and have no reports in this build.
+		if testResult.ReusedFrom != "" {
+			continue
+		}
 		axisValues := testResult.AxisValues
 		// For a given test script this-is-a-test.sh, its test
 		// report file is: tests_this_is_a_test.xml.
@@ -614,6 +728,9 @@ func (r *testReporter) genFailedTestCasesGroupsForAllTests(jirix *jiri.X) (faile
 			fmt.Sprintf("%d", jenkinsBuildNumberFlag),
 			fmt.Sprintf("ARCH=%s,OS=%s,TEST=%s", axisValues.Arch, axisValues.OS, testNameWithPartSuffix(testResult.TestName, testResult.AxisValues.PartIndex)),
 			xUnitReportFileName)
+		if r.local {
+			xUnitReportFile = xunit.ReportPath(testResult.TestName)
+		}
 		bytes, err := ioutil.ReadFile(xUnitReportFile)
 		if err != nil {
 			// It is normal that certain tests don't have report available.
@@ -623,7 +740,7 @@ func (r *testReporter) genFailedTestCasesGroupsForAllTests(jirix *jiri.X) (faile
 
 		// Get the failed test cases from the corresponding postsubmit Jenkins job
 		// to compare with the presubmit failed tests.
-		postsubmitFailedTestCases := []jenkins.TestCase{}
+		postsubmitFailedTestCases := []ci.TestCase{}
 		if data := r.postSubmitResults[testResult.key()]; data != nil {
 			postsubmitFailedTestCases = data.failedTestCases
 		}
@@ -641,7 +758,7 @@ func (r *testReporter) genFailedTestCasesGroupsForAllTests(jirix *jiri.X) (faile
 
 // genFailedTestCasesGroupsForOneTest generates groups for failed tests.
 // See comments of genFailedTestsGroupsForAllTests.
-func (r *testReporter) genFailedTestCasesGroupsForOneTest(jirix *jiri.X, testResult testResultInfo, presubmitXUnitReport []byte, postsubmitFailedTestCases []jenkins.TestCase) (*failedTestCasesGroups, error) {
+func (r *testReporter) genFailedTestCasesGroupsForOneTest(jirix *jiri.X, testResult testResultInfo, presubmitXUnitReport []byte, postsubmitFailedTestCases []ci.TestCase) (*failedTestCasesGroups, error) {
 	testName := testResult.TestName
 
 	// Parse xUnit report of the presubmit test.
@@ -650,8 +767,16 @@ func (r *testReporter) genFailedTestCasesGroupsForOneTest(jirix *jiri.X, testRes
 		return nil, fmt.Errorf("Unmarshal(%v) failed: %v", string(presubmitXUnitReport), err)
 	}
 
+	// Failures are classified using the recent postsubmit runs if they are
+	// recorded in the history, and using the latest postsubmit build
+	// otherwise.
+	var runs testHistoryRecords
+	if r.history != nil {
+		runs = r.history.runs(testResult.key(), testResult.Timestamp, historyWindowFlag)
+	}
+
 	groups := failedTestCasesGroups{}
-	curFailedTestCases := []jenkins.TestCase{}
+	curFailedTestCases := []ci.TestCase{}
 	for _, curTestSuite := range suites.Suites {
 		for _, curTestCase := range curTestSuite.Cases {
 			// Unescape test name and class name.
@@ -666,24 +791,33 @@ func (r *testReporter) genFailedTestCasesGroupsForOneTest(jirix *jiri.X, testRes
 					testName:     testName,
 					axisValues:   testResult.AxisValues,
 				}
-				// Determine whether the curTestCase is a new failure or not.
-				isNewFailure := true
-				for _, postsubmitFailedTestCase := range postsubmitFailedTestCases {
-					curClassName := curTestCase.Classname
-					if curClassName == "" {
-						curClassName = curTestSuite.Name
-					}
-					if curClassName == postsubmitFailedTestCase.ClassName && curTestCase.Name == postsubmitFailedTestCase.Name {
-						isNewFailure = false
-						break
-					}
+				curClassName := curTestCase.Classname
+				if curClassName == "" {
+					curClassName = curTestSuite.Name
 				}
-				if isNewFailure {
-					groups[newFailure] = append(groups[newFailure], linkInfo)
+				curCase := ci.TestCase{ClassName: curClassName, Name: curTestCase.Name}
+				if len(runs) > 0 {
+					failureType, failedRuns := runs.classify(curCase)
+					if failureType == flakyFailure {
+						linkInfo.failedRuns, linkInfo.runs = failedRuns, len(runs)
+					}
+					groups[failureType] = append(groups[failureType], linkInfo)
 				} else {
-					groups[knownFailure] = append(groups[knownFailure], linkInfo)
+					// Determine whether the curTestCase is a new failure or not.
+					isNewFailure := true
+					for _, postsubmitFailedTestCase := range postsubmitFailedTestCases {
+						if postsubmitFailedTestCase.Equal(curCase) {
+							isNewFailure = false
+							break
+						}
+					}
+					if isNewFailure {
+						groups[newFailure] = append(groups[newFailure], linkInfo)
+					} else {
+						groups[knownFailure] = append(groups[knownFailure], linkInfo)
+					}
 				}
-				curFailedTestCases = append(curFailedTestCases, jenkins.TestCase{
+				curFailedTestCases = append(curFailedTestCases, ci.TestCase{
 					ClassName: curTestCase.Classname,
 					Name:      curTestCase.Name,
 				})
@@ -764,11 +898,7 @@ func (r *testReporter) reportUsefulLinks(failedTestNames map[string]struct{}) {
 // submitPresubmitCLs tries to submit CLs in the current presubmit test.
 func submitPresubmitCLs(jirix *jiri.X, refs []string) error {
 	// Query open CLs.
-	gUrl, err := gerritBaseUrl()
-	if err != nil {
-		return err
-	}
-	openCLs, err := jirix.Gerrit(gUrl).Query(defaultQueryString)
+	openCLs, err := queryCLs(jirix, defaultQueryString)
 	if err != nil {
 		return err
 	}
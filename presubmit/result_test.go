@@ -5,11 +5,13 @@
 package main
 
 import (
+	"fmt"
+	"path/filepath"
 	"reflect"
 	"testing"
 
-	"v.io/jiri/jenkins"
 	"v.io/jiri/jiritest"
+	"v.io/x/devtools/internal/ci"
 	"v.io/x/devtools/internal/test"
 )
 
@@ -50,7 +52,7 @@ release/go/src/v.io/x/devtools/v23/main.go:1: you should feel bad
 	jenkinsBuildNumberFlag = 10
 	type testSpec struct {
 		testResult                testResultInfo
-		postsubmitFailedTestCases []jenkins.TestCase
+		postsubmitFailedTestCases []ci.TestCase
 		expectedGroups            *failedTestCasesGroups
 		expectedSeenTests         map[string]int
 	}
@@ -66,7 +68,7 @@ release/go/src/v.io/x/devtools/v23/main.go:1: you should feel bad
 					PartIndex: 0,
 				},
 			},
-			postsubmitFailedTestCases: []jenkins.TestCase{},
+			postsubmitFailedTestCases: []ci.TestCase{},
 			expectedGroups: &failedTestCasesGroups{
 				newFailure: []failedTestCaseInfo{
 					failedTestCaseInfo{
@@ -190,3 +192,165 @@ func TestGenTestFullName(t *testing.T) {
 		}
 	}
 }
+
+func TestGenFailedTestCasesGroupsWithHistory(t *testing.T) {
+	jirix, cleanup := jiritest.NewX(t)
+	defer cleanup()
+	reportFileContent := `
+<?xml version="1.0" encoding="utf-8"?>
+<testsuites>
+  <testsuite name="ts1" tests="3" errors="0" failures="3" skip="0">
+    <testcase classname="c" name="new" time="0">
+      <failure message="error">oops</failure>
+    </testcase>
+    <testcase classname="c" name="flaky" time="0">
+      <failure message="error">oops</failure>
+    </testcase>
+    <testcase classname="c" name="known" time="0">
+      <failure message="error">oops</failure>
+    </testcase>
+  </testsuite>
+</testsuites>
+	`
+	testResult := testResultInfo{
+		Result:    test.Result{Status: test.Failed},
+		TestName:  "vanadium-go-test",
+		Timestamp: 100,
+	}
+	flaky := ci.TestCase{ClassName: "c", Name: "flaky"}
+	known := ci.TestCase{ClassName: "c", Name: "known"}
+
+	// Record 5 postsubmit runs: "flaky" failed in 2 of them and "known"
+	// failed in the last 2. The last run started after the presubmit
+	// test, so it is ignored.
+	path := filepath.Join(jirix.Root, "history.json")
+	history, err := loadTestHistory(jirix, path)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	failures := [][]ci.TestCase{{flaky}, nil, {flaky}, {known}, {known}, nil}
+	for i, cases := range failures {
+		record := testHistoryRecord{
+			Test:      testResult.key(),
+			Build:     i + 1,
+			Result:    ci.ResultSuccess,
+			Timestamp: int64(i*20 + 1),
+			Failures:  cases,
+		}
+		if err := history.add(jirix, record); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+	// Reload the history from its file.
+	if history, err = loadTestHistory(jirix, path); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if got, want := len(history.records[testResult.key()]), len(failures); got != want {
+		t.Fatalf("got %v records, want %v", got, want)
+	}
+
+	historyWindowFlag = 50
+	reporter := testReporter{history: history}
+	groups, err := reporter.genFailedTestCasesGroupsForOneTest(jirix, testResult, []byte(reportFileContent), nil)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	caseNames := func(infos []failedTestCaseInfo) []string {
+		names := []string{}
+		for _, info := range infos {
+			names = append(names, info.testCaseName)
+		}
+		return names
+	}
+	for failureType, want := range map[failureType][]string{
+		newFailure:   []string{"new"},
+		flakyFailure: []string{"flaky"},
+		knownFailure: []string{"known"},
+	} {
+		if got := caseNames((*groups)[failureType]); !reflect.DeepEqual(got, want) {
+			t.Fatalf("%v: got %v, want %v", failureType, got, want)
+		}
+	}
+	if got, want := (*groups)[flakyFailure][0].failedRuns, 2; got != want {
+		t.Fatalf("got %v failed runs, want %v", got, want)
+	}
+	if got, want := (*groups)[flakyFailure][0].runs, 5; got != want {
+		t.Fatalf("got %v runs, want %v", got, want)
+	}
+
+	// With a smaller window, the flaky test failed in 1 of the last 3
+	// runs, which is too few to tell it is flaky.
+	historyWindowFlag = 3
+	if groups, err = reporter.genFailedTestCasesGroupsForOneTest(jirix, testResult, []byte(reportFileContent), nil); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if got := caseNames((*groups)[flakyFailure]); len(got) != 0 {
+		t.Fatalf("got %v, want no flaky failures", got)
+	}
+	if got, want := caseNames((*groups)[newFailure]), []string{"new", "flaky"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+// fakeHistoryExecutor serves the completed builds of a job, whose
+// failed test cases cannot be read for the builds in "unreadable".
+type fakeHistoryExecutor struct {
+	ci.Executor
+	builds     int
+	unreadable map[int]bool
+}
+
+func (e *fakeHistoryExecutor) LastCompleted(job string, axisValues map[string]string) (*ci.Build, error) {
+	return e.Build(job, axisValues, e.builds)
+}
+
+func (e *fakeHistoryExecutor) Build(job string, axisValues map[string]string, number int) (*ci.Build, error) {
+	return &ci.Build{Job: job, ID: fmt.Sprintf("%d", number), Number: number, Result: ci.ResultFailure, Timestamp: int64(number)}, nil
+}
+
+func (e *fakeHistoryExecutor) FailedTestCases(job string, axisValues map[string]string, number int) ([]ci.TestCase, error) {
+	if e.unreadable[number] {
+		return nil, fmt.Errorf("build %d of %v has no test report", number, job)
+	}
+	return []ci.TestCase{{ClassName: "c", Name: "t"}}, nil
+}
+
+func TestRecordPostSubmitBuilds(t *testing.T) {
+	jirix, cleanup := jiritest.NewX(t)
+	defer cleanup()
+	history, err := loadTestHistory(jirix, filepath.Join(jirix.Root, "history.json"))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	testResult := testResultInfo{TestName: "vanadium-go-test"}
+	testResults := []testResultInfo{testResult}
+	key := testResult.key()
+
+	// The failed test cases of build 2 cannot be read, so the build is
+	// not recorded.
+	executor := &fakeHistoryExecutor{builds: 3, unreadable: map[int]bool{2: true}}
+	if err := recordPostSubmitBuilds(jirix, executor, history, testResults, nil, 10); err != nil {
+		t.Fatalf("%v", err)
+	}
+	for build, want := range map[int]bool{1: true, 2: false, 3: true} {
+		if got := history.has(key, build); got != want {
+			t.Fatalf("build %d: got recorded %v, want %v", build, got, want)
+		}
+	}
+
+	// Build 2 is recorded once its failed test cases can be read.
+	executor.builds, executor.unreadable = 4, nil
+	if err := recordPostSubmitBuilds(jirix, executor, history, testResults, nil, 10); err != nil {
+		t.Fatalf("%v", err)
+	}
+	for build := 1; build <= 4; build++ {
+		if !history.has(key, build) {
+			t.Fatalf("build %d not recorded", build)
+		}
+	}
+	for _, record := range history.records[key] {
+		if got, want := len(record.Failures), 1; got != want {
+			t.Fatalf("build %d: got %v failures, want %v", record.Build, got, want)
+		}
+	}
+}
@@ -31,6 +31,7 @@ import (
 	"v.io/x/devtools/internal/test"
 	"v.io/x/devtools/internal/xunit"
 	"v.io/x/lib/cmdline"
+	"v.io/x/lib/envvar"
 )
 
 const (
@@ -47,6 +48,7 @@ const (
 
 var (
 	numWorkersFlag       int
+	pkgsFlag             string
 	reviewTargetRefsFlag string
 	testFlag             string
 	testPartRE           = regexp.MustCompile(`(.*)-part(\d)$`)
@@ -61,6 +63,7 @@ func init() {
 	cmdTest.Flags.IntVar(&jenkinsBuildNumberFlag, "build-number", -1, "The number of the Jenkins build.")
 	cmdTest.Flags.IntVar(&numWorkersFlag, "num-test-workers", runtime.NumCPU(), "Set the number of test workers to use when running sub-tests.")
 	cmdTest.Flags.Lookup("num-test-workers").DefValue = "<runtime.NumCPU()>"
+	cmdTest.Flags.StringVar(&pkgsFlag, "pkgs", "", "Comma-separated list of Go packages that Go-based tests are limited to. By default, all packages are tested.")
 	cmdTest.Flags.StringVar(&projectsFlag, "projects", "", "The base names of the remote projects containing the CLs pointed by the refs, separated by ':'.")
 	cmdTest.Flags.StringVar(&reviewTargetRefsFlag, "refs", "", "The review references separated by ':'.")
 	cmdTest.Flags.StringVar(&testFlag, "test", "", "The name of a single test to run.")
@@ -111,8 +114,9 @@ func runTest(jirix *jiri.X, args []string) (e error) {
 	}
 
 	// Warn users that presubmit will delete all non-master branches when
-	// running on their local machines.
-	if !testMode && os.Getenv("USER") != "veyron" {
+	// running on their local machines. Builds run by a continuous
+	// integration system (including the local queue) set JOB_NAME.
+	if !testMode && os.Getenv("USER") != "veyron" && os.Getenv("JOB_NAME") == "" {
 		fmt.Printf("WARNING: Presubmit will delete all non-master branches.\nContinue? y/N:")
 		var response string
 		if _, err := fmt.Scanf("%s\n", &response); err != nil || response != "y" {
@@ -243,14 +247,17 @@ func runTest(jirix *jiri.X, args []string) (e error) {
 	if partIndex != -1 {
 		jiriArgs = append(jiriArgs, "-part", fmt.Sprintf("%d", partIndex))
 	}
+	if pkgsFlag != "" {
+		jiriArgs = append(jiriArgs, "-pkgs", pkgsFlag, "-limit-pkgs")
+	}
 	jiriArgs = append(jiriArgs, testName)
 
 	var out bytes.Buffer
 	out.Grow(1 << 20)
 	stdout := io.MultiWriter(&out, jirix.Stdout())
 	stderr := io.MultiWriter(&out, jirix.Stderr())
-	if err := s.Env(env).Capture(stdout, stderr).Timeout(jiriTestTimeout).
-		Last("jiri-test", jiriArgs...); err != nil {
+	testPeakRSS, timedOut, err := runJiriTest(jirix, env, stdout, stderr, jiriArgs)
+	if err != nil {
 		// Clean up profiles if any of the CLs modified profile related files.
 		profilesModified, pmErr := profileFilesModified(jirix, cls)
 		if pmErr != nil {
@@ -262,7 +269,7 @@ func runTest(jirix *jiri.X, args []string) (e error) {
 			}
 		}
 		// jiri-test command times out.
-		if runutil.IsTimeout(err) {
+		if timedOut {
 			result := test.Result{
 				Status:       test.TimedOut,
 				TimeoutValue: jiriTestTimeout,
@@ -272,17 +279,8 @@ func runTest(jirix *jiri.X, args []string) (e error) {
 				return err
 			}
 		}
-		oe := runutil.GetOriginalError(err)
 		// Check the error status to differentiate failed test errors.
-		exiterr, ok := oe.(*exec.ExitError)
-		if !ok {
-			return err
-		}
-		status, ok := exiterr.Sys().(syscall.WaitStatus)
-		if !ok {
-			return err
-		}
-		if status.ExitStatus() != test.FailedExitCode {
+		if !isTestFailure(err) {
 			return err
 		}
 	}
@@ -300,6 +298,11 @@ func runTest(jirix *jiri.X, args []string) (e error) {
 	if !ok {
 		return fmt.Errorf("no test result found for %q", testName)
 	}
+	// The test is the only one that the jiri-test process ran, so the
+	// peak RSS of the process is that of the test.
+	if result.Usage != nil {
+		result.Usage.PeakRSS = testPeakRSS
+	}
 
 	// Upload the test results to Google Storage.
 	path := gsPrefix + fmt.Sprintf("presubmit/%d/%s/%s", jenkinsBuildNumberFlag, os.Getenv("OS"), os.Getenv("ARCH"))
@@ -310,16 +313,41 @@ func runTest(jirix *jiri.X, args []string) (e error) {
 	return writeTestStatusFile(jirix, *result, curTimestamp, testName, partIndex)
 }
 
+// runJiriTest runs the "jiri-test" command with the given arguments
+// and environment, killing it after jiriTestTimeout. It returns the peak
+// resident set size of the command and of the processes it waited for,
+// such as test binaries, and whether the command timed out.
+func runJiriTest(jirix *jiri.X, env map[string]string, stdout, stderr io.Writer, args []string) (int64, bool, error) {
+	cmd := exec.Command("jiri-test", args...)
+	cmd.Env = envvar.MapToSlice(envvar.MergeMaps(jirix.Env(), env))
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	setProcessGroup(cmd)
+	if jirix.Verbose() {
+		fmt.Fprintf(jirix.Stdout(), ">> jiri-test %s\n", strings.Join(args, " "))
+	}
+	if err := cmd.Start(); err != nil {
+		return 0, false, fmt.Errorf("Start(jiri-test) failed: %v", err)
+	}
+	timer := time.AfterFunc(jiriTestTimeout, func() {
+		if err := killProcessGroup(cmd); err != nil {
+			fmt.Fprintf(jirix.Stderr(), "failed to kill jiri-test: %v\n", err)
+		}
+	})
+	err := cmd.Wait()
+	timedOut := !timer.Stop()
+	return peakRSS(cmd.ProcessState), timedOut, err
+}
+
 // profileFilesModified checks any of the given CLs modified files under the
 // "jiri-v23-profile/" or "jiri-profile-v23/" directories in the
 // "release.go.x.devtools" project.
 func profileFilesModified(jirix *jiri.X, cls []cl) (bool, error) {
-	gUrl, err := gerritBaseUrl()
+	system, err := newReviewSystem(jirix)
 	if err != nil {
 		return false, err
 	}
 	for _, curCL := range cls {
-		results, err := jirix.Gerrit(gUrl).Query(fmt.Sprintf("change:%d", curCL.clNumber))
+		results, err := system.Query(fmt.Sprintf("change:%d", curCL.clNumber))
 		if err != nil {
 			return false, err
 		}
@@ -327,14 +355,12 @@ func profileFilesModified(jirix *jiri.X, cls []cl) (bool, error) {
 			if result.Project != "release.go.x.devtools" {
 				continue
 			}
-			for _, revision := range result.Revisions {
-				for filename := range revision.Files {
-					if strings.HasPrefix(filename, "jiri-v23-profile/") {
-						return true, nil
-					}
-					if strings.HasPrefix(filename, "jiri-profile-v23/") {
-						return true, nil
-					}
+			for _, filename := range result.Files {
+				if strings.HasPrefix(filename, "jiri-v23-profile/") {
+					return true, nil
+				}
+				if strings.HasPrefix(filename, "jiri-profile-v23/") {
+					return true, nil
 				}
 			}
 		}
@@ -565,6 +591,7 @@ func writeTestStatusFile(jirix *jiri.X, result test.Result, curTimestamp int64,
 			OS:        os.Getenv("OS"),   // OS is stored in environment variable "OS"
 			PartIndex: partIndex,
 		},
+		Partial: pkgsFlag != "",
 	}
 	bytes, err := json.Marshal(r)
 	if err != nil {
@@ -0,0 +1,254 @@
+// Copyright 2015 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"v.io/jiri"
+	"v.io/jiri/runutil"
+	"v.io/x/devtools/internal/test"
+)
+
+const (
+	defaultUsageFile = "${HOME}/tmp/presubmit_usage.json"
+
+	// usageWindow is the number of recent passing runs of a test whose
+	// resource usage forms its baseline.
+	usageWindow = 10
+
+	// minUsageRuns is the number of recent runs of a test that its
+	// baseline requires for budgets to be checked.
+	minUsageRuns = 3
+)
+
+var (
+	topTestsFlag  int
+	usageFileFlag string
+)
+
+func init() {
+	cmdResult.Flags.IntVar(&topTestsFlag, "top-tests", 5, "The number of tests with the highest resource usage to report.")
+	cmdResult.Flags.StringVar(&usageFileFlag, "usage-file", os.ExpandEnv(defaultUsageFile), "The file that records the resource usage of recent test runs, which the resource budgets of tests are checked against. An empty value disables the budgets.")
+	cmdResult.Flags.Lookup("usage-file").DefValue = defaultUsageFile
+}
+
+// usageBaselines maps tests and their configurations (see usageKey) to
+// the resource usage of their recent passing runs, oldest first.
+type usageBaselines map[string][]test.Usage
+
+// loadUsageBaselines reads the baselines stored in the given file. A
+// missing file represents no baselines.
+func loadUsageBaselines(jirix *jiri.X, path string) (usageBaselines, error) {
+	baselines := usageBaselines{}
+	bytes, err := jirix.NewSeq().ReadFile(path)
+	if err != nil {
+		if runutil.IsNotExist(err) {
+			return baselines, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(bytes, &baselines); err != nil {
+		return nil, fmt.Errorf("Unmarshal(%v) failed: %v", path, err)
+	}
+	return baselines, nil
+}
+
+// saveUsageBaselines writes the given baselines to the given file.
+func saveUsageBaselines(jirix *jiri.X, path string, baselines usageBaselines) error {
+	bytes, err := json.MarshalIndent(baselines, "", "  ")
+	if err != nil {
+		return fmt.Errorf("MarshalIndent(%v) failed: %v", baselines, err)
+	}
+	tmpPath := path + ".tmp"
+	s := jirix.NewSeq()
+	return s.MkdirAll(filepath.Dir(path), os.FileMode(0755)).
+		WriteFile(tmpPath, bytes, os.FileMode(0644)).
+		Rename(tmpPath, path).Done()
+}
+
+// usageKey returns the key of the baseline of the test wrapped in the
+// given testResultInfo object. Runs limited to some Go packages use
+// much fewer resources than full runs, so they have separate baselines.
+func usageKey(result testResultInfo) string {
+	if result.Partial {
+		return result.key() + "_partial"
+	}
+	return result.key()
+}
+
+// record adds the resource usage of the given test results to the
+// baselines. Only the results of passing tests that ran for the current
+// patchsets are recorded.
+func (b usageBaselines) record(testResults []testResultInfo) {
+	for _, result := range testResults {
+		if result.Result.Status != test.Passed || result.Result.Usage == nil || result.ReusedFrom != "" {
+			continue
+		}
+		key := usageKey(result)
+		usages := append(b[key], *result.Result.Usage)
+		if len(usages) > usageWindow {
+			usages = usages[len(usages)-usageWindow:]
+		}
+		b[key] = usages
+	}
+}
+
+type durations []time.Duration
+
+func (d durations) Len() int           { return len(d) }
+func (d durations) Less(i, j int) bool { return d[i] < d[j] }
+func (d durations) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
+
+type sizes []int64
+
+func (s sizes) Len() int           { return len(s) }
+func (s sizes) Less(i, j int) bool { return s[i] < s[j] }
+func (s sizes) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// baseline returns the median resource usage of the recent runs of the
+// given test, or false if it has fewer than minUsageRuns runs. The
+// median peak RSS only considers the runs whose peak RSS is known.
+func (b usageBaselines) baseline(key string) (test.Usage, bool) {
+	usages := b[key]
+	if len(usages) < minUsageRuns {
+		return test.Usage{}, false
+	}
+	wallTimes, cpuTimes, peakRSSs := durations{}, durations{}, sizes{}
+	for _, usage := range usages {
+		wallTimes = append(wallTimes, usage.WallTime)
+		cpuTimes = append(cpuTimes, usage.CPUTime)
+		if usage.PeakRSS > 0 {
+			peakRSSs = append(peakRSSs, usage.PeakRSS)
+		}
+	}
+	sort.Sort(wallTimes)
+	sort.Sort(cpuTimes)
+	sort.Sort(peakRSSs)
+	median := len(usages) / 2
+	baseline := test.Usage{
+		WallTime: wallTimes[median],
+		CPUTime:  cpuTimes[median],
+	}
+	if len(peakRSSs) > 0 {
+		baseline.PeakRSS = peakRSSs[len(peakRSSs)/2]
+	}
+	return baseline, true
+}
+
+// overBudget returns descriptions of the resources of the given usage
+// that exceed the given baseline by more than the given percentage.
+func overBudget(usage, baseline test.Usage, growth float64) []string {
+	exceeds := func(value, baseValue float64) bool {
+		return baseValue > 0 && value > baseValue*(1+growth/100)
+	}
+	percent := func(value, baseValue float64) int {
+		return int((value/baseValue-1)*100 + 0.5)
+	}
+	over := []string{}
+	if value, baseValue := float64(usage.WallTime), float64(baseline.WallTime); exceeds(value, baseValue) {
+		over = append(over, fmt.Sprintf("wall time %v is %d%% above %v", roundDuration(usage.WallTime), percent(value, baseValue), roundDuration(baseline.WallTime)))
+	}
+	if value, baseValue := float64(usage.CPUTime), float64(baseline.CPUTime); exceeds(value, baseValue) {
+		over = append(over, fmt.Sprintf("CPU time %v is %d%% above %v", roundDuration(usage.CPUTime), percent(value, baseValue), roundDuration(baseline.CPUTime)))
+	}
+	if value, baseValue := float64(usage.PeakRSS), float64(baseline.PeakRSS); exceeds(value, baseValue) {
+		over = append(over, fmt.Sprintf("peak RSS %v is %d%% above %v", test.FormatBytes(usage.PeakRSS), percent(value, baseValue), test.FormatBytes(baseline.PeakRSS)))
+	}
+	return over
+}
+
+// roundDuration rounds the given duration down to seconds.
+func roundDuration(d time.Duration) time.Duration {
+	return d - d%time.Second
+}
+
+// testUsage records the resource usage of a test in a configuration,
+// summed over its parts.
+type testUsage struct {
+	name  string
+	usage test.Usage
+}
+
+type testUsagesByCost []testUsage
+
+func (t testUsagesByCost) Len() int { return len(t) }
+func (t testUsagesByCost) Less(i, j int) bool {
+	if t[i].usage.CPUTime != t[j].usage.CPUTime {
+		return t[i].usage.CPUTime > t[j].usage.CPUTime
+	}
+	return t[i].name < t[j].name
+}
+func (t testUsagesByCost) Swap(i, j int) { t[i], t[j] = t[j], t[i] }
+
+// reportUsage reports the tests with the highest resource usage (CPU
+// time) and the tests whose parts exceed their resource budgets.
+func (r *testReporter) reportUsage(jirix *jiri.X) {
+	// Sum the usage of the parts of each test in each configuration.
+	usages := map[string]*testUsage{}
+	warnings := []string{}
+	for _, resultInfo := range r.testResults {
+		usage := resultInfo.Result.Usage
+		if usage == nil || resultInfo.ReusedFrom != "" {
+			continue
+		}
+		name := resultInfo.TestName
+		if label := genSubJobLabel(name, resultInfo.AxisValues, r.matrixJobsConf); label != "" {
+			name += fmt.Sprintf(" [%s]", label)
+		}
+		if cur, ok := usages[name]; ok {
+			cur.usage = cur.usage.Add(*usage)
+		} else {
+			usages[name] = &testUsage{name, *usage}
+		}
+
+		// Check the budget of the test.
+		if r.config == nil || r.baselines == nil {
+			continue
+		}
+		budget, ok := r.config.TestBudget(resultInfo.TestName)
+		if !ok {
+			continue
+		}
+		baseline, ok := r.baselines.baseline(usageKey(resultInfo))
+		if !ok {
+			continue
+		}
+		if over := overBudget(*usage, baseline, budget.Growth); len(over) > 0 {
+			if partIndex := resultInfo.AxisValues.PartIndex; partIndex >= 0 {
+				name += fmt.Sprintf(" (part %d)", partIndex)
+			}
+			warnings = append(warnings, fmt.Sprintf("- %s: %s (budget: +%v%%)", name, strings.Join(over, ", "), budget.Growth))
+		}
+	}
+	if len(usages) == 0 {
+		return
+	}
+
+	sorted := testUsagesByCost{}
+	for _, usage := range usages {
+		sorted = append(sorted, *usage)
+	}
+	sort.Sort(sorted)
+	if len(sorted) > topTestsFlag {
+		sorted = sorted[:topTestsFlag]
+	}
+	if len(sorted) > 0 {
+		fmt.Fprintf(r.report, "\nMost expensive tests:\n")
+		for _, usage := range sorted {
+			fmt.Fprintf(r.report, "- %s: %v\n", usage.name, usage.usage)
+		}
+	}
+	if len(warnings) > 0 {
+		sort.Strings(warnings)
+		fmt.Fprintf(r.report, "\nRESOURCE BUDGET WARNINGS:\n%s\n", strings.Join(warnings, "\n"))
+	}
+}
@@ -0,0 +1,8 @@
+// Copyright 2015 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+// maxRSSUnit is the unit of syscall.Rusage.Maxrss in bytes.
+const maxRSSUnit = 1024
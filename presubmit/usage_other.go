@@ -0,0 +1,28 @@
+// Copyright 2015 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !darwin,!linux
+
+package main
+
+import (
+	"os"
+	"os/exec"
+)
+
+// peakRSS is supposed to return the peak resident set size of the
+// given terminated process. However, this implementation always
+// reports that it is unknown.
+func peakRSS(state *os.ProcessState) int64 {
+	return 0
+}
+
+// setProcessGroup is supposed to run the given command in its own
+// process group. However, this implementation does nothing.
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// killProcessGroup kills the process of the given started command.
+func killProcessGroup(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
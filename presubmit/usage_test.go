@@ -0,0 +1,126 @@
+// Copyright 2015 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"v.io/jiri/jiritest"
+	"v.io/x/devtools/internal/test"
+	"v.io/x/devtools/tooldata"
+)
+
+func TestUsageBaselines(t *testing.T) {
+	jirix, cleanup := jiritest.NewX(t)
+	defer cleanup()
+
+	axisValues := axisValuesInfo{Arch: "amd64", OS: "linux", PartIndex: -1}
+	newResult := func(status test.Status, minutes int, reusedFrom string) testResultInfo {
+		return testResultInfo{
+			Result: test.Result{
+				Status: status,
+				Usage: &test.Usage{
+					WallTime: time.Duration(minutes) * time.Minute,
+					CPUTime:  time.Duration(2*minutes) * time.Minute,
+					PeakRSS:  int64(minutes) << 20,
+				},
+			},
+			TestName:   "vanadium-go-test",
+			AxisValues: axisValues,
+			ReusedFrom: reusedFrom,
+		}
+	}
+	key := usageKey(newResult(test.Passed, 0, ""))
+
+	path := filepath.Join(jirix.Root, "usage.json")
+	baselines, err := loadUsageBaselines(jirix, path)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	// Failed and reused results are not recorded.
+	baselines.record([]testResultInfo{newResult(test.Passed, 10, ""), newResult(test.Failed, 100, ""), newResult(test.Passed, 100, "1000/1")})
+	if _, ok := baselines.baseline(key); ok {
+		t.Fatalf("got a baseline from %d runs, want none", len(baselines[key]))
+	}
+	for _, minutes := range []int{30, 20} {
+		baselines.record([]testResultInfo{newResult(test.Passed, minutes, "")})
+	}
+	if err := saveUsageBaselines(jirix, path, baselines); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if baselines, err = loadUsageBaselines(jirix, path); err != nil {
+		t.Fatalf("%v", err)
+	}
+	got, ok := baselines.baseline(key)
+	if want := (test.Usage{WallTime: 20 * time.Minute, CPUTime: 40 * time.Minute, PeakRSS: 20 << 20}); !ok || got != want {
+		t.Fatalf("got %v, %v, want %v", got, ok, want)
+	}
+	// Only the most recent runs are recorded.
+	for i := 0; i < usageWindow; i++ {
+		baselines.record([]testResultInfo{newResult(test.Passed, 5, "")})
+	}
+	if got, want := len(baselines[key]), usageWindow; got != want {
+		t.Fatalf("got %v runs, want %v", got, want)
+	}
+
+	// Check the budgets.
+	baselines = usageBaselines{key: baselines[key][:minUsageRuns]}
+	reporter := testReporter{
+		testResults: []testResultInfo{newResult(test.Passed, 6, "")},
+		config:      tooldata.NewConfig(tooldata.TestBudgetsOpt{"*": {Name: "*", Growth: 10}}),
+		baselines:   baselines,
+		report:      &bytes.Buffer{},
+	}
+	reporter.reportUsage(jirix)
+	want := `
+Most expensive tests:
+- vanadium-go-test: wall time 6m0s, CPU time 12m0s, peak RSS 6.0 MB
+
+RESOURCE BUDGET WARNINGS:
+- vanadium-go-test: wall time 6m0s is 20% above 5m0s, CPU time 12m0s is 20% above 10m0s, peak RSS 6.0 MB is 20% above 5.0 MB (budget: +10%)
+`
+	if got := reporter.report.String(); got != want {
+		t.Fatalf("got:\n%v\nwant:\n%v", got, want)
+	}
+
+	// Partial runs are not checked against the baselines of full runs,
+	// and do not change them.
+	partial := newResult(test.Passed, 6, "")
+	partial.Partial = true
+	reporter.testResults, reporter.report = []testResultInfo{partial}, &bytes.Buffer{}
+	reporter.reportUsage(jirix)
+	if got, want := reporter.report.String(), "\nMost expensive tests:\n- vanadium-go-test: wall time 6m0s, CPU time 12m0s, peak RSS 6.0 MB\n"; got != want {
+		t.Fatalf("got:\n%v\nwant:\n%v", got, want)
+	}
+	baselines.record([]testResultInfo{partial})
+	if got, want := len(baselines[key]), minUsageRuns; got != want {
+		t.Fatalf("got %v runs, want %v", got, want)
+	}
+	if got, want := len(baselines[usageKey(partial)]), 1; got != want {
+		t.Fatalf("got %v partial runs, want %v", got, want)
+	}
+
+	// Runs whose peak RSS is unknown do not lower the baseline.
+	unknown := newResult(test.Passed, 5, "")
+	unknown.Result.Usage.PeakRSS = 0
+	baselines.record([]testResultInfo{unknown, unknown})
+	if got, ok := baselines.baseline(key); !ok || got.PeakRSS != 5<<20 {
+		t.Fatalf("got %v, %v, want peak RSS %v", got, ok, test.FormatBytes(5<<20))
+	}
+	if got, want := (test.Usage{WallTime: time.Minute}).String(), "wall time 1m0s, CPU time 0s"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+
+	if got := overBudget(test.Usage{WallTime: 5 * time.Minute}, test.Usage{WallTime: 5 * time.Minute}, 0); len(got) != 0 {
+		t.Fatalf("got %v, want no resources over budget", got)
+	}
+	if got, want := overBudget(test.Usage{PeakRSS: 2 << 30}, test.Usage{PeakRSS: 1 << 30}, 50), []string{"peak RSS 2.0 GB is 100% above 1.0 GB"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
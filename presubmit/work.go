@@ -0,0 +1,69 @@
+// Copyright 2015 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"v.io/jiri"
+	"v.io/x/devtools/internal/ci"
+	"v.io/x/lib/cmdline"
+)
+
+var (
+	pollIntervalFlag time.Duration
+	workOnceFlag     bool
+)
+
+func init() {
+	cmdWork.Flags.DurationVar(&pollIntervalFlag, "poll-interval", 30*time.Second, "How often to check the local queue for new builds.")
+	cmdWork.Flags.BoolVar(&workOnceFlag, "once", false, "Run the builds that are currently queued and exit.")
+}
+
+// cmdWork represents the 'work' command of the presubmit tool.
+var cmdWork = &cmdline.Command{
+	Runner: jiri.RunnerFunc(runWork),
+	Name:   "work",
+	Short:  "Run builds added to the local queue",
+	Long: `
+This subcommand runs the builds added to the local queue identified by the
+-local-queue flag, one at a time and in the order they were queued. Together
+with "presubmit query -local-queue", it makes it possible to run the presubmit
+pipeline on a single machine without Jenkins.
+
+The queue directory must contain a "jobs.json" file that maps job names to the
+shell commands implementing them. Build parameters (such as REFS and TESTS) are
+passed to the commands as environment variables, along with the JOB_NAME,
+BUILD_NUMBER and WORKSPACE variables.
+`,
+}
+
+// runWork implements the 'work' subcommand.
+func runWork(jirix *jiri.X, _ []string) error {
+	if localQueueFlag == "" {
+		return jirix.UsageErrorf("the -local-queue flag is required")
+	}
+	local, err := ci.NewLocal(localQueueFlag)
+	if err != nil {
+		return err
+	}
+	for {
+		ran, err := local.RunNext()
+		if err != nil {
+			if workOnceFlag {
+				return err
+			}
+			fmt.Fprintf(jirix.Stderr(), "%v\n", err)
+		}
+		if ran {
+			continue
+		}
+		if workOnceFlag {
+			return nil
+		}
+		time.Sleep(pollIntervalFlag)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"encoding/xml"
 	"fmt"
 	"os"
+	"path"
 	"path/filepath"
 	"sort"
 	"strings"
@@ -32,9 +33,18 @@ type Config struct {
 	// jenkinsMatrixJobs identifies the set of matrix (multi-configutation) jobs
 	// in Jenkins.
 	jenkinsMatrixJobs map[string]JenkinsMatrixJobInfo
+	// pathTests maps jiri projects to ordered lists of rules that
+	// identify the tests to execute for changes to the given files of
+	// the project.
+	pathTests map[string][]PathTest
 	// projectTests maps jiri projects to sets of tests that should be
 	// executed to test changes in the given project.
 	projectTests map[string][]string
+	// scriptTests maps test names to tests that are defined by a
+	// command in the config file rather than by Go code.
+	scriptTests map[string]ScriptTest
+	// testBudgets maps tests to the budgets of their resource usage.
+	testBudgets map[string]TestBudget
 	// testDependencies maps tests to sets of tests that the given test
 	// depends on.
 	testDependencies map[string][]string
@@ -80,12 +90,30 @@ type JenkinsMatrixJobsOpt map[string]JenkinsMatrixJobInfo
 
 func (JenkinsMatrixJobsOpt) configOpt() {}
 
+// PathTestsOpt is the type that can be used to pass the Config
+// factory a path tests option.
+type PathTestsOpt map[string][]PathTest
+
+func (PathTestsOpt) configOpt() {}
+
 // ProjectTestsOpt is the type that can be used to pass the Config
 // factory a project tests option.
 type ProjectTestsOpt map[string][]string
 
 func (ProjectTestsOpt) configOpt() {}
 
+// ScriptTestsOpt is the type that can be used to pass the Config
+// factory a script tests option.
+type ScriptTestsOpt map[string]ScriptTest
+
+func (ScriptTestsOpt) configOpt() {}
+
+// TestBudgetsOpt is the type that can be used to pass the Config
+// factory a test budgets option.
+type TestBudgetsOpt map[string]TestBudget
+
+func (TestBudgetsOpt) configOpt() {}
+
 // TestDependenciesOpt is the type that can be used to pass the Config
 // factory a test dependencies option.
 type TestDependenciesOpt map[string][]string
@@ -123,8 +151,14 @@ func NewConfig(opts ...ConfigOpt) *Config {
 			c.goWorkspaces = []string(typedOpt)
 		case JenkinsMatrixJobsOpt:
 			c.jenkinsMatrixJobs = map[string]JenkinsMatrixJobInfo(typedOpt)
+		case PathTestsOpt:
+			c.pathTests = map[string][]PathTest(typedOpt)
 		case ProjectTestsOpt:
 			c.projectTests = map[string][]string(typedOpt)
+		case ScriptTestsOpt:
+			c.scriptTests = map[string]ScriptTest(typedOpt)
+		case TestBudgetsOpt:
+			c.testBudgets = map[string]TestBudget(typedOpt)
 		case TestDependenciesOpt:
 			c.testDependencies = map[string][]string(typedOpt)
 		case TestGroupsOpt:
@@ -185,6 +219,13 @@ func (c Config) Projects() []string {
 	return projects
 }
 
+// PathTests returns the rules that identify the tests to execute for
+// changes to the files of the given project. If the project has no
+// rules, changes to it execute all of its tests.
+func (c Config) PathTests(project string) []PathTest {
+	return c.pathTests[project]
+}
+
 // ProjectTests returns a list of Jenkins tests associated with the
 // given projects by the config.
 func (c Config) ProjectTests(projects []string) []string {
@@ -204,6 +245,23 @@ func (c Config) ProjectTests(projects []string) []string {
 	return tests
 }
 
+// ScriptTests returns the tests that are defined by a command in the
+// config, keyed by test name.
+func (c Config) ScriptTests() map[string]ScriptTest {
+	return c.scriptTests
+}
+
+// TestBudget returns the budget of the resource usage of the given
+// test, which is the default budget (named "*") for tests without their
+// own budget. If there is no such budget, false is returned.
+func (c Config) TestBudget(test string) (TestBudget, bool) {
+	if budget, ok := c.testBudgets[test]; ok {
+		return budget, true
+	}
+	budget, ok := c.testBudgets["*"]
+	return budget, ok
+}
+
 // TestDependencies returns a list of dependencies for the given test.
 func (c Config) TestDependencies(test string) []string {
 	return c.testDependencies[test]
@@ -272,7 +330,10 @@ type configSchema struct {
 	CopyrightCheckProjects []string                `xml:"copyrightCheckProjects>project"`
 	GoWorkspaces           []string                `xml:"goWorkspaces>workspace"`
 	JenkinsMatrixJobs      jenkinsMatrixJobsSchema `xml:"jenkinsMatrixJobs>job"`
+	PathTests              pathTestGroupSchemas    `xml:"pathTests>project"`
 	ProjectTests           testGroupSchemas        `xml:"projectTests>project"`
+	ScriptTests            scriptTestSchemas       `xml:"scriptTests>test"`
+	TestBudgets            testBudgetSchemas       `xml:"testBudgets>test"`
 	TestDependencies       dependencyGroupSchemas  `xml:"testDependencies>test"`
 	TestGroups             testGroupSchemas        `xml:"testGroups>group"`
 	TestParts              partGroupSchemas        `xml:"testParts>test"`
@@ -309,6 +370,95 @@ func (jobs jenkinsMatrixJobsSchema) Len() int           { return len(jobs) }
 func (jobs jenkinsMatrixJobsSchema) Swap(i, j int)      { jobs[i], jobs[j] = jobs[j], jobs[i] }
 func (jobs jenkinsMatrixJobsSchema) Less(i, j int) bool { return jobs[i].Name < jobs[j].Name }
 
+// PathTest maps the files of a project that match a pattern to the
+// tests that should be executed for changes to them.
+type PathTest struct {
+	// Pattern is matched against paths relative to the project root
+	// using path.Match. Patterns that do not contain '/' are matched
+	// against base names, and patterns that end with "/..." match all
+	// paths under the given directory.
+	Pattern string `xml:"pattern,attr"`
+	// All determines whether changes to the matching files require all
+	// tests of the project, for example because they are build files.
+	All bool `xml:"all,attr,omitempty"`
+	// Tests lists the tests and test groups to execute. It may be
+	// empty, for example for documentation.
+	Tests []string `xml:"test"`
+}
+
+// Match determines whether the given path, relative to the project
+// root, matches the pattern.
+func (p PathTest) Match(file string) bool {
+	if strings.HasSuffix(p.Pattern, "/...") {
+		dir := strings.TrimSuffix(p.Pattern, "/...")
+		return file == dir || strings.HasPrefix(file, dir+"/")
+	}
+	if !strings.Contains(p.Pattern, "/") {
+		file = path.Base(file)
+	}
+	match, err := path.Match(p.Pattern, file)
+	return err == nil && match
+}
+
+type pathTestGroupSchema struct {
+	Name  string     `xml:"name,attr"`
+	Paths []PathTest `xml:"path"`
+}
+
+type pathTestGroupSchemas []pathTestGroupSchema
+
+func (p pathTestGroupSchemas) Len() int           { return len(p) }
+func (p pathTestGroupSchemas) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+func (p pathTestGroupSchemas) Less(i, j int) bool { return p[i].Name < p[j].Name }
+
+// ScriptTest describes a test that runs a shell command, such as a
+// make target, and therefore does not need to be implemented in Go.
+type ScriptTest struct {
+	// Name is the name of the test.
+	Name string `xml:"name,attr"`
+	// Description is a short description of the test.
+	Description string `xml:"description,omitempty"`
+	// Dir is the directory, relative to JIRI_ROOT, in which the
+	// command is run.
+	Dir string `xml:"dir"`
+	// Profiles lists the profiles the test requires.
+	Profiles []string `xml:"profile"`
+	// Env lists environment variables of the form "NAME=VALUE" to set
+	// for the command.
+	Env []string `xml:"env"`
+	// Command is the command to run, which is passed to "sh -c".
+	Command string `xml:"command"`
+	// Timeout is the test timeout in the format accepted by
+	// time.ParseDuration. If empty, the default test timeout is used.
+	Timeout string `xml:"timeout,omitempty"`
+	// Results is a glob pattern, relative to Dir, that identifies the
+	// xUnit reports generated by the command.
+	Results string `xml:"results,omitempty"`
+}
+
+type scriptTestSchemas []ScriptTest
+
+func (s scriptTestSchemas) Len() int           { return len(s) }
+func (s scriptTestSchemas) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s scriptTestSchemas) Less(i, j int) bool { return s[i].Name < s[j].Name }
+
+// TestBudget identifies how much the resource usage of a test may grow
+// before presubmit warns about it.
+type TestBudget struct {
+	// Name is the name of the test, or "*" for the default budget.
+	Name string `xml:"name,attr"`
+	// Growth is the percentage by which the wall time, the CPU time or
+	// the peak resident set size of the test may exceed its baseline,
+	// which is the median usage of its recent runs.
+	Growth float64 `xml:"growth,attr"`
+}
+
+type testBudgetSchemas []TestBudget
+
+func (t testBudgetSchemas) Len() int           { return len(t) }
+func (t testBudgetSchemas) Swap(i, j int)      { t[i], t[j] = t[j], t[i] }
+func (t testBudgetSchemas) Less(i, j int) bool { return t[i].Name < t[j].Name }
+
 type partGroupSchema struct {
 	Name  string   `xml:"name,attr"`
 	Parts []string `xml:"part"`
@@ -355,7 +505,10 @@ func loadConfig(jirix *jiri.X, path string) (*Config, error) {
 		copyrightCheckProjects: map[string]struct{}{},
 		goWorkspaces:           []string{},
 		jenkinsMatrixJobs:      map[string]JenkinsMatrixJobInfo{},
+		pathTests:              map[string][]PathTest{},
 		projectTests:           map[string][]string{},
+		scriptTests:            map[string]ScriptTest{},
+		testBudgets:            map[string]TestBudget{},
 		testDependencies:       map[string][]string{},
 		testGroups:             map[string][]string{},
 		testParts:              map[string][]string{},
@@ -370,9 +523,18 @@ func loadConfig(jirix *jiri.X, path string) (*Config, error) {
 	for _, job := range data.JenkinsMatrixJobs {
 		config.jenkinsMatrixJobs[job.Name] = job
 	}
+	for _, project := range data.PathTests {
+		config.pathTests[project.Name] = project.Paths
+	}
 	for _, project := range data.ProjectTests {
 		config.projectTests[project.Name] = project.Tests
 	}
+	for _, test := range data.ScriptTests {
+		config.scriptTests[test.Name] = test
+	}
+	for _, budget := range data.TestBudgets {
+		config.testBudgets[budget.Name] = budget
+	}
 	for _, test := range data.TestDependencies {
 		config.testDependencies[test.Name] = test.Dependencies
 	}
@@ -413,6 +575,13 @@ func saveConfig(jirix *jiri.X, config *Config, path string) error {
 		data.JenkinsMatrixJobs = append(data.JenkinsMatrixJobs, job)
 	}
 	sort.Sort(data.JenkinsMatrixJobs)
+	for name, paths := range config.pathTests {
+		data.PathTests = append(data.PathTests, pathTestGroupSchema{
+			Name:  name,
+			Paths: paths,
+		})
+	}
+	sort.Sort(data.PathTests)
 	for name, tests := range config.projectTests {
 		data.ProjectTests = append(data.ProjectTests, testGroupSchema{
 			Name:  name,
@@ -420,6 +589,14 @@ func saveConfig(jirix *jiri.X, config *Config, path string) error {
 		})
 	}
 	sort.Sort(data.ProjectTests)
+	for _, test := range config.scriptTests {
+		data.ScriptTests = append(data.ScriptTests, test)
+	}
+	sort.Sort(data.ScriptTests)
+	for _, budget := range config.testBudgets {
+		data.TestBudgets = append(data.TestBudgets, budget)
+	}
+	sort.Sort(data.TestBudgets)
 	for name, dependencies := range config.testDependencies {
 		data.TestDependencies = append(data.TestDependencies, dependencyGroupSchema{
 			Name:         name,
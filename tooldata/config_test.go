@@ -40,10 +40,32 @@ var (
 			Name:     "test-job-B",
 		},
 	}
+	pathTests = map[string][]tooldata.PathTest{
+		"test-project": []tooldata.PathTest{
+			{Pattern: "*.md"},
+			{Pattern: "Makefile", All: true},
+			{Pattern: "lib/...", Tests: []string{"test-test-A"}},
+		},
+	}
 	projectTests = map[string][]string{
 		"test-project":  []string{"test-test-A", "test-test-group"},
 		"test-project2": []string{"test-test-D"},
 	}
+	scriptTests = map[string]tooldata.ScriptTest{
+		"test-test-script": {
+			Name:     "test-test-script",
+			Dir:      "test-project",
+			Profiles: []string{"v23:nodejs"},
+			Env:      []string{"FOO=bar"},
+			Command:  "make test",
+			Timeout:  "10m",
+			Results:  "tests_*.xml",
+		},
+	}
+	testBudgets = map[string]tooldata.TestBudget{
+		"*":           {Name: "*", Growth: 20},
+		"test-test-A": {Name: "test-test-A", Growth: 50},
+	}
 	testDependencies = map[string][]string{
 		"test-test-A": []string{"test-test-B"},
 		"test-test-B": []string{"test-test-C"},
@@ -73,6 +95,12 @@ func testConfigAPI(t *testing.T, c *tooldata.Config) {
 	if got, want := c.JenkinsMatrixJobs(), jenkinsMatrixJobs; !reflect.DeepEqual(got, want) {
 		t.Fatalf("unexpected result: got %v, want %v", got, want)
 	}
+	if got, want := c.PathTests("test-project"), pathTests["test-project"]; !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected result: got %v, want %v", got, want)
+	}
+	if got := c.PathTests("test-project2"); got != nil {
+		t.Fatalf("unexpected result: got %v, want nil", got)
+	}
 	if got, want := c.Projects(), []string{"test-project", "test-project2"}; !reflect.DeepEqual(got, want) {
 		t.Fatalf("unexpected result: got %v, want %v", got, want)
 	}
@@ -82,6 +110,15 @@ func testConfigAPI(t *testing.T, c *tooldata.Config) {
 	if got, want := c.ProjectTests([]string{"test-project", "test-project2"}), []string{"test-test-A", "test-test-B", "test-test-C", "test-test-D"}; !reflect.DeepEqual(got, want) {
 		t.Fatalf("unexpected result: got %v, want %v", got, want)
 	}
+	if got, want := c.ScriptTests(), scriptTests; !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected result: got %v, want %v", got, want)
+	}
+	if got, ok := c.TestBudget("test-test-A"); !ok || got != testBudgets["test-test-A"] {
+		t.Fatalf("unexpected result: got %v, %v, want %v", got, ok, testBudgets["test-test-A"])
+	}
+	if got, ok := c.TestBudget("test-test-B"); !ok || got != testBudgets["*"] {
+		t.Fatalf("unexpected result: got %v, %v, want %v", got, ok, testBudgets["*"])
+	}
 	if got, want := c.TestDependencies("test-test-A"), []string{"test-test-B"}; !reflect.DeepEqual(got, want) {
 		t.Fatalf("unexpected result: got %v, want %v", got, want)
 	}
@@ -102,7 +139,10 @@ func TestConfigAPI(t *testing.T) {
 		tooldata.CopyrightCheckProjectsOpt(copyrightCheckProjects),
 		tooldata.GoWorkspacesOpt(goWorkspaces),
 		tooldata.JenkinsMatrixJobsOpt(jenkinsMatrixJobs),
+		tooldata.PathTestsOpt(pathTests),
 		tooldata.ProjectTestsOpt(projectTests),
+		tooldata.ScriptTestsOpt(scriptTests),
+		tooldata.TestBudgetsOpt(testBudgets),
 		tooldata.TestDependenciesOpt(testDependencies),
 		tooldata.TestGroupsOpt(testGroups),
 		tooldata.TestPartsOpt(testParts),
@@ -121,7 +161,10 @@ func TestConfigSerialization(t *testing.T) {
 		tooldata.CopyrightCheckProjectsOpt(copyrightCheckProjects),
 		tooldata.GoWorkspacesOpt(goWorkspaces),
 		tooldata.JenkinsMatrixJobsOpt(jenkinsMatrixJobs),
+		tooldata.PathTestsOpt(pathTests),
 		tooldata.ProjectTestsOpt(projectTests),
+		tooldata.ScriptTestsOpt(scriptTests),
+		tooldata.TestBudgetsOpt(testBudgets),
 		tooldata.TestDependenciesOpt(testDependencies),
 		tooldata.TestGroupsOpt(testGroups),
 		tooldata.TestPartsOpt(testParts),
@@ -139,6 +182,29 @@ func TestConfigSerialization(t *testing.T) {
 	testConfigAPI(t, gotConfig)
 }
 
+func TestPathTestMatch(t *testing.T) {
+	testCases := []struct {
+		pattern string
+		file    string
+		match   bool
+	}{
+		{"*.md", "README.md", true},
+		{"*.md", "lib/doc/README.md", true},
+		{"*.md", "lib/main.go", false},
+		{"lib/*.go", "lib/main.go", true},
+		{"lib/*.go", "lib/internal/main.go", false},
+		{"lib/...", "lib", true},
+		{"lib/...", "lib/internal/main.go", true},
+		{"lib/...", "library/main.go", false},
+	}
+	for _, test := range testCases {
+		p := tooldata.PathTest{Pattern: test.pattern}
+		if got, want := p.Match(test.file), test.match; got != want {
+			t.Errorf("%q.Match(%q): got %v, want %v", test.pattern, test.file, got, want)
+		}
+	}
+}
+
 func testSetPathHelper(t *testing.T, name string) {
 	fake, cleanup := jiritest.NewFakeJiriRoot(t)
 	defer cleanup()
@@ -5,17 +5,52 @@
 package tooldata
 
 import (
+	"bytes"
 	"encoding/xml"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"time"
 
 	"v.io/jiri"
 )
 
+// OncallTimeLayout is the layout of the dates in the oncall rotation
+// file, which are in the timezone of the rotation.
+const OncallTimeLayout = "Jan 2, 2006 3:04:05 PM"
+
+// defaultShiftDays is the length in days of the last shift of a
+// rotation that has only one shift.
+const defaultShiftDays = 7
+
+// OncallShiftLength is the length of the shifts generated for a
+// rotation: a number of calendar days in the timezone of the rotation,
+// plus a duration. Calendar days are 23 or 25 hours long across
+// daylight saving time changes, so shifts that last whole days keep
+// starting at the same time of day.
+type OncallShiftLength struct {
+	Days     int
+	Duration time.Duration
+}
+
+// shiftStart returns the start of the shift of the given length that
+// starts the given number of shifts after the given time.
+func (l OncallShiftLength) shiftStart(from time.Time, n int, loc *time.Location) time.Time {
+	return from.In(loc).AddDate(0, 0, n*l.Days).Add(time.Duration(n) * l.Duration)
+}
+
 type OncallRotation struct {
-	Shifts  []OncallShift `xml:"shift"`
-	XMLName xml.Name      `xml:"rotation"`
+	Shifts    []OncallShift    `xml:"shift"`
+	Overrides []OncallOverride `xml:"override"`
+	// Timezone is the name of the IANA timezone of the dates of the
+	// rotation. If empty, the dates are in the timezone of the time
+	// they are compared with.
+	Timezone string   `xml:"timezone,attr,omitempty"`
+	XMLName  xml.Name `xml:"rotation"`
 }
 
 type OncallShift struct {
@@ -24,6 +59,34 @@ type OncallShift struct {
 	Date      string `xml:"startDate"`
 }
 
+// OncallOverride records a one-off substitution of the primary and/or
+// secondary oncall for the given period. The overrides of a rotation
+// are applied in order, so later overrides take precedence.
+type OncallOverride struct {
+	Primary   string `xml:"primary,omitempty"`
+	Secondary string `xml:"secondary,omitempty"`
+	Start     string `xml:"startDate"`
+	End       string `xml:"endDate"`
+	Reason    string `xml:"reason,omitempty"`
+}
+
+// OncallPeriod is a period during which the oncall does not change,
+// with the overrides of the rotation applied.
+type OncallPeriod struct {
+	Primary   string
+	Secondary string
+	Start     time.Time
+	End       time.Time
+}
+
+// OncallUnavailability records that a member cannot be oncall during
+// the given period.
+type OncallUnavailability struct {
+	Member string
+	Start  time.Time
+	End    time.Time
+}
+
 // LoadOncallRotation parses the default oncall schedule file.
 func LoadOncallRotation(jirix *jiri.X) (*OncallRotation, error) {
 	oncallRotationsFile, err := OncallRotationPath(jirix)
@@ -41,26 +104,462 @@ func LoadOncallRotation(jirix *jiri.X) (*OncallRotation, error) {
 	return &rotation, nil
 }
 
+// SaveOncallRotation writes the given rotation to the default oncall
+// schedule file.
+func SaveOncallRotation(jirix *jiri.X, rotation *OncallRotation) error {
+	oncallRotationsFile, err := OncallRotationPath(jirix)
+	if err != nil {
+		return err
+	}
+	bytes, err := xml.MarshalIndent(rotation, "", "  ")
+	if err != nil {
+		return fmt.Errorf("MarshalIndent(%v) failed: %v", rotation, err)
+	}
+	header := `<?xml version="1.0" ?>
+<!--
+  This file has been auto generated. Please don't edit manually.
+-->
+`
+	content := append([]byte(header), bytes...)
+	content = append(content, '\n')
+	s := jirix.NewSeq()
+	if err := s.MkdirAll(filepath.Dir(oncallRotationsFile), os.FileMode(0755)).
+		WriteFile(oncallRotationsFile, content, os.FileMode(0644)).Done(); err != nil {
+		return err
+	}
+	return nil
+}
+
 // Oncall finds the oncall shift at the given time from the
-// oncall configuration file by comparing timestamps.
+// oncall configuration file by comparing timestamps. The overrides
+// of the rotation are applied to the shift.
 func Oncall(jirix *jiri.X, targetTime time.Time) (*OncallShift, error) {
 	// Parse oncall configuration file.
 	rotation, err := LoadOncallRotation(jirix)
 	if err != nil {
 		return nil, err
 	}
+	loc, err := rotation.Location(targetTime.Location())
+	if err != nil {
+		return nil, err
+	}
+	return rotation.OncallAt(targetTime, loc)
+}
+
+// Location returns the timezone of the dates of the rotation, or the
+// given timezone if the rotation does not have one.
+func (r *OncallRotation) Location(defaultLoc *time.Location) (*time.Location, error) {
+	if r.Timezone == "" {
+		return defaultLoc, nil
+	}
+	loc, err := time.LoadLocation(r.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("LoadLocation(%v) failed: %v", r.Timezone, err)
+	}
+	return loc, nil
+}
+
+// SetTimezone converts the dates of the rotation, which are in its
+// timezone or in the given timezone if it does not have one, to the
+// given IANA timezone, and records it as the timezone of the rotation.
+func (r *OncallRotation) SetTimezone(timezone string, defaultLoc *time.Location) error {
+	from, err := r.Location(defaultLoc)
+	if err != nil {
+		return err
+	}
+	to, err := time.LoadLocation(timezone)
+	if err != nil {
+		return fmt.Errorf("LoadLocation(%v) failed: %v", timezone, err)
+	}
+	convert := func(value *string) error {
+		t, err := parseOncallTime(*value, from)
+		if err != nil {
+			return err
+		}
+		*value = FormatOncallTime(t, to)
+		return nil
+	}
+	for i := range r.Shifts {
+		if err := convert(&r.Shifts[i].Date); err != nil {
+			return err
+		}
+	}
+	for i := range r.Overrides {
+		if err := convert(&r.Overrides[i].Start); err != nil {
+			return err
+		}
+		if err := convert(&r.Overrides[i].End); err != nil {
+			return err
+		}
+	}
+	r.Timezone = timezone
+	return nil
+}
+
+func parseOncallTime(value string, loc *time.Location) (time.Time, error) {
+	t, err := time.ParseInLocation(OncallTimeLayout, value, loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Parse(%q, %v) failed: %v", OncallTimeLayout, value, err)
+	}
+	return t, nil
+}
+
+// FormatOncallTime formats the given time in the given timezone for the
+// oncall rotation file.
+func FormatOncallTime(t time.Time, loc *time.Location) string {
+	return t.In(loc).Format(OncallTimeLayout)
+}
+
+// ShiftStart returns the start of the i-th shift of the rotation.
+func (r *OncallRotation) ShiftStart(i int, loc *time.Location) (time.Time, error) {
+	return parseOncallTime(r.Shifts[i].Date, loc)
+}
+
+// ShiftEnd returns the end of the i-th shift of the rotation, which is
+// the start of the next shift. The last shift is as long as the one
+// before it.
+func (r *OncallRotation) ShiftEnd(i int, loc *time.Location) (time.Time, error) {
+	if i+1 < len(r.Shifts) {
+		return r.ShiftStart(i+1, loc)
+	}
+	start, err := r.ShiftStart(i, loc)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if i == 0 {
+		return start.AddDate(0, 0, defaultShiftDays), nil
+	}
+	prevStart, err := r.ShiftStart(i-1, loc)
+	if err != nil {
+		return time.Time{}, err
+	}
+	// Shifts that last whole days are extended by calendar days, which
+	// may differ in length from the days of the previous shift.
+	days := int(start.Sub(prevStart).Hours()/24 + 0.5)
+	if days > 0 && prevStart.AddDate(0, 0, days).Equal(start) {
+		return start.AddDate(0, 0, days), nil
+	}
+	return start.Add(start.Sub(prevStart)), nil
+}
+
+// ShiftIndex returns the index of the shift at the given time, or -1 if
+// the time precedes the first shift. The last shift never ends.
+func (r *OncallRotation) ShiftIndex(t time.Time, loc *time.Location) (int, error) {
+	for i := len(r.Shifts) - 1; i >= 0; i-- {
+		start, err := r.ShiftStart(i, loc)
+		if err != nil {
+			return -1, err
+		}
+		if t.Unix() >= start.Unix() {
+			return i, nil
+		}
+	}
+	return -1, nil
+}
+
+// OncallAt returns the oncall shift at the given time, with the
+// overrides of the rotation applied, or nil if the time precedes the
+// first shift.
+func (r *OncallRotation) OncallAt(t time.Time, loc *time.Location) (*OncallShift, error) {
+	i, err := r.ShiftIndex(t, loc)
+	if err != nil || i == -1 {
+		return nil, err
+	}
+	shift := r.Shifts[i]
+	for _, override := range r.Overrides {
+		start, err := parseOncallTime(override.Start, loc)
+		if err != nil {
+			return nil, err
+		}
+		end, err := parseOncallTime(override.End, loc)
+		if err != nil {
+			return nil, err
+		}
+		if t.Unix() < start.Unix() || t.Unix() >= end.Unix() {
+			continue
+		}
+		if override.Primary != "" {
+			shift.Primary = override.Primary
+		}
+		if override.Secondary != "" {
+			shift.Secondary = override.Secondary
+		}
+	}
+	return &shift, nil
+}
+
+type timesByValue []time.Time
+
+func (t timesByValue) Len() int           { return len(t) }
+func (t timesByValue) Swap(i, j int)      { t[i], t[j] = t[j], t[i] }
+func (t timesByValue) Less(i, j int) bool { return t[i].Before(t[j]) }
+
+// Schedule returns the periods from the start of the first shift of the
+// rotation to the end of its last shift during which the oncall does
+// not change, with the overrides applied.
+func (r *OncallRotation) Schedule(loc *time.Location) ([]OncallPeriod, error) {
+	if len(r.Shifts) == 0 {
+		return nil, nil
+	}
+	first, err := r.ShiftStart(0, loc)
+	if err != nil {
+		return nil, err
+	}
+	last, err := r.ShiftEnd(len(r.Shifts)-1, loc)
+	if err != nil {
+		return nil, err
+	}
+	// Collect the times at which the oncall may change.
+	times := []time.Time{last}
+	for i := range r.Shifts {
+		start, err := r.ShiftStart(i, loc)
+		if err != nil {
+			return nil, err
+		}
+		times = append(times, start)
+	}
+	for _, override := range r.Overrides {
+		for _, value := range []string{override.Start, override.End} {
+			t, err := parseOncallTime(value, loc)
+			if err != nil {
+				return nil, err
+			}
+			if t.After(first) && t.Before(last) {
+				times = append(times, t)
+			}
+		}
+	}
+	sort.Sort(timesByValue(times))
+	periods := []OncallPeriod{}
+	for i := 0; i+1 < len(times); i++ {
+		if !times[i].Before(times[i+1]) {
+			continue
+		}
+		shift, err := r.OncallAt(times[i], loc)
+		if err != nil {
+			return nil, err
+		}
+		periods = append(periods, OncallPeriod{
+			Primary:   shift.Primary,
+			Secondary: shift.Secondary,
+			Start:     times[i],
+			End:       times[i+1],
+		})
+	}
+	return periods, nil
+}
 
-	// Find the oncall at targetTime.
-	layout := "Jan 2, 2006 3:04:05 PM"
-	for i := len(rotation.Shifts) - 1; i >= 0; i-- {
-		shift := rotation.Shifts[i]
-		t, err := time.ParseInLocation(layout, shift.Date, targetTime.Location())
+// Swap records overrides that swap the oncalls of the shifts at the
+// given times.
+func (r *OncallRotation) Swap(t1, t2 time.Time, reason string, loc *time.Location) error {
+	indices := []int{}
+	for _, t := range []time.Time{t1, t2} {
+		i, err := r.ShiftIndex(t, loc)
 		if err != nil {
-			return nil, fmt.Errorf("Parse(%q, %v) failed: %v", layout, shift.Date, err)
+			return err
 		}
-		if targetTime.Unix() >= t.Unix() {
-			return &shift, nil
+		if i == -1 {
+			return fmt.Errorf("no shift at %v", t)
 		}
+		indices = append(indices, i)
+	}
+	if indices[0] == indices[1] {
+		return fmt.Errorf("%v and %v are in the same shift", t1, t2)
+	}
+	overrides := []OncallOverride{}
+	for j, i := range indices {
+		start, err := r.ShiftStart(i, loc)
+		if err != nil {
+			return err
+		}
+		end, err := r.ShiftEnd(i, loc)
+		if err != nil {
+			return err
+		}
+		otherStart, err := r.ShiftStart(indices[1-j], loc)
+		if err != nil {
+			return err
+		}
+		other, err := r.OncallAt(otherStart, loc)
+		if err != nil {
+			return err
+		}
+		overrides = append(overrides, OncallOverride{
+			Primary:   other.Primary,
+			Secondary: other.Secondary,
+			Start:     FormatOncallTime(start, loc),
+			End:       FormatOncallTime(end, loc),
+			Reason:    reason,
+		})
+	}
+	r.Overrides = append(r.Overrides, overrides...)
+	return nil
+}
+
+// Generate replaces the shifts of the rotation that start at or after
+// the given time by the given number of shifts of the given length,
+// whose oncalls are picked from the given members. The overrides of the
+// replaced shifts would replace the new oncalls, so the overrides that
+// start at or after the given time are dropped, and the ones that end
+// after it are cut short at it. Generate returns the dropped overrides.
+//
+// The rotation is fair: the primary of a shift is the available member
+// who has been primary the least often, and the secondary is the other
+// available member who has been secondary the least often, counting the
+// shifts that are kept. Ties go to the member who was oncall the least
+// often, then the least recently, then the first in the list.
+func (r *OncallRotation) Generate(members []string, from time.Time, length OncallShiftLength, n int, unavailable []OncallUnavailability, loc *time.Location) ([]OncallOverride, error) {
+	if length.Days < 0 || length.Duration < 0 || (length.Days == 0 && length.Duration == 0) {
+		return nil, fmt.Errorf("invalid shift length of %d days and %v", length.Days, length.Duration)
+	}
+	type stats struct {
+		primary, secondary, last, order int
+	}
+	memberStats := map[string]*stats{}
+	for i, member := range members {
+		if _, ok := memberStats[member]; ok {
+			return nil, fmt.Errorf("duplicate member %v", member)
+		}
+		memberStats[member] = &stats{last: -1, order: i}
+	}
+
+	// Keep the shifts before the given time.
+	kept := []OncallShift{}
+	for i, shift := range r.Shifts {
+		start, err := r.ShiftStart(i, loc)
+		if err != nil {
+			return nil, err
+		}
+		if !start.Before(from) {
+			break
+		}
+		kept = append(kept, shift)
+		if s, ok := memberStats[shift.Primary]; ok {
+			s.primary++
+			s.last = i
+		}
+		if s, ok := memberStats[shift.Secondary]; ok {
+			s.secondary++
+			s.last = i
+		}
+	}
+
+	isAvailable := func(member string, start, end time.Time) bool {
+		for _, u := range unavailable {
+			if u.Member == member && u.Start.Before(end) && start.Before(u.End) {
+				return false
+			}
+		}
+		return true
+	}
+	// pick returns the available member other than the given one with
+	// the fewest shifts in the role whose count is returned by the given
+	// function.
+	pick := func(start, end time.Time, other string, count func(*stats) int) string {
+		best := ""
+		for _, member := range members {
+			if member == other || !isAvailable(member, start, end) {
+				continue
+			}
+			if best == "" {
+				best = member
+				continue
+			}
+			s, b := memberStats[member], memberStats[best]
+			keys := [][2]int{
+				{count(s), count(b)},
+				{s.primary + s.secondary, b.primary + b.secondary},
+				{s.last, b.last},
+			}
+			for _, key := range keys {
+				if key[0] != key[1] {
+					if key[0] < key[1] {
+						best = member
+					}
+					break
+				}
+			}
+		}
+		return best
+	}
+
+	shifts := kept
+	for i := 0; i < n; i++ {
+		start := length.shiftStart(from, i, loc)
+		end := length.shiftStart(from, i+1, loc)
+		primary := pick(start, end, "", func(s *stats) int { return s.primary })
+		secondary := pick(start, end, primary, func(s *stats) int { return s.secondary })
+		if primary == "" || secondary == "" {
+			return nil, fmt.Errorf("not enough available members for the shift starting at %v", start)
+		}
+		memberStats[primary].primary++
+		memberStats[primary].last = len(shifts)
+		memberStats[secondary].secondary++
+		memberStats[secondary].last = len(shifts)
+		shifts = append(shifts, OncallShift{
+			Primary:   primary,
+			Secondary: secondary,
+			Date:      FormatOncallTime(start, loc),
+		})
+	}
+
+	// Drop the overrides of the replaced shifts.
+	overrides, dropped := []OncallOverride{}, []OncallOverride{}
+	for _, override := range r.Overrides {
+		start, err := parseOncallTime(override.Start, loc)
+		if err != nil {
+			return nil, err
+		}
+		end, err := parseOncallTime(override.End, loc)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case !start.Before(from):
+			dropped = append(dropped, override)
+			continue
+		case end.After(from):
+			override.End = FormatOncallTime(from, loc)
+		}
+		overrides = append(overrides, override)
+	}
+	r.Shifts, r.Overrides = shifts, overrides
+	return dropped, nil
+}
+
+// WriteOncallCalendar writes the given oncall periods to the given
+// writer as an iCalendar (RFC 5545) calendar, stamped with the given
+// time.
+func WriteOncallCalendar(w io.Writer, periods []OncallPeriod, now time.Time) error {
+	const layout = "20060102T150405Z"
+	var buf bytes.Buffer
+	line := func(format string, args ...interface{}) {
+		// Lines are folded after 75 octets and end with CRLF.
+		text := fmt.Sprintf(format, args...)
+		for len(text) > 75 {
+			buf.WriteString(text[:75] + "\r\n")
+			text = " " + text[75:]
+		}
+		buf.WriteString(text + "\r\n")
+	}
+	escape := strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`)
+	line("BEGIN:VCALENDAR")
+	line("VERSION:2.0")
+	line("PRODID:-//Vanadium//jiri oncall//EN")
+	line("X-WR-CALNAME:Vanadium oncall")
+	for _, period := range periods {
+		line("BEGIN:VEVENT")
+		line("UID:%d-%d-%s@oncall.v.io", period.Start.Unix(), period.End.Unix(), period.Primary)
+		line("DTSTAMP:%s", now.UTC().Format(layout))
+		line("DTSTART:%s", period.Start.UTC().Format(layout))
+		line("DTEND:%s", period.End.UTC().Format(layout))
+		line("SUMMARY:%s", escape.Replace(fmt.Sprintf("Oncall: %s, %s", period.Primary, period.Secondary)))
+		line("DESCRIPTION:%s", escape.Replace(fmt.Sprintf("Primary: %s\nSecondary: %s", period.Primary, period.Secondary)))
+		line("END:VEVENT")
+	}
+	line("END:VCALENDAR")
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("Write() failed: %v", err)
 	}
-	return nil, nil
+	return nil
 }
@@ -5,10 +5,12 @@
 package tooldata_test
 
 import (
+	"bytes"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -100,3 +102,243 @@ func TestOncall(t *testing.T) {
 		}
 	}
 }
+
+func TestOncallOverrides(t *testing.T) {
+	fake, cleanup := jiritest.NewFakeJiriRoot(t)
+	defer cleanup()
+
+	createOncallFile(t, fake.X)
+	rotation, err := tooldata.LoadOncallRotation(fake.X)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	loc := time.UTC
+	date := func(day, hour int) time.Time {
+		return time.Date(2014, time.November, day, hour, 0, 0, 0, loc)
+	}
+	rotation.Overrides = append(rotation.Overrides, tooldata.OncallOverride{
+		Secondary: "jsimsa",
+		Start:     tooldata.FormatOncallTime(date(13, 12), loc),
+		End:       tooldata.FormatOncallTime(date(14, 12), loc),
+	})
+	if err := rotation.Swap(date(6, 0), date(20, 0), "vacation", loc); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := rotation.Swap(date(6, 0), date(7, 0), "", loc); err == nil {
+		t.Fatalf("swapped a shift with itself")
+	}
+	if err := tooldata.SaveOncallRotation(fake.X, rotation); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// The overrides are applied to the shifts, which do not change.
+	testCases := []struct {
+		targetTime time.Time
+		primary    string
+		secondary  string
+		date       string
+	}{
+		{date(6, 0), "jsimsa", "toddw", "Nov 5, 2014 12:00:00 PM"},
+		{date(13, 11), "suharshs", "jingjin", "Nov 12, 2014 12:00:00 PM"},
+		{date(13, 12), "suharshs", "jsimsa", "Nov 12, 2014 12:00:00 PM"},
+		{date(20, 0), "spetrovic", "suharshs", "Nov 19, 2014 12:00:00 PM"},
+		{date(27, 0), "jsimsa", "toddw", "Nov 19, 2014 12:00:00 PM"},
+	}
+	for _, test := range testCases {
+		got, err := tooldata.Oncall(fake.X, test.targetTime)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		want := &tooldata.OncallShift{Primary: test.primary, Secondary: test.secondary, Date: test.date}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("%v: want %#v, got %#v", test.targetTime, want, got)
+		}
+	}
+
+	rotation, err = tooldata.LoadOncallRotation(fake.X)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if got, want := len(rotation.Shifts), 3; got != want {
+		t.Fatalf("want %v shifts, got %v", want, got)
+	}
+	if got, want := rotation.Overrides[1].Reason, "vacation"; got != want {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+	periods, err := rotation.Schedule(loc)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	wantPeriods := []tooldata.OncallPeriod{
+		{"jsimsa", "toddw", date(5, 12), date(12, 12)},
+		{"suharshs", "jingjin", date(12, 12), date(13, 12)},
+		{"suharshs", "jsimsa", date(13, 12), date(14, 12)},
+		{"suharshs", "jingjin", date(14, 12), date(19, 12)},
+		{"spetrovic", "suharshs", date(19, 12), date(26, 12)},
+	}
+	if !reflect.DeepEqual(periods, wantPeriods) {
+		t.Fatalf("want %v, got %v", wantPeriods, periods)
+	}
+
+	var buf bytes.Buffer
+	if err := tooldata.WriteOncallCalendar(&buf, periods[:1], date(1, 0)); err != nil {
+		t.Fatalf("%v", err)
+	}
+	wantCalendar := strings.Join([]string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//Vanadium//jiri oncall//EN",
+		"X-WR-CALNAME:Vanadium oncall",
+		"BEGIN:VEVENT",
+		"UID:1415188800-1415793600-jsimsa@oncall.v.io",
+		"DTSTAMP:20141101T000000Z",
+		"DTSTART:20141105T120000Z",
+		"DTEND:20141112T120000Z",
+		`SUMMARY:Oncall: jsimsa\, toddw`,
+		`DESCRIPTION:Primary: jsimsa\nSecondary: toddw`,
+		"END:VEVENT",
+		"END:VCALENDAR",
+		"",
+	}, "\r\n")
+	if got := buf.String(); got != wantCalendar {
+		t.Fatalf("want %q, got %q", wantCalendar, got)
+	}
+}
+
+func TestOncallTimezone(t *testing.T) {
+	rotation := &tooldata.OncallRotation{
+		Shifts:   []tooldata.OncallShift{{Primary: "jsimsa", Secondary: "toddw", Date: "Nov 5, 2014 12:00:00 PM"}},
+		Timezone: "America/Los_Angeles",
+	}
+	loc, err := rotation.Location(time.UTC)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	// Nov 5, 2014 12:00:00 PM in Los Angeles is 20:00 UTC.
+	for hour, want := range map[int]bool{19: false, 20: true} {
+		got, err := rotation.OncallAt(time.Date(2014, time.November, 5, hour, 0, 0, 0, time.UTC), loc)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		if (got != nil) != want {
+			t.Fatalf("%v:00 UTC: want oncall %v, got %#v", hour, want, got)
+		}
+	}
+}
+
+func TestOncallSetTimezone(t *testing.T) {
+	rotation := &tooldata.OncallRotation{
+		Shifts:    []tooldata.OncallShift{{Primary: "jsimsa", Secondary: "toddw", Date: "Nov 5, 2014 12:00:00 PM"}},
+		Overrides: []tooldata.OncallOverride{{Primary: "nlacasse", Start: "Nov 6, 2014 12:00:00 PM", End: "Nov 7, 2014 12:00:00 PM"}},
+		Timezone:  "America/Los_Angeles",
+	}
+	// The dates are converted from the timezone of the rotation, not
+	// from the given default timezone.
+	if err := rotation.SetTimezone("Europe/Paris", time.UTC); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if got, want := rotation.Timezone, "Europe/Paris"; got != want {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+	if got, want := rotation.Shifts[0].Date, "Nov 5, 2014 9:00:00 PM"; got != want {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+	if got, want := rotation.Overrides[0], (tooldata.OncallOverride{Primary: "nlacasse", Start: "Nov 6, 2014 9:00:00 PM", End: "Nov 7, 2014 9:00:00 PM"}); got != want {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+}
+
+func TestOncallGenerate(t *testing.T) {
+	fake, cleanup := jiritest.NewFakeJiriRoot(t)
+	defer cleanup()
+
+	createOncallFile(t, fake.X)
+	rotation, err := tooldata.LoadOncallRotation(fake.X)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	loc := time.UTC
+	date := func(month time.Month, day int) time.Time {
+		return time.Date(2014, month, day, 12, 0, 0, 0, loc)
+	}
+	week := tooldata.OncallShiftLength{Days: 7}
+	unavailable := []tooldata.OncallUnavailability{
+		{Member: "c", Start: date(time.December, 3), End: date(time.December, 4)},
+	}
+	if _, err := rotation.Generate([]string{"a", "b", "c", "d"}, date(time.November, 26), week, 4, unavailable, loc); err != nil {
+		t.Fatalf("%v", err)
+	}
+	want := []tooldata.OncallShift{
+		{Primary: "a", Secondary: "b", Date: "Nov 26, 2014 12:00:00 PM"},
+		{Primary: "d", Secondary: "a", Date: "Dec 3, 2014 12:00:00 PM"},
+		{Primary: "c", Secondary: "d", Date: "Dec 10, 2014 12:00:00 PM"},
+		{Primary: "b", Secondary: "c", Date: "Dec 17, 2014 12:00:00 PM"},
+	}
+	if got := rotation.Shifts[3:]; !reflect.DeepEqual(got, want) {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+
+	// Regenerating replaces the later shifts, counting the earlier ones,
+	// and drops the overrides of the replaced shifts.
+	rotation.Overrides = []tooldata.OncallOverride{
+		{Primary: "x", Start: "Nov 12, 2014 12:00:00 PM", End: "Nov 26, 2014 12:00:00 PM"},
+		{Primary: "y", Start: "Nov 20, 2014 12:00:00 PM", End: "Nov 21, 2014 12:00:00 PM"},
+	}
+	dropped, err := rotation.Generate([]string{"spetrovic", "suharshs", "jsimsa"}, date(time.November, 19), week, 1, nil, loc)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if got, want := dropped, []tooldata.OncallOverride{{Primary: "y", Start: "Nov 20, 2014 12:00:00 PM", End: "Nov 21, 2014 12:00:00 PM"}}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("want dropped %v, got %v", want, got)
+	}
+	wantOverrides := []tooldata.OncallOverride{{Primary: "x", Start: "Nov 12, 2014 12:00:00 PM", End: "Nov 19, 2014 12:00:00 PM"}}
+	if got := rotation.Overrides; !reflect.DeepEqual(got, wantOverrides) {
+		t.Fatalf("want overrides %v, got %v", wantOverrides, got)
+	}
+	if got, want := len(rotation.Shifts), 3; got != want {
+		t.Fatalf("want %v shifts, got %v", want, got)
+	}
+	if got, want := rotation.Shifts[2], (tooldata.OncallShift{Primary: "jsimsa", Secondary: "spetrovic", Date: "Nov 19, 2014 12:00:00 PM"}); got != want {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+
+	unavailable = []tooldata.OncallUnavailability{
+		{Member: "a", Start: date(time.November, 1), End: date(time.December, 1)},
+	}
+	if _, err := rotation.Generate([]string{"a", "b"}, date(time.November, 26), week, 1, unavailable, loc); err == nil {
+		t.Fatalf("generated a shift without enough available members")
+	}
+}
+
+func TestOncallGenerateAcrossDST(t *testing.T) {
+	loc, err := time.LoadLocation("America/Los_Angeles")
+	if err != nil {
+		t.Skipf("LoadLocation() failed: %v", err)
+	}
+	rotation := &tooldata.OncallRotation{Timezone: "America/Los_Angeles"}
+	// Daylight saving time ends on Nov 6, 2016 in Los Angeles, so the
+	// first week of the rotation is an hour longer than 7*24 hours.
+	from := time.Date(2016, time.November, 5, 9, 0, 0, 0, loc)
+	if _, err := rotation.Generate([]string{"a", "b"}, from, tooldata.OncallShiftLength{Days: 7}, 2, nil, loc); err != nil {
+		t.Fatalf("%v", err)
+	}
+	// The last shift lasts as many calendar days as the previous one.
+	end, err := rotation.ShiftEnd(len(rotation.Shifts)-1, loc)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if got, want := end, time.Date(2016, time.November, 19, 9, 0, 0, 0, loc); !got.Equal(want) {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+	if _, err := rotation.Generate([]string{"a", "b"}, end, tooldata.OncallShiftLength{Days: 3}, 2, nil, loc); err != nil {
+		t.Fatalf("%v", err)
+	}
+	want := []string{"Nov 5, 2016 9:00:00 AM", "Nov 12, 2016 9:00:00 AM", "Nov 19, 2016 9:00:00 AM", "Nov 22, 2016 9:00:00 AM"}
+	got := []string{}
+	for _, shift := range rotation.Shifts {
+		got = append(got, shift.Date)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+}
@@ -28,9 +28,8 @@ var cmdRoot = &cmdline.Command{
 	Name:  "vkiosk",
 	Short: "takes and shows screenshots of a given url",
 	Long: `
-Command vkiosk runs Chrome in a virtual X11 environtment for a given url, takes
-its screenshots periodically, exports them to Google Storage, and serves them
-in a http server.
+Command vkiosk takes screenshots of given urls periodically in headless Chrome,
+exports them to Google Storage, and serves them in a http server.
 
 This tool is only tested in Debian/Ubuntu.
 `,
@@ -5,12 +5,11 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"os/exec"
 	"os/signal"
-	"path/filepath"
-	"strings"
 	"syscall"
 	"time"
 
@@ -19,31 +18,59 @@ import (
 	"v.io/x/lib/cmdline"
 )
 
+const (
+	defaultDevToolsPort = 9222
+	chromeStartTimeout  = 30 * time.Second
+)
+
 var (
-	displayFlag            string
-	resolutionFlag         string
+	chromeFlag             string
+	configFlag             string
+	devtoolsFlag           string
+	heightFlag             int
+	keepFlag               int
 	screenshotIntervalFlag string
 	screenshotNameFlag     string
+	timeoutFlag            string
 	urlFlag                string
+	waitForFlag            string
+	widthFlag              int
 )
 
 func init() {
-	cmdCollect.Flags.StringVar(&displayFlag, "display", ":1365", "The value of DISPLAY environment variable for Xvfb.")
-	cmdCollect.Flags.StringVar(&resolutionFlag, "resolution", "1920x1080x24", "The resolution string for Xvfb.")
+	cmdCollect.Flags.StringVar(&chromeFlag, "chrome", "google-chrome", "The Chrome binary to start in headless mode.")
+	cmdCollect.Flags.StringVar(&configFlag, "config", "", "The path to a JSON file that lists the pages to take screenshots of. Overrides the -name, -url, -width, -height and -wait-for flags.")
+	cmdCollect.Flags.StringVar(&devtoolsFlag, "devtools", "", fmt.Sprintf("The URL of the DevTools endpoint of a running Chrome, such as http://localhost:%d. If empty, a headless Chrome is started.", defaultDevToolsPort))
+	cmdCollect.Flags.IntVar(&heightFlag, "height", defaultViewportHeight, "The height of the viewport.")
+	cmdCollect.Flags.IntVar(&keepFlag, "keep", 720, "The number of screenshots to keep for each page.")
 	cmdCollect.Flags.StringVar(&screenshotIntervalFlag, "interval", "5s", "The interval between screenshots.")
-	cmdCollect.Flags.StringVar(&screenshotNameFlag, "name", "", "The name of the screenshot file.")
+	cmdCollect.Flags.StringVar(&screenshotNameFlag, "name", "", "The name of the page, which names the directory of its screenshots.")
+	cmdCollect.Flags.StringVar(&timeoutFlag, "timeout", "30s", "The timeout for loading a page.")
 	cmdCollect.Flags.StringVar(&urlFlag, "url", "", "The url to take screenshots for.")
+	cmdCollect.Flags.StringVar(&waitForFlag, "wait-for", "", "The CSS selector of an element to wait for before taking a screenshot.")
+	cmdCollect.Flags.IntVar(&widthFlag, "width", defaultViewportWidth, "The width of the viewport.")
 }
 
 var cmdCollect = &cmdline.Command{
 	Name:  "collect",
-	Short: "Takes screenshots of a given URL in Chrome and stores them in the given export dir",
+	Short: "Takes screenshots of given URLs in headless Chrome and stores them in the given export dir",
 	Long: `
-The collect commands takes screenshots of a given URL in Chrome and stores them
-in the given export dir.
-
-To use this command, the following programs need to be installed:
-Google Chrome,Xvfb, and Fluxbox.
+The collect commands takes screenshots of given URLs in headless Chrome through
+the DevTools protocol and stores them in the given export dir. The screenshots
+of a page are stored in the "<name>/<time>.png" files of the export dir, where
+time is the UTC time the screenshot was taken at.
+
+The pages are given by the -name and -url flags, or by a JSON file passed to
+the -config flag that lists pages with their own viewport, element to wait for
+and login cookies. For example:
+
+[
+  {"name": "dashboard", "url": "https://dashboard.v.io", "width": 1920, "height": 1080, "waitFor": "#status"},
+  {"name": "oncall", "url": "https://oncall.v.io", "cookies": [{"name": "SID", "value": "...", "domain": "oncall.v.io"}]}
+]
+
+To use this command, Google Chrome needs to be installed, unless the -devtools
+flag points to a running Chrome.
 `,
 	Runner: cmdline.RunnerFunc(runCollect),
 }
@@ -53,158 +80,142 @@ func runCollect(env *cmdline.Env, args []string) error {
 	if err != nil {
 		return err
 	}
-	if err := checkPreRequisites(); err != nil {
-		return err
-	}
-
-	// A tmp dir to store screenshots.
-	tmpDir, err := jirix.NewSeq().TempDir("", "vkiosk")
+	pages, err := loadPages(jirix)
 	if err != nil {
 		return err
 	}
-	fmt.Fprintf(jirix.Stdout(), "Tmp screenshot dir: %s\n", tmpDir)
-
-	fmt.Fprintf(jirix.Stdout(), "Starting Xvfb at DISPLAY=%s with resolution %s...\n", displayFlag, resolutionFlag)
-	p, err := startXvfb(jirix)
+	interval, err := time.ParseDuration(screenshotIntervalFlag)
 	if err != nil {
-		return err
-	}
-
-	// Set up cleanup function to remove tmp screenshot dir and kill Xvfb.
-	// When Xvfb is killed, the Chrome running in it will also be killed
-	// automatically.
-	cleanupFn := func() {
-		// Ignore all errors.
-		jirix.NewSeq().RemoveAll(tmpDir)
-		p.Kill()
+		return fmt.Errorf("ParseDuration(%s) failed: %v", screenshotIntervalFlag, err)
 	}
-	defer cleanupFn()
-
-	// Trap SIGTERM and SIGINT signal.
-	go func() {
-		sigchan := make(chan os.Signal, 1)
-		signal.Notify(sigchan, syscall.SIGINT, syscall.SIGTERM)
-		<-sigchan
-		cleanupFn()
-		os.Exit(0)
-	}()
-
-	fmt.Fprintf(jirix.Stdout(), "Starting Chrome for %q in Xvfb...\n", urlFlag)
-	if err := startChrome(jirix); err != nil {
-		return err
+	timeout, err := time.ParseDuration(timeoutFlag)
+	if err != nil {
+		return fmt.Errorf("ParseDuration(%s) failed: %v", timeoutFlag, err)
 	}
 
-	fmt.Fprintf(jirix.Stdout(), "Starting Fluxbox in Xvfb...\n")
-	if err := startFluxbox(jirix); err != nil {
+	url := devtoolsFlag
+	if url == "" {
+		url = fmt.Sprintf("http://localhost:%d", defaultDevToolsPort)
+		fmt.Fprintf(jirix.Stdout(), "Starting headless Chrome with DevTools at %s...\n", url)
+		p, profileDir, err := startChrome(jirix, defaultDevToolsPort)
+		if err != nil {
+			return err
+		}
+		// Set up cleanup function to kill Chrome and remove its profile
+		// dir.
+		cleanupFn := func() {
+			// Ignore all errors.
+			p.Kill()
+			jirix.NewSeq().RemoveAll(profileDir)
+		}
+		defer cleanupFn()
+
+		// Trap SIGTERM and SIGINT signal.
+		go func() {
+			sigchan := make(chan os.Signal, 1)
+			signal.Notify(sigchan, syscall.SIGINT, syscall.SIGTERM)
+			<-sigchan
+			cleanupFn()
+			os.Exit(0)
+		}()
+	}
+	d := newDevTools(url)
+	if err := d.waitUntilReady(chromeStartTimeout); err != nil {
 		return err
 	}
 
-	if err := takeScreenshots(jirix, tmpDir); err != nil {
-		return err
+	store := newScreenshotStore(exportDirFlag)
+	ticker := time.NewTicker(interval)
+	for range ticker.C {
+		takeScreenshots(jirix, d, store, pages, timeout)
 	}
 	return nil
 }
 
-func checkPreRequisites() error {
-	programs := []string{"Xvfb", "fluxbox", "google-chrome", "scrot"}
-	notInstalled := []string{}
-	for _, p := range programs {
-		if _, err := exec.LookPath(p); err != nil {
-			notInstalled = append(notInstalled, p)
+// loadPages returns the pages given by the -config flag, or by the
+// -name, -url, -width, -height and -wait-for flags.
+func loadPages(jirix *jiri.X) ([]page, error) {
+	if configFlag == "" {
+		if screenshotNameFlag == "" || urlFlag == "" {
+			return nil, jirix.UsageErrorf("either -config or -name and -url must be set")
 		}
-	}
-	if len(notInstalled) != 0 {
-		return fmt.Errorf("%q not found in PATH", strings.Join(notInstalled, ","))
-	}
-	return nil
-}
-
-// startXvfb starts Xvfb at the given display and screen resolution.
-// It provides an virtual X11 environment for Chrome to run in.
-func startXvfb(jirix *jiri.X) (*runutil.Handle, error) {
-	args := []string{
-		displayFlag,
-		"-screen",
-		"0",
-		resolutionFlag,
-		"-ac",
-	}
-	cmd, err := jirix.NewSeq().Start("Xvfb", args...)
+		return []page{{
+			Name:    screenshotNameFlag,
+			URL:     urlFlag,
+			Width:   widthFlag,
+			Height:  heightFlag,
+			WaitFor: waitForFlag,
+		}}, nil
+	}
+	bytes, err := jirix.NewSeq().ReadFile(configFlag)
 	if err != nil {
 		return nil, err
 	}
-	// Wait a little bit to make sure it finishes initialization.
-	time.Sleep(time.Second * 5)
-	return cmd, nil
-}
-
-// startFluxbox starts a light weight windows manager Fluxbox.
-// Without it, Chrome can't be maximized or run in kiosk mode properly.
-func startFluxbox(jirix *jiri.X) error {
-	args := []string{
-		"-display",
-		displayFlag,
+	var pages []page
+	if err := json.Unmarshal(bytes, &pages); err != nil {
+		return nil, fmt.Errorf("Unmarshal(%v) failed: %v", configFlag, err)
 	}
-	if _, err := jirix.NewSeq().Start("fluxbox", args...); err != nil {
-		return err
+	names := map[string]bool{}
+	for _, p := range pages {
+		if p.Name == "" || p.URL == "" {
+			return nil, fmt.Errorf("page %#v has no name or url", p)
+		}
+		if names[p.Name] {
+			return nil, fmt.Errorf("duplicate page %q", p.Name)
+		}
+		names[p.Name] = true
 	}
-	return nil
+	return pages, nil
 }
 
-// startChrome starts Chrome in a kiosk mode in the given X11 environment.
-func startChrome(jirix *jiri.X) error {
-	args := []string{
-		"--kiosk",
-		urlFlag,
+// startChrome starts Chrome in headless mode with its DevTools endpoint
+// at the given port. It returns the Chrome process and its profile dir.
+func startChrome(jirix *jiri.X, port int) (*runutil.Handle, string, error) {
+	if _, err := exec.LookPath(chromeFlag); err != nil {
+		return nil, "", fmt.Errorf("%q not found in PATH", chromeFlag)
 	}
-	env := map[string]string{"DISPLAY": displayFlag}
-	if _, err := jirix.NewSeq().Env(env).Start("google-chrome", args...); err != nil {
-		return err
-	}
-	return nil
-}
-
-// takeScreenshot takes screenshots periodically, and stores them in the given
-// export dir.
-func takeScreenshots(jirix *jiri.X, tmpDir string) error {
-	d, err := time.ParseDuration(screenshotIntervalFlag)
+	s := jirix.NewSeq()
+	profileDir, err := s.TempDir("", "vkiosk")
 	if err != nil {
-		return fmt.Errorf("ParseDuration(%s) failed: %v", screenshotIntervalFlag, err)
+		return nil, "", err
 	}
-	screenshotFile := filepath.Join(tmpDir, screenshotNameFlag)
-	scrotArgs := []string{
-		screenshotFile,
+	args := []string{
+		"--headless",
+		"--disable-gpu",
+		"--hide-scrollbars",
+		fmt.Sprintf("--remote-debugging-port=%d", port),
+		"--remote-allow-origins=*",
+		"--user-data-dir=" + profileDir,
+		"about:blank",
+	}
+	p, err := s.Start(chromeFlag, args...)
+	if err != nil {
+		jirix.NewSeq().RemoveAll(profileDir)
+		return nil, "", err
 	}
-	s := jirix.NewSeq()
-	env := map[string]string{"DISPLAY": displayFlag}
-	gsutilArgs := []string{
-		"-q",
-		"cp",
-		screenshotFile,
-		exportDirFlag + "/" + screenshotNameFlag,
-	}
-	ticker := time.NewTicker(d)
-	for range ticker.C {
-		// Use "scrot" command to take screenshots.
-		fmt.Fprintf(jirix.Stdout(), "[%s]: take screenshot to %q...\n", nowTimestamp(), screenshotFile)
-		if err := s.Env(env).Last("scrot", scrotArgs...); err != nil {
+	return p, profileDir, nil
+}
+
+// takeScreenshots takes a screenshot of each of the given pages, and
+// stores it in the given store. Errors are reported, and do not stop
+// the screenshots of the other pages.
+func takeScreenshots(jirix *jiri.X, d *devtools, store *screenshotStore, pages []page, timeout time.Duration) {
+	for _, p := range pages {
+		fmt.Fprintf(jirix.Stdout(), "[%s]: take screenshot of %q...\n", nowTimestamp(), p.URL)
+		data, err := d.capture(p, timeout)
+		if err != nil {
 			fmt.Fprintf(jirix.Stderr(), "%v\n", err)
 			continue
 		}
-
-		// Store the screenshots to export dir.
-		fmt.Fprintf(jirix.Stdout(), "[%s]: copying screenshot to %s...\n", nowTimestamp(), exportDirFlag)
-		if strings.HasPrefix(exportDirFlag, "gs://") {
-			if err := s.Last("gsutil", gsutilArgs...); err != nil {
-				fmt.Fprintf(jirix.Stderr(), "%v\n", err)
-			}
-		} else {
-			if err := s.Rename(screenshotFile, filepath.Join(exportDirFlag, screenshotNameFlag)).Done(); err != nil {
-				fmt.Fprintf(jirix.Stderr(), "%v\n", err)
-			}
+		fmt.Fprintf(jirix.Stdout(), "[%s]: storing screenshot of %q in %s...\n", nowTimestamp(), p.Name, exportDirFlag)
+		if err := store.save(jirix, p.Name, time.Now(), data); err != nil {
+			fmt.Fprintf(jirix.Stderr(), "%v\n", err)
+			continue
+		}
+		if err := store.prune(jirix, p.Name, keepFlag); err != nil {
+			fmt.Fprintf(jirix.Stderr(), "%v\n", err)
 		}
 	}
-	return nil
 }
 
 func nowTimestamp() string {
@@ -0,0 +1,261 @@
+// Copyright 2015 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+const (
+	defaultViewportWidth   = 1920
+	defaultViewportHeight  = 1080
+	devtoolsRequestTimeout = 30 * time.Second
+)
+
+// pollInterval is how often a page is checked for being ready to be
+// captured. It is a variable so that tests can shorten it.
+var pollInterval = 250 * time.Millisecond
+
+// page describes a page to take screenshots of.
+type page struct {
+	// Name identifies the screenshots of the page in the export dir.
+	Name string `json:"name"`
+	URL  string `json:"url"`
+	// Width and Height are the size of the viewport, which default to
+	// 1920x1080.
+	Width  int `json:"width,omitempty"`
+	Height int `json:"height,omitempty"`
+	// WaitFor is a CSS selector of an element that must be present
+	// before the screenshot is taken.
+	WaitFor string `json:"waitFor,omitempty"`
+	// Cookies are set before the page is loaded, such as the session
+	// cookies of a login.
+	Cookies []cookie `json:"cookies,omitempty"`
+}
+
+// cookie is a cookie in the format of the DevTools protocol.
+type cookie struct {
+	Name     string `json:"name"`
+	Value    string `json:"value"`
+	Domain   string `json:"domain,omitempty"`
+	Path     string `json:"path,omitempty"`
+	Secure   bool   `json:"secure,omitempty"`
+	HTTPOnly bool   `json:"httpOnly,omitempty"`
+}
+
+// devtools is a client of the DevTools protocol of a Chrome instance,
+// which is served at the given HTTP URL, such as
+// "http://localhost:9222".
+type devtools struct {
+	url    string
+	client *http.Client
+}
+
+func newDevTools(url string) *devtools {
+	return &devtools{
+		url:    strings.TrimSuffix(url, "/"),
+		client: &http.Client{Timeout: devtoolsRequestTimeout},
+	}
+}
+
+// devtoolsTarget is a tab of Chrome.
+type devtoolsTarget struct {
+	ID                   string `json:"id"`
+	WebSocketDebuggerURL string `json:"webSocketDebuggerUrl"`
+}
+
+// get sends a request with the given method to the given path of the
+// DevTools HTTP endpoint, and returns the response body.
+func (d *devtools) get(method, path string) ([]byte, error) {
+	url := d.url + path
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("NewRequest(%v) failed: %v", url, err)
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%s(%v) failed: %v", method, url, err)
+	}
+	defer resp.Body.Close()
+	bytes, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("ReadAll() failed: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s(%v) failed: %v %s", method, url, resp.Status, string(bytes))
+	}
+	return bytes, nil
+}
+
+// waitUntilReady waits until the DevTools endpoint accepts requests.
+func (d *devtools) waitUntilReady(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		_, err := d.get("GET", "/json/version")
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("DevTools at %v not ready after %v: %v", d.url, timeout, err)
+		}
+		time.Sleep(pollInterval)
+	}
+}
+
+// capture opens the given page in a new tab, waits until it is loaded,
+// and returns its screenshot in PNG format.
+func (d *devtools) capture(p page, timeout time.Duration) ([]byte, error) {
+	bytes, err := d.get("PUT", "/json/new?about:blank")
+	if err != nil {
+		return nil, err
+	}
+	var target devtoolsTarget
+	if err := json.Unmarshal(bytes, &target); err != nil {
+		return nil, fmt.Errorf("Unmarshal(%v) failed: %v", string(bytes), err)
+	}
+	defer d.get("GET", "/json/close/"+target.ID)
+
+	conn, err := websocket.Dial(target.WebSocketDebuggerURL, "", d.url)
+	if err != nil {
+		return nil, fmt.Errorf("Dial(%v) failed: %v", target.WebSocketDebuggerURL, err)
+	}
+	defer conn.Close()
+	s := &devtoolsSession{conn: conn}
+
+	if len(p.Cookies) > 0 {
+		if err := s.call("Network.enable", nil, nil); err != nil {
+			return nil, err
+		}
+		if err := s.call("Network.setCookies", map[string]interface{}{"cookies": p.Cookies}, nil); err != nil {
+			return nil, err
+		}
+	}
+	width, height := p.Width, p.Height
+	if width == 0 {
+		width = defaultViewportWidth
+	}
+	if height == 0 {
+		height = defaultViewportHeight
+	}
+	if err := s.call("Emulation.setDeviceMetricsOverride", map[string]interface{}{
+		"width":             width,
+		"height":            height,
+		"deviceScaleFactor": 1,
+		"mobile":            false,
+	}, nil); err != nil {
+		return nil, err
+	}
+	if err := s.call("Page.navigate", map[string]interface{}{"url": p.URL}, nil); err != nil {
+		return nil, err
+	}
+
+	// Wait until the page is loaded and has the element to wait for.
+	expression := "document.readyState === 'complete'"
+	if p.WaitFor != "" {
+		selector, err := json.Marshal(p.WaitFor)
+		if err != nil {
+			return nil, fmt.Errorf("Marshal(%v) failed: %v", p.WaitFor, err)
+		}
+		expression += fmt.Sprintf(" && document.querySelector(%s) !== null", selector)
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		var result struct {
+			Result struct {
+				Value interface{} `json:"value"`
+			} `json:"result"`
+		}
+		if err := s.call("Runtime.evaluate", map[string]interface{}{
+			"expression":    expression,
+			"returnByValue": true,
+		}, &result); err != nil {
+			return nil, err
+		}
+		if result.Result.Value == true {
+			break
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("%v not ready after %v", p.URL, timeout)
+		}
+		time.Sleep(pollInterval)
+	}
+
+	var screenshot struct {
+		Data string `json:"data"`
+	}
+	if err := s.call("Page.captureScreenshot", map[string]interface{}{"format": "png"}, &screenshot); err != nil {
+		return nil, err
+	}
+	data, err := base64.StdEncoding.DecodeString(screenshot.Data)
+	if err != nil {
+		return nil, fmt.Errorf("DecodeString() failed: %v", err)
+	}
+	return data, nil
+}
+
+// devtoolsSession sends commands of the DevTools protocol to a tab
+// through its WebSocket connection.
+type devtoolsSession struct {
+	conn   *websocket.Conn
+	nextID int
+}
+
+// devtoolsMessage is a response or an event of the DevTools protocol.
+// Events have no ID.
+type devtoolsMessage struct {
+	ID     int             `json:"id"`
+	Method string          `json:"method"`
+	Result json.RawMessage `json:"result"`
+	Error  *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// call sends the given command, waits for its response, and stores its
+// result in the given value, if any. Events received in the meantime
+// are ignored.
+func (s *devtoolsSession) call(method string, params interface{}, result interface{}) error {
+	s.nextID++
+	id := s.nextID
+	if params == nil {
+		params = map[string]interface{}{}
+	}
+	s.conn.SetDeadline(time.Now().Add(devtoolsRequestTimeout))
+	command := map[string]interface{}{
+		"id":     id,
+		"method": method,
+		"params": params,
+	}
+	if err := websocket.JSON.Send(s.conn, command); err != nil {
+		return fmt.Errorf("Send(%v) failed: %v", method, err)
+	}
+	for {
+		var msg devtoolsMessage
+		if err := websocket.JSON.Receive(s.conn, &msg); err != nil {
+			return fmt.Errorf("Receive(%v) failed: %v", method, err)
+		}
+		if msg.ID != id {
+			continue
+		}
+		if msg.Error != nil {
+			return fmt.Errorf("%v failed: %v", method, msg.Error.Message)
+		}
+		if result != nil {
+			if err := json.Unmarshal(msg.Result, result); err != nil {
+				return fmt.Errorf("Unmarshal(%v) failed: %v", string(msg.Result), err)
+			}
+		}
+		return nil
+	}
+}
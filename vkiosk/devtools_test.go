@@ -0,0 +1,235 @@
+// Copyright 2015 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+	"v.io/jiri/jiritest"
+)
+
+// fakeDevTools is a fake DevTools endpoint of Chrome. Its screenshots
+// describe the page they are taken of, and pages are loaded after they
+// are checked a couple of times.
+type fakeDevTools struct {
+	server *httptest.Server
+	mu     sync.Mutex
+	// methods lists the methods called in each tab.
+	methods map[string][]string
+	nextID  int
+}
+
+func newFakeDevTools() *fakeDevTools {
+	f := &fakeDevTools{methods: map[string][]string{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/json/version", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"Browser": "FakeChrome/1.0"}`)
+	})
+	mux.HandleFunc("/json/new", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		f.nextID++
+		id := fmt.Sprintf("tab%d", f.nextID)
+		f.methods[id] = []string{}
+		f.mu.Unlock()
+		wsURL := strings.Replace(f.server.URL, "http://", "ws://", 1) + "/devtools/page/" + id
+		fmt.Fprintf(w, `{"id": %q, "webSocketDebuggerUrl": %q}`, id, wsURL)
+	})
+	mux.HandleFunc("/json/close/", func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(r.URL.Path, "/json/close/")
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if _, ok := f.methods[id]; !ok {
+			http.NotFound(w, r)
+			return
+		}
+		f.methods[id] = append(f.methods[id], "close")
+		fmt.Fprint(w, "Target is closing")
+	})
+	mux.Handle("/devtools/page/", websocket.Handler(f.serveTab))
+	f.server = httptest.NewServer(mux)
+	return f
+}
+
+func (f *fakeDevTools) serveTab(conn *websocket.Conn) {
+	id := strings.TrimPrefix(conn.Request().URL.Path, "/devtools/page/")
+	var url, viewport string
+	cookies := []string{}
+	checks := 0
+	for {
+		var command struct {
+			ID     int                    `json:"id"`
+			Method string                 `json:"method"`
+			Params map[string]interface{} `json:"params"`
+		}
+		if err := websocket.JSON.Receive(conn, &command); err != nil {
+			return
+		}
+		f.mu.Lock()
+		f.methods[id] = append(f.methods[id], command.Method)
+		f.mu.Unlock()
+		result := map[string]interface{}{}
+		switch command.Method {
+		case "Network.enable":
+		case "Network.setCookies":
+			for _, c := range command.Params["cookies"].([]interface{}) {
+				c := c.(map[string]interface{})
+				cookies = append(cookies, fmt.Sprintf("%v=%v", c["name"], c["value"]))
+			}
+		case "Emulation.setDeviceMetricsOverride":
+			viewport = fmt.Sprintf("%vx%v", command.Params["width"], command.Params["height"])
+		case "Page.navigate":
+			url = command.Params["url"].(string)
+			// Events are sent along with the responses.
+			websocket.JSON.Send(conn, map[string]interface{}{"method": "Page.frameStartedLoading"})
+		case "Runtime.evaluate":
+			checks++
+			result["result"] = map[string]interface{}{"type": "boolean", "value": checks > 2}
+		case "Page.captureScreenshot":
+			screenshot := fmt.Sprintf("%s %s %s", url, viewport, strings.Join(cookies, ";"))
+			result["data"] = base64.StdEncoding.EncodeToString([]byte(screenshot))
+		default:
+			websocket.JSON.Send(conn, map[string]interface{}{
+				"id":    command.ID,
+				"error": map[string]interface{}{"code": -32601, "message": "'" + command.Method + "' wasn't found"},
+			})
+			continue
+		}
+		websocket.JSON.Send(conn, map[string]interface{}{"id": command.ID, "result": result})
+	}
+}
+
+func TestCollect(t *testing.T) {
+	jirix, cleanup := jiritest.NewX(t)
+	defer cleanup()
+	pollInterval = time.Millisecond
+
+	f := newFakeDevTools()
+	defer f.server.Close()
+	d := newDevTools(f.server.URL + "/")
+	if err := d.waitUntilReady(time.Second); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	pages := []page{
+		{Name: "dashboard", URL: "https://dashboard.v.io", WaitFor: "#status"},
+		{
+			Name:    "oncall",
+			URL:     "https://oncall.v.io",
+			Width:   800,
+			Height:  600,
+			Cookies: []cookie{{Name: "SID", Value: "1234", Domain: "oncall.v.io"}},
+		},
+	}
+	store := newScreenshotStore(jirix.Root)
+	start := time.Date(2015, time.October, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		for _, p := range pages {
+			data, err := d.capture(p, time.Second)
+			if err != nil {
+				t.Fatalf("%v", err)
+			}
+			if err := store.save(jirix, p.Name, start.Add(time.Duration(i)*time.Minute), data); err != nil {
+				t.Fatalf("%v", err)
+			}
+			if err := store.prune(jirix, p.Name, 2); err != nil {
+				t.Fatalf("%v", err)
+			}
+		}
+	}
+
+	// Each tab is closed after its screenshot is taken.
+	wantMethods := []string{
+		"Network.enable",
+		"Network.setCookies",
+		"Emulation.setDeviceMetricsOverride",
+		"Page.navigate",
+		"Runtime.evaluate",
+		"Runtime.evaluate",
+		"Runtime.evaluate",
+		"Page.captureScreenshot",
+		"close",
+	}
+	if got := f.methods["tab6"]; !reflect.DeepEqual(got, wantMethods) {
+		t.Fatalf("got methods %v, want %v", got, wantMethods)
+	}
+
+	names, err := store.pages(jirix)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if want := []string{"dashboard", "oncall"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("got pages %v, want %v", names, want)
+	}
+	want := map[string]string{
+		"dashboard": "https://dashboard.v.io 1920x1080 ",
+		"oncall":    "https://oncall.v.io 800x600 SID=1234",
+	}
+	for name, wantData := range want {
+		data, timestamp, err := store.latest(jirix, name)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		if got := string(data); got != wantData {
+			t.Fatalf("got screenshot %q, want %q", got, wantData)
+		}
+		if want := start.Add(2 * time.Minute); !timestamp.Equal(want) {
+			t.Fatalf("got time %v, want %v", timestamp, want)
+		}
+		// Only the latest screenshots are kept.
+		times, err := store.times(jirix, name)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		if got, want := len(times), 2; got != want {
+			t.Fatalf("got %v screenshots, want %v", got, want)
+		}
+	}
+
+	// The latest screenshot is served with its time.
+	exportDirFlag = jirix.Root
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		dataHandler(jirix, w, r)
+	}))
+	defer server.Close()
+	resp, err := http.Get(server.URL + "/data?n=oncall")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer resp.Body.Close()
+	var result struct {
+		Data      string
+		Timestamp int64
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if data, err := base64.StdEncoding.DecodeString(result.Data); err != nil || string(data) != want["oncall"] {
+		t.Fatalf("got screenshot %q, %v, want %q", data, err, want["oncall"])
+	}
+	if got, want := result.Timestamp, start.Add(2*time.Minute).Unix(); got != want {
+		t.Fatalf("got timestamp %v, want %v", got, want)
+	}
+
+	// Unknown methods fail.
+	conn, err := websocket.Dial(strings.Replace(f.server.URL, "http://", "ws://", 1)+"/devtools/page/tab1", "", f.server.URL)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer conn.Close()
+	s := &devtoolsSession{conn: conn}
+	if err := s.call("Page.crash", nil, nil); err == nil {
+		t.Fatalf("call of an unknown method did not fail")
+	}
+}
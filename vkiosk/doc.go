@@ -6,9 +6,8 @@
 // DO NOT UPDATE MANUALLY
 
 /*
-Command vkiosk runs Chrome in a virtual X11 environtment for a given url, takes
-its screenshots periodically, exports them to Google Storage, and serves them in
-a http server.
+Command vkiosk takes screenshots of given urls periodically in headless Chrome,
+exports them to Google Storage, and serves them in a http server.
 
 This tool is only tested in Debian/Ubuntu.
 
@@ -16,8 +15,8 @@ Usage:
    vkiosk [flags] <command>
 
 The vkiosk commands are:
-   collect     Takes screenshots of a given URL in Chrome and stores them in the
-               given export dir
+   collect     Takes screenshots of given URLs in headless Chrome and stores them
+               in the given export dir
    serve       Serve screenshots from local file system or Google Storage
    help        Display help for commands or topics
 
@@ -36,28 +35,53 @@ The global flags are:
  -time=false
    Dump timing information to stderr before exiting the program.
 
-Vkiosk collect - Takes screenshots of a given URL in Chrome and stores them in the given export dir
+Vkiosk collect - Takes screenshots of given URLs in headless Chrome and stores them in the given export dir
 
-The collect commands takes screenshots of a given URL in Chrome and stores them
-in the given export dir.
+The collect commands takes screenshots of given URLs in headless Chrome through
+the DevTools protocol and stores them in the given export dir. The screenshots
+of a page are stored in the "<name>/<time>.png" files of the export dir, where
+time is the UTC time the screenshot was taken at.
 
-To use this command, the following programs need to be installed: Google
-Chrome,Xvfb, and Fluxbox.
+The pages are given by the -name and -url flags, or by a JSON file passed to the
+-config flag that lists pages with their own viewport, element to wait for and
+login cookies. For example:
+
+[
+  {"name": "dashboard", "url": "https://dashboard.v.io", "width": 1920, "height": 1080, "waitFor": "#status"},
+  {"name": "oncall", "url": "https://oncall.v.io", "cookies": [{"name": "SID", "value": "...", "domain": "oncall.v.io"}]}
+]
+
+To use this command, Google Chrome needs to be installed, unless the -devtools
+flag points to a running Chrome.
 
 Usage:
    vkiosk collect [flags]
 
 The vkiosk collect flags are:
- -display=:1365
-   The value of DISPLAY environment variable for Xvfb.
+ -chrome=google-chrome
+   The Chrome binary to start in headless mode.
+ -config=
+   The path to a JSON file that lists the pages to take screenshots of.
+   Overrides the -name, -url, -width, -height and -wait-for flags.
+ -devtools=
+   The URL of the DevTools endpoint of a running Chrome, such as
+   http://localhost:9222. If empty, a headless Chrome is started.
+ -height=1080
+   The height of the viewport.
  -interval=5s
    The interval between screenshots.
+ -keep=720
+   The number of screenshots to keep for each page.
  -name=
-   The name of the screenshot file.
- -resolution=1920x1080x24
-   The resolution string for Xvfb.
+   The name of the page, which names the directory of its screenshots.
+ -timeout=30s
+   The timeout for loading a page.
  -url=
    The url to take screenshots for.
+ -wait-for=
+   The CSS selector of an element to wait for before taking a screenshot.
+ -width=1920
+   The width of the viewport.
 
  -color=true
    Use color to format output.
@@ -71,6 +95,10 @@ Vkiosk serve - Serve screenshots from local file system or Google Storage
 
 Serve screenshots from local file system or Google Storage.
 
+The index page shows the latest screenshots of the pages given by the "n"
+parameter, a comma-separated list of page names, or of all pages if it is not
+set. It rotates through them every "r" milliseconds.
+
 Usage:
    vkiosk serve [flags]
 
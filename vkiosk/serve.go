@@ -5,13 +5,11 @@
 package main
 
 import (
-	"bytes"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"net/http"
-	"path/filepath"
+	"strconv"
 	"strings"
 	"text/template"
 
@@ -40,9 +38,24 @@ var tmpl = template.Must(template.New("screenshot").Parse(`
 		width: 100%;
 		height: 100%;
 	}
+	#caption {
+		position: fixed;
+		right: 0px;
+		bottom: 0px;
+		padding: 2px 6px;
+		font-family: sans-serif;
+		font-size: 10px;
+		color: white;
+		background-color: rgba(0, 0, 0, 0.5);
+	}
 	</style>
 	<script>
+		var names = {{ .ScreenshotNames }};
+		var current = 0;
+
 		function loadScreenshot() {
+			var name = names[current];
+			current = (current + 1) % names.length;
 			var xhr = new XMLHttpRequest();
 			xhr.onreadystatechange=function() {
 				if (xhr.readyState == 4 && xhr.status == 200) {
@@ -51,9 +64,13 @@ var tmpl = template.Must(template.New("screenshot").Parse(`
 					if (ele) {
 						ele.src = 'data:image/png;base64,' + data.Data;
 					}
+					var caption = document.getElementById('caption');
+					if (caption) {
+						caption.textContent = name + ' ' + new Date(data.Timestamp * 1000).toLocaleString();
+					}
 				}
 			}
-			xhr.open("GET", "/data?n={{ .ScreenshotName }}", true);
+			xhr.open("GET", "/data?n=" + encodeURIComponent(name), true);
 			xhr.send();
 		}
 
@@ -65,6 +82,7 @@ var tmpl = template.Must(template.New("screenshot").Parse(`
 	</head>
 	<body onload="pageLoaded()">
 	<img id="screenshot"></img>
+	<div id="caption"></div>
 	</body>
 </html>
 `))
@@ -75,9 +93,15 @@ func init() {
 
 // cmdServe represents the 'serve' command of the vkiosk tool.
 var cmdServe = &cmdline.Command{
-	Name:   "serve",
-	Short:  "Serve screenshots from local file system or Google Storage",
-	Long:   "Serve screenshots from local file system or Google Storage.",
+	Name:  "serve",
+	Short: "Serve screenshots from local file system or Google Storage",
+	Long: `
+Serve screenshots from local file system or Google Storage.
+
+The index page shows the latest screenshots of the pages given by the "n"
+parameter, a comma-separated list of page names, or of all pages if it is not
+set. It rotates through them every "r" milliseconds.
+`,
 	Runner: cmdline.RunnerFunc(runServe),
 }
 
@@ -103,23 +127,42 @@ func runServe(env *cmdline.Env, _ []string) (e error) {
 // indexHandler handles requests for index.html.
 func indexHandler(jirix *jiri.X, w http.ResponseWriter, r *http.Request) {
 	r.ParseForm()
-	// Parameter "n" specifies the name of the screenshot file.
-	name := r.Form.Get("n")
-	if name == "" {
-		respondWithError(jirix, fmt.Errorf("parameter 'n' not found"), w)
-		return
+	// Parameter "n" specifies the comma-separated names of the pages to
+	// rotate through.
+	names := []string{}
+	if n := r.Form.Get("n"); n != "" {
+		names = strings.Split(n, ",")
+	} else {
+		var err error
+		if names, err = newScreenshotStore(exportDirFlag).pages(jirix); err != nil {
+			respondWithError(jirix, err, w)
+			return
+		}
+		if len(names) == 0 {
+			respondWithError(jirix, fmt.Errorf("no screenshots in %v", exportDirFlag), w)
+			return
+		}
 	}
 	// Parameter "r" specifies the refresh interval.
 	refreshMs := r.Form.Get("r")
 	if refreshMs == "" {
 		refreshMs = defaultRefreshMs
 	}
+	if _, err := strconv.Atoi(refreshMs); err != nil {
+		respondWithError(jirix, fmt.Errorf("Atoi(%v) failed: %v", refreshMs, err), w)
+		return
+	}
+	bytes, err := json.Marshal(names)
+	if err != nil {
+		respondWithError(jirix, fmt.Errorf("Marshal() failed: %v", err), w)
+		return
+	}
 	data := struct {
-		ScreenshotName string
-		RefreshMs      string
+		ScreenshotNames string
+		RefreshMs       string
 	}{
-		ScreenshotName: name,
-		RefreshMs:      refreshMs,
+		ScreenshotNames: string(bytes),
+		RefreshMs:       refreshMs,
 	}
 	if err := tmpl.Execute(w, data); err != nil {
 		respondWithError(jirix, fmt.Errorf("Execute() failed: %v", err), w)
@@ -128,8 +171,9 @@ func indexHandler(jirix *jiri.X, w http.ResponseWriter, r *http.Request) {
 }
 
 // dataHandler handles requests for /data.
-// It reads screenshots from local file system or Google Storage, and returns
-// base64 encoded string as JSON.
+// It reads the latest screenshot of the given page from local file
+// system or Google Storage, and returns it as a base64 encoded string
+// and the time it was taken at as JSON.
 func dataHandler(jirix *jiri.X, w http.ResponseWriter, r *http.Request) {
 	r.ParseForm()
 	name := r.Form.Get("n")
@@ -137,14 +181,18 @@ func dataHandler(jirix *jiri.X, w http.ResponseWriter, r *http.Request) {
 		respondWithError(jirix, fmt.Errorf("parameter 'n' not found"), w)
 		return
 	}
-	bytes, err := readScreenshot(jirix, name)
+	bytes, t, err := newScreenshotStore(exportDirFlag).latest(jirix, name)
 	if err != nil {
 		respondWithError(jirix, fmt.Errorf("%v", err), w)
 		return
 	}
 	encoded := base64.StdEncoding.EncodeToString(bytes)
-	jsonData := struct{ Data string }{
-		Data: encoded,
+	jsonData := struct {
+		Data      string
+		Timestamp int64
+	}{
+		Data:      encoded,
+		Timestamp: t.Unix(),
 	}
 	bytes, err = json.Marshal(&jsonData)
 	if err != nil {
@@ -155,22 +203,6 @@ func dataHandler(jirix *jiri.X, w http.ResponseWriter, r *http.Request) {
 	w.Write(bytes)
 }
 
-func readScreenshot(jirix *jiri.X, name string) ([]byte, error) {
-	if strings.HasPrefix(exportDirFlag, "gs://") {
-		args := []string{
-			"-q",
-			"cat",
-			exportDirFlag + "/" + name,
-		}
-		var output bytes.Buffer
-		if err := jirix.NewSeq().Capture(&output, &output).Last("gsutil", args...); err != nil {
-			return nil, err
-		}
-		return output.Bytes(), nil
-	}
-	return ioutil.ReadFile(filepath.Join(exportDirFlag, name))
-}
-
 func respondWithError(jirix *jiri.X, err error, w http.ResponseWriter) {
 	fmt.Fprintf(jirix.Stderr(), "%v\n", err)
 	http.Error(w, "500 internal server error", http.StatusInternalServerError)
@@ -0,0 +1,131 @@
+// Copyright 2015 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"v.io/jiri"
+	"v.io/jiri/runutil"
+	"v.io/x/devtools/internal/blobstore"
+)
+
+const (
+	// screenshotTimeLayout is the layout of the names of the screenshot
+	// files, which are the UTC times the screenshots were taken at.
+	screenshotTimeLayout = "20060102-150405"
+	screenshotExt        = ".png"
+)
+
+// screenshotStore stores the screenshots of pages, named by the times
+// they were taken at, in the "<name>/<time>.png" blobs of the export
+// dir.
+type screenshotStore struct {
+	store blobstore.WritableStore
+}
+
+// newScreenshotStore returns the store of the given export dir. Dirs
+// that start with "gs://" point to Google Storage buckets.
+func newScreenshotStore(dir string) *screenshotStore {
+	return &screenshotStore{store: blobstore.NewWritable(dir)}
+}
+
+func screenshotFileName(t time.Time) string {
+	return t.UTC().Format(screenshotTimeLayout) + screenshotExt
+}
+
+// screenshotTimes returns the sorted times of the screenshots with the
+// given file names, ignoring other files.
+func screenshotTimes(names []string) []time.Time {
+	times := []time.Time{}
+	for _, name := range names {
+		if !strings.HasSuffix(name, screenshotExt) {
+			continue
+		}
+		t, err := time.Parse(screenshotTimeLayout, strings.TrimSuffix(name, screenshotExt))
+		if err != nil {
+			continue
+		}
+		times = append(times, t)
+	}
+	sort.Sort(timesByValue(times))
+	return times
+}
+
+type timesByValue []time.Time
+
+func (t timesByValue) Len() int           { return len(t) }
+func (t timesByValue) Swap(i, j int)      { t[i], t[j] = t[j], t[i] }
+func (t timesByValue) Less(i, j int) bool { return t[i].Before(t[j]) }
+
+// save stores the given screenshot of the given page.
+func (s *screenshotStore) save(jirix *jiri.X, name string, t time.Time, data []byte) error {
+	return s.store.Write(jirix, path.Join(name, screenshotFileName(t)), data)
+}
+
+// pages returns the names of the pages with screenshots, which are the
+// directories of the export dir.
+func (s *screenshotStore) pages(jirix *jiri.X) ([]string, error) {
+	entries, err := s.store.List(jirix, "")
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, entry := range entries {
+		// Directories are not blobs.
+		if _, err := s.store.Stat(jirix, entry); err == nil {
+			continue
+		} else if !runutil.IsNotExist(err) {
+			return nil, err
+		}
+		names = append(names, entry)
+	}
+	return names, nil
+}
+
+// times returns the sorted times of the screenshots of the given page.
+func (s *screenshotStore) times(jirix *jiri.X, name string) ([]time.Time, error) {
+	names, err := s.store.List(jirix, name)
+	if err != nil {
+		return nil, err
+	}
+	return screenshotTimes(names), nil
+}
+
+// latest returns the latest screenshot of the given page and the time
+// it was taken at.
+func (s *screenshotStore) latest(jirix *jiri.X, name string) ([]byte, time.Time, error) {
+	times, err := s.times(jirix, name)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if len(times) == 0 {
+		return nil, time.Time{}, fmt.Errorf("no screenshots of %v", name)
+	}
+	t := times[len(times)-1]
+	data, err := s.store.Read(jirix, path.Join(name, screenshotFileName(t)))
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return data, t, nil
+}
+
+// prune removes all but the given number of latest screenshots of the
+// given page.
+func (s *screenshotStore) prune(jirix *jiri.X, name string, keep int) error {
+	times, err := s.times(jirix, name)
+	if err != nil {
+		return err
+	}
+	names := []string{}
+	for i := 0; i < len(times)-keep; i++ {
+		names = append(names, path.Join(name, screenshotFileName(times[i])))
+	}
+	return s.store.Remove(jirix, names...)
+}